@@ -0,0 +1,313 @@
+// Package billing charges doctors' consultation fees.
+package billing
+
+import (
+	"context"
+	"fmt"
+	"life-signal/database"
+	"life-signal/jobs"
+	"life-signal/models"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// When invoices are issued: as soon as an appointment is booked, so it is
+// paid ahead, or once the doctor completes it.
+const (
+	IssueOnBooked    = "booked"
+	IssueOnCompleted = "completed"
+)
+
+const (
+	defaultCurrency          = "USD"
+	defaultCancellationHours = 24
+)
+
+// TaxRate is a tax charged on every invoice, in basis points: 2000 is 20%.
+type TaxRate struct {
+	Name        string
+	BasisPoints int64
+}
+
+// Config is how invoices are issued.
+type Config struct {
+	Currency string
+	IssueOn  string
+	TaxRates []TaxRate
+	// LateRefundPercent is the share of the payment refunded when the
+	// patient cancels with less notice than the cancellation window.
+	LateRefundPercent int64
+}
+
+var (
+	current     Config
+	currentOnce sync.Once
+	currentMu   sync.RWMutex
+)
+
+// Init configures billing from the environment: BILLING_CURRENCY is the
+// ISO 4217 code of doctors' fees and invoices (USD by default),
+// BILLING_ISSUE_ON is booked (default) or completed, BILLING_TAX_RATES lists
+// taxes as name=percent pairs separated by commas, such as "VAT=20", and
+// LATE_CANCELLATION_REFUND_PERCENT is the share refunded for a late
+// cancellation, none by default.
+func Init() error {
+	cfg := Config{Currency: defaultCurrency, IssueOn: IssueOnBooked}
+	if currency := os.Getenv("BILLING_CURRENCY"); currency != "" {
+		if len(currency) != 3 {
+			return fmt.Errorf("BILLING_CURRENCY %q is not an ISO 4217 code", currency)
+		}
+		cfg.Currency = strings.ToUpper(currency)
+	}
+	switch issueOn := strings.ToLower(os.Getenv("BILLING_ISSUE_ON")); issueOn {
+	case "", IssueOnBooked:
+	case IssueOnCompleted:
+		cfg.IssueOn = IssueOnCompleted
+	default:
+		return fmt.Errorf("unknown BILLING_ISSUE_ON %q, expected booked or completed", issueOn)
+	}
+	rates, err := ParseTaxRates(os.Getenv("BILLING_TAX_RATES"))
+	if err != nil {
+		return err
+	}
+	cfg.TaxRates = rates
+	if raw := os.Getenv("LATE_CANCELLATION_REFUND_PERCENT"); raw != "" {
+		percent, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return fmt.Errorf("LATE_CANCELLATION_REFUND_PERCENT must be between 0 and 100")
+		}
+		cfg.LateRefundPercent = percent
+	}
+	Configure(cfg)
+	return nil
+}
+
+// ParseTaxRates reads tax rates written as name=percent pairs separated by
+// commas. Percentages may have up to two decimals.
+func ParseTaxRates(raw string) ([]TaxRate, error) {
+	var rates []TaxRate
+	for _, part := range strings.Split(raw, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		name, percent, ok := strings.Cut(part, "=")
+		name = strings.TrimSpace(name)
+		value, err := strconv.ParseFloat(strings.TrimSpace(percent), 64)
+		if !ok || name == "" || err != nil || value < 0 || value > 100 {
+			return nil, fmt.Errorf("tax rate %q must be a name and a percentage such as VAT=20", strings.TrimSpace(part))
+		}
+		rates = append(rates, TaxRate{Name: name, BasisPoints: int64(math.Round(value * 100))})
+	}
+	return rates, nil
+}
+
+// Configure replaces the billing configuration in use.
+func Configure(cfg Config) {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	current = cfg
+}
+
+// Current returns the billing configuration, falling back to invoices in
+// USD issued on booking without taxes when Init was never called.
+func Current() Config {
+	currentOnce.Do(func() {
+		currentMu.Lock()
+		defer currentMu.Unlock()
+		if current.Currency == "" {
+			current = Config{Currency: defaultCurrency, IssueOn: IssueOnBooked}
+		}
+	})
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+	return current
+}
+
+// CancellationWindow is how long before the start patients can no longer
+// reschedule a booking themselves, and the notice a patient's cancellation
+// needs for a full refund. It is set in hours by
+// CANCELLATION_WINDOW_HOURS.
+func CancellationWindow() time.Duration {
+	hours, err := strconv.Atoi(os.Getenv("CANCELLATION_WINDOW_HOURS"))
+	if err != nil || hours < 0 {
+		hours = defaultCancellationHours
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// zeroDecimal are the currencies without a minor unit.
+var zeroDecimal = map[string]bool{
+	"BIF": true, "CLP": true, "DJF": true, "GNF": true, "JPY": true, "KMF": true,
+	"KRW": true, "MGA": true, "PYG": true, "RWF": true, "UGX": true, "VND": true,
+	"VUV": true, "XAF": true, "XOF": true, "XPF": true,
+}
+
+// ToMinor converts amount in currency to its minor unit, such as cents.
+func ToMinor(amount float64, currency string) int64 {
+	if zeroDecimal[strings.ToUpper(currency)] {
+		return int64(math.Round(amount))
+	}
+	return int64(math.Round(amount * 100))
+}
+
+// Compute fills in the amounts of invoice from its lines. discount, when
+// set, is taken off the subtotal, and each tax rate is charged on what is
+// left, rounded half up. An amount-off discount in another currency does
+// not apply.
+func Compute(invoice *models.Invoice, discount *models.Discount, rates []TaxRate) {
+	invoice.Subtotal = 0
+	for i := range invoice.Lines {
+		line := &invoice.Lines[i]
+		line.Amount = line.Quantity * line.UnitAmount
+		invoice.Subtotal += line.Amount
+	}
+
+	invoice.Discounts = nil
+	invoice.DiscountTotal = 0
+	if discount != nil {
+		amount := int64(0)
+		switch {
+		case discount.PercentOff > 0:
+			amount = (invoice.Subtotal*discount.PercentOff + 50) / 100
+		case discount.Currency == invoice.Currency:
+			amount = discount.AmountOff
+		}
+		amount = min(amount, invoice.Subtotal)
+		if amount > 0 {
+			invoice.Discounts = []models.InvoiceDiscount{{Code: discount.Code, Description: discount.Description, Amount: amount}}
+			invoice.DiscountTotal = amount
+		}
+	}
+
+	taxable := invoice.Subtotal - invoice.DiscountTotal
+	invoice.Taxes = nil
+	invoice.TaxTotal = 0
+	for _, rate := range rates {
+		amount := (taxable*rate.BasisPoints + 5000) / 10000
+		invoice.Taxes = append(invoice.Taxes, models.TaxLine{Name: rate.Name, RateBasisPoints: rate.BasisPoints, Taxable: taxable, Amount: amount})
+		invoice.TaxTotal += amount
+	}
+	invoice.Total = taxable + invoice.TaxTotal
+}
+
+// Issue returns the invoice of booking, issuing it for the doctor's fee and
+// the booking's discount code on first use. A discount code that can no
+// longer be redeemed is left out. An invoice with nothing to pay is issued
+// as paid.
+func Issue(ctx context.Context, db *mongo.Client, booking *models.Booking) (*models.Invoice, error) {
+	invoices := database.GetCollection(db, "life-signal", "invoices")
+	existing, err := database.FindInvoice(ctx, invoices, bson.M{"booking_id": booking.ID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find invoice: %w", err)
+	}
+	if existing != nil {
+		return existing, nil
+	}
+	var doctor models.Doctor
+	if err := database.GetCollection(db, "life-signal", "doctors").FindOne(ctx, bson.M{"_id": booking.DoctorID}).Decode(&doctor); err != nil {
+		return nil, fmt.Errorf("failed to find doctor: %w", err)
+	}
+
+	cfg := Current()
+	fee := ToMinor(doctor.Fee, cfg.Currency)
+	start := booking.Start
+	if location, err := time.LoadLocation(booking.TimeZone); err == nil {
+		start = start.In(location)
+	}
+	invoice := models.Invoice{
+		ID:        uuid.New().String(),
+		BookingID: booking.ID,
+		UserID:    booking.UserID,
+		DoctorID:  booking.DoctorID,
+		Currency:  cfg.Currency,
+		Status:    models.InvoiceOpen,
+		Lines: []models.InvoiceLine{{
+			Description: fmt.Sprintf("Consultation with %s on %s", booking.DoctorName, start.Format("Mon 2 Jan 2006 15:04 MST")),
+			Quantity:    1,
+			UnitAmount:  fee,
+		}},
+	}
+
+	discounts := database.GetCollection(db, "life-signal", "discounts")
+	var discount *models.Discount
+	if booking.DiscountCode != "" {
+		discount, err = database.RedeemDiscount(ctx, discounts, booking.DiscountCode)
+		if err == database.ErrDiscountUnavailable {
+			slog.Warn("Discount code no longer available", "appointmentID", booking.ID, "code", booking.DiscountCode)
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to redeem discount: %w", err)
+		}
+	}
+	release := func() {
+		if discount == nil {
+			return
+		}
+		if err := database.ReleaseDiscount(ctx, discounts, discount.Code); err != nil {
+			slog.Warn("Failed to release discount code", "code", discount.Code, "error", err)
+		}
+	}
+
+	Compute(&invoice, discount, cfg.TaxRates)
+	if invoice.Total == 0 {
+		now := time.Now()
+		invoice.Status = models.InvoicePaid
+		invoice.PaidAt = &now
+	}
+	invoice.Number, err = database.NextInvoiceNumber(ctx, database.GetCollection(db, "life-signal", "counters"), time.Now().Year())
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("failed to number invoice: %w", err)
+	}
+	err = database.InsertInvoice(ctx, invoices, &invoice)
+	if err == database.ErrAlreadyInvoiced {
+		release()
+		return database.FindInvoice(ctx, invoices, bson.M{"booking_id": booking.ID})
+	}
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("failed to save invoice: %w", err)
+	}
+	return &invoice, nil
+}
+
+const (
+	issueInvoiceJob       = "issue-invoice"
+	settleCancellationJob = "settle-cancellation"
+)
+
+// QueueIssue issues the invoice of booking through the job queue, for when
+// issuing it right away failed.
+func QueueIssue(ctx context.Context, db *mongo.Client, booking models.Booking) error {
+	return jobs.Enqueue(ctx, db, issueInvoiceJob, booking.ID, time.Now(), map[string]string{"booking_id": booking.ID, "user_id": booking.UserID})
+}
+
+// QueueCancellation settles the invoice of a cancelled booking through the
+// job queue, so a provider that is down delays the refund rather than
+// losing it.
+func QueueCancellation(ctx context.Context, db *mongo.Client, booking models.Booking) error {
+	return jobs.Enqueue(ctx, db, settleCancellationJob, booking.ID, time.Now(), map[string]string{"booking_id": booking.ID, "user_id": booking.UserID})
+}
+
+// Register adds the billing jobs to s.
+func Register(s *jobs.Scheduler) {
+	s.Handle(issueInvoiceJob, func(ctx context.Context, job *models.Job) error {
+		booking, err := database.FindBooking(ctx, database.GetCollection(s.DB, "life-signal", "appointments"), bson.M{"_id": job.Payload["booking_id"]})
+		if err != nil || booking == nil || booking.Status == models.BookingCancelled {
+			return err
+		}
+		_, err = Issue(ctx, s.DB, booking)
+		return err
+	})
+	s.Handle(settleCancellationJob, func(ctx context.Context, job *models.Job) error {
+		return SettleCancellation(ctx, s.DB, job.Payload["booking_id"])
+	})
+}
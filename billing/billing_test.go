@@ -0,0 +1,137 @@
+package billing
+
+import (
+	"life-signal/models"
+	"life-signal/payments"
+	"testing"
+	"time"
+)
+
+func TestToMinor(t *testing.T) {
+	tests := []struct {
+		amount   float64
+		currency string
+		want     int64
+	}{
+		{80, "USD", 8000},
+		{12.35, "EUR", 1235},
+		{0.1 + 0.2, "USD", 30},
+		{3000, "JPY", 3000},
+		{3000.4, "jpy", 3000},
+		{12500, "KRW", 12500},
+	}
+	for _, test := range tests {
+		if got := ToMinor(test.amount, test.currency); got != test.want {
+			t.Errorf("ToMinor(%v, %s) = %d, want %d", test.amount, test.currency, got, test.want)
+		}
+	}
+}
+
+func TestCompute(t *testing.T) {
+	vat := TaxRate{Name: "VAT", BasisPoints: 2000}
+	city := TaxRate{Name: "City", BasisPoints: 250}
+	tests := []struct {
+		name     string
+		currency string
+		fee      int64
+		code     *models.Discount
+		rates    []TaxRate
+		discount int64
+		taxes    []int64
+		total    int64
+	}{
+		{"no discount or tax", "USD", 5000, nil, nil, 0, nil, 5000},
+		{"percent off with two rates", "USD", 5000, &models.Discount{Code: "SPRING", PercentOff: 15}, []TaxRate{vat, city}, 750, []int64{850, 106}, 5206},
+		{"percent off rounds half up", "USD", 333, &models.Discount{Code: "HALF", PercentOff: 50}, nil, 167, nil, 166},
+		{"amount off", "USD", 5000, &models.Discount{Code: "TENOFF", AmountOff: 1000, Currency: "USD"}, []TaxRate{{Name: "Sales", BasisPoints: 725}}, 1000, []int64{290}, 4290},
+		{"amount off in another currency", "EUR", 5000, &models.Discount{Code: "TENOFF", AmountOff: 1000, Currency: "USD"}, []TaxRate{vat}, 0, []int64{1000}, 6000},
+		{"amount off larger than the fee", "USD", 800, &models.Discount{Code: "FREE", AmountOff: 5000, Currency: "USD"}, []TaxRate{vat}, 800, []int64{0}, 0},
+		{"zero-decimal currency", "JPY", 3000, &models.Discount{Code: "TEN", PercentOff: 10}, []TaxRate{{Name: "Consumption", BasisPoints: 1000}}, 300, []int64{270}, 2970},
+		{"zero-decimal amount off", "JPY", 3000, &models.Discount{Code: "YEN", AmountOff: 500, Currency: "JPY"}, nil, 500, nil, 2500},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			invoice := &models.Invoice{Currency: test.currency, Lines: []models.InvoiceLine{{Quantity: 1, UnitAmount: test.fee}}}
+			Compute(invoice, test.code, test.rates)
+			if invoice.Subtotal != test.fee || invoice.Lines[0].Amount != test.fee {
+				t.Errorf("subtotal %d, line %d, want %d", invoice.Subtotal, invoice.Lines[0].Amount, test.fee)
+			}
+			if invoice.DiscountTotal != test.discount {
+				t.Errorf("discount %d, want %d", invoice.DiscountTotal, test.discount)
+			}
+			if (len(invoice.Discounts) > 0) != (test.discount > 0) {
+				t.Errorf("discount lines %v for a discount of %d", invoice.Discounts, test.discount)
+			}
+			if len(invoice.Taxes) != len(test.taxes) {
+				t.Fatalf("%d tax lines, want %d", len(invoice.Taxes), len(test.taxes))
+			}
+			for i, tax := range invoice.Taxes {
+				if tax.Amount != test.taxes[i] || tax.Taxable != test.fee-test.discount {
+					t.Errorf("tax %s: %d on %d, want %d on %d", tax.Name, tax.Amount, tax.Taxable, test.taxes[i], test.fee-test.discount)
+				}
+			}
+			if invoice.Total != test.total {
+				t.Errorf("total %d, want %d", invoice.Total, test.total)
+			}
+		})
+	}
+}
+
+func TestRefundPercent(t *testing.T) {
+	t.Setenv("CANCELLATION_WINDOW_HOURS", "24")
+	Configure(Config{Currency: "USD", LateRefundPercent: 50})
+	t.Cleanup(func() { Configure(Config{Currency: defaultCurrency, IssueOn: IssueOnBooked}) })
+
+	start := time.Date(2026, 10, 20, 9, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := start.Add(-d)
+		return &t
+	}
+	tests := []struct {
+		name    string
+		booking models.Booking
+		want    int64
+	}{
+		{"not cancelled", models.Booking{Status: models.BookingConfirmed}, 0},
+		{"doctor cancelled late", models.Booking{Status: models.BookingCancelled, CancelledBy: "doctor", CancelledAt: at(time.Hour)}, 100},
+		{"patient gave two days notice", models.Booking{Status: models.BookingCancelled, CancelledBy: "patient", CancelledAt: at(48 * time.Hour)}, 100},
+		{"patient cancelled right at the window", models.Booking{Status: models.BookingCancelled, CancelledBy: "patient", CancelledAt: at(24 * time.Hour)}, 100},
+		{"patient cancelled late", models.Booking{Status: models.BookingCancelled, CancelledBy: "patient", CancelledAt: at(2 * time.Hour)}, 50},
+		{"patient cancellation without a time", models.Booking{Status: models.BookingCancelled, CancelledBy: "patient"}, 50},
+	}
+	for _, test := range tests {
+		test.booking.Start = start
+		if got := RefundPercent(&test.booking); got != test.want {
+			t.Errorf("%s: RefundPercent = %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRecordRefund(t *testing.T) {
+	invoice := &models.Invoice{Status: models.InvoicePaid, AmountPaid: 5000}
+	recordRefund(invoice, &payments.Refund{ID: "re_1", Status: "pending", Amount: 2000}, "late cancellation", "refund-inv-1-a")
+	if invoice.Status != models.InvoicePartiallyRefunded || invoice.AmountRefunded != 2000 {
+		t.Errorf("after a partial refund: %s with %d refunded", invoice.Status, invoice.AmountRefunded)
+	}
+
+	// The webhook for the same refund updates it without losing its key.
+	recordRefund(invoice, &payments.Refund{ID: "re_1", Status: "succeeded", Amount: 2000}, "", "")
+	if len(invoice.Refunds) != 1 || invoice.Refunds[0].IdempotencyKey != "refund-inv-1-a" || invoice.Refunds[0].Reason != "late cancellation" {
+		t.Errorf("refunds %+v, want the one refund with its key and reason", invoice.Refunds)
+	}
+
+	recordRefund(invoice, &payments.Refund{ID: "re_2", Status: payments.RefundFailed, Amount: 3000}, "goodwill", "refund-inv-1-b")
+	if invoice.Status != models.InvoicePartiallyRefunded || invoice.AmountRefunded != 2000 {
+		t.Errorf("a failed refund counted: %s with %d refunded", invoice.Status, invoice.AmountRefunded)
+	}
+	recordRefund(invoice, &payments.Refund{ID: "re_3", Status: "pending", Amount: 3000}, "goodwill", "refund-inv-1-c")
+	if invoice.Status != models.InvoiceRefunded || invoice.AmountRefunded != 5000 {
+		t.Errorf("after refunding the rest: %s with %d refunded", invoice.Status, invoice.AmountRefunded)
+	}
+
+	void := &models.Invoice{Status: models.InvoiceVoid}
+	recordRefund(void, &payments.Refund{ID: "re_4", Status: "pending"}, "", "")
+	if void.Status != models.InvoiceVoid {
+		t.Errorf("void invoice became %s", void.Status)
+	}
+}
@@ -0,0 +1,325 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"life-signal/database"
+	"life-signal/models"
+	"life-signal/payments"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrNotPayable      = errors.New("invoice is not open for payment")
+	ErrNothingToRefund = errors.New("invoice has no payment left to refund")
+	ErrRefundTooLarge  = errors.New("refund exceeds what is left of the payment")
+)
+
+// Pay returns the provider payment that collects invoice, creating it on
+// first use. A payment already started for the invoice is handed out again
+// unless it was canceled, so paying twice from two devices charges once.
+func Pay(ctx context.Context, db *mongo.Client, invoice *models.Invoice) (*payments.Payment, error) {
+	if invoice.Status != models.InvoiceOpen || invoice.Total == 0 {
+		return nil, ErrNotPayable
+	}
+	provider := payments.Current()
+	if invoice.PaymentID != "" {
+		payment, err := provider.GetPayment(ctx, invoice.PaymentID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find payment: %w", err)
+		}
+		if payment.Status != payments.PaymentCanceled {
+			return payment, nil
+		}
+	}
+
+	// The key changes with the version, so a payment canceled since is
+	// replaced rather than handed out again by the provider.
+	payment, err := provider.CreatePayment(ctx, payments.PaymentReq{
+		Amount:         invoice.Total,
+		Currency:       invoice.Currency,
+		Description:    "Invoice " + invoice.Number,
+		Metadata:       map[string]string{"invoice_id": invoice.ID, "booking_id": invoice.BookingID},
+		IdempotencyKey: fmt.Sprintf("invoice-%s-v%d", invoice.ID, invoice.Version),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create payment: %w", err)
+	}
+	invoices := database.GetCollection(db, "life-signal", "invoices")
+	updated, err := database.UpdateInvoice(ctx, invoices, bson.M{"_id": invoice.ID, "status": models.InvoiceOpen}, func(invoice *models.Invoice) error {
+		invoice.Provider = provider.Name()
+		invoice.PaymentID = payment.ID
+		invoice.PaymentError = ""
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save payment: %w", err)
+	}
+	if updated == nil {
+		return nil, ErrNotPayable
+	}
+	return payment, nil
+}
+
+// HandleEvent applies a webhook from the payment provider to the invoice it
+// is about. Events arrive at least once and in any order, so applying one
+// again or late leaves the invoice as it was.
+func HandleEvent(ctx context.Context, db *mongo.Client, event *payments.Event) error {
+	switch {
+	case event.Payment != nil:
+		return handlePayment(ctx, db, event.Type, event.Payment)
+	case event.Refund != nil:
+		return handleRefund(ctx, db, event.Refund)
+	}
+	return nil
+}
+
+// invoiceOf selects the invoice a payment or refund belongs to, preferring
+// the invoice id it was created with.
+func invoiceOf(metadata map[string]string, paymentID string) bson.M {
+	if id := metadata["invoice_id"]; id != "" {
+		return bson.M{"_id": id}
+	}
+	return bson.M{"payment_id": paymentID}
+}
+
+func handlePayment(ctx context.Context, db *mongo.Client, eventType string, payment *payments.EventPayment) error {
+	invoices := database.GetCollection(db, "life-signal", "invoices")
+	invoice, err := database.UpdateInvoice(ctx, invoices, invoiceOf(payment.Metadata, payment.ID), func(invoice *models.Invoice) error {
+		switch eventType {
+		case payments.EventPaymentSucceeded:
+			invoice.PaymentID = payment.ID
+			invoice.PaymentError = ""
+			invoice.AmountPaid = max(invoice.AmountPaid, payment.AmountReceived)
+			if invoice.PaidAt == nil {
+				now := time.Now()
+				invoice.PaidAt = &now
+			}
+			settle(invoice)
+		case payments.EventPaymentFailed:
+			invoice.PaymentError = payment.FailureMessage
+			if invoice.PaymentError == "" {
+				invoice.PaymentError = "payment failed"
+			}
+		case payments.EventPaymentCanceled:
+			if invoice.PaymentID == payment.ID {
+				invoice.PaymentID = ""
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply payment: %w", err)
+	}
+	if invoice == nil {
+		slog.Warn("Payment event for unknown invoice", "paymentID", payment.ID, "type", eventType)
+		return nil
+	}
+	// A payment that went through after its booking was cancelled and the
+	// invoice voided is given back in full.
+	if eventType == payments.EventPaymentSucceeded && invoice.Status == models.InvoiceVoid && invoice.AmountPaid > invoice.AmountRefunded {
+		if _, err := Refund(ctx, db, invoice.ID, 0, "invoice void", "refund-"+invoice.ID+"-void"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func handleRefund(ctx context.Context, db *mongo.Client, refund *payments.Refund) error {
+	invoices := database.GetCollection(db, "life-signal", "invoices")
+	invoice, err := database.UpdateInvoice(ctx, invoices, invoiceOf(refund.Metadata, refund.PaymentID), func(invoice *models.Invoice) error {
+		recordRefund(invoice, refund, refund.Metadata["reason"], "")
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply refund: %w", err)
+	}
+	if invoice == nil {
+		slog.Warn("Refund event for unknown invoice", "refundID", refund.ID, "paymentID", refund.PaymentID)
+	}
+	return nil
+}
+
+// recordRefund adds refund to invoice or updates the one already there,
+// then settles the invoice's amounts and status. key is the idempotency key
+// the refund was requested with, empty when it comes from a webhook.
+func recordRefund(invoice *models.Invoice, refund *payments.Refund, reason, key string) {
+	found := false
+	for i := range invoice.Refunds {
+		if invoice.Refunds[i].ID == refund.ID {
+			invoice.Refunds[i].Status = refund.Status
+			invoice.Refunds[i].Amount = refund.Amount
+			if key != "" {
+				invoice.Refunds[i].IdempotencyKey = key
+			}
+			found = true
+		}
+	}
+	if !found {
+		invoice.Refunds = append(invoice.Refunds, models.InvoiceRefund{
+			ID:             refund.ID,
+			Amount:         refund.Amount,
+			Reason:         reason,
+			Status:         refund.Status,
+			IdempotencyKey: key,
+			CreatedAt:      time.Now(),
+		})
+	}
+	settle(invoice)
+}
+
+// settle works out the refunded amount and the status of invoice. Pending
+// refunds count as refunded, so the same money is never refunded twice; a
+// void invoice stays void.
+func settle(invoice *models.Invoice) {
+	invoice.AmountRefunded = 0
+	for _, refund := range invoice.Refunds {
+		if refund.Status != payments.RefundFailed && refund.Status != payments.RefundCanceled {
+			invoice.AmountRefunded += refund.Amount
+		}
+	}
+	if invoice.Status == models.InvoiceVoid || invoice.AmountPaid == 0 {
+		return
+	}
+	switch {
+	case invoice.AmountRefunded >= invoice.AmountPaid:
+		invoice.Status = models.InvoiceRefunded
+	case invoice.AmountRefunded > 0:
+		invoice.Status = models.InvoicePartiallyRefunded
+	default:
+		invoice.Status = models.InvoicePaid
+	}
+}
+
+// Refund gives back amount of what was paid on the invoice, or all that is
+// left when amount is 0. key makes the refund idempotent: a retry with the
+// key of a refund already recorded returns the invoice unchanged, and the
+// provider answers a concurrent one with the same refund.
+func Refund(ctx context.Context, db *mongo.Client, invoiceID string, amount int64, reason, key string) (*models.Invoice, error) {
+	invoices := database.GetCollection(db, "life-signal", "invoices")
+	invoice, err := database.FindInvoice(ctx, invoices, bson.M{"_id": invoiceID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find invoice: %w", err)
+	}
+	if invoice == nil || invoice.PaymentID == "" {
+		return nil, ErrNothingToRefund
+	}
+	for _, refund := range invoice.Refunds {
+		if key != "" && refund.IdempotencyKey == key {
+			return invoice, nil
+		}
+	}
+	refundable := invoice.AmountPaid - invoice.AmountRefunded
+	if refundable <= 0 {
+		return nil, ErrNothingToRefund
+	}
+	if amount == 0 {
+		amount = refundable
+	}
+	if amount > refundable {
+		return nil, ErrRefundTooLarge
+	}
+
+	refund, err := payments.Current().Refund(ctx, payments.RefundReq{
+		PaymentID:      invoice.PaymentID,
+		Amount:         amount,
+		Reason:         reason,
+		Metadata:       map[string]string{"invoice_id": invoice.ID, "booking_id": invoice.BookingID},
+		IdempotencyKey: key,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to refund payment: %w", err)
+	}
+	updated, err := database.UpdateInvoice(ctx, invoices, bson.M{"_id": invoice.ID}, func(invoice *models.Invoice) error {
+		recordRefund(invoice, refund, reason, key)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save refund: %w", err)
+	}
+	return updated, nil
+}
+
+// RefundPercent is the share of the payment for a cancelled booking that is
+// refunded: all of it when the doctor cancelled or the patient gave at least
+// the cancellation window's notice, and the late cancellation share
+// otherwise.
+func RefundPercent(booking *models.Booking) int64 {
+	if booking.Status != models.BookingCancelled {
+		return 0
+	}
+	if booking.CancelledBy != "patient" {
+		return 100
+	}
+	if booking.CancelledAt != nil && !booking.CancelledAt.After(booking.Start.Add(-CancellationWindow())) {
+		return 100
+	}
+	return Current().LateRefundPercent
+}
+
+// SettleCancellation closes the invoice of a cancelled booking. An unpaid
+// invoice is voided and its payment canceled; a paid one is refunded under
+// the cancellation policy.
+func SettleCancellation(ctx context.Context, db *mongo.Client, bookingID string) error {
+	booking, err := database.FindBooking(ctx, database.GetCollection(db, "life-signal", "appointments"), bson.M{"_id": bookingID})
+	if err != nil {
+		return fmt.Errorf("failed to find booking: %w", err)
+	}
+	invoices := database.GetCollection(db, "life-signal", "invoices")
+	invoice, err := database.FindInvoice(ctx, invoices, bson.M{"booking_id": bookingID})
+	if err != nil {
+		return fmt.Errorf("failed to find invoice: %w", err)
+	}
+	if booking == nil || invoice == nil || invoice.Status == models.InvoiceVoid {
+		return nil
+	}
+
+	if invoice.Status == models.InvoiceOpen && invoice.AmountPaid == 0 {
+		if invoice.PaymentID != "" {
+			provider := payments.Current()
+			if err := provider.CancelPayment(ctx, invoice.PaymentID, "cancel-"+invoice.PaymentID); err != nil {
+				payment, getErr := provider.GetPayment(ctx, invoice.PaymentID)
+				if getErr != nil {
+					return fmt.Errorf("failed to cancel payment: %w", err)
+				}
+				// A payment that went through first is refunded under the
+				// policy once its webhook marks the invoice paid, on a
+				// later attempt of this job.
+				if payment.Status != payments.PaymentCanceled {
+					return fmt.Errorf("payment %s is %s and could not be canceled: %w", payment.ID, payment.Status, err)
+				}
+			}
+		}
+		voided, err := database.UpdateInvoice(ctx, invoices, bson.M{"_id": invoice.ID, "status": models.InvoiceOpen, "amount_paid": 0}, func(invoice *models.Invoice) error {
+			now := time.Now()
+			invoice.Status = models.InvoiceVoid
+			invoice.VoidedAt = &now
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to void invoice: %w", err)
+		}
+		if voided == nil {
+			return fmt.Errorf("invoice %s was paid while being voided", invoice.ID)
+		}
+		discounts := database.GetCollection(db, "life-signal", "discounts")
+		for _, discount := range voided.Discounts {
+			if err := database.ReleaseDiscount(ctx, discounts, discount.Code); err != nil {
+				slog.Warn("Failed to release discount code", "invoiceID", voided.ID, "code", discount.Code, "error", err)
+			}
+		}
+		return nil
+	}
+
+	amount := invoice.AmountPaid*RefundPercent(booking)/100 - invoice.AmountRefunded
+	if amount <= 0 {
+		return nil
+	}
+	_, err = Refund(ctx, db, invoice.ID, amount, "cancelled by "+booking.CancelledBy, "refund-"+invoice.ID+"-cancellation")
+	return err
+}
@@ -0,0 +1,70 @@
+package bulk
+
+import (
+	"fmt"
+)
+
+// Kind is a collection that can be exported and imported in bulk.
+type Kind string
+
+const (
+	Doctors          Kind = "doctors"
+	MedicalHistories Kind = "medical-histories"
+)
+
+func ParseKind(value string) (Kind, error) {
+	switch Kind(value) {
+	case Doctors, MedicalHistories:
+		return Kind(value), nil
+	}
+	return "", fmt.Errorf("kind must be %s or %s", Doctors, MedicalHistories)
+}
+
+func (k Kind) collection() string {
+	if k == Doctors {
+		return "doctors"
+	}
+	return "user-medical-history"
+}
+
+type Format string
+
+const (
+	CSV    Format = "csv"
+	NDJSON Format = "ndjson"
+)
+
+func ParseFormat(value string) (Format, error) {
+	switch Format(value) {
+	case CSV, NDJSON:
+		return Format(value), nil
+	}
+	return "", fmt.Errorf("format must be %s or %s", CSV, NDJSON)
+}
+
+func (f Format) ContentType() string {
+	if f == CSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+// Stats counts what an import did so far. Rows excludes the CSV header.
+type Stats struct {
+	Rows     int  `json:"rows"`
+	Valid    int  `json:"valid"`
+	Invalid  int  `json:"invalid"`
+	Inserted int  `json:"inserted"`
+	Updated  int  `json:"updated"`
+	Failed   int  `json:"failed"`
+	Warnings int  `json:"warnings"`
+	DryRun   bool `json:"dry_run"`
+}
+
+// RowError reports a row that was not imported. Line is the line the row
+// starts on in the input, counting from 1.
+type RowError struct {
+	Line  int    `json:"line"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error"`
+}
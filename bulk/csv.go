@@ -0,0 +1,182 @@
+package bulk
+
+import (
+	"encoding/json"
+	"fmt"
+	"life-signal/geocode"
+	"life-signal/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSV columns. Doctor list fields are separated by semicolons; the entries of
+// a medical history are JSON arrays, one column per list.
+var (
+	doctorColumns = []string{
+		"id", "first_name", "last_name", "speciality", "phone", "email",
+		"clinic_name", "clinic_address", "profile_picture", "rating", "experience", "fee",
+		"availability", "languages", "qualifications", "services", "about",
+		"linkedin", "twitter", "facebook", "instagram", "website", "latitude", "longitude", "created_at",
+	}
+	historyColumns = []string{
+		"user_id", "medical_issues", "prescriptions", "appointments", "allergies", "immunizations",
+	}
+)
+
+func columns(kind Kind) []string {
+	if kind == Doctors {
+		return doctorColumns
+	}
+	return historyColumns
+}
+
+func joinList(values []string) string {
+	return strings.Join(values, "; ")
+}
+
+func splitList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ";") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func doctorRecord(doctor models.Doctor) []string {
+	created := ""
+	if !doctor.CreatedAt.IsZero() {
+		created = doctor.CreatedAt.UTC().Format(time.RFC3339)
+	}
+	latitude, longitude := "", ""
+	if doctor.Location != nil && len(doctor.Location.Coordinates) == 2 {
+		longitude = strconv.FormatFloat(doctor.Location.Coordinates[0], 'f', -1, 64)
+		latitude = strconv.FormatFloat(doctor.Location.Coordinates[1], 'f', -1, 64)
+	}
+	return []string{
+		doctor.ID, doctor.FirstName, doctor.LastName, doctor.Speciality, doctor.Phone, doctor.Email,
+		doctor.ClinicName, doctor.ClinicAddress, doctor.ProfilePicture,
+		strconv.FormatFloat(float64(doctor.Rating), 'f', -1, 32), strconv.Itoa(doctor.Experience),
+		strconv.FormatFloat(doctor.Fee, 'f', -1, 64),
+		joinList(doctor.Availability), joinList(doctor.Languages), joinList(doctor.Qualifications), joinList(doctor.Services),
+		doctor.About, doctor.SocialLinks.LinkedIn, doctor.SocialLinks.Twitter, doctor.SocialLinks.Facebook,
+		doctor.SocialLinks.Instagram, doctor.SocialLinks.Website, latitude, longitude, created,
+	}
+}
+
+// parseDoctor reads a CSV row through header, which maps column names to
+// positions. Missing optional columns are left empty.
+func parseDoctor(header map[string]int, row []string) (models.Doctor, error) {
+	get := func(column string) string {
+		if i, ok := header[column]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+	doctor := models.Doctor{
+		ID:             get("id"),
+		FirstName:      get("first_name"),
+		LastName:       get("last_name"),
+		Speciality:     get("speciality"),
+		Phone:          get("phone"),
+		Email:          get("email"),
+		ClinicName:     get("clinic_name"),
+		ClinicAddress:  get("clinic_address"),
+		ProfilePicture: get("profile_picture"),
+		Availability:   splitList(get("availability")),
+		Languages:      splitList(get("languages")),
+		Qualifications: splitList(get("qualifications")),
+		Services:       splitList(get("services")),
+		About:          get("about"),
+		SocialLinks: models.Social{
+			LinkedIn:  get("linkedin"),
+			Twitter:   get("twitter"),
+			Facebook:  get("facebook"),
+			Instagram: get("instagram"),
+			Website:   get("website"),
+		},
+	}
+	if value := get("rating"); value != "" {
+		rating, err := strconv.ParseFloat(value, 32)
+		if err != nil {
+			return doctor, fmt.Errorf("rating %q is not a number", value)
+		}
+		doctor.Rating = float32(rating)
+	}
+	if value := get("experience"); value != "" {
+		experience, err := strconv.Atoi(value)
+		if err != nil {
+			return doctor, fmt.Errorf("experience %q is not a whole number", value)
+		}
+		doctor.Experience = experience
+	}
+	if value := get("fee"); value != "" {
+		fee, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return doctor, fmt.Errorf("fee %q is not a number", value)
+		}
+		doctor.Fee = fee
+	}
+	latitude, longitude := get("latitude"), get("longitude")
+	if (latitude == "") != (longitude == "") {
+		return doctor, fmt.Errorf("latitude and longitude must be given together")
+	}
+	if latitude != "" {
+		lat, err := strconv.ParseFloat(latitude, 64)
+		if err != nil {
+			return doctor, fmt.Errorf("latitude %q is not a number", latitude)
+		}
+		lng, err := strconv.ParseFloat(longitude, 64)
+		if err != nil {
+			return doctor, fmt.Errorf("longitude %q is not a number", longitude)
+		}
+		location := geocode.Point(lat, lng)
+		doctor.Location = &location
+	}
+	if value := get("created_at"); value != "" {
+		created, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return doctor, fmt.Errorf("created_at %q is not an RFC 3339 timestamp", value)
+		}
+		doctor.CreatedAt = created
+	}
+	return doctor, nil
+}
+
+func historyRecord(history models.MedicalHistory) ([]string, error) {
+	record := []string{history.UserID}
+	for _, list := range []any{history.MedicalIssues, history.Prescriptions, history.Appointments, history.Allergies, history.Immunizations} {
+		encoded, err := json.Marshal(list)
+		if err != nil {
+			return nil, err
+		}
+		record = append(record, string(encoded))
+	}
+	return record, nil
+}
+
+func parseHistory(header map[string]int, row []string) (models.MedicalHistory, error) {
+	history := models.MedicalHistory{}
+	if i, ok := header["user_id"]; ok && i < len(row) {
+		history.UserID = strings.TrimSpace(row[i])
+	}
+	targets := map[string]any{
+		"medical_issues": &history.MedicalIssues,
+		"prescriptions":  &history.Prescriptions,
+		"appointments":   &history.Appointments,
+		"allergies":      &history.Allergies,
+		"immunizations":  &history.Immunizations,
+	}
+	for _, column := range historyColumns[1:] {
+		i, ok := header[column]
+		if !ok || i >= len(row) || strings.TrimSpace(row[i]) == "" {
+			continue
+		}
+		if err := strictUnmarshal([]byte(row[i]), targets[column]); err != nil {
+			return history, fmt.Errorf("%s: %v", column, err)
+		}
+	}
+	return history, nil
+}
@@ -0,0 +1,103 @@
+package bulk
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"life-signal/database"
+	"life-signal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// flusher is implemented by HTTP response writers.
+type flusher interface {
+	Flush()
+}
+
+const exportFlushEvery = 100
+
+// Export streams every record of kind to w in format and returns how many
+// were written. Records go out as the cursor yields them, so memory use does
+// not grow with the collection. onRecord, if set, is called with the ID of
+// each record written.
+func Export(ctx context.Context, db *mongo.Client, kind Kind, format Format, w io.Writer, onRecord func(id string)) (int, error) {
+	collection := database.GetCollection(db, "life-signal", kind.collection())
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return 0, fmt.Errorf("failed to query %s: %w", kind, err)
+	}
+	defer cursor.Close(ctx)
+
+	var csvWriter *csv.Writer
+	encoder := json.NewEncoder(w)
+	if format == CSV {
+		csvWriter = csv.NewWriter(w)
+		if err := csvWriter.Write(columns(kind)); err != nil {
+			return 0, err
+		}
+	}
+
+	count := 0
+	for cursor.Next(ctx) {
+		var record []string
+		var value any
+		var id string
+		switch kind {
+		case Doctors:
+			var doctor models.Doctor
+			if err := cursor.Decode(&doctor); err != nil {
+				return count, fmt.Errorf("failed to decode doctor: %w", err)
+			}
+			value, record, id = doctor, doctorRecord(doctor), doctor.ID
+		case MedicalHistories:
+			history, err := database.DecodeMedicalHistory(ctx, cursor.Current)
+			if err != nil {
+				return count, fmt.Errorf("failed to decode medical history: %w", err)
+			}
+			value, id = history, history.UserID
+			if format == CSV {
+				if record, err = historyRecord(*history); err != nil {
+					return count, fmt.Errorf("failed to encode medical history: %w", err)
+				}
+			}
+		}
+
+		if format == CSV {
+			err = csvWriter.Write(record)
+		} else {
+			err = encoder.Encode(value)
+		}
+		if err != nil {
+			return count, fmt.Errorf("failed to write record: %w", err)
+		}
+		count++
+		if onRecord != nil {
+			onRecord(id)
+		}
+		if count%exportFlushEvery == 0 {
+			flush(w, csvWriter)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return count, fmt.Errorf("failed to iterate %s: %w", kind, err)
+	}
+	flush(w, csvWriter)
+	if csvWriter != nil {
+		return count, csvWriter.Error()
+	}
+	return count, nil
+}
+
+func flush(w io.Writer, csvWriter *csv.Writer) {
+	if csvWriter != nil {
+		csvWriter.Flush()
+	}
+	if f, ok := w.(flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,466 @@
+package bulk
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"life-signal/database"
+	"life-signal/dosage"
+	"life-signal/geocode"
+	"life-signal/helpers"
+	"life-signal/interactions"
+	"life-signal/models"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	DefaultBatchSize = 500
+	MaxBatchSize     = 5000
+	// maxLineBytes bounds a single NDJSON line so a malformed file cannot
+	// make the import buffer without limit.
+	maxLineBytes = 4 << 20
+)
+
+// Options tune an import. Histories whose new prescriptions have blocking
+// interactions are rejected unless OverrideInteractions is set; the findings
+// are reported through OnWarning either way.
+type Options struct {
+	DryRun               bool
+	BatchSize            int
+	OverrideInteractions bool
+	OnError              func(RowError)
+	OnWarning            func(RowError)
+	OnProgress           func(Stats)
+}
+
+type row struct {
+	line    int
+	doctor  models.Doctor
+	history models.MedicalHistory
+}
+
+func (r row) id(kind Kind) string {
+	if kind == Doctors {
+		return r.doctor.ID
+	}
+	return r.history.UserID
+}
+
+// rowError is a row that could not be parsed. The import reports it and
+// carries on with the next row.
+type rowError struct {
+	line int
+	err  error
+}
+
+func (e *rowError) Error() string {
+	return e.err.Error()
+}
+
+// source yields parsed rows one at a time and io.EOF at the end.
+type source interface {
+	next() (row, error)
+}
+
+// strictUnmarshal decodes one JSON value, rejecting unknown fields.
+func strictUnmarshal(data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if decoder.More() {
+		return fmt.Errorf("unexpected data after JSON value")
+	}
+	return nil
+}
+
+type csvSource struct {
+	kind   Kind
+	reader *csv.Reader
+	header map[string]int
+}
+
+func newCSVSource(kind Kind, r io.Reader) (*csvSource, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	names, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("input is empty, expected a header row")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header row: %w", err)
+	}
+	known := map[string]bool{}
+	for _, column := range columns(kind) {
+		known[column] = true
+	}
+	header := map[string]int{}
+	for i, name := range names {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		if !known[name] {
+			return nil, fmt.Errorf("unknown column %q, expected some of %s", name, strings.Join(columns(kind), ", "))
+		}
+		header[name] = i
+	}
+	required := []string{"user_id"}
+	if kind == Doctors {
+		required = []string{"first_name", "last_name", "speciality"}
+	}
+	for _, column := range required {
+		if _, ok := header[column]; !ok {
+			return nil, fmt.Errorf("column %q is required", column)
+		}
+	}
+	return &csvSource{kind: kind, reader: reader, header: header}, nil
+}
+
+func (s *csvSource) next() (row, error) {
+	record, err := s.reader.Read()
+	if err == io.EOF {
+		return row{}, io.EOF
+	}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return row{}, &rowError{line: parseErr.StartLine, err: parseErr.Err}
+	}
+	if err != nil {
+		return row{}, err
+	}
+	line, _ := s.reader.FieldPos(0)
+	r := row{line: line}
+	if s.kind == Doctors {
+		r.doctor, err = parseDoctor(s.header, record)
+	} else {
+		r.history, err = parseHistory(s.header, record)
+	}
+	if err != nil {
+		return r, &rowError{line: line, err: err}
+	}
+	return r, nil
+}
+
+type ndjsonSource struct {
+	kind    Kind
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newNDJSONSource(kind Kind, r io.Reader) *ndjsonSource {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineBytes)
+	return &ndjsonSource{kind: kind, scanner: scanner}
+}
+
+func (s *ndjsonSource) next() (row, error) {
+	for s.scanner.Scan() {
+		s.line++
+		data := bytes.TrimSpace(s.scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		r := row{line: s.line}
+		var err error
+		if s.kind == Doctors {
+			err = strictUnmarshal(data, &r.doctor)
+		} else {
+			err = strictUnmarshal(data, &r.history)
+		}
+		if err != nil {
+			return r, &rowError{line: s.line, err: err}
+		}
+		return r, nil
+	}
+	if err := s.scanner.Err(); err != nil {
+		return row{}, fmt.Errorf("failed to read line %d: %w", s.line+1, err)
+	}
+	return row{}, io.EOF
+}
+
+// prepare fills in defaults and validates a row before it joins a batch.
+// Doctors without a location are geocoded from their clinic address; an
+// address the geocoder does not know leaves the location empty.
+func prepare(ctx context.Context, kind Kind, r *row, now time.Time) error {
+	if kind == Doctors {
+		if err := helpers.ValidateDoctor(r.doctor); err != nil {
+			return err
+		}
+		if r.doctor.Location == nil && r.doctor.ClinicAddress != "" {
+			if location, err := geocode.Current().Geocode(ctx, r.doctor.ClinicAddress); err == nil {
+				r.doctor.Location = &location
+			}
+		}
+		if r.doctor.ID == "" {
+			r.doctor.ID = uuid.New().String()
+		}
+		if r.doctor.CreatedAt.IsZero() {
+			r.doctor.CreatedAt = now
+		}
+		// Imported doctors join the review queue like onboarded ones.
+		if r.doctor.Verification.Status == "" {
+			r.doctor.Verification = models.Verification{Status: models.DoctorPending, SubmittedAt: &now}
+		}
+		return nil
+	}
+	if r.history.UserID == "" {
+		return fmt.Errorf("user_id is required")
+	}
+	dosage.NormalizeHistory(&r.history)
+	return helpers.ValidateMedicalHistory(r.history, r.history.UserID)
+}
+
+type importer struct {
+	kind    Kind
+	db      *mongo.Client
+	options Options
+	stats   Stats
+}
+
+func (im *importer) reject(line int, id string, err error) {
+	im.stats.Invalid++
+	if im.options.OnError != nil {
+		im.options.OnError(RowError{Line: line, ID: id, Error: err.Error()})
+	}
+}
+
+func (im *importer) warn(line int, id string, finding interactions.Finding) {
+	im.stats.Warnings++
+	if im.options.OnWarning != nil {
+		im.options.OnWarning(RowError{Line: line, ID: id, Error: finding.String()})
+	}
+}
+
+func (im *importer) fail(line int, id string, err error) {
+	im.stats.Failed++
+	if im.options.OnError != nil {
+		im.options.OnError(RowError{Line: line, ID: id, Error: err.Error()})
+	}
+}
+
+// Import reads records of kind from r, validates each one and upserts them
+// in batches. Rows that fail are reported through OnError and skipped; only
+// a problem with the input as a whole or the database ends the import early.
+// Memory use is bounded by the batch size, not the input.
+func Import(ctx context.Context, db *mongo.Client, kind Kind, format Format, r io.Reader, opts Options) (Stats, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.BatchSize > MaxBatchSize {
+		opts.BatchSize = MaxBatchSize
+	}
+	im := &importer{kind: kind, db: db, options: opts, stats: Stats{DryRun: opts.DryRun}}
+
+	var src source = newNDJSONSource(kind, r)
+	if format == CSV {
+		csvSrc, err := newCSVSource(kind, r)
+		if err != nil {
+			return im.stats, err
+		}
+		src = csvSrc
+	}
+
+	batch := make([]row, 0, opts.BatchSize)
+	ids := map[string]bool{}
+	for {
+		next, err := src.next()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *rowError
+		if errors.As(err, &rowErr) {
+			im.stats.Rows++
+			im.reject(rowErr.line, next.id(kind), rowErr.err)
+			continue
+		}
+		if err != nil {
+			return im.stats, err
+		}
+		im.stats.Rows++
+		if err := prepare(ctx, kind, &next, time.Now()); err != nil {
+			im.reject(next.line, next.id(kind), err)
+			continue
+		}
+		// A batch must not hold the same record twice, or the second write
+		// would race the first.
+		if ids[next.id(kind)] {
+			if err := im.flush(ctx, batch); err != nil {
+				return im.stats, err
+			}
+			batch, ids = batch[:0], map[string]bool{}
+		}
+		batch = append(batch, next)
+		ids[next.id(kind)] = true
+		if len(batch) == opts.BatchSize {
+			if err := im.flush(ctx, batch); err != nil {
+				return im.stats, err
+			}
+			batch, ids = batch[:0], map[string]bool{}
+		}
+	}
+	if err := im.flush(ctx, batch); err != nil {
+		return im.stats, err
+	}
+	return im.stats, nil
+}
+
+func (im *importer) flush(ctx context.Context, batch []row) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	var err error
+	if im.kind == Doctors {
+		err = im.writeDoctors(ctx, batch)
+	} else {
+		err = im.writeHistories(ctx, batch)
+	}
+	if err != nil {
+		return err
+	}
+	if im.options.OnProgress != nil {
+		im.options.OnProgress(im.stats)
+	}
+	return nil
+}
+
+func (im *importer) writeDoctors(ctx context.Context, batch []row) error {
+	im.stats.Valid += len(batch)
+	if im.options.DryRun {
+		return nil
+	}
+	writes := make([]mongo.WriteModel, len(batch))
+	for i, r := range batch {
+		writes[i] = mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": r.doctor.ID}).SetUpdate(doctorUpdate(r.doctor)).SetUpsert(true)
+	}
+	collection := database.GetCollection(im.db, "life-signal", Doctors.collection())
+	result, err := collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	if result != nil {
+		im.stats.Inserted += int(result.UpsertedCount)
+		im.stats.Updated += int(result.MatchedCount)
+	}
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil {
+		for _, writeErr := range bulkErr.WriteErrors {
+			r := batch[writeErr.Index]
+			im.fail(r.line, r.doctor.ID, writeErr)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write doctors: %w", err)
+	}
+	return nil
+}
+
+// doctorUpdate sets the profile fields an import carries. The rating,
+// reviews, credentials and verification belong to the running system and are
+// only written when the doctor is new.
+func doctorUpdate(doctor models.Doctor) bson.M {
+	set := bson.M{
+		"first_name":      doctor.FirstName,
+		"last_name":       doctor.LastName,
+		"speciality":      doctor.Speciality,
+		"phone":           doctor.Phone,
+		"email":           doctor.Email,
+		"clinic_name":     doctor.ClinicName,
+		"clinic_address":  doctor.ClinicAddress,
+		"profile_picture": doctor.ProfilePicture,
+		"experience":      doctor.Experience,
+		"availability":    doctor.Availability,
+		"fee":             doctor.Fee,
+		"languages":       doctor.Languages,
+		"qualifications":  doctor.Qualifications,
+		"services":        doctor.Services,
+		"about":           doctor.About,
+		"social_links":    doctor.SocialLinks,
+	}
+	onInsert := bson.M{
+		"created_at":   doctor.CreatedAt,
+		"verification": doctor.Verification,
+		"rating":       0,
+		"review_count": 0,
+	}
+	if doctor.LicenceNumber != "" {
+		onInsert["licence_number"] = doctor.LicenceNumber
+	}
+	update := bson.M{"$set": set, "$setOnInsert": onInsert}
+	if doctor.Location != nil {
+		set["location"] = doctor.Location
+	} else {
+		update["$unset"] = bson.M{"location": ""}
+	}
+	return update
+}
+
+func (im *importer) writeHistories(ctx context.Context, batch []row) error {
+	userIDs := make([]string, len(batch))
+	for i, r := range batch {
+		userIDs[i] = r.history.UserID
+	}
+	users := database.GetCollection(im.db, "life-signal", "users")
+	found, err := users.Distinct(ctx, "_id", bson.M{"_id": bson.M{"$in": userIDs}})
+	if err != nil {
+		return fmt.Errorf("failed to look up users: %w", err)
+	}
+	exists := map[string]bool{}
+	for _, id := range found {
+		if s, ok := id.(string); ok {
+			exists[s] = true
+		}
+	}
+	collection := database.GetCollection(im.db, "life-signal", MedicalHistories.collection())
+	stored, err := database.FindMedicalHistories(ctx, collection, userIDs)
+	if err != nil {
+		return fmt.Errorf("failed to look up medical histories: %w", err)
+	}
+	dataset := interactions.Current()
+	valid := make([]row, 0, len(batch))
+	for _, r := range batch {
+		if !exists[r.history.UserID] {
+			im.reject(r.line, r.history.UserID, fmt.Errorf("user %s does not exist", r.history.UserID))
+			continue
+		}
+		findings := dataset.CheckWrite(stored[r.history.UserID], &r.history)
+		if interactions.HasBlocking(findings) && !im.options.OverrideInteractions {
+			im.reject(r.line, r.history.UserID, fmt.Errorf("prescriptions conflict with the patient's medications or allergies: %s", findings[0]))
+			continue
+		}
+		for _, finding := range findings {
+			im.warn(r.line, r.history.UserID, finding)
+		}
+		valid = append(valid, r)
+	}
+	im.stats.Valid += len(valid)
+	if im.options.DryRun || len(valid) == 0 {
+		return nil
+	}
+
+	histories := make([]models.MedicalHistory, len(valid))
+	for i, r := range valid {
+		histories[i] = r.history
+		database.AssignEntryIDs(stored[r.history.UserID], &histories[i])
+	}
+	created, replaced, errs, err := database.ReplaceMedicalHistories(ctx, collection, histories)
+	im.stats.Inserted += created
+	im.stats.Updated += replaced
+	if err != nil {
+		return fmt.Errorf("failed to write medical histories: %w", err)
+	}
+	for i, writeErr := range errs {
+		if writeErr != nil {
+			im.fail(valid[i].line, valid[i].history.UserID, writeErr)
+		}
+	}
+	return nil
+}
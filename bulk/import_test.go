@@ -0,0 +1,175 @@
+package bulk
+
+import (
+	"context"
+	"errors"
+	"life-signal/geocode"
+	"life-signal/models"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type stubGeocoder map[string]models.GeoPoint
+
+func (g stubGeocoder) Geocode(ctx context.Context, address string) (models.GeoPoint, error) {
+	if point, ok := g[address]; ok {
+		return point, nil
+	}
+	return models.GeoPoint{}, errors.New("unknown address")
+}
+
+func TestDoctorCSVRoundTrip(t *testing.T) {
+	location := geocode.Point(12.97, 77.59)
+	doctor := models.Doctor{
+		ID: "doctor-1", FirstName: "Ravi", LastName: "Mehta", Speciality: "Endocrinology",
+		Phone: "+15555550100", Email: "ravi@example.com", ClinicName: "Mehta Clinic", ClinicAddress: "MG Road, Bengaluru",
+		Rating: 4.5, Experience: 12, Fee: 800,
+		Availability: []string{"Mon", "Wed"}, Languages: []string{"English", "Kannada"},
+		Qualifications: []string{"MBBS", "MD"}, Services: []string{"Diabetes care"}, About: "Thyroid, diabetes",
+		SocialLinks: models.Social{Website: "https://mehta.example.com"},
+		Location:    &location,
+		CreatedAt:   time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC),
+	}
+	header := map[string]int{}
+	for i, column := range doctorColumns {
+		header[column] = i
+	}
+	parsed, err := parseDoctor(header, doctorRecord(doctor))
+	if err != nil {
+		t.Fatalf("parseDoctor: %v", err)
+	}
+	if !reflect.DeepEqual(parsed, doctor) {
+		t.Errorf("round trip changed the doctor:\n got %+v\nwant %+v", parsed, doctor)
+	}
+}
+
+func TestParseDoctorRejectsBadValues(t *testing.T) {
+	header := map[string]int{"first_name": 0, "last_name": 1, "speciality": 2, "fee": 3, "latitude": 4, "longitude": 5}
+	tests := []struct {
+		name string
+		row  []string
+		want string
+	}{
+		{"fee not a number", []string{"Ravi", "Mehta", "ENT", "free", "", ""}, "fee"},
+		{"latitude without longitude", []string{"Ravi", "Mehta", "ENT", "", "12.9", ""}, "together"},
+		{"longitude not a number", []string{"Ravi", "Mehta", "ENT", "", "12.9", "east"}, "longitude"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := parseDoctor(header, test.row); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("parseDoctor = %v, want an error mentioning %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestPrepareDoctor(t *testing.T) {
+	location := geocode.Point(12.97, 77.59)
+	geocode.Configure(stubGeocoder{"MG Road, Bengaluru": location})
+	t.Cleanup(func() { geocode.Configure(stubGeocoder{}) })
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	r := row{doctor: models.Doctor{FirstName: "Ravi", LastName: "Mehta", Speciality: "ENT", ClinicAddress: "MG Road, Bengaluru"}}
+	if err := prepare(context.Background(), Doctors, &r, now); err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	if r.doctor.ID == "" || !r.doctor.CreatedAt.Equal(now) {
+		t.Errorf("prepare left id %q and created_at %v unset", r.doctor.ID, r.doctor.CreatedAt)
+	}
+	if r.doctor.Location == nil || !reflect.DeepEqual(*r.doctor.Location, location) {
+		t.Errorf("location %v, want the geocoded clinic %v", r.doctor.Location, location)
+	}
+	if r.doctor.Verification.Status != models.DoctorPending {
+		t.Errorf("imported doctor has status %q, want %s", r.doctor.Verification.Status, models.DoctorPending)
+	}
+
+	unknown := row{doctor: models.Doctor{FirstName: "Ravi", LastName: "Mehta", Speciality: "ENT", ClinicAddress: "Nowhere"}}
+	if err := prepare(context.Background(), Doctors, &unknown, now); err != nil || unknown.doctor.Location != nil {
+		t.Errorf("prepare with an unknown address = %v, location %v", err, unknown.doctor.Location)
+	}
+
+	invalid := row{doctor: models.Doctor{FirstName: "Ravi", LastName: "Mehta"}}
+	if err := prepare(context.Background(), Doctors, &invalid, now); err == nil {
+		t.Error("prepare accepted a doctor without a speciality")
+	}
+}
+
+func TestDoctorUpdateKeepsSystemFields(t *testing.T) {
+	doctor := models.Doctor{
+		ID: "doctor-1", FirstName: "Ravi", LastName: "Mehta", Speciality: "ENT",
+		Rating: 5, ReviewCount: 200, RatingVersion: 7, LicenceNumber: "KA-1234",
+		Credentials:  []models.Credential{{AttachmentID: "a1", Kind: "licence"}},
+		Verification: models.Verification{Status: models.DoctorPending},
+	}
+	update := doctorUpdate(doctor)
+	set := update["$set"].(bson.M)
+	for _, field := range []string{"rating", "review_count", "rating_version", "credentials", "verification", "licence_number", "created_at", "user_id"} {
+		if _, ok := set[field]; ok {
+			t.Errorf("$set overwrites %s on existing doctors", field)
+		}
+	}
+	if set["first_name"] != "Ravi" || set["speciality"] != "ENT" {
+		t.Errorf("$set = %v, want the profile fields", set)
+	}
+	onInsert := update["$setOnInsert"].(bson.M)
+	if onInsert["rating"] != 0 || onInsert["review_count"] != 0 {
+		t.Errorf("new doctors start with rating %v and %v reviews, want zero", onInsert["rating"], onInsert["review_count"])
+	}
+	if onInsert["licence_number"] != "KA-1234" {
+		t.Errorf("$setOnInsert licence_number = %v", onInsert["licence_number"])
+	}
+	if _, ok := update["$unset"].(bson.M)["location"]; !ok {
+		t.Error("a doctor without a location keeps the stored one")
+	}
+}
+
+func TestImportDoctorsDryRun(t *testing.T) {
+	geocode.Configure(stubGeocoder{})
+	t.Cleanup(func() { geocode.Configure(stubGeocoder{}) })
+	tests := []struct {
+		name   string
+		format Format
+		input  string
+	}{
+		{"csv", CSV, "\ufefffirst_name,last_name,speciality,fee\n" +
+			"Ravi,Mehta,ENT,800\n" +
+			"Asha,Rao,,100\n" +
+			"Meera,Iyer,Cardiology,lots\n" +
+			"\"Sam,Lee,GP\n"},
+		{"ndjson", NDJSON, `{"first_name":"Ravi","last_name":"Mehta","speciality":"ENT","fee":800}` + "\n\n" +
+			`{"first_name":"Asha","last_name":"Rao","fee":100}` + "\n" +
+			`{"first_name":"Meera","last_name":"Iyer","speciality":"Cardiology","fees":1}` + "\n" +
+			`{"first_name":` + "\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var rowErrors []RowError
+			stats, err := Import(context.Background(), nil, Doctors, test.format, strings.NewReader(test.input), Options{
+				DryRun:  true,
+				OnError: func(e RowError) { rowErrors = append(rowErrors, e) },
+			})
+			if err != nil {
+				t.Fatalf("Import: %v", err)
+			}
+			want := Stats{Rows: 4, Valid: 1, Invalid: 3, DryRun: true}
+			if stats != want {
+				t.Errorf("stats %+v, want %+v", stats, want)
+			}
+			if len(rowErrors) != 3 || rowErrors[0].Line < 2 {
+				t.Errorf("row errors %+v, want three with input line numbers", rowErrors)
+			}
+		})
+	}
+}
+
+func TestImportRejectsBadHeader(t *testing.T) {
+	for _, input := range []string{"", "first_name,last_name\n", "first_name,last_name,speciality,rank\n"} {
+		if _, err := Import(context.Background(), nil, Doctors, CSV, strings.NewReader(input), Options{DryRun: true}); err == nil {
+			t.Errorf("Import accepted the header %q", input)
+		}
+	}
+}
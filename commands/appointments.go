@@ -0,0 +1,54 @@
+package commands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"life-signal/database"
+	"life-signal/models"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func init() {
+	register("record-appointments", "[-dry-run]", recordAppointments)
+}
+
+// recordAppointments adds completed bookings that never made it into their
+// patient's medical history, for instance because the write failed.
+func recordAppointments(ctx context.Context, db *mongo.Client, args []string) error {
+	flags := flag.NewFlagSet("record-appointments", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "list the bookings without recording them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	bookings := database.GetCollection(db, "life-signal", "appointments")
+	histories := database.GetCollection(db, "life-signal", "user-medical-history")
+	pending, err := database.FindBookings(ctx, bookings, bson.M{"status": models.BookingCompleted, "recorded_in_history": false}, time.Time{}, time.Time{})
+	if err != nil {
+		return fmt.Errorf("failed to find bookings: %w", err)
+	}
+	failed := 0
+	for i := range pending {
+		booking := &pending[i]
+		if *dryRun {
+			fmt.Printf("would record %s for %s\n", booking.ID, booking.UserID)
+			continue
+		}
+		if err := database.RecordCompletedBooking(ctx, histories, bookings, booking); err != nil {
+			slog.Error("Recording appointment failed", "appointmentID", booking.ID, "error", err)
+			failed++
+			continue
+		}
+		fmt.Printf("recorded %s for %s\n", booking.ID, booking.UserID)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d appointments failed", failed, len(pending))
+	}
+	fmt.Printf("%d completed appointments to record (dry run: %t)\n", len(pending), *dryRun)
+	return nil
+}
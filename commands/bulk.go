@@ -0,0 +1,121 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"life-signal/bulk"
+	"os"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func init() {
+	register("export-records", "-kind doctors|medical-histories [-format csv|ndjson] [-out <file|->]", exportRecords)
+	register("import-records", "-kind doctors|medical-histories -file <file|-> [-format csv|ndjson] [-dry-run] [-batch-size N] [-override-interactions]", importRecords)
+}
+
+func parseBulkFlags(flags *flag.FlagSet, args []string) (kind *string, format *string, err error) {
+	kind = flags.String("kind", "", "doctors or medical-histories")
+	format = flags.String("format", string(bulk.NDJSON), "csv or ndjson")
+	err = flags.Parse(args)
+	return kind, format, err
+}
+
+func exportRecords(ctx context.Context, db *mongo.Client, args []string) error {
+	flags := flag.NewFlagSet("export-records", flag.ContinueOnError)
+	path := flags.String("out", "-", "output file, - for stdout")
+	kindFlag, formatFlag, err := parseBulkFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	kind, err := bulk.ParseKind(*kindFlag)
+	if err != nil {
+		return err
+	}
+	format, err := bulk.ParseFormat(*formatFlag)
+	if err != nil {
+		return err
+	}
+
+	var output io.Writer = os.Stdout
+	if *path != "-" {
+		file, err := os.Create(*path)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer file.Close()
+		output = file
+	}
+	buffered := bufio.NewWriter(output)
+	count, err := bulk.Export(ctx, db, kind, format, buffered, nil)
+	if err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "exported %d %s\n", count, kind)
+	return nil
+}
+
+// importRecords prints rejected rows as NDJSON on stdout and progress on
+// stderr, so the errors can be redirected to a file and fixed.
+func importRecords(ctx context.Context, db *mongo.Client, args []string) error {
+	flags := flag.NewFlagSet("import-records", flag.ContinueOnError)
+	path := flags.String("file", "", "input file, - for stdin")
+	dryRun := flags.Bool("dry-run", false, "validate every row without saving")
+	batchSize := flags.Int("batch-size", bulk.DefaultBatchSize, "rows written per bulk write")
+	override := flags.Bool("override-interactions", false, "import prescriptions despite blocking interactions")
+	kindFlag, formatFlag, err := parseBulkFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	kind, err := bulk.ParseKind(*kindFlag)
+	if err != nil {
+		return err
+	}
+	format, err := bulk.ParseFormat(*formatFlag)
+	if err != nil {
+		return err
+	}
+	if *path == "" {
+		return fmt.Errorf("-file is required")
+	}
+
+	var input io.Reader = os.Stdin
+	if *path != "-" {
+		file, err := os.Open(*path)
+		if err != nil {
+			return fmt.Errorf("failed to open input: %w", err)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	stats, err := bulk.Import(ctx, db, kind, format, bufio.NewReader(input), bulk.Options{
+		DryRun:               *dryRun,
+		BatchSize:            *batchSize,
+		OverrideInteractions: *override,
+		OnError: func(rowErr bulk.RowError) {
+			encoder.Encode(rowErr)
+		},
+		OnWarning: func(warning bulk.RowError) {
+			fmt.Fprintf(os.Stderr, "line %d (%s): interaction: %s\n", warning.Line, warning.ID, warning.Error)
+		},
+		OnProgress: func(stats bulk.Stats) {
+			fmt.Fprintf(os.Stderr, "%d rows read, %d valid, %d invalid, %d inserted, %d updated, %d failed\n",
+				stats.Rows, stats.Valid, stats.Invalid, stats.Inserted, stats.Updated, stats.Failed)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("import stopped after %d rows: %w", stats.Rows, err)
+	}
+	fmt.Fprintf(os.Stderr, "done: %d rows, %d valid, %d invalid, %d inserted, %d updated, %d failed, %d interaction warnings (dry run: %t)\n",
+		stats.Rows, stats.Valid, stats.Invalid, stats.Inserted, stats.Updated, stats.Failed, stats.Warnings, stats.DryRun)
+	return nil
+}
@@ -0,0 +1,66 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type command struct {
+	usage         string
+	beforeKeys    bool
+	beforeIndexes bool
+	run           func(ctx context.Context, db *mongo.Client, args []string) error
+}
+
+var registry = map[string]command{}
+
+func register(name, usage string, run func(ctx context.Context, db *mongo.Client, args []string) error) {
+	registry[name] = command{usage: usage, run: run}
+}
+
+// registerMigration adds a command that repairs data an index depends on, so
+// it runs before the indexes are created.
+func registerMigration(name, usage string, run func(ctx context.Context, db *mongo.Client, args []string) error) {
+	registry[name] = command{usage: usage, beforeIndexes: true, run: run}
+}
+
+// registerKeySetup adds a command that prepares the encryption keys, so it
+// runs before they are loaded.
+func registerKeySetup(name, usage string, run func(ctx context.Context, db *mongo.Client, args []string) error) {
+	registry[name] = command{usage: usage, beforeKeys: true, run: run}
+}
+
+func RunsBeforeKeys(name string) bool {
+	return registry[name].beforeKeys
+}
+
+func RunsBeforeIndexes(name string) bool {
+	return registry[name].beforeIndexes
+}
+
+// Run executes the maintenance command named by args[0] against db.
+func Run(db *mongo.Client, args []string) error {
+	cmd, ok := registry[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q\n%s", args[0], Usage())
+	}
+	return cmd.run(context.Background(), db, args[1:])
+}
+
+func Usage() string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString("commands:\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "  %s %s\n", name, registry[name].usage)
+	}
+	return b.String()
+}
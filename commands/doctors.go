@@ -0,0 +1,44 @@
+package commands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"life-signal/database"
+	"life-signal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func init() {
+	register("verify-legacy-doctors", "[-dry-run]", verifyLegacyDoctors)
+}
+
+// verifyLegacyDoctors marks doctors created before verification existed as
+// verified, so they keep appearing in listings. Doctors who onboarded
+// themselves always have a status and are left alone.
+func verifyLegacyDoctors(ctx context.Context, db *mongo.Client, args []string) error {
+	flags := flag.NewFlagSet("verify-legacy-doctors", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "count the doctors without changing them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	doctorCollection := database.GetCollection(db, "life-signal", "doctors")
+	filter := bson.M{"verification.status": bson.M{"$in": bson.A{"", nil}}, "user_id": bson.M{"$exists": false}}
+	if *dryRun {
+		count, err := doctorCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			return fmt.Errorf("failed to count doctors: %w", err)
+		}
+		fmt.Printf("%d doctors would be marked verified\n", count)
+		return nil
+	}
+	result, err := doctorCollection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"verification.status": models.DoctorVerified}})
+	if err != nil {
+		return fmt.Errorf("failed to update doctors: %w", err)
+	}
+	fmt.Printf("%d doctors marked verified\n", result.ModifiedCount)
+	return nil
+}
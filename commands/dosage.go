@@ -0,0 +1,76 @@
+package commands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"life-signal/database"
+	"life-signal/dosage"
+	"life-signal/models"
+	"log/slog"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func init() {
+	register("migrate-dosage", "[-dry-run]", migrateDosage)
+}
+
+// migrateDosage parses the free-text dosage of every prescription that has no
+// structured dosage yet. Sigs that cannot be parsed are listed and left alone.
+func migrateDosage(ctx context.Context, db *mongo.Client, args []string) error {
+	flags := flag.NewFlagSet("migrate-dosage", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "report what would change without saving")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	historyCollection := database.GetCollection(db, "life-signal", "user-medical-history")
+	// Prescriptions are encrypted at rest, so every history has to be read.
+	cursor, err := historyCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return fmt.Errorf("failed to query medical histories: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var scanned, migrated, conflicts, parsed, unparsed int
+	for cursor.Next(ctx) {
+		current, err := database.DecodeMedicalHistory(ctx, cursor.Current)
+		if err != nil {
+			return fmt.Errorf("failed to decode medical history: %w", err)
+		}
+		scanned++
+
+		next := *current
+		next.Prescriptions = append([]models.Prescription{}, current.Prescriptions...)
+		changed := dosage.NormalizeHistory(&next)
+		parsed += changed
+		for i, prescription := range next.Prescriptions {
+			if prescription.StructuredDosage == nil && prescription.Dosage != "" {
+				unparsed++
+				fmt.Printf("unparsed\t%s\tprescriptions[%d]\t%q\n", current.UserID, i, prescription.Dosage)
+			}
+		}
+		if changed == 0 || *dryRun {
+			continue
+		}
+		err = database.WriteMedicalHistory(ctx, historyCollection, current, &next)
+		if err == database.ErrVersionConflict {
+			conflicts++
+			slog.Warn("Skipping medical history changed during migration", "userID", current.UserID)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save medical history for %s: %w", current.UserID, err)
+		}
+		migrated++
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to iterate medical histories: %w", err)
+	}
+
+	fmt.Printf("scanned %d histories, parsed %d prescriptions, %d unparsed, saved %d histories, %d conflicts (dry run: %t)\n",
+		scanned, parsed, unparsed, migrated, conflicts, *dryRun)
+	return nil
+}
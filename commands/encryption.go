@@ -0,0 +1,348 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"life-signal/database"
+	"life-signal/encryption"
+	"life-signal/models"
+	"life-signal/storage"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func init() {
+	register("encrypt-records", "[-dry-run]", encryptRecords)
+	register("rotate-master-key", "[-rewrap-only] [-prune] [-dry-run]", rotateMasterKey)
+	registerKeySetup("init-keys", "[-file <path>] [-force]", initKeys)
+}
+
+// initKeys creates the master key file for a new deployment. Data keys
+// already in the database were wrapped with another key file, so it refuses
+// to run against them unless forced.
+func initKeys(ctx context.Context, db *mongo.Client, args []string) error {
+	flags := flag.NewFlagSet("init-keys", flag.ContinueOnError)
+	path := flags.String("file", os.Getenv("MASTER_KEY_FILE"), "where to write the master key file")
+	force := flags.Bool("force", false, "create the file even though data keys exist")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	count, err := database.GetCollection(db, "life-signal", "data-keys").CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return fmt.Errorf("failed to count data keys: %w", err)
+	}
+	if count > 0 && !*force {
+		return fmt.Errorf("%d data keys exist and need the master key file they were wrapped with; restore it instead, or pass -force", count)
+	}
+	kms, err := encryption.CreateKeyFile(*path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created %s with master key %s; back it up, data cannot be decrypted without it\n", *path, kms.CurrentKeyID())
+	return nil
+}
+
+// encryptRecords encrypts users, medical histories, vital signs, lab results,
+// booking reasons and attachment contents stored before encryption at rest
+// was enabled. It is safe to run repeatedly.
+func encryptRecords(ctx context.Context, db *mongo.Client, args []string) error {
+	flags := flag.NewFlagSet("encrypt-records", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "report what would change without saving")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	userCollection := database.GetCollection(db, "life-signal", "users")
+	cursor, err := userCollection.Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"phone": bson.M{"$nin": bson.A{"", nil}, "$not": bson.M{"$regex": "^enc:"}}},
+		bson.M{"email": bson.M{"$nin": bson.A{"", nil}, "$not": bson.M{"$regex": "^enc:"}}},
+	}})
+	if err != nil {
+		return fmt.Errorf("failed to query users: %w", err)
+	}
+	defer cursor.Close(ctx)
+	var users int
+	for cursor.Next(ctx) {
+		var user models.UserDetails
+		if err := cursor.Decode(&user); err != nil {
+			return fmt.Errorf("failed to decode user: %w", err)
+		}
+		users++
+		if *dryRun {
+			continue
+		}
+		stored, err := database.EncryptUser(ctx, &user)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt user %s: %w", user.ID, err)
+		}
+		// Match on the plaintext so a concurrent change is not overwritten.
+		filter := bson.M{"_id": user.ID, "phone": user.Phone, "email": user.Email}
+		update := bson.M{"$set": bson.M{
+			"phone":       stored.Phone,
+			"email":       stored.Email,
+			"phone_index": stored.PhoneIndex,
+			"email_index": stored.EmailIndex,
+		}}
+		result, err := userCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return fmt.Errorf("failed to save user %s: %w", user.ID, err)
+		}
+		if result.MatchedCount == 0 {
+			slog.Warn("Skipping user changed during encryption", "userID", user.ID)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to iterate users: %w", err)
+	}
+
+	historyCollection := database.GetCollection(db, "life-signal", "user-medical-history")
+	cursor, err = historyCollection.Find(ctx, bson.M{"encrypted_content": bson.M{"$exists": false}})
+	if err != nil {
+		return fmt.Errorf("failed to query medical histories: %w", err)
+	}
+	defer cursor.Close(ctx)
+	var histories, conflicts int
+	for cursor.Next(ctx) {
+		current, err := database.DecodeMedicalHistory(ctx, cursor.Current)
+		if err != nil {
+			return fmt.Errorf("failed to decode medical history: %w", err)
+		}
+		histories++
+		if *dryRun {
+			continue
+		}
+		next := *current
+		err = database.WriteMedicalHistory(ctx, historyCollection, current, &next)
+		if err == database.ErrVersionConflict {
+			conflicts++
+			slog.Warn("Skipping medical history changed during encryption", "userID", current.UserID)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save medical history for %s: %w", current.UserID, err)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to iterate medical histories: %w", err)
+	}
+
+	vitals, vitalConflicts, err := encryptObservations(ctx, database.GetCollection(db, "life-signal", "vital-signs"), "vital signs", *dryRun,
+		func(ctx context.Context, collection *mongo.Collection, raw bson.Raw) (bool, error) {
+			vital, err := database.DecodeVitalSign(ctx, raw)
+			if err != nil {
+				return false, err
+			}
+			return database.EncryptVitalSign(ctx, collection, vital)
+		})
+	if err != nil {
+		return err
+	}
+	labs, labConflicts, err := encryptObservations(ctx, database.GetCollection(db, "life-signal", "lab-observations"), "lab results", *dryRun,
+		func(ctx context.Context, collection *mongo.Collection, raw bson.Raw) (bool, error) {
+			observation, err := database.DecodeLabObservation(ctx, raw)
+			if err != nil {
+				return false, err
+			}
+			return database.EncryptLabObservation(ctx, collection, observation)
+		})
+	if err != nil {
+		return err
+	}
+	conflicts += vitalConflicts + labConflicts
+
+	bookings, err := encryptBookingReasons(ctx, database.GetCollection(db, "life-signal", "appointments"), *dryRun)
+	if err != nil {
+		return err
+	}
+	attachments, err := encryptAttachments(ctx, database.GetCollection(db, "life-signal", "attachments"), *dryRun)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("encrypted %d users, %d histories, %d vital signs, %d lab results, %d bookings and %d attachments, %d conflicts (dry run: %t)\n",
+		users, histories, vitals, labs, bookings, attachments, conflicts, *dryRun)
+	return nil
+}
+
+// encryptObservations runs encrypt on every document of collection still
+// stored in plaintext, counting the ones changed concurrently as conflicts.
+func encryptObservations(ctx context.Context, collection *mongo.Collection, name string, dryRun bool, encrypt func(context.Context, *mongo.Collection, bson.Raw) (bool, error)) (int, int, error) {
+	cursor, err := collection.Find(ctx, bson.M{"encrypted_content": bson.M{"$exists": false}})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to query %s: %w", name, err)
+	}
+	defer cursor.Close(ctx)
+	var encrypted, conflicts int
+	for cursor.Next(ctx) {
+		encrypted++
+		if dryRun {
+			continue
+		}
+		id := cursor.Current.Lookup("_id")
+		replaced, err := encrypt(ctx, collection, cursor.Current)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to encrypt %s %s: %w", name, id, err)
+		}
+		if !replaced {
+			conflicts++
+			slog.Warn("Skipping record changed during encryption", "collection", collection.Name(), "id", id.String())
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return 0, 0, fmt.Errorf("failed to iterate %s: %w", name, err)
+	}
+	return encrypted, conflicts, nil
+}
+
+// encryptBookingReasons encrypts the reasons of bookings stored in plaintext.
+func encryptBookingReasons(ctx context.Context, collection *mongo.Collection, dryRun bool) (int, error) {
+	cursor, err := collection.Find(ctx, bson.M{"reason": bson.M{"$nin": bson.A{"", nil}, "$not": bson.M{"$regex": "^enc:"}}})
+	if err != nil {
+		return 0, fmt.Errorf("failed to query bookings: %w", err)
+	}
+	defer cursor.Close(ctx)
+	var bookings int
+	for cursor.Next(ctx) {
+		var booking models.Booking
+		if err := cursor.Decode(&booking); err != nil {
+			return 0, fmt.Errorf("failed to decode booking: %w", err)
+		}
+		bookings++
+		if dryRun {
+			continue
+		}
+		stored, err := database.EncryptBooking(ctx, &booking)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encrypt booking %s: %w", booking.ID, err)
+		}
+		// Match on the plaintext so a concurrent change is not overwritten.
+		result, err := collection.UpdateOne(ctx,
+			bson.M{"_id": booking.ID, "reason": booking.Reason},
+			bson.M{"$set": bson.M{"reason": stored.Reason}})
+		if err != nil {
+			return 0, fmt.Errorf("failed to save booking %s: %w", booking.ID, err)
+		}
+		if result.MatchedCount == 0 {
+			slog.Warn("Skipping booking changed during encryption", "bookingID", booking.ID)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return 0, fmt.Errorf("failed to iterate bookings: %w", err)
+	}
+	return bookings, nil
+}
+
+// encryptAttachments writes an encrypted copy of every plaintext attachment
+// under a new key, points the attachment at it and removes the plaintext.
+func encryptAttachments(ctx context.Context, collection *mongo.Collection, dryRun bool) (int, error) {
+	cursor, err := collection.Find(ctx, bson.M{"encrypted": bson.M{"$ne": true}})
+	if err != nil {
+		return 0, fmt.Errorf("failed to query attachments: %w", err)
+	}
+	defer cursor.Close(ctx)
+	store := storage.Current()
+	var attachments int
+	for cursor.Next(ctx) {
+		var attachment models.Attachment
+		if err := cursor.Decode(&attachment); err != nil {
+			return 0, fmt.Errorf("failed to decode attachment: %w", err)
+		}
+		attachments++
+		if dryRun {
+			continue
+		}
+		data, err := database.ReadAttachment(ctx, &attachment)
+		if err == storage.ErrNotFound {
+			slog.Warn("Skipping attachment with missing contents", "attachmentID", attachment.ID)
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read attachment %s: %w", attachment.ID, err)
+		}
+		plaintextKey := attachment.StorageKey
+		sealed, err := database.EncryptAttachment(ctx, &attachment, data)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encrypt attachment %s: %w", attachment.ID, err)
+		}
+		attachment.StorageKey = plaintextKey + ".enc"
+		if err := store.Put(ctx, attachment.StorageKey, bytes.NewReader(sealed), int64(len(sealed)), attachment.ContentType); err != nil {
+			return 0, fmt.Errorf("failed to store attachment %s: %w", attachment.ID, err)
+		}
+		result, err := collection.UpdateOne(ctx,
+			bson.M{"_id": attachment.ID, "storage_key": plaintextKey, "encrypted": bson.M{"$ne": true}},
+			bson.M{"$set": bson.M{"storage_key": attachment.StorageKey, "encrypted": true}})
+		if err != nil {
+			return 0, fmt.Errorf("failed to save attachment %s: %w", attachment.ID, err)
+		}
+		if result.MatchedCount == 0 {
+			slog.Warn("Skipping attachment changed during encryption", "attachmentID", attachment.ID)
+			if err := store.Delete(ctx, attachment.StorageKey); err != nil {
+				slog.Warn("Failed to remove unused encrypted copy", "attachmentID", attachment.ID, "error", err)
+			}
+			continue
+		}
+		if err := store.Delete(ctx, plaintextKey); err != nil {
+			slog.Warn("Failed to remove plaintext attachment contents", "attachmentID", attachment.ID, "error", err)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return 0, fmt.Errorf("failed to iterate attachments: %w", err)
+	}
+	return attachments, nil
+}
+
+// rotateMasterKey makes a new master key current and rewraps every data key
+// with it. Records are not rewritten since data keys do not change.
+func rotateMasterKey(ctx context.Context, db *mongo.Client, args []string) error {
+	flags := flag.NewFlagSet("rotate-master-key", flag.ContinueOnError)
+	rewrapOnly := flags.Bool("rewrap-only", false, "rewrap with the current master key without creating a new one")
+	prune := flags.Bool("prune", false, "remove master keys no data key is wrapped with anymore")
+	dryRun := flags.Bool("dry-run", false, "report how many data keys would be rewrapped")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	envelope := encryption.Current()
+	rotator, canRotate := envelope.KeyManager().(encryption.Rotator)
+	if !*rewrapOnly && !*dryRun {
+		if !canRotate {
+			return fmt.Errorf("the key manager cannot create master keys, rotate it externally and use -rewrap-only")
+		}
+		keyID, err := rotator.Rotate(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to create master key: %w", err)
+		}
+		fmt.Printf("created master key %s\n", keyID)
+	}
+
+	rewrapped, err := envelope.Rewrap(ctx, *dryRun)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("rewrapped %d data keys with %s (dry run: %t)\n", rewrapped, envelope.KeyManager().CurrentKeyID(), *dryRun)
+
+	if !*prune || *dryRun {
+		return nil
+	}
+	if !canRotate {
+		return fmt.Errorf("the key manager cannot remove master keys")
+	}
+	inUse, err := envelope.MasterKeysInUse(ctx)
+	if err != nil {
+		return err
+	}
+	removed, err := rotator.Prune(ctx, inUse)
+	if err != nil {
+		return fmt.Errorf("failed to prune master keys: %w", err)
+	}
+	sort.Strings(removed)
+	fmt.Printf("removed %d unused master keys %s\n", len(removed), strings.Join(removed, ", "))
+	return nil
+}
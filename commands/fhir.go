@@ -0,0 +1,92 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"life-signal/database"
+	"life-signal/dosage"
+	"life-signal/fhir"
+	"life-signal/helpers"
+	"life-signal/interactions"
+	"life-signal/models"
+	"log/slog"
+	"os"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func init() {
+	register("import-fhir", "-user <id> -file <bundle.json|-> [-dry-run] [-override-interactions]", importFHIR)
+}
+
+func importFHIR(ctx context.Context, db *mongo.Client, args []string) error {
+	flags := flag.NewFlagSet("import-fhir", flag.ContinueOnError)
+	userID := flags.String("user", "", "user whose medical history receives the bundle")
+	path := flags.String("file", "", "FHIR R4 Bundle JSON file, - for stdin")
+	dryRun := flags.Bool("dry-run", false, "report the merge without saving it")
+	override := flags.Bool("override-interactions", false, "save prescriptions despite blocking interactions")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *userID == "" || *path == "" {
+		return fmt.Errorf("-user and -file are required")
+	}
+
+	var input io.Reader = os.Stdin
+	if *path != "-" {
+		file, err := os.Open(*path)
+		if err != nil {
+			return fmt.Errorf("failed to open bundle: %w", err)
+		}
+		defer file.Close()
+		input = file
+	}
+	var bundle fhir.Bundle
+	if err := json.NewDecoder(input).Decode(&bundle); err != nil {
+		return fmt.Errorf("failed to decode bundle: %w", err)
+	}
+	if bundle.ResourceType != "Bundle" {
+		return fmt.Errorf("resourceType must be Bundle, got %q", bundle.ResourceType)
+	}
+
+	historyCollection := database.GetCollection(db, "life-signal", "user-medical-history")
+	current, err := database.FindMedicalHistory(ctx, historyCollection, *userID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch medical history: %w", err)
+	}
+	base := models.MedicalHistory{UserID: *userID}
+	if current != nil {
+		base = *current
+	}
+	merged, report := fhir.ImportBundle(&bundle, base)
+	dosage.NormalizeHistory(&merged)
+	if err := helpers.ValidateMedicalHistory(merged, *userID); err != nil {
+		return fmt.Errorf("merged history is invalid: %w", err)
+	}
+	findings := interactions.Current().CheckWrite(current, &merged)
+	for _, finding := range findings {
+		fmt.Fprintf(os.Stderr, "interaction: %s\n", finding)
+	}
+	if interactions.HasBlocking(findings) {
+		if !*override {
+			return fmt.Errorf("prescriptions conflict with the patient's medications or allergies, rerun with -override-interactions to import them anyway")
+		}
+		slog.Warn("import-fhir overriding blocking interactions", "userID", *userID, "findings", len(findings))
+	}
+	if !*dryRun && fhir.EntryCount(merged) != fhir.EntryCount(base) {
+		// The operator runs this against whatever version is stored, so the
+		// write has no If-Match; it still fails if the history changes while
+		// the bundle is merged.
+		slog.Warn("import-fhir writing medical history without a version precondition", "userID", *userID, "version", base.Version)
+		if err := database.WriteMedicalHistory(ctx, historyCollection, current, &merged); err != nil {
+			return fmt.Errorf("failed to save medical history: %w", err)
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
@@ -0,0 +1,70 @@
+package commands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"life-signal/database"
+	"life-signal/geocode"
+	"life-signal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func init() {
+	register("geocode-doctors", "[-all] [-dry-run]", geocodeDoctors)
+}
+
+// geocodeDoctors sets the location of doctors from their clinic address, so
+// they show up in nearby searches. Addresses the geocoder does not know are
+// listed for someone to add to the gazetteer.
+func geocodeDoctors(ctx context.Context, db *mongo.Client, args []string) error {
+	flags := flag.NewFlagSet("geocode-doctors", flag.ContinueOnError)
+	all := flags.Bool("all", false, "also geocode doctors that already have a location")
+	dryRun := flags.Bool("dry-run", false, "report what would change without saving")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	doctorCollection := database.GetCollection(db, "life-signal", "doctors")
+	filter := bson.M{"clinic_address": bson.M{"$nin": bson.A{"", nil}}}
+	if !*all {
+		filter["location"] = bson.M{"$exists": false}
+	}
+	cursor, err := doctorCollection.Find(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("failed to query doctors: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var located, unknown int
+	for cursor.Next(ctx) {
+		var doctor models.Doctor
+		if err := cursor.Decode(&doctor); err != nil {
+			return fmt.Errorf("failed to decode doctor: %w", err)
+		}
+		location, err := geocode.Current().Geocode(ctx, doctor.ClinicAddress)
+		if err == geocode.ErrNotFound {
+			fmt.Printf("unknown address for doctor %s: %q\n", doctor.ID, doctor.ClinicAddress)
+			unknown++
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to geocode doctor %s: %w", doctor.ID, err)
+		}
+		located++
+		if *dryRun {
+			continue
+		}
+		update := bson.M{"$set": bson.M{"location": location}}
+		if _, err := doctorCollection.UpdateOne(ctx, bson.M{"_id": doctor.ID}, update); err != nil {
+			return fmt.Errorf("failed to save doctor %s: %w", doctor.ID, err)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to iterate doctors: %w", err)
+	}
+	fmt.Printf("%d doctors located, %d with unknown addresses (dry run: %t)\n", located, unknown, *dryRun)
+	return nil
+}
@@ -0,0 +1,148 @@
+package commands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"life-signal/database"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func init() {
+	registerMigration("dedupe-histories", "[-dry-run]", dedupeHistories)
+	register("assign-entry-ids", "[-dry-run]", assignEntryIDs)
+}
+
+// assignEntryIDs gives the issues, prescriptions and appointments of
+// histories written before entries had IDs one.
+func assignEntryIDs(ctx context.Context, db *mongo.Client, args []string) error {
+	flags := flag.NewFlagSet("assign-entry-ids", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "count the histories without saving")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	historyCollection := database.GetCollection(db, "life-signal", "user-medical-history")
+	// Entries are encrypted at rest, so every history has to be read.
+	cursor, err := historyCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return fmt.Errorf("failed to query medical histories: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var scanned, pending, saved, conflicts int
+	for cursor.Next(ctx) {
+		current, err := database.DecodeMedicalHistory(ctx, cursor.Current)
+		if err != nil {
+			return fmt.Errorf("failed to decode medical history: %w", err)
+		}
+		scanned++
+		missing := false
+		for _, issue := range current.MedicalIssues {
+			missing = missing || issue.ID == ""
+		}
+		for _, prescription := range current.Prescriptions {
+			missing = missing || prescription.ID == ""
+		}
+		for _, appointment := range current.Appointments {
+			missing = missing || appointment.ID == ""
+		}
+		if !missing {
+			continue
+		}
+		pending++
+		if *dryRun {
+			continue
+		}
+		next := *current
+		err = database.WriteMedicalHistory(ctx, historyCollection, current, &next)
+		if err == database.ErrVersionConflict {
+			conflicts++
+			slog.Warn("Skipping medical history changed during migration", "userID", current.UserID)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save medical history for %s: %w", current.UserID, err)
+		}
+		saved++
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to iterate medical histories: %w", err)
+	}
+	fmt.Printf("scanned %d histories, %d without entry IDs, saved %d, %d conflicts (dry run: %t)\n", scanned, pending, saved, conflicts, *dryRun)
+	return nil
+}
+
+// dedupeHistories keeps one medical history per user, the one with the highest
+// version and then the latest update, so the unique index on user_id can be
+// built. The others are moved to user-medical-history-duplicates.
+func dedupeHistories(ctx context.Context, db *mongo.Client, args []string) error {
+	flags := flag.NewFlagSet("dedupe-histories", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "list the duplicates without moving them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	historyCollection := database.GetCollection(db, "life-signal", "user-medical-history")
+	archive := database.GetCollection(db, "life-signal", "user-medical-history-duplicates")
+	pipeline := mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: "user_id", Value: 1}, {Key: "version", Value: -1}, {Key: "updated_at", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$user_id", "ids": bson.M{"$push": "$_id"}, "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	}
+	cursor, err := historyCollection.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return fmt.Errorf("failed to find duplicate histories: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var users, moved, failed int
+	for cursor.Next(ctx) {
+		var group struct {
+			UserID string `bson:"_id"`
+			IDs    []any  `bson:"ids"`
+		}
+		if err := cursor.Decode(&group); err != nil {
+			return fmt.Errorf("failed to decode duplicate histories: %w", err)
+		}
+		users++
+		for _, id := range group.IDs[1:] {
+			if *dryRun {
+				fmt.Printf("would move history %v of %s, keeping %v\n", id, group.UserID, group.IDs[0])
+				continue
+			}
+			if err := archiveHistory(ctx, historyCollection, archive, id); err != nil {
+				fmt.Printf("failed to move history %v of %s: %v\n", id, group.UserID, err)
+				failed++
+				continue
+			}
+			moved++
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to iterate duplicate histories: %w", err)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d duplicate histories failed", failed, failed+moved)
+	}
+	fmt.Printf("%d users had duplicate histories, moved %d (dry run: %t)\n", users, moved, *dryRun)
+	return nil
+}
+
+func archiveHistory(ctx context.Context, histories, archive *mongo.Collection, id any) error {
+	var raw bson.M
+	if err := histories.FindOne(ctx, bson.M{"_id": id}).Decode(&raw); err != nil {
+		return err
+	}
+	raw["archived_at"] = time.Now()
+	if _, err := archive.ReplaceOne(ctx, bson.M{"_id": id}, raw, options.Replace().SetUpsert(true)); err != nil {
+		return err
+	}
+	_, err := histories.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
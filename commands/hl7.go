@@ -0,0 +1,102 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"life-signal/database"
+	"life-signal/hl7"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func init() {
+	register("hl7-send", "-addr <host:port> [-tls] -file <message.hl7|->", sendHL7)
+	register("redact-hl7-dead-letters", "[-dry-run]", redactHL7DeadLetters)
+}
+
+// redactHL7DeadLetters drops the raw message from dead letters stored before
+// only the control ID and reason were kept.
+func redactHL7DeadLetters(ctx context.Context, db *mongo.Client, args []string) error {
+	flags := flag.NewFlagSet("redact-hl7-dead-letters", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "count the dead letters without changing them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	collection := database.GetCollection(db, "life-signal", "hl7-dead-letters")
+	filter := bson.M{"raw": bson.M{"$exists": true}}
+	if *dryRun {
+		count, err := collection.CountDocuments(ctx, filter)
+		if err != nil {
+			return fmt.Errorf("failed to count dead letters: %w", err)
+		}
+		fmt.Printf("would redact %d dead letters\n", count)
+		return nil
+	}
+	result, err := collection.UpdateMany(ctx, filter, bson.M{"$unset": bson.M{"raw": ""}})
+	if err != nil {
+		return fmt.Errorf("failed to redact dead letters: %w", err)
+	}
+	fmt.Printf("redacted %d dead letters\n", result.ModifiedCount)
+	return nil
+}
+
+// sendHL7 is a small MLLP client for exercising the listener locally. Line
+// breaks in the file are converted to the CR segment separator.
+func sendHL7(ctx context.Context, db *mongo.Client, args []string) error {
+	flags := flag.NewFlagSet("hl7-send", flag.ContinueOnError)
+	addr := flags.String("addr", "localhost:2575", "MLLP listener address")
+	path := flags.String("file", "", "HL7 v2 message file, - for stdin")
+	timeout := flags.Duration("timeout", 10*time.Second, "time to wait for the acknowledgment")
+	useTLS := flags.Bool("tls", false, "connect over TLS")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" {
+		return fmt.Errorf("-file is required")
+	}
+
+	var data []byte
+	var err error
+	if *path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(*path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read message: %w", err)
+	}
+	message := strings.ReplaceAll(strings.TrimSpace(string(data)), "\r\n", "\r")
+	message = strings.ReplaceAll(message, "\n", "\r") + "\r"
+
+	dialer := net.Dialer{Timeout: *timeout}
+	var conn net.Conn
+	if *useTLS {
+		conn, err = (&tls.Dialer{NetDialer: &dialer}).DialContext(ctx, "tcp", *addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", *addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", *addr, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(*timeout))
+
+	if err := hl7.WriteFrame(conn, message); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	ack, err := hl7.ReadFrame(bufio.NewReader(conn))
+	if err != nil {
+		return fmt.Errorf("failed to read acknowledgment: %w", err)
+	}
+	fmt.Println(strings.ReplaceAll(strings.TrimRight(ack, "\r"), "\r", "\n"))
+	return nil
+}
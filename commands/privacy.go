@@ -0,0 +1,46 @@
+package commands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"life-signal/privacy"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func init() {
+	register("process-erasures", "[-dry-run]", processErasures)
+}
+
+// processErasures erases every account whose erasure grace period is over.
+// An account that fails is retried on the next run.
+func processErasures(ctx context.Context, db *mongo.Client, args []string) error {
+	flags := flag.NewFlagSet("process-erasures", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "list the accounts that are due without erasing them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if *dryRun {
+		due, err := privacy.DueErasures(ctx, db, now)
+		if err != nil {
+			return err
+		}
+		for _, userID := range due {
+			fmt.Printf("would erase %s\n", userID)
+		}
+		fmt.Printf("%d accounts due for erasure (dry run: true)\n", len(due))
+		return nil
+	}
+	due, err := privacy.EraseDue(ctx, db, now, func(userID string, removed map[string]int64) {
+		fmt.Printf("erased %s: %v\n", userID, removed)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d accounts due for erasure (dry run: false)\n", due)
+	return nil
+}
@@ -0,0 +1,44 @@
+package commands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"life-signal/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func init() {
+	register("purge-medication-reminders", "[-dry-run]", purgeMedicationReminders)
+}
+
+// purgeMedicationReminders deletes medication reminder jobs queued with the
+// medication and dose in their payload, including dead letters. Upcoming
+// doses are queued again by the next scan, naming only the prescription.
+func purgeMedicationReminders(ctx context.Context, db *mongo.Client, args []string) error {
+	flags := flag.NewFlagSet("purge-medication-reminders", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "count the jobs without deleting them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	filter := bson.M{"kind": "medication-reminder", "payload.medication": bson.M{"$exists": true}}
+	for _, name := range []string{"jobs", "job-dead-letters"} {
+		collection := database.GetCollection(db, "life-signal", name)
+		if *dryRun {
+			count, err := collection.CountDocuments(ctx, filter)
+			if err != nil {
+				return fmt.Errorf("failed to count %s: %w", name, err)
+			}
+			fmt.Printf("would delete %d %s\n", count, name)
+			continue
+		}
+		result, err := collection.DeleteMany(ctx, filter)
+		if err != nil {
+			return fmt.Errorf("failed to delete %s: %w", name, err)
+		}
+		fmt.Printf("deleted %d %s\n", result.DeletedCount, name)
+	}
+	return nil
+}
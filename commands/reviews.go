@@ -0,0 +1,76 @@
+package commands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"life-signal/database"
+	"life-signal/reviews"
+	"log/slog"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func init() {
+	register("rebuild-ratings", "[-dry-run] [-doctor id]", rebuildRatings)
+}
+
+// rebuildRatings recomputes the ratings of doctors from their reviews, for
+// instance after a ledger was lost or to clear seeded ratings. Without
+// -doctor it covers every doctor, so those without reviews are reset to
+// zero, as well as any reviews or ledgers left by deleted doctors.
+func rebuildRatings(ctx context.Context, db *mongo.Client, args []string) error {
+	flags := flag.NewFlagSet("rebuild-ratings", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "list the doctors without rebuilding their ratings")
+	doctorID := flags.String("doctor", "", "rebuild only the rating of this doctor")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	doctorIDs := []string{*doctorID}
+	if *doctorID == "" {
+		doctors, err := database.GetCollection(db, "life-signal", "doctors").Distinct(ctx, "_id", bson.M{})
+		if err != nil {
+			return fmt.Errorf("failed to find doctors: %w", err)
+		}
+		reviewed, err := database.GetCollection(db, "life-signal", "reviews").Distinct(ctx, "doctor_id", bson.M{})
+		if err != nil {
+			return fmt.Errorf("failed to find reviewed doctors: %w", err)
+		}
+		ledgers, err := database.GetCollection(db, "life-signal", "doctor-ratings").Distinct(ctx, "_id", bson.M{})
+		if err != nil {
+			return fmt.Errorf("failed to find rating ledgers: %w", err)
+		}
+		seen := map[string]bool{}
+		doctorIDs = doctorIDs[:0]
+		for _, id := range append(append(doctors, reviewed...), ledgers...) {
+			if s, ok := id.(string); ok && !seen[s] {
+				seen[s] = true
+				doctorIDs = append(doctorIDs, s)
+			}
+		}
+		sort.Strings(doctorIDs)
+	}
+
+	failed := 0
+	for _, id := range doctorIDs {
+		if *dryRun {
+			fmt.Printf("would rebuild the rating of %s\n", id)
+			continue
+		}
+		ledger, err := reviews.Rebuild(ctx, db, id)
+		if err != nil {
+			slog.Error("Rebuilding rating failed", "doctorID", id, "error", err)
+			failed++
+			continue
+		}
+		fmt.Printf("rebuilt %s: %d reviews, sum %d\n", id, ledger.Count, ledger.Sum)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d ratings failed", failed, len(doctorIDs))
+	}
+	fmt.Printf("%d doctor ratings to rebuild (dry run: %t)\n", len(doctorIDs), *dryRun)
+	return nil
+}
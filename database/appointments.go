@@ -0,0 +1,203 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"life-signal/encryption"
+	"life-signal/models"
+	"life-signal/schedule"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrSlotTaken = errors.New("slot is already booked")
+
+var errAlreadyRecorded = errors.New("booking is already in the medical history")
+
+const bookingReasonField = "booking_reason"
+
+// activeBookings are the states in which a booking holds its slot.
+var activeBookings = bson.A{models.BookingRequested, models.BookingConfirmed}
+
+// bookingHolds lists the instants a booking of doctorID from start to end
+// occupies, one per schedule.Granularity.
+func bookingHolds(doctorID string, start, end time.Time) []string {
+	step := schedule.Granularity * time.Minute
+	holds := []string{}
+	for t := start.Truncate(step); t.Before(end); t = t.Add(step) {
+		holds = append(holds, fmt.Sprintf("%s/%d", doctorID, t.Unix()))
+	}
+	return holds
+}
+
+// EncryptBooking returns a copy of booking ready to be stored, with the
+// patient's reason encrypted.
+func EncryptBooking(ctx context.Context, booking *models.Booking) (*models.Booking, error) {
+	stored := *booking
+	var err error
+	if stored.Reason, err = encryption.Current().EncryptString(ctx, booking.UserID, bookingReasonField, booking.Reason); err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// DecryptBooking decrypts the reason of a stored booking in place.
+func DecryptBooking(ctx context.Context, booking *models.Booking) error {
+	reason, err := encryption.Current().DecryptString(ctx, booking.UserID, bookingReasonField, booking.Reason)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt booking: %w", err)
+	}
+	booking.Reason = reason
+	return nil
+}
+
+// InsertBooking stores a new booking, failing with ErrSlotTaken when any
+// part of its time is already held by another booking of the same doctor.
+func InsertBooking(ctx context.Context, collection *mongo.Collection, booking *models.Booking) error {
+	now := time.Now()
+	booking.Holds = bookingHolds(booking.DoctorID, booking.Start, booking.End)
+	booking.Version = 1
+	booking.CreatedAt = now
+	booking.UpdatedAt = now
+	stored, err := EncryptBooking(ctx, booking)
+	if err != nil {
+		return err
+	}
+	_, err = collection.InsertOne(ctx, stored)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrSlotTaken
+	}
+	return err
+}
+
+// UpdateBooking stores next as the successor of the booking at version
+// expected. A cancelled booking releases its time; any other one holds the
+// time from next.Start to next.End, failing with ErrSlotTaken when another
+// booking holds part of it.
+func UpdateBooking(ctx context.Context, collection *mongo.Collection, next *models.Booking, expected int64) error {
+	next.Holds = nil
+	if next.Status != models.BookingCancelled {
+		next.Holds = bookingHolds(next.DoctorID, next.Start, next.End)
+	}
+	next.Version = expected + 1
+	next.UpdatedAt = time.Now()
+	stored, err := EncryptBooking(ctx, next)
+	if err != nil {
+		return err
+	}
+	result, err := collection.ReplaceOne(ctx, versionFilter(bson.M{"_id": next.ID}, expected), stored)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrSlotTaken
+	}
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// FindBooking returns the booking matching filter, or nil when there is none.
+func FindBooking(ctx context.Context, collection *mongo.Collection, filter bson.M) (*models.Booking, error) {
+	var booking models.Booking
+	err := collection.FindOne(ctx, filter).Decode(&booking)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := DecryptBooking(ctx, &booking); err != nil {
+		return nil, err
+	}
+	return &booking, nil
+}
+
+// FindBookings returns the bookings matching filter that start within
+// [from, to), earliest first. Zero bounds are open.
+func FindBookings(ctx context.Context, collection *mongo.Collection, filter bson.M, from, to time.Time) ([]models.Booking, error) {
+	start := bson.M{}
+	if !from.IsZero() {
+		start["$gte"] = from
+	}
+	if !to.IsZero() {
+		start["$lt"] = to
+	}
+	if len(start) > 0 {
+		filter["start"] = start
+	}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "start", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	bookings := []models.Booking{}
+	if err := cursor.All(ctx, &bookings); err != nil {
+		return nil, err
+	}
+	for i := range bookings {
+		if err := DecryptBooking(ctx, &bookings[i]); err != nil {
+			return nil, err
+		}
+	}
+	return bookings, nil
+}
+
+// FindBusySlots returns the times of a doctor's bookings that still hold
+// their slot and overlap [from, to).
+func FindBusySlots(ctx context.Context, collection *mongo.Collection, doctorID string, from, to time.Time) ([]models.Slot, error) {
+	filter := bson.M{
+		"doctor_id": doctorID,
+		"status":    bson.M{"$in": activeBookings},
+		"start":     bson.M{"$lt": to},
+		"end":       bson.M{"$gt": from},
+	}
+	opts := options.Find().SetProjection(bson.M{"start": 1, "end": 1}).SetSort(bson.D{{Key: "start", Value: 1}})
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	busy := []models.Slot{}
+	if err := cursor.All(ctx, &busy); err != nil {
+		return nil, err
+	}
+	return busy, nil
+}
+
+// RecordCompletedBooking adds a completed booking to the patient's medical
+// history as an Appointment and marks it recorded. A booking already in the
+// history is not added twice, so an interrupted recording can be retried.
+func RecordCompletedBooking(ctx context.Context, histories, bookings *mongo.Collection, booking *models.Booking) error {
+	_, err := UpdateMedicalHistory(ctx, histories, booking.UserID, func(history *models.MedicalHistory) error {
+		for _, appointment := range history.Appointments {
+			if appointment.BookingID == booking.ID {
+				return errAlreadyRecorded
+			}
+		}
+		history.Appointments = append(history.Appointments, models.Appointment{
+			DoctorID:        booking.DoctorID,
+			DoctorName:      booking.DoctorName,
+			AppointmentDate: booking.Start,
+			Notes:           booking.Notes,
+			BookingID:       booking.ID,
+		})
+		return nil
+	})
+	if err != nil && err != errAlreadyRecorded {
+		return fmt.Errorf("failed to update medical history: %w", err)
+	}
+	_, err = bookings.UpdateOne(ctx, bson.M{"_id": booking.ID}, bson.M{
+		"$set": bson.M{"recorded_in_history": true, "updated_at": time.Now()},
+		"$inc": bson.M{"version": 1},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mark booking recorded: %w", err)
+	}
+	booking.RecordedInHistory = true
+	booking.Version++
+	return nil
+}
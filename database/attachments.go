@@ -0,0 +1,49 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"life-signal/encryption"
+	"life-signal/models"
+	"life-signal/storage"
+)
+
+// attachmentContentField binds the contents of an attachment to its ID, so
+// one attachment's blob cannot be served as another's.
+func attachmentContentField(attachment *models.Attachment) string {
+	return "attachment/" + attachment.ID
+}
+
+// EncryptAttachment seals the contents of attachment with its owner's data
+// key and marks it encrypted.
+func EncryptAttachment(ctx context.Context, attachment *models.Attachment, data []byte) ([]byte, error) {
+	sealed, err := encryption.Current().EncryptBytes(ctx, attachment.UserID, attachmentContentField(attachment), data)
+	if err != nil {
+		return nil, err
+	}
+	attachment.Encrypted = true
+	return sealed, nil
+}
+
+// ReadAttachment returns the contents of attachment from the blob store,
+// decrypted, or storage.ErrNotFound when they are missing.
+func ReadAttachment(ctx context.Context, attachment *models.Attachment) ([]byte, error) {
+	reader, err := storage.Current().Get(ctx, attachment.StorageKey)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attachment contents: %w", err)
+	}
+	if !attachment.Encrypted {
+		return data, nil
+	}
+	plaintext, err := encryption.Current().DecryptBytes(ctx, attachment.UserID, attachmentContentField(attachment), data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt attachment contents: %w", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,54 @@
+package database
+
+import (
+	"context"
+	"life-signal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RecordAudit stores entry in the audit log, filling in its ID and time.
+func RecordAudit(ctx context.Context, collection *mongo.Collection, entry models.AuditEntry) error {
+	entry.ID = uuid.New().String()
+	if entry.At.IsZero() {
+		entry.At = time.Now()
+	}
+	_, err := collection.InsertOne(ctx, entry)
+	return err
+}
+
+// RecordAudits stores a copy of entry for each of subjects, for requests that
+// read the records of many users at once.
+func RecordAudits(ctx context.Context, collection *mongo.Collection, entry models.AuditEntry, subjects []string) error {
+	if len(subjects) == 0 {
+		return nil
+	}
+	if entry.At.IsZero() {
+		entry.At = time.Now()
+	}
+	entries := make([]any, len(subjects))
+	for i, subject := range subjects {
+		entry.ID = uuid.New().String()
+		entry.SubjectID = subject
+		entries[i] = entry
+	}
+	_, err := collection.InsertMany(ctx, entries, options.InsertMany().SetOrdered(false))
+	return err
+}
+
+// FindAuditTrail returns the entries about userID, oldest first.
+func FindAuditTrail(ctx context.Context, collection *mongo.Collection, userID string) ([]models.AuditEntry, error) {
+	cursor, err := collection.Find(ctx, bson.M{"subject_id": userID}, options.Find().SetSort(bson.D{{Key: "at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	entries := []models.AuditEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -46,6 +47,137 @@ func GetCollection(db *mongo.Client, databaseName, collectionName string) *mongo
 	return db.Database(databaseName).Collection(collectionName)
 }
 
+func EnsureIndexes(client *mongo.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Blind indexes are only set on users stored after encryption was enabled.
+	encryptedOnly := func(field string) *options.IndexOptions {
+		return options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{field: bson.M{"$type": "string"}})
+	}
+	indexes := map[string][]mongo.IndexModel{
+		"users": {
+			{Keys: bson.D{{Key: "phone_index", Value: 1}}, Options: encryptedOnly("phone_index")},
+			{Keys: bson.D{{Key: "email_index", Value: 1}}, Options: encryptedOnly("email_index")},
+			{Keys: bson.D{{Key: "erasure_due_at", Value: 1}}, Options: options.Index().SetSparse(true)},
+			{Keys: bson.D{{Key: "medication_reminders", Value: 1}}, Options: options.Index().SetSparse(true)},
+		},
+		"user-medical-history": {
+			{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		// Doctor listings only show verified doctors, filter on an equality
+		// and sort on one field with the ID as tie-breaker, so each sort gets
+		// an index with and without the speciality after the status. Array
+		// filters get one multikey index each, and nearby searches need the
+		// 2dsphere index on the clinic location. Text searches rank a match
+		// on the speciality above one in the profile.
+		"doctors": {
+			{Keys: bson.D{{Key: "verification.status", Value: 1}, {Key: "_id", Value: 1}}},
+			{Keys: bson.D{{Key: "verification.status", Value: 1}, {Key: "rating", Value: 1}, {Key: "_id", Value: 1}}},
+			{Keys: bson.D{{Key: "verification.status", Value: 1}, {Key: "fee", Value: 1}, {Key: "_id", Value: 1}}},
+			{Keys: bson.D{{Key: "verification.status", Value: 1}, {Key: "experience", Value: 1}, {Key: "_id", Value: 1}}},
+			{Keys: bson.D{{Key: "verification.status", Value: 1}, {Key: "speciality", Value: 1}, {Key: "rating", Value: 1}, {Key: "_id", Value: 1}}},
+			{Keys: bson.D{{Key: "verification.status", Value: 1}, {Key: "speciality", Value: 1}, {Key: "fee", Value: 1}, {Key: "_id", Value: 1}}},
+			{Keys: bson.D{{Key: "verification.status", Value: 1}, {Key: "speciality", Value: 1}, {Key: "experience", Value: 1}, {Key: "_id", Value: 1}}},
+			{Keys: bson.D{{Key: "languages", Value: 1}, {Key: "rating", Value: 1}}},
+			{Keys: bson.D{{Key: "availability", Value: 1}, {Key: "rating", Value: 1}}},
+			{Keys: bson.D{{Key: "qualifications", Value: 1}}},
+			{Keys: bson.D{{Key: "services", Value: 1}}},
+			{Keys: bson.D{{Key: "location", Value: "2dsphere"}, {Key: "verification.status", Value: 1}, {Key: "speciality", Value: 1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"user_id": bson.M{"$type": "string"}})},
+			{
+				Keys: bson.D{
+					{Key: "first_name", Value: "text"}, {Key: "last_name", Value: "text"},
+					{Key: "speciality", Value: "text"}, {Key: "services", Value: "text"},
+					{Key: "about", Value: "text"}, {Key: "clinic_name", Value: "text"},
+				},
+				Options: options.Index().SetName("doctor_text").SetWeights(bson.D{
+					{Key: "speciality", Value: 10}, {Key: "first_name", Value: 8}, {Key: "last_name", Value: 8},
+					{Key: "services", Value: 5}, {Key: "clinic_name", Value: 3}, {Key: "about", Value: 1},
+				}),
+			},
+		},
+		"user-medical-history-revisions": {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "version", Value: 1}}},
+		},
+		"audit-log": {
+			{Keys: bson.D{{Key: "subject_id", Value: 1}, {Key: "at", Value: -1}}},
+		},
+		"lab-observations": {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "observed_at", Value: -1}}},
+		},
+		"vital-signs": {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "type", Value: 1}, {Key: "measured_at", Value: -1}}},
+		},
+		"attachments": {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "uploaded_at", Value: -1}}},
+		},
+		// Holds are unique across bookings, so two bookings can never
+		// occupy the same time of a doctor whatever order they arrive in.
+		"appointments": {
+			{Keys: bson.D{{Key: "holds", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+			{Keys: bson.D{{Key: "doctor_id", Value: 1}, {Key: "start", Value: 1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "start", Value: 1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "recorded_in_history", Value: 1}}},
+		},
+		// Each patient reviews a doctor once. Listings page through a
+		// doctor's reviews in one state newest first, and the sync job only
+		// looks at the few reviews not yet synced.
+		"reviews": {
+			{Keys: bson.D{{Key: "doctor_id", Value: 1}, {Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "doctor_id", Value: 1}, {Key: "status", Value: 1}, {Key: "_id", Value: -1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "_id", Value: -1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "_id", Value: -1}}},
+			{Keys: bson.D{{Key: "rating_synced", Value: 1}}, Options: options.Index().SetPartialFilterExpression(bson.M{"rating_synced": false})},
+		},
+		"review-reports": {
+			{Keys: bson.D{{Key: "review_id", Value: 1}, {Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		},
+		// A booking has one invoice, and invoice numbers are never reused.
+		// Webhooks find the invoice of a payment by its id.
+		"invoices": {
+			{Keys: bson.D{{Key: "booking_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "number", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "issued_at", Value: -1}}},
+			{Keys: bson.D{{Key: "doctor_id", Value: 1}, {Key: "issued_at", Value: -1}}},
+			{Keys: bson.D{{Key: "payment_id", Value: 1}}, Options: options.Index().SetSparse(true)},
+		},
+		// Providers redeliver webhooks for a few days, so processed event
+		// ids are kept for a month before they expire.
+		"payment-events": {
+			{Keys: bson.D{{Key: "processed_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(30 * 24 * 60 * 60)},
+		},
+		"calendar-feeds": {
+			{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		// Finished jobs are kept for a week so keyed jobs are not enqueued
+		// twice, then expire.
+		"jobs": {
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "run_at", Value: 1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "lease_until", Value: 1}}},
+			{Keys: bson.D{{Key: "finished_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(7 * 24 * 60 * 60)},
+		},
+		"job-dead-letters": {
+			{Keys: bson.D{{Key: "died_at", Value: -1}}},
+		},
+		"hl7-dead-letters": {
+			{Keys: bson.D{{Key: "received_at", Value: -1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		},
+	}
+	for collectionName, models := range indexes {
+		collection := GetCollection(client, "life-signal", collectionName)
+		if _, err := collection.Indexes().CreateMany(ctx, models); err != nil {
+			if collectionName == "user-medical-history" && mongo.IsDuplicateKeyError(err) {
+				return fmt.Errorf("failed to create indexes on %s: users have several histories, run dedupe-histories first: %v", collectionName, err)
+			}
+			return fmt.Errorf("failed to create indexes on %s: %v", collectionName, err)
+		}
+	}
+	return nil
+}
+
 func DisconnectDB(client *mongo.Client) error {
 	if client == nil {
 		return fmt.Errorf("no active database connection")
@@ -0,0 +1,303 @@
+package database
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"life-signal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// DoctorSortFields maps the sort keys clients may ask for to stored fields.
+var DoctorSortFields = map[string]string{
+	"rating":     "rating",
+	"fee":        "fee",
+	"experience": "experience",
+}
+
+// DoctorQuery selects a page of doctors. Nil bounds and empty strings do not
+// filter. Sort is a key of DoctorSortFields, RelevanceSort for text searches,
+// or empty to list by ID. Text is a MongoDB $text search string, and Status
+// a verification state.
+type DoctorQuery struct {
+	Status        string
+	Text          string
+	Speciality    string
+	Language      string
+	Qualification string
+	Service       string
+	Day           string
+	MinRating     *float64
+	MaxFee        *float64
+	MinExperience *int
+	MaxExperience *int
+	Sort          string
+	Descending    bool
+	Cursor        string
+	Limit         int64
+}
+
+// doctorCursor is the position after the last doctor of a page. It records
+// the sort it was issued for so it cannot be replayed against another one.
+type doctorCursor struct {
+	Sort  string   `json:"s,omitempty"`
+	Desc  bool     `json:"d,omitempty"`
+	Value *float64 `json:"v,omitempty"`
+	ID    string   `json:"id"`
+}
+
+func (q DoctorQuery) filter() bson.M {
+	filter := bson.M{}
+	if q.Text != "" {
+		filter["$text"] = bson.M{"$search": q.Text}
+	}
+	for field, value := range map[string]string{
+		"verification.status": q.Status,
+		"speciality":          q.Speciality,
+		"languages":           q.Language,
+		"qualifications":      q.Qualification,
+		"services":            q.Service,
+		"availability":        q.Day,
+	} {
+		if value != "" {
+			filter[field] = value
+		}
+	}
+	if q.MinRating != nil {
+		filter["rating"] = bson.M{"$gte": *q.MinRating}
+	}
+	if q.MaxFee != nil {
+		filter["fee"] = bson.M{"$lte": *q.MaxFee}
+	}
+	experience := bson.M{}
+	if q.MinExperience != nil {
+		experience["$gte"] = *q.MinExperience
+	}
+	if q.MaxExperience != nil {
+		experience["$lte"] = *q.MaxExperience
+	}
+	if len(experience) > 0 {
+		filter["experience"] = experience
+	}
+	return filter
+}
+
+// RelevanceSort orders search results by text score, best first.
+const RelevanceSort = "relevance"
+
+func sortValue(doctor models.Doctor, score float64, sort string) float64 {
+	switch sort {
+	case "rating":
+		return float64(doctor.Rating)
+	case "fee":
+		return doctor.Fee
+	case "experience":
+		return float64(doctor.Experience)
+	default:
+		return score
+	}
+}
+
+// sortField is the stored field behind q.Sort, "score" for relevance or ""
+// when listing by ID.
+func (q DoctorQuery) sortField() string {
+	if q.Sort == RelevanceSort {
+		return "score"
+	}
+	return DoctorSortFields[q.Sort]
+}
+
+// after returns the condition selecting doctors past q.Cursor in the sort
+// order, or nil on the first page.
+func (q DoctorQuery) after() (bson.M, error) {
+	if q.Cursor == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var after doctorCursor
+	if err := json.Unmarshal(data, &after); err != nil || after.ID == "" || after.Sort != q.Sort || after.Desc != q.Descending {
+		return nil, ErrInvalidCursor
+	}
+	comparison := "$gt"
+	if q.Descending {
+		comparison = "$lt"
+	}
+	field := q.sortField()
+	if field == "" {
+		return bson.M{"_id": bson.M{comparison: after.ID}}, nil
+	}
+	if after.Value == nil {
+		return nil, ErrInvalidCursor
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{field: bson.M{comparison: *after.Value}},
+		bson.M{field: *after.Value, "_id": bson.M{comparison: after.ID}},
+	}}, nil
+}
+
+func (q DoctorQuery) sort() bson.D {
+	direction := 1
+	if q.Descending {
+		direction = -1
+	}
+	if field := q.sortField(); field != "" {
+		return bson.D{{Key: field, Value: direction}, {Key: "_id", Value: direction}}
+	}
+	return bson.D{{Key: "_id", Value: direction}}
+}
+
+// nextCursor encodes the position of last, which had the given text score.
+func (q DoctorQuery) nextCursor(last models.Doctor, score float64) (string, error) {
+	next := doctorCursor{Sort: q.Sort, Desc: q.Descending, ID: last.ID}
+	if q.sortField() != "" {
+		value := sortValue(last, score, q.Sort)
+		next.Value = &value
+	}
+	data, err := json.Marshal(next)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// CountDoctors returns how many doctors match the filters of q.
+func CountDoctors(ctx context.Context, collection *mongo.Collection, q DoctorQuery) (int64, error) {
+	return collection.CountDocuments(ctx, q.filter())
+}
+
+// FindDoctors returns the page of doctors after q.Cursor and the cursor of
+// the next page, or "" when this is the last one. Ties on the sort field are
+// broken by ID, so pages neither skip nor repeat doctors.
+func FindDoctors(ctx context.Context, collection *mongo.Collection, q DoctorQuery) ([]models.Doctor, string, error) {
+	filter := q.filter()
+	after, err := q.after()
+	if err != nil {
+		return nil, "", err
+	}
+	if after != nil {
+		filter = bson.M{"$and": bson.A{filter, after}}
+	}
+	// One extra doctor tells whether another page follows.
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(q.sort()).SetLimit(q.Limit+1))
+	if err != nil {
+		return nil, "", err
+	}
+	doctors := []models.Doctor{}
+	if err := cursor.All(ctx, &doctors); err != nil {
+		return nil, "", err
+	}
+	if int64(len(doctors)) <= q.Limit {
+		return doctors, "", nil
+	}
+	doctors = doctors[:q.Limit]
+	next, err := q.nextCursor(doctors[len(doctors)-1], 0)
+	if err != nil {
+		return nil, "", err
+	}
+	return doctors, next, nil
+}
+
+// ScoredDoctor is a doctor found by a text search with its relevance score.
+type ScoredDoctor struct {
+	models.Doctor `bson:",inline"`
+	Score         float64 `json:"score" bson:"score"`
+}
+
+// SearchDoctors runs the text search in q.Text with the filters of q and
+// returns a page like FindDoctors. With RelevanceSort the best matches come
+// first.
+func SearchDoctors(ctx context.Context, collection *mongo.Collection, q DoctorQuery) ([]ScoredDoctor, string, error) {
+	after, err := q.after()
+	if err != nil {
+		return nil, "", err
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: q.filter()}},
+		{{Key: "$addFields", Value: bson.M{"score": bson.M{"$meta": "textScore"}}}},
+	}
+	if after != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: after}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$sort", Value: q.sort()}},
+		bson.D{{Key: "$limit", Value: q.Limit + 1}},
+	)
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, "", err
+	}
+	doctors := []ScoredDoctor{}
+	if err := cursor.All(ctx, &doctors); err != nil {
+		return nil, "", err
+	}
+	if int64(len(doctors)) <= q.Limit {
+		return doctors, "", nil
+	}
+	doctors = doctors[:q.Limit]
+	last := doctors[len(doctors)-1]
+	next, err := q.nextCursor(last.Doctor, last.Score)
+	if err != nil {
+		return nil, "", err
+	}
+	return doctors, next, nil
+}
+
+// DoctorDistance is a doctor found near a point, with the distance to their
+// clinic in metres.
+type DoctorDistance struct {
+	models.Doctor `bson:",inline"`
+	Distance      float64 `json:"distance_m" bson:"distance"`
+}
+
+// FindDoctorsNear returns up to q.Limit doctors matching the filters of q
+// whose clinic lies within maxMeters of point, nearest first. Doctors
+// without a location are never found.
+func FindDoctorsNear(ctx context.Context, collection *mongo.Collection, q DoctorQuery, point models.GeoPoint, maxMeters float64) ([]DoctorDistance, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$geoNear", Value: bson.M{
+			"near":          point,
+			"key":           "location",
+			"distanceField": "distance",
+			"maxDistance":   maxMeters,
+			"spherical":     true,
+			"query":         q.filter(),
+		}}},
+		{{Key: "$limit", Value: q.Limit}},
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	doctors := []DoctorDistance{}
+	if err := cursor.All(ctx, &doctors); err != nil {
+		return nil, err
+	}
+	return doctors, nil
+}
+
+// DoctorVocabulary returns the distinct names, specialities, services and
+// clinic names of verified doctors.
+func DoctorVocabulary(ctx context.Context, collection *mongo.Collection) ([]string, error) {
+	texts := []string{}
+	for _, field := range []string{"first_name", "last_name", "speciality", "services", "clinic_name"} {
+		values, err := collection.Distinct(ctx, field, bson.M{"verification.status": models.DoctorVerified})
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range values {
+			if text, ok := value.(string); ok {
+				texts = append(texts, text)
+			}
+		}
+	}
+	return texts, nil
+}
@@ -0,0 +1,475 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"life-signal/encryption"
+	"life-signal/models"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrVersionConflict = errors.New("resource was modified by another request")
+
+// versionFilter matches documents still at version. Documents written before
+// versioning was introduced have no version field and count as version 0.
+func versionFilter(filter bson.M, version int64) bson.M {
+	if version == 0 {
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	} else {
+		filter["version"] = version
+	}
+	return filter
+}
+
+// historyContent is the part of a history that is encrypted at rest.
+type historyContent struct {
+	MedicalIssues []models.Issue        `bson:"medical_issues"`
+	Prescriptions []models.Prescription `bson:"prescriptions"`
+	Appointments  []models.Appointment  `bson:"appointments"`
+	Allergies     []models.Allergy      `bson:"allergies"`
+	Immunizations []models.Immunization `bson:"immunizations"`
+}
+
+// encryptedHistory is how a history is stored: only what is needed to find
+// and version it stays in the clear.
+type encryptedHistory struct {
+	ID        string    `bson:"_id"`
+	UserID    string    `bson:"user_id"`
+	Version   int64     `bson:"version"`
+	CreatedAt time.Time `bson:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at"`
+	Content   string    `bson:"encrypted_content"`
+}
+
+const historyContentField = "medical_history"
+
+func encryptHistory(ctx context.Context, history *models.MedicalHistory) (*encryptedHistory, error) {
+	content, err := bson.Marshal(historyContent{
+		MedicalIssues: history.MedicalIssues,
+		Prescriptions: history.Prescriptions,
+		Appointments:  history.Appointments,
+		Allergies:     history.Allergies,
+		Immunizations: history.Immunizations,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode medical history: %w", err)
+	}
+	ciphertext, err := encryption.Current().Encrypt(ctx, history.UserID, historyContentField, content)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedHistory{
+		ID:        history.ID,
+		UserID:    history.UserID,
+		Version:   history.Version,
+		CreatedAt: history.CreatedAt,
+		UpdatedAt: history.UpdatedAt,
+		Content:   ciphertext,
+	}, nil
+}
+
+// historyRevision is a copy of every version of a history ever written, kept
+// so users can export the full record. It stays encrypted under the same data
+// key as the history itself.
+type historyRevision struct {
+	ID        string    `bson:"_id"`
+	HistoryID string    `bson:"history_id"`
+	UserID    string    `bson:"user_id"`
+	Version   int64     `bson:"version"`
+	CreatedAt time.Time `bson:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at"`
+	Content   string    `bson:"encrypted_content"`
+}
+
+// RevisionsCollection returns the collection holding the revisions of the
+// histories in collection.
+func RevisionsCollection(collection *mongo.Collection) *mongo.Collection {
+	return collection.Database().Collection(collection.Name() + "-revisions")
+}
+
+// archiveRevisions copies written histories into the revisions collection.
+// The histories are already saved by then, so a failure is only logged.
+func archiveRevisions(ctx context.Context, collection *mongo.Collection, stored ...*encryptedHistory) {
+	if len(stored) == 0 {
+		return
+	}
+	documents := make([]any, len(stored))
+	for i, history := range stored {
+		documents[i] = historyRevision{
+			ID:        history.ID + ":" + strconv.FormatInt(history.Version, 10),
+			HistoryID: history.ID,
+			UserID:    history.UserID,
+			Version:   history.Version,
+			CreatedAt: history.CreatedAt,
+			UpdatedAt: history.UpdatedAt,
+			Content:   history.Content,
+		}
+	}
+	_, err := RevisionsCollection(collection).InsertMany(ctx, documents, options.InsertMany().SetOrdered(false))
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		slog.Warn("Failed to archive medical history revision", "count", len(stored), "error", err)
+	}
+}
+
+// FindMedicalHistoryRevisions returns every archived version of the user's
+// history, oldest first. A revision keeps the ID it was archived under, the
+// history ID followed by its version.
+func FindMedicalHistoryRevisions(ctx context.Context, collection *mongo.Collection, userID string) ([]models.MedicalHistory, error) {
+	cursor, err := RevisionsCollection(collection).Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.D{{Key: "version", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	revisions := []models.MedicalHistory{}
+	for cursor.Next(ctx) {
+		revision, err := DecodeMedicalHistory(ctx, cursor.Current)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, *revision)
+	}
+	return revisions, cursor.Err()
+}
+
+// DecodeMedicalHistory decrypts a stored history document. Histories written
+// before encryption was enabled are decoded as they are.
+func DecodeMedicalHistory(ctx context.Context, raw bson.Raw) (*models.MedicalHistory, error) {
+	var history models.MedicalHistory
+	if _, err := raw.LookupErr("encrypted_content"); err != nil {
+		if err := bson.Unmarshal(raw, &history); err != nil {
+			return nil, err
+		}
+		return &history, nil
+	}
+
+	var stored encryptedHistory
+	if err := bson.Unmarshal(raw, &stored); err != nil {
+		return nil, err
+	}
+	plaintext, err := encryption.Current().Decrypt(ctx, stored.UserID, historyContentField, stored.Content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt medical history: %w", err)
+	}
+	var content historyContent
+	if err := bson.Unmarshal(plaintext, &content); err != nil {
+		return nil, fmt.Errorf("failed to decode medical history: %w", err)
+	}
+	return &models.MedicalHistory{
+		ID:            stored.ID,
+		UserID:        stored.UserID,
+		MedicalIssues: content.MedicalIssues,
+		Prescriptions: content.Prescriptions,
+		Appointments:  content.Appointments,
+		Allergies:     content.Allergies,
+		Immunizations: content.Immunizations,
+		Version:       stored.Version,
+		CreatedAt:     stored.CreatedAt,
+		UpdatedAt:     stored.UpdatedAt,
+	}, nil
+}
+
+// FindMedicalHistory returns the user's history, or nil if none is stored yet.
+func FindMedicalHistory(ctx context.Context, collection *mongo.Collection, userID string) (*models.MedicalHistory, error) {
+	raw, err := collection.FindOne(ctx, bson.M{"user_id": userID}).Raw()
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return DecodeMedicalHistory(ctx, raw)
+}
+
+// FindMedicalHistories returns the stored histories of userIDs by user ID.
+func FindMedicalHistories(ctx context.Context, collection *mongo.Collection, userIDs []string) (map[string]*models.MedicalHistory, error) {
+	cursor, err := collection.Find(ctx, bson.M{"user_id": bson.M{"$in": userIDs}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	histories := map[string]*models.MedicalHistory{}
+	for cursor.Next(ctx) {
+		history, err := DecodeMedicalHistory(ctx, cursor.Current)
+		if err != nil {
+			return nil, err
+		}
+		histories[history.UserID] = history
+	}
+	return histories, cursor.Err()
+}
+
+// CheckVersion compares the stored history against the client's precondition.
+// A missing history is only at version 0, and "*" only matches an existing one.
+func CheckVersion(current *models.MedicalHistory, expected int64, wildcard bool) error {
+	if current == nil {
+		if wildcard || expected != 0 {
+			return ErrVersionConflict
+		}
+		return nil
+	}
+	if !wildcard && current.Version != expected {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+func issueKey(issue models.Issue) string {
+	return strings.ToLower(strings.TrimSpace(issue.Condition)) + "|" + issue.StartDate.UTC().Format(time.RFC3339)
+}
+
+func appointmentKey(appointment models.Appointment) string {
+	return appointment.DoctorID + "|" + appointment.AppointmentDate.UTC().Format(time.RFC3339)
+}
+
+func prescriptionKey(prescription models.Prescription) string {
+	return strings.ToLower(strings.TrimSpace(prescription.MedicationName)) + "|" + prescription.StartDate.UTC().Format(time.RFC3339)
+}
+
+// AssignEntryIDs gives each issue, prescription and appointment of next
+// without an ID the ID of the same entry in current, or a new one, so
+// attachments and reminders stay linked to an entry however the lists are
+// reordered.
+func AssignEntryIDs(current, next *models.MedicalHistory) {
+	issueIDs, prescriptionIDs, appointmentIDs := map[string][]string{}, map[string][]string{}, map[string][]string{}
+	if current != nil {
+		for _, issue := range current.MedicalIssues {
+			if issue.ID != "" {
+				issueIDs[issueKey(issue)] = append(issueIDs[issueKey(issue)], issue.ID)
+			}
+		}
+		for _, prescription := range current.Prescriptions {
+			if prescription.ID != "" {
+				prescriptionIDs[prescriptionKey(prescription)] = append(prescriptionIDs[prescriptionKey(prescription)], prescription.ID)
+			}
+		}
+		for _, appointment := range current.Appointments {
+			if appointment.ID != "" {
+				appointmentIDs[appointmentKey(appointment)] = append(appointmentIDs[appointmentKey(appointment)], appointment.ID)
+			}
+		}
+	}
+	// IDs the client sent are kept, unless two entries share one.
+	present, taken := map[string]bool{}, map[string]bool{}
+	for _, issue := range next.MedicalIssues {
+		present[issue.ID] = true
+	}
+	for _, prescription := range next.Prescriptions {
+		present[prescription.ID] = true
+	}
+	for _, appointment := range next.Appointments {
+		present[appointment.ID] = true
+	}
+	assign := func(id string, candidates []string) string {
+		if id == "" || taken[id] {
+			id = uuid.New().String()
+			for _, candidate := range candidates {
+				if !present[candidate] && !taken[candidate] {
+					id = candidate
+					break
+				}
+			}
+		}
+		taken[id] = true
+		return id
+	}
+
+	if len(next.MedicalIssues) > 0 {
+		next.MedicalIssues = append([]models.Issue{}, next.MedicalIssues...)
+	}
+	for i := range next.MedicalIssues {
+		issue := &next.MedicalIssues[i]
+		issue.ID = assign(issue.ID, issueIDs[issueKey(*issue)])
+	}
+	if len(next.Prescriptions) > 0 {
+		next.Prescriptions = append([]models.Prescription{}, next.Prescriptions...)
+	}
+	for i := range next.Prescriptions {
+		prescription := &next.Prescriptions[i]
+		prescription.ID = assign(prescription.ID, prescriptionIDs[prescriptionKey(*prescription)])
+	}
+	if len(next.Appointments) > 0 {
+		next.Appointments = append([]models.Appointment{}, next.Appointments...)
+	}
+	for i := range next.Appointments {
+		appointment := &next.Appointments[i]
+		appointment.ID = assign(appointment.ID, appointmentIDs[appointmentKey(*appointment)])
+	}
+}
+
+// WriteMedicalHistory stores next as the successor of current, bumping the
+// version. The write only succeeds if nobody else changed the history since
+// current was read.
+func WriteMedicalHistory(ctx context.Context, collection *mongo.Collection, current, next *models.MedicalHistory) error {
+	now := time.Now()
+	next.UpdatedAt = now
+	AssignEntryIDs(current, next)
+	if current == nil {
+		next.ID = uuid.New().String()
+		next.Version = 1
+		next.CreatedAt = now
+		stored, err := encryptHistory(ctx, next)
+		if err != nil {
+			return err
+		}
+		_, err = collection.InsertOne(ctx, stored)
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrVersionConflict
+		}
+		if err != nil {
+			return err
+		}
+		archiveRevisions(ctx, collection, stored)
+		return nil
+	}
+
+	next.ID = current.ID
+	next.Version = current.Version + 1
+	next.CreatedAt = current.CreatedAt
+	stored, err := encryptHistory(ctx, next)
+	if err != nil {
+		return err
+	}
+	result, err := collection.ReplaceOne(ctx, versionFilter(bson.M{"_id": current.ID}, current.Version), stored)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrVersionConflict
+	}
+	archiveRevisions(ctx, collection, stored)
+	return nil
+}
+
+const maxUpdateAttempts = 3
+
+// UpdateMedicalHistory applies mutate to the user's current history and
+// writes it back, retrying from a fresh read when another write got there
+// first. mutate must not keep references to the history between attempts.
+func UpdateMedicalHistory(ctx context.Context, collection *mongo.Collection, userID string, mutate func(history *models.MedicalHistory) error) (*models.MedicalHistory, error) {
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		current, err := FindMedicalHistory(ctx, collection, userID)
+		if err != nil {
+			return nil, err
+		}
+		next := models.MedicalHistory{UserID: userID}
+		if current != nil {
+			next = *current
+			next.MedicalIssues = append([]models.Issue{}, current.MedicalIssues...)
+			next.Appointments = append([]models.Appointment{}, current.Appointments...)
+		}
+		if err := mutate(&next); err != nil {
+			return nil, err
+		}
+		err = WriteMedicalHistory(ctx, collection, current, &next)
+		if err == ErrVersionConflict {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &next, nil
+	}
+	return nil, ErrVersionConflict
+}
+
+// ReplaceMedicalHistories stores each history as the next version of its
+// user's current history, or as a new one, in a single bulk write. errs holds
+// one entry per history: nil when it was written, ErrVersionConflict when the
+// user's history changed or was created concurrently.
+func ReplaceMedicalHistories(ctx context.Context, collection *mongo.Collection, histories []models.MedicalHistory) (created, replaced int, errs []error, err error) {
+	errs = make([]error, len(histories))
+	if len(histories) == 0 {
+		return 0, 0, errs, nil
+	}
+	userIDs := make([]string, len(histories))
+	for i, history := range histories {
+		userIDs[i] = history.UserID
+	}
+	projection := options.Find().SetProjection(bson.M{"_id": 1, "user_id": 1, "version": 1, "created_at": 1})
+	cursor, err := collection.Find(ctx, bson.M{"user_id": bson.M{"$in": userIDs}}, projection)
+	if err != nil {
+		return 0, 0, errs, err
+	}
+	var existing []encryptedHistory
+	if err := cursor.All(ctx, &existing); err != nil {
+		return 0, 0, errs, err
+	}
+	current := map[string]encryptedHistory{}
+	for _, history := range existing {
+		current[history.UserID] = history
+	}
+
+	now := time.Now()
+	writes := make([]mongo.WriteModel, 0, len(histories))
+	documents := make([]*encryptedHistory, 0, len(histories))
+	indexes := make([]int, 0, len(histories))
+	for i := range histories {
+		next := histories[i]
+		next.UpdatedAt = now
+		AssignEntryIDs(nil, &next)
+		filter := bson.M{}
+		if stored, ok := current[next.UserID]; ok {
+			next.ID = stored.ID
+			next.Version = stored.Version + 1
+			next.CreatedAt = stored.CreatedAt
+			filter = versionFilter(bson.M{"_id": stored.ID}, stored.Version)
+		} else {
+			next.ID = uuid.New().String()
+			next.Version = 1
+			next.CreatedAt = now
+			filter["_id"] = next.ID
+		}
+		document, err := encryptHistory(ctx, &next)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		// Upserting turns a lost race into a duplicate key error on _id or
+		// user_id, which identifies the history that conflicted.
+		writes = append(writes, mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(document).SetUpsert(true))
+		documents = append(documents, document)
+		indexes = append(indexes, i)
+	}
+	if len(writes) == 0 {
+		return 0, 0, errs, nil
+	}
+
+	result, err := collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	if result != nil {
+		created, replaced = int(result.UpsertedCount), int(result.MatchedCount)
+	}
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil {
+		failed := map[int]bool{}
+		for _, writeErr := range bulkErr.WriteErrors {
+			failed[writeErr.Index] = true
+			if writeErr.Code == 11000 {
+				errs[indexes[writeErr.Index]] = ErrVersionConflict
+			} else {
+				errs[indexes[writeErr.Index]] = writeErr
+			}
+		}
+		written := make([]*encryptedHistory, 0, len(documents))
+		for i, document := range documents {
+			if !failed[i] {
+				written = append(written, document)
+			}
+		}
+		archiveRevisions(ctx, collection, written...)
+		return created, replaced, errs, nil
+	}
+	if err != nil {
+		return created, replaced, errs, err
+	}
+	archiveRevisions(ctx, collection, documents...)
+	return created, replaced, errs, nil
+}
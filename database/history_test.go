@@ -0,0 +1,94 @@
+package database
+
+import (
+	"life-signal/models"
+	"testing"
+	"time"
+)
+
+func TestAssignEntryIDs(t *testing.T) {
+	day := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	current := &models.MedicalHistory{
+		UserID: "u1",
+		MedicalIssues: []models.Issue{
+			{ID: "issue-asthma", Condition: "Asthma", StartDate: day},
+			{ID: "issue-flu", Condition: "Influenza", StartDate: day.AddDate(0, 0, 3)},
+		},
+		Appointments: []models.Appointment{
+			{ID: "appt-1", DoctorID: "d1", AppointmentDate: day},
+		},
+	}
+
+	// A client that does not know about IDs sends the lists reordered.
+	next := &models.MedicalHistory{
+		UserID: "u1",
+		MedicalIssues: []models.Issue{
+			{Condition: "influenza ", StartDate: day.AddDate(0, 0, 3)},
+			{Condition: "Sprained ankle", StartDate: day},
+			{Condition: "Asthma", StartDate: day},
+		},
+		Appointments: []models.Appointment{
+			{DoctorID: "d1", AppointmentDate: day},
+			{DoctorID: "d2", AppointmentDate: day},
+		},
+	}
+	AssignEntryIDs(current, next)
+	if got := next.MedicalIssues[0].ID; got != "issue-flu" {
+		t.Errorf("reordered issue got ID %q, want issue-flu", got)
+	}
+	if got := next.MedicalIssues[2].ID; got != "issue-asthma" {
+		t.Errorf("reordered issue got ID %q, want issue-asthma", got)
+	}
+	if got := next.Appointments[0].ID; got != "appt-1" {
+		t.Errorf("appointment got ID %q, want appt-1", got)
+	}
+	seen := map[string]bool{}
+	for _, id := range []string{next.MedicalIssues[0].ID, next.MedicalIssues[1].ID, next.MedicalIssues[2].ID, next.Appointments[0].ID, next.Appointments[1].ID} {
+		if id == "" || seen[id] {
+			t.Errorf("entry ID %q is empty or repeated", id)
+		}
+		seen[id] = true
+	}
+	if current.MedicalIssues[0].ID != "issue-asthma" {
+		t.Error("AssignEntryIDs changed the current history")
+	}
+}
+
+func TestAssignEntryIDsKeepsClientIDs(t *testing.T) {
+	day := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	current := &models.MedicalHistory{MedicalIssues: []models.Issue{{ID: "old", Condition: "Asthma", StartDate: day}}}
+	next := &models.MedicalHistory{MedicalIssues: []models.Issue{
+		{Condition: "Asthma", StartDate: day},
+		{ID: "old", Condition: "Asthma (renamed)", StartDate: day},
+		{ID: "copied", Condition: "Eczema", StartDate: day},
+		{ID: "copied", Condition: "Eczema", StartDate: day},
+	}}
+	AssignEntryIDs(current, next)
+	if next.MedicalIssues[1].ID != "old" {
+		t.Errorf("client ID replaced with %q", next.MedicalIssues[1].ID)
+	}
+	if id := next.MedicalIssues[0].ID; id == "old" || id == "" {
+		t.Errorf("entry without ID got %q, which another entry already claims", id)
+	}
+	if next.MedicalIssues[2].ID != "copied" || next.MedicalIssues[3].ID == "copied" {
+		t.Errorf("duplicated IDs %q and %q, want the second one replaced", next.MedicalIssues[2].ID, next.MedicalIssues[3].ID)
+	}
+}
+
+func TestAssignEntryIDsToPrescriptions(t *testing.T) {
+	day := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	current := &models.MedicalHistory{Prescriptions: []models.Prescription{
+		{ID: "rx-metformin", MedicationName: "Metformin", StartDate: day},
+	}}
+	next := &models.MedicalHistory{Prescriptions: []models.Prescription{
+		{MedicationName: "Atorvastatin", StartDate: day},
+		{MedicationName: "metformin", StartDate: day, Dosage: "2 tablets daily"},
+	}}
+	AssignEntryIDs(current, next)
+	if got := next.Prescriptions[1].ID; got != "rx-metformin" {
+		t.Errorf("edited prescription got ID %q, want rx-metformin", got)
+	}
+	if id := next.Prescriptions[0].ID; id == "" || id == "rx-metformin" {
+		t.Errorf("new prescription got ID %q", id)
+	}
+}
@@ -0,0 +1,203 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"life-signal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrAlreadyInvoiced = errors.New("booking already has an invoice")
+
+var ErrDiscountExists = errors.New("discount code already exists")
+
+var ErrDiscountUnavailable = errors.New("discount code is unknown, inactive, expired or used up")
+
+// InsertInvoice stores a new invoice, failing with ErrAlreadyInvoiced when
+// its booking has one.
+func InsertInvoice(ctx context.Context, collection *mongo.Collection, invoice *models.Invoice) error {
+	now := time.Now()
+	invoice.Version = 1
+	invoice.IssuedAt = now
+	invoice.UpdatedAt = now
+	_, err := collection.InsertOne(ctx, invoice)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrAlreadyInvoiced
+	}
+	return err
+}
+
+// FindInvoice returns the invoice matching filter, or nil when there is none.
+func FindInvoice(ctx context.Context, collection *mongo.Collection, filter bson.M) (*models.Invoice, error) {
+	var invoice models.Invoice
+	err := collection.FindOne(ctx, filter).Decode(&invoice)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// FindInvoices returns the invoices matching filter, newest first.
+func FindInvoices(ctx context.Context, collection *mongo.Collection, filter bson.M) ([]models.Invoice, error) {
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "issued_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	invoices := []models.Invoice{}
+	if err := cursor.All(ctx, &invoices); err != nil {
+		return nil, err
+	}
+	return invoices, nil
+}
+
+// UpdateInvoice applies mutate to the invoice matching filter and writes it
+// back, retrying from a fresh read when another write got there first. It
+// returns nil when no invoice matches. mutate must not keep references to
+// the invoice between attempts.
+func UpdateInvoice(ctx context.Context, collection *mongo.Collection, filter bson.M, mutate func(invoice *models.Invoice) error) (*models.Invoice, error) {
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		invoice, err := FindInvoice(ctx, collection, filter)
+		if err != nil || invoice == nil {
+			return nil, err
+		}
+		if err := mutate(invoice); err != nil {
+			return nil, err
+		}
+		expected := invoice.Version
+		invoice.Version++
+		invoice.UpdatedAt = time.Now()
+		result, err := collection.ReplaceOne(ctx, bson.M{"_id": invoice.ID, "version": expected}, invoice)
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 1 {
+			return invoice, nil
+		}
+	}
+	return nil, ErrVersionConflict
+}
+
+// NextInvoiceNumber returns the next number in the invoice sequence of year,
+// such as INV-2026-000042. Numbers are never handed out twice, but one taken
+// by an invoice that failed to save leaves a gap.
+func NextInvoiceNumber(ctx context.Context, counters *mongo.Collection, year int) (string, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := counters.FindOneAndUpdate(ctx, bson.M{"_id": fmt.Sprintf("invoice-%d", year)}, bson.M{"$inc": bson.M{"seq": 1}}, opts).Decode(&counter)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("INV-%d-%06d", year, counter.Seq), nil
+}
+
+// InsertDiscount stores a new discount code, failing with ErrDiscountExists
+// when the code is taken.
+func InsertDiscount(ctx context.Context, collection *mongo.Collection, discount *models.Discount) error {
+	discount.CreatedAt = time.Now()
+	_, err := collection.InsertOne(ctx, discount)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDiscountExists
+	}
+	return err
+}
+
+// FindDiscounts returns every discount code, newest first.
+func FindDiscounts(ctx context.Context, collection *mongo.Collection) ([]models.Discount, error) {
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	discounts := []models.Discount{}
+	if err := cursor.All(ctx, &discounts); err != nil {
+		return nil, err
+	}
+	return discounts, nil
+}
+
+// usableDiscount selects the discount code if it can still be redeemed at
+// now.
+func usableDiscount(code string, now time.Time) bson.M {
+	return bson.M{
+		"_id":    code,
+		"active": true,
+		"$and": bson.A{
+			bson.M{"$or": bson.A{bson.M{"expires_at": nil}, bson.M{"expires_at": bson.M{"$gt": now}}}},
+			bson.M{"$or": bson.A{bson.M{"max_redemptions": 0}, bson.M{"$expr": bson.M{"$lt": bson.A{"$redemptions", "$max_redemptions"}}}}},
+		},
+	}
+}
+
+// FindUsableDiscount returns the discount code if it can still be redeemed,
+// failing with ErrDiscountUnavailable otherwise.
+func FindUsableDiscount(ctx context.Context, collection *mongo.Collection, code string) (*models.Discount, error) {
+	var discount models.Discount
+	err := collection.FindOne(ctx, usableDiscount(code, time.Now())).Decode(&discount)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrDiscountUnavailable
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &discount, nil
+}
+
+// RedeemDiscount counts one use of the discount code, failing with
+// ErrDiscountUnavailable when it cannot be redeemed any more. The check and
+// the count are one write, so the code is never used more often than
+// allowed.
+func RedeemDiscount(ctx context.Context, collection *mongo.Collection, code string) (*models.Discount, error) {
+	var discount models.Discount
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := collection.FindOneAndUpdate(ctx, usableDiscount(code, time.Now()), bson.M{"$inc": bson.M{"redemptions": 1}}, opts).Decode(&discount)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrDiscountUnavailable
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &discount, nil
+}
+
+// ReleaseDiscount gives back a use of the discount code taken by an invoice
+// that was not saved or was voided.
+func ReleaseDiscount(ctx context.Context, collection *mongo.Collection, code string) error {
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": code, "redemptions": bson.M{"$gt": 0}}, bson.M{"$inc": bson.M{"redemptions": -1}})
+	return err
+}
+
+// ClaimPaymentEvent records that the webhook event id is being processed and
+// reports whether it was processed before. An event whose processing failed
+// part way is handed out again.
+func ClaimPaymentEvent(ctx context.Context, collection *mongo.Collection, event models.PaymentEvent) (bool, error) {
+	event.Status = models.PaymentEventProcessing
+	event.ReceivedAt = time.Now()
+	_, err := collection.InsertOne(ctx, event)
+	if err == nil {
+		return false, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return false, err
+	}
+	var existing models.PaymentEvent
+	if err := collection.FindOne(ctx, bson.M{"_id": event.ID}).Decode(&existing); err != nil {
+		return false, err
+	}
+	return existing.Status == models.PaymentEventProcessed, nil
+}
+
+// MarkPaymentEventProcessed records that the webhook event id was applied.
+func MarkPaymentEventProcessed(ctx context.Context, collection *mongo.Collection, id string) error {
+	now := time.Now()
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"status": models.PaymentEventProcessed, "processed_at": now}})
+	return err
+}
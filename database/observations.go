@@ -0,0 +1,325 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"life-signal/encryption"
+	"life-signal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	vitalSignContentField      = "vital_sign"
+	labObservationContentField = "lab_observation"
+)
+
+// vitalSignContent is the part of a vital sign that is encrypted at rest.
+type vitalSignContent struct {
+	Value     float64  `bson:"value"`
+	Diastolic *float64 `bson:"diastolic,omitempty"`
+	Unit      string   `bson:"unit"`
+	Notes     string   `bson:"notes,omitempty"`
+}
+
+// encryptedVitalSign is how a vital sign is stored: the type and time stay
+// in the clear for filtering and sorting.
+type encryptedVitalSign struct {
+	ID         string    `bson:"_id"`
+	UserID     string    `bson:"user_id"`
+	Type       string    `bson:"type"`
+	MeasuredAt time.Time `bson:"measured_at"`
+	CreatedAt  time.Time `bson:"created_at"`
+	Content    string    `bson:"encrypted_content"`
+}
+
+// labObservationContent is the part of a lab result that is encrypted at rest.
+type labObservationContent struct {
+	Value          string   `bson:"value"`
+	NumericValue   *float64 `bson:"numeric_value,omitempty"`
+	Unit           string   `bson:"unit,omitempty"`
+	ReferenceRange string   `bson:"reference_range,omitempty"`
+	AbnormalFlag   string   `bson:"abnormal_flag,omitempty"`
+}
+
+type encryptedLabObservation struct {
+	ID              string    `bson:"_id"`
+	UserID          string    `bson:"user_id"`
+	Code            string    `bson:"code"`
+	Display         string    `bson:"display"`
+	CodingSystem    string    `bson:"coding_system,omitempty"`
+	Status          string    `bson:"status"`
+	ObservedAt      time.Time `bson:"observed_at"`
+	SourceMessageID string    `bson:"source_message_id,omitempty"`
+	CreatedAt       time.Time `bson:"created_at"`
+	Content         string    `bson:"encrypted_content"`
+}
+
+// sealContent encrypts content with the data key of userID.
+func sealContent(ctx context.Context, userID, field string, content any) (string, error) {
+	plaintext, err := bson.Marshal(content)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode %s: %w", field, err)
+	}
+	return encryption.Current().Encrypt(ctx, userID, field, plaintext)
+}
+
+// openContent decrypts the encrypted_content of raw into content, leaving
+// content as it is for records stored before they were encrypted.
+func openContent(ctx context.Context, raw bson.Raw, field string, content any) error {
+	ciphertext, ok := raw.Lookup("encrypted_content").StringValueOK()
+	if !ok {
+		return nil
+	}
+	userID, _ := raw.Lookup("user_id").StringValueOK()
+	plaintext, err := encryption.Current().Decrypt(ctx, userID, field, ciphertext)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt %s: %w", field, err)
+	}
+	return bson.Unmarshal(plaintext, content)
+}
+
+func encryptVitalSign(ctx context.Context, vital *models.VitalSign) (*encryptedVitalSign, error) {
+	content, err := sealContent(ctx, vital.UserID, vitalSignContentField, vitalSignContent{
+		Value:     vital.Value,
+		Diastolic: vital.Diastolic,
+		Unit:      vital.Unit,
+		Notes:     vital.Notes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedVitalSign{
+		ID:         vital.ID,
+		UserID:     vital.UserID,
+		Type:       vital.Type,
+		MeasuredAt: vital.MeasuredAt,
+		CreatedAt:  vital.CreatedAt,
+		Content:    content,
+	}, nil
+}
+
+// DecodeVitalSign decrypts a stored vital sign document.
+func DecodeVitalSign(ctx context.Context, raw bson.Raw) (*models.VitalSign, error) {
+	var vital models.VitalSign
+	if err := bson.Unmarshal(raw, &vital); err != nil {
+		return nil, err
+	}
+	content := vitalSignContent{Value: vital.Value, Diastolic: vital.Diastolic, Unit: vital.Unit, Notes: vital.Notes}
+	if err := openContent(ctx, raw, vitalSignContentField, &content); err != nil {
+		return nil, err
+	}
+	vital.Value, vital.Diastolic, vital.Unit, vital.Notes = content.Value, content.Diastolic, content.Unit, content.Notes
+	return &vital, nil
+}
+
+func InsertVitalSign(ctx context.Context, collection *mongo.Collection, vital *models.VitalSign) error {
+	stored, err := encryptVitalSign(ctx, vital)
+	if err != nil {
+		return err
+	}
+	_, err = collection.InsertOne(ctx, stored)
+	return err
+}
+
+// EncryptVitalSign replaces a vital sign stored in plaintext with its
+// encrypted form, reporting false when it was no longer in plaintext.
+func EncryptVitalSign(ctx context.Context, collection *mongo.Collection, vital *models.VitalSign) (bool, error) {
+	stored, err := encryptVitalSign(ctx, vital)
+	if err != nil {
+		return false, err
+	}
+	result, err := collection.ReplaceOne(ctx, bson.M{"_id": vital.ID, "encrypted_content": bson.M{"$exists": false}}, stored)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func encryptLabObservation(ctx context.Context, observation *models.LabObservation) (*encryptedLabObservation, error) {
+	content, err := sealContent(ctx, observation.UserID, labObservationContentField, labObservationContent{
+		Value:          observation.Value,
+		NumericValue:   observation.NumericValue,
+		Unit:           observation.Unit,
+		ReferenceRange: observation.ReferenceRange,
+		AbnormalFlag:   observation.AbnormalFlag,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedLabObservation{
+		ID:              observation.ID,
+		UserID:          observation.UserID,
+		Code:            observation.Code,
+		Display:         observation.Display,
+		CodingSystem:    observation.CodingSystem,
+		Status:          observation.Status,
+		ObservedAt:      observation.ObservedAt,
+		SourceMessageID: observation.SourceMessageID,
+		CreatedAt:       observation.CreatedAt,
+		Content:         content,
+	}, nil
+}
+
+// DecodeLabObservation decrypts a stored lab result document.
+func DecodeLabObservation(ctx context.Context, raw bson.Raw) (*models.LabObservation, error) {
+	var observation models.LabObservation
+	if err := bson.Unmarshal(raw, &observation); err != nil {
+		return nil, err
+	}
+	content := labObservationContent{
+		Value:          observation.Value,
+		NumericValue:   observation.NumericValue,
+		Unit:           observation.Unit,
+		ReferenceRange: observation.ReferenceRange,
+		AbnormalFlag:   observation.AbnormalFlag,
+	}
+	if err := openContent(ctx, raw, labObservationContentField, &content); err != nil {
+		return nil, err
+	}
+	observation.Value, observation.NumericValue, observation.Unit = content.Value, content.NumericValue, content.Unit
+	observation.ReferenceRange, observation.AbnormalFlag = content.ReferenceRange, content.AbnormalFlag
+	return &observation, nil
+}
+
+// SaveLabObservation stores observation, replacing the one with the same ID
+// if any.
+func SaveLabObservation(ctx context.Context, collection *mongo.Collection, observation *models.LabObservation) error {
+	stored, err := encryptLabObservation(ctx, observation)
+	if err != nil {
+		return err
+	}
+	_, err = collection.ReplaceOne(ctx, bson.M{"_id": observation.ID}, stored, options.Replace().SetUpsert(true))
+	return err
+}
+
+// EncryptLabObservation replaces a lab result stored in plaintext with its
+// encrypted form, reporting false when it was no longer in plaintext.
+func EncryptLabObservation(ctx context.Context, collection *mongo.Collection, observation *models.LabObservation) (bool, error) {
+	stored, err := encryptLabObservation(ctx, observation)
+	if err != nil {
+		return false, err
+	}
+	result, err := collection.ReplaceOne(ctx, bson.M{"_id": observation.ID, "encrypted_content": bson.M{"$exists": false}}, stored)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// decodeAll decodes every document of cursor with decode.
+func decodeAll[T any](ctx context.Context, cursor *mongo.Cursor, decode func(context.Context, bson.Raw) (*T, error)) ([]T, error) {
+	defer cursor.Close(ctx)
+	records := []T{}
+	for cursor.Next(ctx) {
+		record, err := decode(ctx, cursor.Current)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, *record)
+	}
+	return records, cursor.Err()
+}
+
+// FindVitalSigns returns the user's vital signs, newest first. An empty
+// vitalType returns every type; a limit of 0 returns everything.
+func FindVitalSigns(ctx context.Context, collection *mongo.Collection, userID, vitalType string, limit int64) ([]models.VitalSign, error) {
+	filter := bson.M{"user_id": userID}
+	if vitalType != "" {
+		filter["type"] = vitalType
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "measured_at", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	return decodeAll(ctx, cursor, DecodeVitalSign)
+}
+
+// FindUserVitalSigns returns the user's vital signs measured within
+// [from, to). Zero times leave the range open.
+func FindUserVitalSigns(ctx context.Context, collection *mongo.Collection, userID string, from, to time.Time) ([]models.VitalSign, error) {
+	cursor, err := collection.Find(ctx, userRecordsFilter(userID, "measured_at", from, to))
+	if err != nil {
+		return nil, err
+	}
+	return decodeAll(ctx, cursor, DecodeVitalSign)
+}
+
+// LatestVitalSigns returns the most recent reading of each vital sign type.
+func LatestVitalSigns(ctx context.Context, collection *mongo.Collection, userID string) ([]models.VitalSign, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"user_id": userID}}},
+		{{Key: "$sort", Value: bson.D{{Key: "measured_at", Value: -1}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$type", "latest": bson.M{"$first": "$$ROOT"}}}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$latest"}}},
+		{{Key: "$sort", Value: bson.D{{Key: "type", Value: 1}}}},
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	return decodeAll(ctx, cursor, DecodeVitalSign)
+}
+
+// FindLabObservations returns the user's lab results, newest first. An empty
+// code returns every test; a limit of 0 returns everything.
+func FindLabObservations(ctx context.Context, collection *mongo.Collection, userID, code string, limit int64) ([]models.LabObservation, error) {
+	filter := bson.M{"user_id": userID}
+	if code != "" {
+		filter["code"] = code
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "observed_at", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	return decodeAll(ctx, cursor, DecodeLabObservation)
+}
+
+// FindUserLabObservations returns the user's lab results observed within
+// [from, to). Zero times leave the range open.
+func FindUserLabObservations(ctx context.Context, collection *mongo.Collection, userID string, from, to time.Time) ([]models.LabObservation, error) {
+	cursor, err := collection.Find(ctx, userRecordsFilter(userID, "observed_at", from, to))
+	if err != nil {
+		return nil, err
+	}
+	return decodeAll(ctx, cursor, DecodeLabObservation)
+}
+
+// userRecordsFilter matches the records of the user whose dateField lies in
+// [from, to). Zero times leave the range open.
+func userRecordsFilter(userID, dateField string, from, to time.Time) bson.M {
+	filter := bson.M{"user_id": userID}
+	dateRange := bson.M{}
+	if !from.IsZero() {
+		dateRange["$gte"] = from
+	}
+	if !to.IsZero() {
+		dateRange["$lt"] = to
+	}
+	if len(dateRange) > 0 {
+		filter[dateField] = dateRange
+	}
+	return filter
+}
+
+// FindUserRecords decodes every record of the user whose dateField lies in
+// [from, to) into out, a pointer to a slice. Zero times leave the range open.
+func FindUserRecords(ctx context.Context, collection *mongo.Collection, userID, dateField string, from, to time.Time, out any) error {
+	cursor, err := collection.Find(ctx, userRecordsFilter(userID, dateField, from, to))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	return cursor.All(ctx, out)
+}
@@ -0,0 +1,194 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"life-signal/models"
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrAlreadyReviewed = errors.New("doctor is already reviewed by this user")
+
+var ErrAlreadyReported = errors.New("review is already reported by this user")
+
+// InsertReview stores a new review, failing with ErrAlreadyReviewed when its
+// author has reviewed the doctor before.
+func InsertReview(ctx context.Context, collection *mongo.Collection, review *models.Review) error {
+	now := time.Now()
+	review.RatingSynced = false
+	review.Version = 1
+	review.CreatedAt = now
+	review.UpdatedAt = now
+	_, err := collection.InsertOne(ctx, review)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrAlreadyReviewed
+	}
+	return err
+}
+
+// UpdateReview stores next as the successor of the review at version
+// expected and leaves its rating to be synced to the doctor again.
+func UpdateReview(ctx context.Context, collection *mongo.Collection, next *models.Review, expected int64) error {
+	next.RatingSynced = false
+	next.Version = expected + 1
+	next.UpdatedAt = time.Now()
+	result, err := collection.ReplaceOne(ctx, versionFilter(bson.M{"_id": next.ID}, expected), next)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// FindReview returns the review matching filter, or nil when there is none.
+func FindReview(ctx context.Context, collection *mongo.Collection, filter bson.M) (*models.Review, error) {
+	var review models.Review
+	err := collection.FindOne(ctx, filter).Decode(&review)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+// FindReviews returns up to limit reviews matching filter, newest first,
+// starting after the review with ID cursor, and the cursor of the next page
+// or "" on the last one. Review IDs are object IDs, so they sort by age.
+func FindReviews(ctx context.Context, collection *mongo.Collection, filter bson.M, cursor string, limit int64) ([]models.Review, string, error) {
+	if cursor != "" {
+		filter["_id"] = bson.M{"$lt": cursor}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(limit + 1)
+	found, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	reviews := []models.Review{}
+	if err := found.All(ctx, &reviews); err != nil {
+		return nil, "", err
+	}
+	if int64(len(reviews)) <= limit {
+		return reviews, "", nil
+	}
+	reviews = reviews[:limit]
+	return reviews, reviews[len(reviews)-1].ID, nil
+}
+
+// InsertReviewReport stores an abuse report, failing with ErrAlreadyReported
+// when its author has reported the review before.
+func InsertReviewReport(ctx context.Context, collection *mongo.Collection, report *models.ReviewReport) error {
+	report.CreatedAt = time.Now()
+	_, err := collection.InsertOne(ctx, report)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrAlreadyReported
+	}
+	return err
+}
+
+// FindReviewReports returns the abuse reports about the reviews with the
+// given IDs, oldest first.
+func FindReviewReports(ctx context.Context, collection *mongo.Collection, reviewIDs []string) ([]models.ReviewReport, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	found, err := collection.Find(ctx, bson.M{"review_id": bson.M{"$in": reviewIDs}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	reports := []models.ReviewReport{}
+	if err := found.All(ctx, &reports); err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+// FindDoctorRating returns the rating ledger of doctorID, or an empty one at
+// version 0 when the doctor has none yet.
+func FindDoctorRating(ctx context.Context, collection *mongo.Collection, doctorID string) (*models.DoctorRating, error) {
+	ledger := models.DoctorRating{DoctorID: doctorID}
+	err := collection.FindOne(ctx, bson.M{"_id": doctorID}).Decode(&ledger)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	if ledger.Reviews == nil {
+		ledger.Reviews = map[string]models.RatedReview{}
+	}
+	return &ledger, nil
+}
+
+// UpdateDoctorRating stores the totals of ledger and its entry for reviewID
+// as the successor of version expected, failing with ErrVersionConflict when
+// the ledger changed in the meantime. Only that entry is written, however
+// many reviews the doctor has.
+func UpdateDoctorRating(ctx context.Context, collection *mongo.Collection, ledger *models.DoctorRating, reviewID string, expected int64) error {
+	ledger.Version = expected + 1
+	ledger.UpdatedAt = time.Now()
+	if expected == 0 {
+		_, err := collection.InsertOne(ctx, ledger)
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrVersionConflict
+		}
+		return err
+	}
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": ledger.DoctorID, "version": expected}, bson.M{"$set": bson.M{
+		"sum":                 ledger.Sum,
+		"count":               ledger.Count,
+		"reviews." + reviewID: ledger.Reviews[reviewID],
+		"version":             ledger.Version,
+		"updated_at":          ledger.UpdatedAt,
+	}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// ReplaceDoctorRating stores ledger whole as the successor of version
+// expected, failing with ErrVersionConflict when the ledger changed in the
+// meantime.
+func ReplaceDoctorRating(ctx context.Context, collection *mongo.Collection, ledger *models.DoctorRating, expected int64) error {
+	ledger.Version = expected + 1
+	ledger.UpdatedAt = time.Now()
+	if expected == 0 {
+		_, err := collection.InsertOne(ctx, ledger)
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrVersionConflict
+		}
+		return err
+	}
+	result, err := collection.ReplaceOne(ctx, bson.M{"_id": ledger.DoctorID, "version": expected}, ledger)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// SetDoctorRating copies the average and count of ledger onto its doctor,
+// unless a newer version of the ledger was copied already. The average is
+// rounded to two decimals.
+func SetDoctorRating(ctx context.Context, collection *mongo.Collection, ledger *models.DoctorRating) error {
+	var rating float32
+	if ledger.Count > 0 {
+		rating = float32(math.Round(float64(ledger.Sum)/float64(ledger.Count)*100) / 100)
+	}
+	filter := bson.M{"_id": ledger.DoctorID, "rating_version": bson.M{"$not": bson.M{"$gt": ledger.Version}}}
+	_, err := collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{
+		"rating":         rating,
+		"review_count":   ledger.Count,
+		"rating_version": ledger.Version,
+	}})
+	return err
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"context"
+	"life-signal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// FindSchedule returns the schedule of a doctor, or nil when they have not
+// set one.
+func FindSchedule(ctx context.Context, collection *mongo.Collection, doctorID string) (*models.Schedule, error) {
+	var schedule models.Schedule
+	err := collection.FindOne(ctx, bson.M{"_id": doctorID}).Decode(&schedule)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// WriteSchedule stores next as the successor of the schedule at version
+// expected, 0 meaning there is none yet, and bumps the version.
+func WriteSchedule(ctx context.Context, collection *mongo.Collection, next *models.Schedule, expected int64) error {
+	next.Version = expected + 1
+	next.UpdatedAt = time.Now()
+	if expected == 0 {
+		_, err := collection.InsertOne(ctx, next)
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrVersionConflict
+		}
+		return err
+	}
+	result, err := collection.ReplaceOne(ctx, versionFilter(bson.M{"_id": next.DoctorID}, expected), next)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"life-signal/encryption"
+	"life-signal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	emailField = "email"
+	phoneField = "phone"
+)
+
+// blindIndexFilter matches field through its blind index, and through the
+// plaintext value for users stored before encryption was enabled.
+func blindIndexFilter(field string, values []string) bson.M {
+	indexes := make([]string, 0, len(values))
+	for _, value := range values {
+		if index := encryption.Current().BlindIndex(field, value); index != "" {
+			indexes = append(indexes, index)
+		}
+	}
+	return bson.M{"$or": []bson.M{
+		{field + "_index": bson.M{"$in": indexes}},
+		{field: bson.M{"$in": values}},
+	}}
+}
+
+func PhoneFilter(phones ...string) bson.M {
+	return blindIndexFilter(phoneField, phones)
+}
+
+func EmailFilter(emails ...string) bson.M {
+	return blindIndexFilter(emailField, emails)
+}
+
+// EncryptUser returns a copy of user ready to be stored, with contact fields
+// encrypted and their blind indexes set.
+func EncryptUser(ctx context.Context, user *models.UserDetails) (*models.UserDetails, error) {
+	envelope := encryption.Current()
+	stored := *user
+	var err error
+	if stored.Email, err = envelope.EncryptString(ctx, user.ID, emailField, user.Email); err != nil {
+		return nil, err
+	}
+	if stored.Phone, err = envelope.EncryptString(ctx, user.ID, phoneField, user.Phone); err != nil {
+		return nil, err
+	}
+	stored.EmailIndex = envelope.BlindIndex(emailField, user.Email)
+	stored.PhoneIndex = envelope.BlindIndex(phoneField, user.Phone)
+	return &stored, nil
+}
+
+// DecryptUser decrypts the contact fields of a stored user in place.
+func DecryptUser(ctx context.Context, user *models.UserDetails) error {
+	envelope := encryption.Current()
+	var err error
+	if user.Email, err = envelope.DecryptString(ctx, user.ID, emailField, user.Email); err != nil {
+		return fmt.Errorf("failed to decrypt user: %w", err)
+	}
+	if user.Phone, err = envelope.DecryptString(ctx, user.ID, phoneField, user.Phone); err != nil {
+		return fmt.Errorf("failed to decrypt user: %w", err)
+	}
+	return nil
+}
+
+// FindUser returns the first user matching filter with contact fields
+// decrypted, or mongo.ErrNoDocuments.
+func FindUser(ctx context.Context, collection *mongo.Collection, filter bson.M) (*models.UserDetails, error) {
+	var user models.UserDetails
+	if err := collection.FindOne(ctx, filter).Decode(&user); err != nil {
+		return nil, err
+	}
+	if err := DecryptUser(ctx, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func InsertUser(ctx context.Context, collection *mongo.Collection, user *models.UserDetails) error {
+	stored, err := EncryptUser(ctx, user)
+	if err != nil {
+		return err
+	}
+	_, err = collection.InsertOne(ctx, stored)
+	return err
+}
@@ -0,0 +1,290 @@
+package dosage
+
+import (
+	"fmt"
+	"life-signal/models"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type rule struct {
+	pattern *regexp.Regexp
+	apply   func(d *models.Dosage, match []string)
+}
+
+var routes = map[string]string{
+	"po": "PO", "by mouth": "PO", "orally": "PO", "oral": "PO",
+	"iv": "IV", "intravenous": "IV", "intravenously": "IV",
+	"im": "IM", "intramuscular": "IM", "intramuscularly": "IM",
+	"sc": "SC", "sq": "SC", "subcut": "SC", "subcutaneous": "SC", "subcutaneously": "SC",
+	"sl": "SL", "sublingual": "SL", "sublingually": "SL",
+	"pr": "PR", "rectally": "PR",
+	"inh": "INH", "inhaled": "INH", "by inhalation": "INH",
+	"top": "TOP", "topical": "TOP", "topically": "TOP",
+	"nasal": "NAS", "nasally": "NAS", "intranasal": "NAS",
+}
+
+var routeText = map[string]string{
+	"PO":  "by mouth",
+	"IV":  "intravenously",
+	"IM":  "intramuscularly",
+	"SC":  "subcutaneously",
+	"SL":  "under the tongue",
+	"PR":  "rectally",
+	"INH": "inhaled",
+	"TOP": "applied to the skin",
+	"NAS": "in the nose",
+}
+
+var units = map[string]string{
+	"mg": "mg", "mcg": "mcg", "µg": "mcg", "g": "g", "ml": "mL",
+	"unit": "unit", "units": "unit", "iu": "unit",
+	"tab": "tablet", "tabs": "tablet", "tablet": "tablet", "tablets": "tablet",
+	"cap": "capsule", "caps": "capsule", "capsule": "capsule", "capsules": "capsule",
+	"puff": "puff", "puffs": "puff",
+	"drop": "drop", "drops": "drop",
+	"spray": "spray", "sprays": "spray",
+	"patch": "patch", "patches": "patch",
+}
+
+var pluralUnits = map[string]string{
+	"unit": "units", "tablet": "tablets", "capsule": "capsules", "puff": "puffs",
+	"drop": "drops", "spray": "sprays", "patch": "patches",
+}
+
+var periodUnits = map[string]bool{"h": true, "d": true, "wk": true, "mo": true}
+
+func daily(count int) func(d *models.Dosage, match []string) {
+	return func(d *models.Dosage, match []string) {
+		d.Frequency = &models.Frequency{Count: count, Period: 1, PeriodUnit: "d"}
+	}
+}
+
+func addInstruction(d *models.Dosage, text string) {
+	if d.Instructions == "" {
+		d.Instructions = text
+	} else {
+		d.Instructions += ", " + text
+	}
+}
+
+func atoi(value string) int {
+	n, _ := strconv.Atoi(value)
+	return n
+}
+
+// The rules run in order and each removes what it matched, so longer or more
+// specific phrases come first ("for 7 days" before "prn for pain").
+var rules = []rule{
+	{regexp.MustCompile(`\b(?:x|for)\s*(\d+)\s*(days?|d|weeks?|wks?|w|months?|mo)\b`), func(d *models.Dosage, m []string) {
+		n := atoi(m[1])
+		switch {
+		case strings.HasPrefix(m[2], "w"):
+			n *= 7
+		case strings.HasPrefix(m[2], "mo"):
+			n *= 30
+		}
+		d.DurationDays = n
+	}},
+	{regexp.MustCompile(`\b(?:q|every)\s*(\d+)?(?:\s*-\s*\d+)?\s*(?:h|hr|hrs|hours?)\b|\bhourly\b`), func(d *models.Dosage, m []string) {
+		d.Frequency = &models.Frequency{Count: 1, Period: max(atoi(m[1]), 1), PeriodUnit: "h"}
+	}},
+	{regexp.MustCompile(`\b(\d+)\s*(?:x|times)\s*(?:a|per|/)\s*day\b`), func(d *models.Dosage, m []string) {
+		d.Frequency = &models.Frequency{Count: atoi(m[1]), Period: 1, PeriodUnit: "d"}
+	}},
+	{regexp.MustCompile(`\b(?:qid|four times (?:a day|daily))\b`), daily(4)},
+	{regexp.MustCompile(`\b(?:tid|three times (?:a day|daily))\b`), daily(3)},
+	{regexp.MustCompile(`\b(?:bid|twice (?:a day|daily))\b`), daily(2)},
+	{regexp.MustCompile(`\b(?:qhs|at bedtime|nightly)\b`), func(d *models.Dosage, m []string) {
+		daily(1)(d, m)
+		addInstruction(d, "at bedtime")
+	}},
+	{regexp.MustCompile(`\b(?:qam|every morning)\b`), func(d *models.Dosage, m []string) {
+		daily(1)(d, m)
+		addInstruction(d, "in the morning")
+	}},
+	{regexp.MustCompile(`\b(?:qod|every other day)\b`), func(d *models.Dosage, m []string) {
+		d.Frequency = &models.Frequency{Count: 1, Period: 2, PeriodUnit: "d"}
+	}},
+	{regexp.MustCompile(`\b(?:qwk|weekly|once (?:a week|weekly))\b`), func(d *models.Dosage, m []string) {
+		d.Frequency = &models.Frequency{Count: 1, Period: 1, PeriodUnit: "wk"}
+	}},
+	{regexp.MustCompile(`\b(?:monthly|once (?:a month|monthly))\b`), func(d *models.Dosage, m []string) {
+		d.Frequency = &models.Frequency{Count: 1, Period: 1, PeriodUnit: "mo"}
+	}},
+	{regexp.MustCompile(`\b(?:qd|od|once (?:a day|daily)|daily)\b`), daily(1)},
+	{regexp.MustCompile(`\b(by mouth|by inhalation|intravenously|intravenous|intramuscularly|intramuscular|subcutaneously|subcutaneous|subcut|sublingually|sublingual|rectally|inhaled|topically|topical|intranasal|nasally|nasal|orally|oral|po|iv|im|sc|sq|sl|pr|inh|top)\b`), func(d *models.Dosage, m []string) {
+		d.Route = routes[m[1]]
+	}},
+	{regexp.MustCompile(`(\d+(?:\.\d+)?)\s*(mg|mcg|µg|g|ml|units?|iu|tabs?|tablets?|caps?|capsules?|puffs?|drops?|sprays?|patch(?:es)?)\b`), func(d *models.Dosage, m []string) {
+		d.Amount, _ = strconv.ParseFloat(m[1], 64)
+		d.Unit = units[m[2]]
+	}},
+	{regexp.MustCompile(`\b(?:prn|as needed)\b(?:\s+(?:for\s+)?([a-z ]+?))?\s*(?:$|[,;.])`), func(d *models.Dosage, m []string) {
+		d.AsNeeded = true
+		if m[1] != "" {
+			addInstruction(d, "for "+strings.TrimSpace(m[1]))
+		}
+	}},
+}
+
+var fillers = regexp.MustCompile(`^(?:take|give|use|apply|inhale|inject|instil|instill)\b|\s+`)
+
+// Parse turns a sig such as "500 mg PO BID x 7 days" or "2 puffs inhaled q4-6h
+// prn for wheeze" into a structured dosage. Anything it does not recognise is
+// kept as instructions; only a missing dose amount is an error.
+func Parse(sig string) (models.Dosage, error) {
+	var d models.Dosage
+	text := " " + strings.ToLower(strings.TrimSpace(sig)) + " "
+	for _, r := range rules {
+		loc := r.pattern.FindStringSubmatchIndex(text)
+		if loc == nil {
+			continue
+		}
+		match := make([]string, len(loc)/2)
+		for i := range match {
+			if loc[2*i] >= 0 {
+				match[i] = text[loc[2*i]:loc[2*i+1]]
+			}
+		}
+		r.apply(&d, match)
+		text = text[:loc[0]] + " " + text[loc[1]:]
+	}
+	if d.Unit == "" {
+		return models.Dosage{}, fmt.Errorf("no dose amount and unit found in %q", sig)
+	}
+
+	rest := strings.TrimSpace(fillers.ReplaceAllString(strings.TrimSpace(text), " "))
+	rest = strings.Trim(rest, " ,;.")
+	if rest != "" {
+		addInstruction(&d, rest)
+	}
+	return d, nil
+}
+
+// Render writes a dosage back as patient-facing text, e.g.
+// "500 mg by mouth twice daily for 7 days".
+func Render(d models.Dosage) string {
+	unit := d.Unit
+	if plural, ok := pluralUnits[unit]; ok && d.Amount != 1 {
+		unit = plural
+	}
+	parts := []string{strconv.FormatFloat(d.Amount, 'f', -1, 64) + " " + unit}
+	if text, ok := routeText[d.Route]; ok {
+		parts = append(parts, text)
+	}
+	if d.Frequency != nil {
+		parts = append(parts, renderFrequency(*d.Frequency))
+	}
+	if d.AsNeeded {
+		parts = append(parts, "as needed")
+	}
+	if d.DurationDays > 0 {
+		if d.DurationDays == 1 {
+			parts = append(parts, "for 1 day")
+		} else {
+			parts = append(parts, fmt.Sprintf("for %d days", d.DurationDays))
+		}
+	}
+	if d.Instructions != "" {
+		parts = append(parts, d.Instructions)
+	}
+	return strings.Join(parts, " ")
+}
+
+func renderFrequency(f models.Frequency) string {
+	if f.Period == 1 && f.PeriodUnit == "d" {
+		switch f.Count {
+		case 1:
+			return "once daily"
+		case 2:
+			return "twice daily"
+		case 3:
+			return "three times daily"
+		case 4:
+			return "four times daily"
+		default:
+			return fmt.Sprintf("%d times daily", f.Count)
+		}
+	}
+	names := map[string]string{"h": "hour", "d": "day", "wk": "week", "mo": "month"}
+	name := names[f.PeriodUnit]
+	if f.Count == 1 {
+		switch {
+		case f.Period == 1 && f.PeriodUnit == "h":
+			return "every hour"
+		case f.Period == 1:
+			return "once " + name + "ly"
+		case f.Period == 2 && f.PeriodUnit == "d":
+			return "every other day"
+		default:
+			return fmt.Sprintf("every %d %ss", f.Period, name)
+		}
+	}
+	if f.Period == 1 {
+		return fmt.Sprintf("%d times every %s", f.Count, name)
+	}
+	return fmt.Sprintf("%d times every %d %ss", f.Count, f.Period, name)
+}
+
+// Validate checks a structured dosage written by a client.
+func Validate(d models.Dosage) error {
+	if d.Amount <= 0 {
+		return fmt.Errorf("amount must be positive")
+	}
+	known := false
+	for _, unit := range units {
+		if unit == d.Unit {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return fmt.Errorf("unit %q is not supported", d.Unit)
+	}
+	if d.Route != "" {
+		if _, ok := routeText[d.Route]; !ok {
+			return fmt.Errorf("route %q is not supported", d.Route)
+		}
+	}
+	if d.Frequency != nil {
+		if d.Frequency.Count <= 0 || d.Frequency.Period <= 0 || !periodUnits[d.Frequency.PeriodUnit] {
+			return fmt.Errorf("frequency needs a positive count and period and a period_unit of h, d, wk or mo")
+		}
+	}
+	if d.DurationDays < 0 {
+		return fmt.Errorf("duration_days cannot be negative")
+	}
+	return nil
+}
+
+// Normalize fills whichever of the free-text and structured dosage is
+// missing from the other. It reports whether the prescription changed.
+func Normalize(prescription *models.Prescription) bool {
+	if prescription.StructuredDosage == nil && prescription.Dosage != "" {
+		parsed, err := Parse(prescription.Dosage)
+		if err != nil {
+			return false
+		}
+		prescription.StructuredDosage = &parsed
+		return true
+	}
+	if prescription.StructuredDosage != nil && prescription.Dosage == "" {
+		prescription.Dosage = Render(*prescription.StructuredDosage)
+		return true
+	}
+	return false
+}
+
+// NormalizeHistory normalizes every prescription in history and returns how
+// many changed.
+func NormalizeHistory(history *models.MedicalHistory) int {
+	changed := 0
+	for i := range history.Prescriptions {
+		if Normalize(&history.Prescriptions[i]) {
+			changed++
+		}
+	}
+	return changed
+}
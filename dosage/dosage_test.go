@@ -0,0 +1,111 @@
+package dosage
+
+import (
+	"life-signal/models"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		sig    string
+		want   models.Dosage
+		render string
+	}{
+		{
+			"500 mg PO BID x 7 days",
+			models.Dosage{Amount: 500, Unit: "mg", Route: "PO", Frequency: &models.Frequency{Count: 2, Period: 1, PeriodUnit: "d"}, DurationDays: 7},
+			"500 mg by mouth twice daily for 7 days",
+		},
+		{
+			"2 puffs inhaled q4-6h prn for wheeze",
+			models.Dosage{Amount: 2, Unit: "puff", Route: "INH", Frequency: &models.Frequency{Count: 1, Period: 4, PeriodUnit: "h"}, AsNeeded: true, Instructions: "for wheeze"},
+			"2 puffs inhaled every 4 hours as needed for wheeze",
+		},
+		{
+			"1 tab qhs",
+			models.Dosage{Amount: 1, Unit: "tablet", Frequency: &models.Frequency{Count: 1, Period: 1, PeriodUnit: "d"}, Instructions: "at bedtime"},
+			"1 tablet once daily at bedtime",
+		},
+		{
+			"10 units sc 3 times a day",
+			models.Dosage{Amount: 10, Unit: "unit", Route: "SC", Frequency: &models.Frequency{Count: 3, Period: 1, PeriodUnit: "d"}},
+			"10 units subcutaneously three times daily",
+		},
+		{
+			"Take 0.5 mg orally every other day with food",
+			models.Dosage{Amount: 0.5, Unit: "mg", Route: "PO", Frequency: &models.Frequency{Count: 1, Period: 2, PeriodUnit: "d"}, Instructions: "with food"},
+			"0.5 mg by mouth every other day with food",
+		},
+		{
+			"1 patch weekly for 2 weeks",
+			models.Dosage{Amount: 1, Unit: "patch", Frequency: &models.Frequency{Count: 1, Period: 1, PeriodUnit: "wk"}, DurationDays: 14},
+			"1 patch once weekly for 14 days",
+		},
+		{
+			"1 mg IM monthly",
+			models.Dosage{Amount: 1, Unit: "mg", Route: "IM", Frequency: &models.Frequency{Count: 1, Period: 1, PeriodUnit: "mo"}},
+			"1 mg intramuscularly once monthly",
+		},
+		{
+			"2 drops q1h",
+			models.Dosage{Amount: 2, Unit: "drop", Frequency: &models.Frequency{Count: 1, Period: 1, PeriodUnit: "h"}},
+			"2 drops every hour",
+		},
+	}
+	for _, test := range tests {
+		got, err := Parse(test.sig)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.sig, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Parse(%q) = %+v, want %+v", test.sig, got, test.want)
+		}
+		if err := Validate(got); err != nil {
+			t.Errorf("Validate(Parse(%q)): %v", test.sig, err)
+		}
+		rendered := Render(got)
+		if rendered != test.render {
+			t.Errorf("Render(Parse(%q)) = %q, want %q", test.sig, rendered, test.render)
+		}
+		again, err := Parse(rendered)
+		if err != nil || !reflect.DeepEqual(again, got) {
+			t.Errorf("Parse(%q) = %+v, %v, want the dosage it was rendered from %+v", rendered, again, err, got)
+		}
+	}
+}
+
+func TestParseRejectsSigsWithoutAmount(t *testing.T) {
+	for _, sig := range []string{"", "BID", "take as directed", "PO daily x 7 days", "two tablets twice daily"} {
+		if d, err := Parse(sig); err == nil {
+			t.Errorf("Parse(%q) = %+v, want an error", sig, d)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := models.Dosage{Amount: 5, Unit: "mL", Route: "PO", Frequency: &models.Frequency{Count: 1, Period: 8, PeriodUnit: "h"}}
+	if err := Validate(valid); err != nil {
+		t.Errorf("Validate(%+v): %v", valid, err)
+	}
+	tests := []struct {
+		name   string
+		change func(d *models.Dosage)
+	}{
+		{"zero amount", func(d *models.Dosage) { d.Amount = 0 }},
+		{"unknown unit", func(d *models.Dosage) { d.Unit = "spoon" }},
+		{"unit as typed rather than normalized", func(d *models.Dosage) { d.Unit = "tabs" }},
+		{"unknown route", func(d *models.Dosage) { d.Route = "by mouth" }},
+		{"zero count", func(d *models.Dosage) { d.Frequency = &models.Frequency{Period: 1, PeriodUnit: "d"} }},
+		{"unknown period unit", func(d *models.Dosage) { d.Frequency = &models.Frequency{Count: 1, Period: 1, PeriodUnit: "y"} }},
+		{"negative duration", func(d *models.Dosage) { d.DurationDays = -1 }},
+	}
+	for _, test := range tests {
+		d := valid
+		test.change(&d)
+		if err := Validate(d); err == nil {
+			t.Errorf("%s: Validate(%+v) = nil, want an error", test.name, d)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package dosage
+
+import (
+	"life-signal/models"
+	"time"
+)
+
+// dailyTimes are the hours of day doses are taken at for a number of doses
+// a day. Larger counts are spread evenly from 08:00 to 22:00.
+var dailyTimes = map[int][]float64{
+	1: {9},
+	2: {9, 21},
+	3: {8, 14, 20},
+	4: {8, 12, 16, 20},
+}
+
+const (
+	firstDoseHour = 8
+	lastDoseHour  = 22
+	weeklyHour    = 9
+)
+
+func hoursOfDay(count int) []float64 {
+	if hours, ok := dailyTimes[count]; ok {
+		return hours
+	}
+	hours := make([]float64, count)
+	for i := range hours {
+		hours[i] = firstDoseHour + float64(i)*(lastDoseHour-firstDoseHour)/float64(count-1)
+	}
+	return hours
+}
+
+// daysBetween counts calendar days from a to b, ignoring daylight saving.
+func daysBetween(a, b time.Time) int {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return int(time.Date(by, bm, bd, 0, 0, 0, 0, time.UTC).Sub(time.Date(ay, am, ad, 0, 0, 0, 0, time.UTC)).Hours() / 24)
+}
+
+func atHour(day time.Time, hour float64) time.Time {
+	minutes := int(hour * 60)
+	return time.Date(day.Year(), day.Month(), day.Day(), minutes/60, minutes%60, 0, 0, day.Location())
+}
+
+// Times returns when doses of d, started at start, fall within [from, to),
+// reading times of day in location. Doses a day are spread over waking
+// hours, hourly schedules count from 08:00 on the first day, and weekly or
+// monthly ones fall at 09:00. Doses taken as needed have no times.
+func Times(d models.Dosage, start, from, to time.Time, location *time.Location) []time.Time {
+	f := d.Frequency
+	if d.AsNeeded || f == nil || f.Count <= 0 || f.Period <= 0 {
+		return nil
+	}
+	start = start.In(location)
+	if d.DurationDays > 0 {
+		end := time.Date(start.Year(), start.Month(), start.Day()+d.DurationDays, 0, 0, 0, 0, location)
+		if end.Before(to) {
+			to = end
+		}
+	}
+	if from.Before(start) {
+		from = start
+	}
+	if !from.Before(to) {
+		return nil
+	}
+
+	times := []time.Time{}
+	keep := func(t time.Time) {
+		if !t.Before(from) && t.Before(to) {
+			times = append(times, t)
+		}
+	}
+	first := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, location)
+	switch f.PeriodUnit {
+	case "h":
+		interval := time.Duration(f.Period) * time.Hour / time.Duration(f.Count)
+		anchor := atHour(first, firstDoseHour)
+		if interval <= 0 {
+			return nil
+		}
+		k := int64(0)
+		if from.After(anchor) {
+			k = int64(from.Sub(anchor) / interval)
+		}
+		for t := anchor.Add(time.Duration(k) * interval); t.Before(to); t = t.Add(interval) {
+			keep(t)
+		}
+	case "d":
+		hours := hoursOfDay(f.Count)
+		local := from.In(location)
+		for day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location); day.Before(to); day = day.AddDate(0, 0, 1) {
+			if daysBetween(first, day)%f.Period != 0 {
+				continue
+			}
+			for _, hour := range hours {
+				keep(atHour(day, hour))
+			}
+		}
+	case "wk", "mo":
+		days := 7 * f.Period
+		if f.PeriodUnit == "mo" {
+			days = 30 * f.Period
+		}
+		every := max(days/f.Count, 1)
+		local := from.In(location)
+		for day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location); day.Before(to); day = day.AddDate(0, 0, 1) {
+			if daysBetween(first, day)%every == 0 {
+				keep(atHour(day, weeklyHour))
+			}
+		}
+	}
+	return times
+}
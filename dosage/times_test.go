@@ -0,0 +1,119 @@
+package dosage
+
+import (
+	"life-signal/models"
+	"testing"
+	"time"
+)
+
+func TestTimes(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2025, time.March, day, hour, minute, 0, 0, london)
+	}
+	every := func(count, period int, unit string) *models.Frequency {
+		return &models.Frequency{Count: count, Period: period, PeriodUnit: unit}
+	}
+	tests := []struct {
+		name      string
+		dosage    models.Dosage
+		start     time.Time
+		from, to  time.Time
+		wantTimes []time.Time
+	}{
+		{
+			name:      "twice daily",
+			dosage:    models.Dosage{Frequency: every(2, 1, "d")},
+			start:     at(10, 7, 0),
+			from:      at(10, 0, 0),
+			to:        at(12, 0, 0),
+			wantTimes: []time.Time{at(10, 9, 0), at(10, 21, 0), at(11, 9, 0), at(11, 21, 0)},
+		},
+		{
+			name:      "doses before the start are skipped",
+			dosage:    models.Dosage{Frequency: every(3, 1, "d")},
+			start:     at(10, 12, 0),
+			from:      at(1, 0, 0),
+			to:        at(11, 0, 0),
+			wantTimes: []time.Time{at(10, 14, 0), at(10, 20, 0)},
+		},
+		{
+			name:      "five times daily spreads doses from 8:00 to 22:00",
+			dosage:    models.Dosage{Frequency: every(5, 1, "d")},
+			start:     at(10, 0, 0),
+			from:      at(10, 0, 0),
+			to:        at(11, 0, 0),
+			wantTimes: []time.Time{at(10, 8, 0), at(10, 11, 30), at(10, 15, 0), at(10, 18, 30), at(10, 22, 0)},
+		},
+		{
+			name:      "every other day counts from the start day",
+			dosage:    models.Dosage{Frequency: every(1, 2, "d")},
+			start:     at(10, 0, 0),
+			from:      at(11, 0, 0),
+			to:        at(15, 0, 0),
+			wantTimes: []time.Time{at(12, 9, 0), at(14, 9, 0)},
+		},
+		{
+			name:      "every 8 hours from 8:00",
+			dosage:    models.Dosage{Frequency: every(1, 8, "h")},
+			start:     at(10, 0, 0),
+			from:      at(10, 12, 0),
+			to:        at(11, 12, 0),
+			wantTimes: []time.Time{at(10, 16, 0), at(11, 0, 0), at(11, 8, 0)},
+		},
+		{
+			name:      "duration ends at midnight after the last day",
+			dosage:    models.Dosage{Frequency: every(1, 1, "d"), DurationDays: 2},
+			start:     at(10, 8, 0),
+			from:      at(10, 0, 0),
+			to:        at(20, 0, 0),
+			wantTimes: []time.Time{at(10, 9, 0), at(11, 9, 0)},
+		},
+		{
+			name:      "weekly on the start weekday",
+			dosage:    models.Dosage{Frequency: every(1, 1, "wk")},
+			start:     at(3, 0, 0),
+			from:      at(4, 0, 0),
+			to:        at(25, 0, 0),
+			wantTimes: []time.Time{at(10, 9, 0), at(17, 9, 0), at(24, 9, 0)},
+		},
+		{
+			name:      "daily doses keep their local time across the clocks going forward",
+			dosage:    models.Dosage{Frequency: every(1, 1, "d")},
+			start:     at(29, 0, 0),
+			from:      at(29, 0, 0),
+			to:        at(31, 23, 0),
+			wantTimes: []time.Time{at(29, 9, 0), at(30, 9, 0), at(31, 9, 0)},
+		},
+		{
+			name:   "as needed has no schedule",
+			dosage: models.Dosage{Frequency: every(1, 4, "h"), AsNeeded: true},
+			start:  at(10, 0, 0),
+			from:   at(10, 0, 0),
+			to:     at(11, 0, 0),
+		},
+		{
+			name:   "no frequency has no schedule",
+			dosage: models.Dosage{},
+			start:  at(10, 0, 0),
+			from:   at(10, 0, 0),
+			to:     at(11, 0, 0),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Times(tt.dosage, tt.start, tt.from, tt.to, london)
+			if len(got) != len(tt.wantTimes) {
+				t.Fatalf("Times = %v, want %v", got, tt.wantTimes)
+			}
+			for i := range got {
+				if !got[i].Equal(tt.wantTimes[i]) {
+					t.Errorf("Times[%d] = %v, want %v", i, got[i], tt.wantTimes[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package encryption
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ciphertextPrefix marks encrypted field values so legacy plaintext written
+// before encryption was enabled can still be read.
+const ciphertextPrefix = "enc:v1:"
+
+// DataKey is a per-user AES key, stored wrapped by a master key.
+type DataKey struct {
+	UserID      string    `bson:"_id"`
+	WrappedKey  []byte    `bson:"wrapped_key"`
+	MasterKeyID string    `bson:"master_key_id"`
+	CreatedAt   time.Time `bson:"created_at"`
+	RotatedAt   time.Time `bson:"rotated_at,omitempty"`
+}
+
+// Envelope encrypts PHI with per-user data keys wrapped by a KeyManager.
+type Envelope struct {
+	kms      KeyManager
+	indexKey []byte
+	keys     *mongo.Collection
+
+	mu    sync.RWMutex
+	cache map[string][]byte
+}
+
+func NewEnvelope(kms KeyManager, indexKey []byte, keys *mongo.Collection) *Envelope {
+	return &Envelope{kms: kms, indexKey: indexKey, keys: keys, cache: map[string][]byte{}}
+}
+
+var (
+	current *Envelope
+	mu      sync.RWMutex
+)
+
+// Init loads the master key file and sets up the envelope used by Current.
+func Init(keys *mongo.Collection, keyFile string) error {
+	kms, err := LoadKeyFile(keyFile)
+	if err != nil {
+		return err
+	}
+	Configure(NewEnvelope(kms, kms.BlindIndexKey(), keys))
+	return nil
+}
+
+func Configure(e *Envelope) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = e
+}
+
+// Current returns the configured envelope. Unlike the terminology and
+// storage packages there is no safe default, so it panics before Init.
+func Current() *Envelope {
+	mu.RLock()
+	defer mu.RUnlock()
+	if current == nil {
+		panic("encryption.Init was not called")
+	}
+	return current
+}
+
+// KeyManager returns the master key manager behind the envelope.
+func (e *Envelope) KeyManager() KeyManager {
+	return e.kms
+}
+
+// ErrNoDataKey is returned when decrypting for a user whose data key was
+// deleted, e.g. after an erasure request.
+var ErrNoDataKey = errors.New("no data key for user")
+
+// dataKey returns the user's unwrapped data key, creating one when create is
+// set and none exists yet.
+func (e *Envelope) dataKey(ctx context.Context, userID string, create bool) ([]byte, error) {
+	e.mu.RLock()
+	key, ok := e.cache[userID]
+	e.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	var stored DataKey
+	err := e.keys.FindOne(ctx, bson.M{"_id": userID}).Decode(&stored)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if !create {
+			return nil, ErrNoDataKey
+		}
+		return e.createDataKey(ctx, userID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load data key: %w", err)
+	}
+	key, err = e.kms.Unwrap(ctx, stored.MasterKeyID, stored.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	e.remember(userID, key)
+	return key, nil
+}
+
+func (e *Envelope) createDataKey(ctx context.Context, userID string) ([]byte, error) {
+	key := randomKey()
+	keyID := e.kms.CurrentKeyID()
+	wrapped, err := e.kms.Wrap(ctx, keyID, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	_, err = e.keys.InsertOne(ctx, DataKey{UserID: userID, WrappedKey: wrapped, MasterKeyID: keyID, CreatedAt: time.Now().UTC()})
+	if mongo.IsDuplicateKeyError(err) {
+		// Another request created the key first; use that one.
+		return e.dataKey(ctx, userID, false)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to store data key: %w", err)
+	}
+	e.remember(userID, key)
+	return key, nil
+}
+
+func (e *Envelope) remember(userID string, key []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cache[userID] = key
+}
+
+// Encrypt seals plaintext with the user's data key. The field name is bound
+// as additional data so ciphertext cannot be moved between fields or users.
+func (e *Envelope) Encrypt(ctx context.Context, userID, field string, plaintext []byte) (string, error) {
+	sealed, err := e.EncryptBytes(ctx, userID, field, plaintext)
+	if err != nil {
+		return "", err
+	}
+	return ciphertextPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (e *Envelope) Decrypt(ctx context.Context, userID, field, ciphertext string) ([]byte, error) {
+	if !IsEncrypted(ciphertext) {
+		return nil, fmt.Errorf("%s is not encrypted", field)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(ciphertext, ciphertextPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", field, err)
+	}
+	return e.DecryptBytes(ctx, userID, field, sealed)
+}
+
+// EncryptBytes is Encrypt for binary contents such as files.
+func (e *Envelope) EncryptBytes(ctx context.Context, userID, field string, plaintext []byte) ([]byte, error) {
+	key, err := e.dataKey(ctx, userID, true)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := seal(key, plaintext, []byte(userID+"/"+field))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt %s: %w", field, err)
+	}
+	return sealed, nil
+}
+
+func (e *Envelope) DecryptBytes(ctx context.Context, userID, field string, sealed []byte) ([]byte, error) {
+	key, err := e.dataKey(ctx, userID, false)
+	if err != nil {
+		return nil, err
+	}
+	return open(key, sealed, []byte(userID+"/"+field))
+}
+
+// EncryptString leaves empty values empty so optional fields stay optional.
+func (e *Envelope) EncryptString(ctx context.Context, userID, field, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	return e.Encrypt(ctx, userID, field, []byte(value))
+}
+
+// DecryptString passes legacy plaintext values through unchanged.
+func (e *Envelope) DecryptString(ctx context.Context, userID, field, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	plaintext, err := e.Decrypt(ctx, userID, field, value)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, ciphertextPrefix)
+}
+
+// BlindIndex returns a deterministic keyed hash of value so encrypted fields
+// can still be matched exactly. Values are trimmed and lower-cased first.
+func (e *Envelope) BlindIndex(field, value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, e.indexKey)
+	mac.Write([]byte(field + ":" + value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// DeleteDataKey removes the user's data key, which makes everything
+// encrypted with it unreadable.
+func (e *Envelope) DeleteDataKey(ctx context.Context, userID string) error {
+	if _, err := e.keys.DeleteOne(ctx, bson.M{"_id": userID}); err != nil {
+		return fmt.Errorf("failed to delete data key: %w", err)
+	}
+	e.mu.Lock()
+	delete(e.cache, userID)
+	e.mu.Unlock()
+	return nil
+}
+
+// Rewrap re-encrypts every data key not yet wrapped by the current master
+// key. Data keys themselves do not change, so no records are rewritten.
+func (e *Envelope) Rewrap(ctx context.Context, dryRun bool) (int, error) {
+	keyID := e.kms.CurrentKeyID()
+	cursor, err := e.keys.Find(ctx, bson.M{"master_key_id": bson.M{"$ne": keyID}})
+	if err != nil {
+		return 0, fmt.Errorf("failed to query data keys: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	rewrapped := 0
+	for cursor.Next(ctx) {
+		var stored DataKey
+		if err := cursor.Decode(&stored); err != nil {
+			return rewrapped, fmt.Errorf("failed to decode data key: %w", err)
+		}
+		if dryRun {
+			rewrapped++
+			continue
+		}
+		key, err := e.kms.Unwrap(ctx, stored.MasterKeyID, stored.WrappedKey)
+		if err != nil {
+			return rewrapped, fmt.Errorf("failed to unwrap data key for %s: %w", stored.UserID, err)
+		}
+		wrapped, err := e.kms.Wrap(ctx, keyID, key)
+		if err != nil {
+			return rewrapped, fmt.Errorf("failed to wrap data key for %s: %w", stored.UserID, err)
+		}
+		// Only replace the key if nobody rewrapped it in the meantime.
+		_, err = e.keys.UpdateOne(ctx,
+			bson.M{"_id": stored.UserID, "master_key_id": stored.MasterKeyID},
+			bson.M{"$set": bson.M{"wrapped_key": wrapped, "master_key_id": keyID, "rotated_at": time.Now().UTC()}},
+		)
+		if err != nil {
+			return rewrapped, fmt.Errorf("failed to store data key for %s: %w", stored.UserID, err)
+		}
+		rewrapped++
+	}
+	return rewrapped, cursor.Err()
+}
+
+// MasterKeysInUse lists the master key IDs that still wrap a data key.
+func (e *Envelope) MasterKeysInUse(ctx context.Context) (map[string]bool, error) {
+	ids, err := e.keys.Distinct(ctx, "master_key_id", bson.M{}, options.Distinct())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list master keys in use: %w", err)
+	}
+	inUse := map[string]bool{}
+	for _, id := range ids {
+		if s, ok := id.(string); ok {
+			inUse[s] = true
+		}
+	}
+	return inUse, nil
+}
@@ -0,0 +1,263 @@
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// KeyManager wraps and unwraps data keys with master keys it never hands
+// out, the way a cloud KMS does. Key IDs are stored next to each wrapped key
+// so older master keys keep working until everything is rewrapped.
+type KeyManager interface {
+	CurrentKeyID() string
+	Wrap(ctx context.Context, keyID string, plaintext []byte) ([]byte, error)
+	Unwrap(ctx context.Context, keyID string, ciphertext []byte) ([]byte, error)
+}
+
+// Rotator is implemented by key managers that can create a new master key
+// themselves. Rotation through a real KMS happens outside life-signal.
+type Rotator interface {
+	Rotate(ctx context.Context) (keyID string, err error)
+	Prune(ctx context.Context, keep map[string]bool) (removed []string, err error)
+}
+
+type keyFile struct {
+	CurrentKeyID  string            `json:"current_key_id"`
+	MasterKeys    map[string]string `json:"master_keys"`
+	BlindIndexKey string            `json:"blind_index_key"`
+}
+
+// LocalKeyManager keeps AES-256 master keys in a JSON file. The file also
+// holds the blind index key, which is not rotated since every index would
+// have to be recomputed.
+type LocalKeyManager struct {
+	path string
+
+	mu       sync.RWMutex
+	current  string
+	keys     map[string][]byte
+	indexKey []byte
+}
+
+func randomKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return key
+}
+
+func newKeyID() string {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return "mk-" + time.Now().UTC().Format("20060102") + "-" + hex.EncodeToString(suffix)
+}
+
+// LoadKeyFile reads the key file at path. A missing file is an error rather
+// than a reason to make new keys, which could not decrypt anything already
+// stored; new files are made by CreateKeyFile.
+func LoadKeyFile(path string) (*LocalKeyManager, error) {
+	if path == "" {
+		return nil, fmt.Errorf("MASTER_KEY_FILE is not set")
+	}
+	m := &LocalKeyManager{path: path, keys: map[string][]byte{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("master key file %s does not exist, restore it or run init-keys: %w", path, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read master key file: %w", err)
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse master key file: %w", err)
+	}
+	for id, encoded := range file.MasterKeys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("master key %s must be 32 base64-encoded bytes", id)
+		}
+		m.keys[id] = key
+	}
+	if _, ok := m.keys[file.CurrentKeyID]; !ok {
+		return nil, fmt.Errorf("current master key %q is not in the key file", file.CurrentKeyID)
+	}
+	m.current = file.CurrentKeyID
+	m.indexKey, err = base64.StdEncoding.DecodeString(file.BlindIndexKey)
+	if err != nil || len(m.indexKey) != 32 {
+		return nil, fmt.Errorf("blind_index_key must be 32 base64-encoded bytes")
+	}
+	return m, nil
+}
+
+// CreateKeyFile writes a new key file at path with a fresh master key and
+// blind index key. It never replaces an existing file.
+func CreateKeyFile(path string) (*LocalKeyManager, error) {
+	if path == "" {
+		return nil, fmt.Errorf("MASTER_KEY_FILE is not set")
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("master key file %s already exists", path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to check master key file: %w", err)
+	}
+	m := &LocalKeyManager{path: path, keys: map[string][]byte{}}
+	m.current = newKeyID()
+	m.keys[m.current] = randomKey()
+	m.indexKey = randomKey()
+	if err := m.save(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// save writes the key file atomically with owner-only permissions.
+func (m *LocalKeyManager) save() error {
+	file := keyFile{CurrentKeyID: m.current, MasterKeys: map[string]string{}, BlindIndexKey: base64.StdEncoding.EncodeToString(m.indexKey)}
+	for id, key := range m.keys {
+		file.MasterKeys[id] = base64.StdEncoding.EncodeToString(key)
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode master key file: %w", err)
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write master key file: %w", err)
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		return fmt.Errorf("failed to write master key file: %w", err)
+	}
+	return nil
+}
+
+func (m *LocalKeyManager) CurrentKeyID() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.current
+}
+
+// BlindIndexKey returns the key blind indexes are computed with.
+func (m *LocalKeyManager) BlindIndexKey() []byte {
+	return m.indexKey
+}
+
+func (m *LocalKeyManager) key(keyID string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	key, ok := m.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown master key %q", keyID)
+	}
+	return key, nil
+}
+
+func (m *LocalKeyManager) Wrap(ctx context.Context, keyID string, plaintext []byte) ([]byte, error) {
+	key, err := m.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return seal(key, plaintext, []byte(keyID))
+}
+
+func (m *LocalKeyManager) Unwrap(ctx context.Context, keyID string, ciphertext []byte) ([]byte, error) {
+	key, err := m.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return open(key, ciphertext, []byte(keyID))
+}
+
+// Rotate adds a new master key, makes it current and saves the file. Older
+// keys stay so existing wrapped data keys can still be unwrapped.
+func (m *LocalKeyManager) Rotate(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := newKeyID()
+	previous := m.current
+	m.keys[id] = randomKey()
+	m.current = id
+	if err := m.save(); err != nil {
+		delete(m.keys, id)
+		m.current = previous
+		return "", err
+	}
+	return id, nil
+}
+
+// Prune removes master keys that are neither current nor in keep.
+func (m *LocalKeyManager) Prune(ctx context.Context, keep map[string]bool) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var removed []string
+	for id := range m.keys {
+		if id != m.current && !keep[id] {
+			removed = append(removed, id)
+		}
+	}
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	kept := map[string][]byte{}
+	for id, key := range m.keys {
+		kept[id] = key
+	}
+	for _, id := range removed {
+		delete(m.keys, id)
+	}
+	if err := m.save(); err != nil {
+		m.keys = kept
+		return nil, err
+	}
+	return removed, nil
+}
+
+// seal encrypts with AES-256-GCM and prefixes the random nonce.
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key, ciphertext, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext is too short")
+	}
+	nonce, sealed := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, sealed, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,56 @@
+package encryption
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadKeyFileRequiresExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "master-key.json")
+	if _, err := LoadKeyFile(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("LoadKeyFile of a missing file = %v, want ErrNotExist", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Error("LoadKeyFile created the missing key file")
+	}
+}
+
+func TestCreateKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "master-key.json")
+	created, err := CreateKeyFile(path)
+	if err != nil {
+		t.Fatalf("CreateKeyFile: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat key file: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("key file mode %v, want 0600", info.Mode().Perm())
+	}
+
+	loaded, err := LoadKeyFile(path)
+	if err != nil {
+		t.Fatalf("LoadKeyFile: %v", err)
+	}
+	if loaded.CurrentKeyID() != created.CurrentKeyID() {
+		t.Errorf("loaded key %s, want %s", loaded.CurrentKeyID(), created.CurrentKeyID())
+	}
+	wrapped, err := created.Wrap(context.Background(), created.CurrentKeyID(), []byte("data key"))
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	if plaintext, err := loaded.Unwrap(context.Background(), loaded.CurrentKeyID(), wrapped); err != nil || string(plaintext) != "data key" {
+		t.Errorf("Unwrap with the loaded file = %q, %v", plaintext, err)
+	}
+
+	if _, err := CreateKeyFile(path); err == nil {
+		t.Error("CreateKeyFile replaced an existing key file")
+	}
+	if again, err := LoadKeyFile(path); err != nil || again.CurrentKeyID() != created.CurrentKeyID() {
+		t.Errorf("key file changed after a refused CreateKeyFile: %v", err)
+	}
+}
@@ -0,0 +1,454 @@
+package fhir
+
+import (
+	"encoding/json"
+	"fmt"
+	"life-signal/models"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	SNOMEDSystem                = "http://snomed.info/sct"
+	ConditionClinicalSystem     = "http://terminology.hl7.org/CodeSystem/condition-clinical"
+	ConditionVerificationSystem = "http://terminology.hl7.org/CodeSystem/condition-ver-status"
+	ActCodeSystem               = "http://terminology.hl7.org/CodeSystem/v3-ActCode"
+	AllergyClinicalSystem       = "http://terminology.hl7.org/CodeSystem/allergyintolerance-clinical"
+	AllergyVerificationSystem   = "http://terminology.hl7.org/CodeSystem/allergyintolerance-verification"
+	ObservationCategorySystem   = "http://terminology.hl7.org/CodeSystem/observation-category"
+	InterpretationSystem        = "http://terminology.hl7.org/CodeSystem/v3-ObservationInterpretation"
+	LOINCSystem                 = "http://loinc.org"
+	UCUMSystem                  = "http://unitsofmeasure.org"
+	IdentifierSystem            = "urn:life-signal:user"
+
+	// Appointments are stored with a start time only; R4 requires both ends.
+	defaultAppointmentDuration = 30 * time.Minute
+)
+
+// SeverityCodes maps Issue.Severity onto the SNOMED CT codes bound to
+// Condition.severity in R4.
+var SeverityCodes = map[string]Coding{
+	"Mild":     {System: SNOMEDSystem, Code: "255604002", Display: "Mild"},
+	"Moderate": {System: SNOMEDSystem, Code: "6736007", Display: "Moderate"},
+	"Severe":   {System: SNOMEDSystem, Code: "24484000", Display: "Severe"},
+}
+
+// VitalCodes maps VitalSign.Type onto the LOINC codes of the R4 vital signs
+// profiles. Blood pressure is a panel whose components carry the values.
+var VitalCodes = map[string]Coding{
+	"blood-pressure": {System: LOINCSystem, Code: "85354-9", Display: "Blood pressure panel with all children optional"},
+	"heart-rate":     {System: LOINCSystem, Code: "8867-4", Display: "Heart rate"},
+	"spo2":           {System: LOINCSystem, Code: "59408-5", Display: "Oxygen saturation in Arterial blood by Pulse oximetry"},
+	"glucose":        {System: LOINCSystem, Code: "2339-0", Display: "Glucose [Mass/volume] in Blood"},
+	"weight":         {System: LOINCSystem, Code: "29463-7", Display: "Body weight"},
+}
+
+var (
+	systolicCode  = Coding{System: LOINCSystem, Code: "8480-6", Display: "Systolic blood pressure"}
+	diastolicCode = Coding{System: LOINCSystem, Code: "8462-4", Display: "Diastolic blood pressure"}
+)
+
+// ucumCodes maps the units VitalSign stores onto UCUM.
+var ucumCodes = map[string]string{
+	"mmHg": "mm[Hg]", "/min": "/min", "%": "%", "mg/dL": "mg/dL", "mmol/L": "mmol/L", "kg": "kg", "lb": "[lb_av]",
+}
+
+// hl7CodingSystems maps HL7 v2 coding system names seen in OBX-3 to FHIR URIs.
+var hl7CodingSystems = map[string]string{"LN": LOINCSystem, "SCT": SNOMEDSystem, "SNM": SNOMEDSystem}
+
+// observationStatuses maps HL7 v2 result status (OBX-11) onto Observation.status.
+var observationStatuses = map[string]string{
+	"": "final", "F": "final", "C": "corrected", "P": "preliminary", "R": "preliminary",
+	"I": "registered", "X": "cancelled", "W": "entered-in-error", "D": "entered-in-error",
+}
+
+// PatientRecord is everything ExportBundle needs about one user. Doctors is
+// keyed by Doctor.ID and only needs to hold the doctors referenced by the
+// history's appointments.
+type PatientRecord struct {
+	User       models.UserDetails
+	History    *models.MedicalHistory
+	Doctors    map[string]models.Doctor
+	VitalSigns []models.VitalSign
+	LabResults []models.LabObservation
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return formatTime(*t)
+}
+
+func appendEntry(bundle *Bundle, resource interface{}) error {
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return fmt.Errorf("failed to encode FHIR resource: %w", err)
+	}
+	bundle.Entry = append(bundle.Entry, BundleEntry{Resource: data})
+	return nil
+}
+
+// ExportBundle converts a user's record into an R4 collection Bundle.
+// Appointments in the past become Encounters, future ones Appointments.
+func ExportBundle(record PatientRecord, now time.Time) (*Bundle, error) {
+	user := record.User
+	patientRef := Reference{Reference: "Patient/" + user.ID, Display: displayName(user.FirstName, user.LastName, user.Username)}
+	bundle := &Bundle{
+		ResourceType: "Bundle",
+		ID:           user.ID + "-export",
+		Meta:         &Meta{LastUpdated: formatTime(now)},
+		Type:         "collection",
+		Timestamp:    formatTime(now),
+	}
+
+	if err := appendEntry(bundle, patientResource(user)); err != nil {
+		return nil, err
+	}
+	for _, vital := range record.VitalSigns {
+		if err := appendEntry(bundle, vitalSignResource(vital, patientRef)); err != nil {
+			return nil, err
+		}
+	}
+	for _, observation := range record.LabResults {
+		if err := appendEntry(bundle, labResultResource(observation, patientRef)); err != nil {
+			return nil, err
+		}
+	}
+	if record.History == nil {
+		total := len(bundle.Entry)
+		bundle.Total = &total
+		return bundle, nil
+	}
+	history := record.History
+
+	for i, issue := range history.MedicalIssues {
+		if err := appendEntry(bundle, conditionResource(fmt.Sprintf("%s-condition-%d", user.ID, i), issue, patientRef, history.CreatedAt)); err != nil {
+			return nil, err
+		}
+	}
+	for i, prescription := range history.Prescriptions {
+		if err := appendEntry(bundle, medicationStatementResource(fmt.Sprintf("%s-medication-%d", user.ID, i), prescription, patientRef)); err != nil {
+			return nil, err
+		}
+	}
+	for i, allergy := range history.Allergies {
+		if err := appendEntry(bundle, allergyResource(fmt.Sprintf("%s-allergy-%d", user.ID, i), allergy, patientRef, history.CreatedAt)); err != nil {
+			return nil, err
+		}
+	}
+	for i, immunization := range history.Immunizations {
+		if err := appendEntry(bundle, immunizationResource(fmt.Sprintf("%s-immunization-%d", user.ID, i), immunization, patientRef)); err != nil {
+			return nil, err
+		}
+	}
+
+	referenced := map[string]bool{}
+	for i, appointment := range history.Appointments {
+		practitionerRef := Reference{Display: appointment.DoctorName}
+		if _, ok := record.Doctors[appointment.DoctorID]; ok {
+			practitionerRef.Reference = "Practitioner/" + appointment.DoctorID
+			referenced[appointment.DoctorID] = true
+		}
+		var resource interface{}
+		if appointment.AppointmentDate.Before(now) {
+			resource = encounterResource(fmt.Sprintf("%s-encounter-%d", user.ID, i), appointment, patientRef, practitionerRef)
+		} else {
+			resource = appointmentResource(fmt.Sprintf("%s-appointment-%d", user.ID, i), appointment, patientRef, practitionerRef)
+		}
+		if err := appendEntry(bundle, resource); err != nil {
+			return nil, err
+		}
+	}
+
+	doctorIDs := make([]string, 0, len(referenced))
+	for id := range referenced {
+		doctorIDs = append(doctorIDs, id)
+	}
+	sort.Strings(doctorIDs)
+	for _, id := range doctorIDs {
+		if err := appendEntry(bundle, practitionerResource(record.Doctors[id])); err != nil {
+			return nil, err
+		}
+	}
+
+	total := len(bundle.Entry)
+	bundle.Total = &total
+	return bundle, nil
+}
+
+func displayName(first, last, fallback string) string {
+	switch {
+	case first != "" && last != "":
+		return first + " " + last
+	case first != "" || last != "":
+		return first + last
+	default:
+		return fallback
+	}
+}
+
+func patientResource(user models.UserDetails) Patient {
+	active := true
+	patient := Patient{
+		Resource:   Resource{ResourceType: "Patient", ID: user.ID, Meta: &Meta{LastUpdated: formatTime(user.UpdatedAt)}},
+		Identifier: []Identifier{{System: IdentifierSystem, Value: user.ID}},
+		Active:     &active,
+		Name:       []HumanName{{Use: "official", Text: displayName(user.FirstName, user.LastName, user.Username), Family: user.LastName}},
+	}
+	if user.FirstName != "" {
+		patient.Name[0].Given = []string{user.FirstName}
+	}
+	if user.Phone != "" {
+		patient.Telecom = append(patient.Telecom, ContactPoint{System: "phone", Value: user.Phone, Use: "mobile"})
+	}
+	if user.Email != "" {
+		patient.Telecom = append(patient.Telecom, ContactPoint{System: "email", Value: user.Email})
+	}
+	return patient
+}
+
+func codeableConcept(text string, coding *models.Coding) *CodeableConcept {
+	concept := &CodeableConcept{Text: text}
+	if coding != nil {
+		concept.Coding = []Coding{{System: coding.System, Code: coding.Code, Display: coding.Display}}
+	}
+	return concept
+}
+
+func conditionResource(id string, issue models.Issue, subject Reference, recorded time.Time) Condition {
+	clinicalStatus := "active"
+	if issue.EndDate != nil {
+		clinicalStatus = "resolved"
+	}
+	condition := Condition{
+		Resource:           Resource{ResourceType: "Condition", ID: id},
+		ClinicalStatus:     &CodeableConcept{Coding: []Coding{{System: ConditionClinicalSystem, Code: clinicalStatus}}},
+		VerificationStatus: &CodeableConcept{Coding: []Coding{{System: ConditionVerificationSystem, Code: "confirmed"}}},
+		Code:               codeableConcept(issue.Condition, issue.ConditionCode),
+		Subject:            subject,
+		OnsetDateTime:      formatTime(issue.StartDate),
+		AbatementDateTime:  formatOptionalTime(issue.EndDate),
+	}
+	if !recorded.IsZero() {
+		condition.RecordedDate = formatTime(recorded)
+	}
+	if coding, ok := SeverityCodes[issue.Severity]; ok {
+		condition.Severity = &CodeableConcept{Coding: []Coding{coding}, Text: issue.Severity}
+	}
+	if issue.Notes != "" {
+		condition.Note = []Annotation{{Text: issue.Notes}}
+	}
+	return condition
+}
+
+func medicationStatementResource(id string, prescription models.Prescription, subject Reference) MedicationStatement {
+	status := "active"
+	if prescription.EndDate != nil {
+		status = "completed"
+	}
+	statement := MedicationStatement{
+		Resource:                  Resource{ResourceType: "MedicationStatement", ID: id},
+		Status:                    status,
+		MedicationCodeableConcept: codeableConcept(prescription.MedicationName, prescription.MedicationCode),
+		Subject:                   subject,
+		EffectivePeriod:           &Period{Start: formatTime(prescription.StartDate), End: formatOptionalTime(prescription.EndDate)},
+	}
+	if prescription.Dosage != "" {
+		statement.Dosage = []Dosage{{Text: prescription.Dosage}}
+	}
+	return statement
+}
+
+func encounterResource(id string, appointment models.Appointment, subject, practitioner Reference) Encounter {
+	encounter := Encounter{
+		Resource:    Resource{ResourceType: "Encounter", ID: id},
+		Status:      "finished",
+		Class:       Coding{System: ActCodeSystem, Code: "AMB", Display: "ambulatory"},
+		Subject:     &subject,
+		Participant: []EncounterParticipant{{Individual: &practitioner}},
+		Period: &Period{
+			Start: formatTime(appointment.AppointmentDate),
+			End:   formatTime(appointment.AppointmentDate.Add(defaultAppointmentDuration)),
+		},
+	}
+	if appointment.Notes != "" {
+		encounter.ReasonCode = []CodeableConcept{{Text: appointment.Notes}}
+	}
+	return encounter
+}
+
+func appointmentResource(id string, appointment models.Appointment, subject, practitioner Reference) Appointment {
+	return Appointment{
+		Resource: Resource{ResourceType: "Appointment", ID: id},
+		Status:   "booked",
+		Start:    formatTime(appointment.AppointmentDate),
+		End:      formatTime(appointment.AppointmentDate.Add(defaultAppointmentDuration)),
+		Comment:  appointment.Notes,
+		Participant: []AppointmentParticipant{
+			{Actor: &subject, Status: "accepted"},
+			{Actor: &practitioner, Status: "accepted"},
+		},
+	}
+}
+
+func practitionerResource(doctor models.Doctor) Practitioner {
+	practitioner := Practitioner{
+		Resource: Resource{ResourceType: "Practitioner", ID: doctor.ID},
+		Name: []HumanName{{
+			Text:   displayName(doctor.FirstName, doctor.LastName, ""),
+			Family: doctor.LastName,
+			Given:  []string{doctor.FirstName},
+			Prefix: []string{"Dr."},
+		}},
+	}
+	if doctor.Phone != "" {
+		practitioner.Telecom = append(practitioner.Telecom, ContactPoint{System: "phone", Value: doctor.Phone, Use: "work"})
+	}
+	if doctor.Email != "" {
+		practitioner.Telecom = append(practitioner.Telecom, ContactPoint{System: "email", Value: doctor.Email, Use: "work"})
+	}
+	if doctor.ClinicAddress != "" {
+		practitioner.Address = []Address{{Text: doctor.ClinicName + ", " + doctor.ClinicAddress}}
+	}
+	for _, qualification := range doctor.Qualifications {
+		practitioner.Qualification = append(practitioner.Qualification, PractitionerQualification{Code: CodeableConcept{Text: qualification}})
+	}
+	for _, language := range doctor.Languages {
+		practitioner.Communication = append(practitioner.Communication, CodeableConcept{Text: language})
+	}
+	return practitioner
+}
+
+func allergyResource(id string, allergy models.Allergy, patient Reference, recorded time.Time) AllergyIntolerance {
+	clinicalStatus := "active"
+	if allergy.EndDate != nil {
+		clinicalStatus = "resolved"
+	}
+	resource := AllergyIntolerance{
+		Resource:           Resource{ResourceType: "AllergyIntolerance", ID: id},
+		ClinicalStatus:     &CodeableConcept{Coding: []Coding{{System: AllergyClinicalSystem, Code: clinicalStatus}}},
+		VerificationStatus: &CodeableConcept{Coding: []Coding{{System: AllergyVerificationSystem, Code: "confirmed"}}},
+		Criticality:        allergy.Criticality,
+		Code:               codeableConcept(allergy.Substance, allergy.Code),
+		Patient:            patient,
+		OnsetDateTime:      formatOptionalTime(allergy.OnsetDate),
+	}
+	if allergy.Category != "" {
+		resource.Category = []string{allergy.Category}
+	}
+	if !recorded.IsZero() {
+		resource.RecordedDate = formatTime(recorded)
+	}
+	if allergy.Reaction != "" {
+		resource.Reaction = []AllergyReaction{{
+			Manifestation: []CodeableConcept{{Text: allergy.Reaction}},
+			Severity:      strings.ToLower(allergy.Severity),
+		}}
+	}
+	if allergy.Notes != "" {
+		resource.Note = []Annotation{{Text: allergy.Notes}}
+	}
+	return resource
+}
+
+func immunizationResource(id string, immunization models.Immunization, patient Reference) Immunization {
+	resource := Immunization{
+		Resource:           Resource{ResourceType: "Immunization", ID: id},
+		Status:             "completed",
+		VaccineCode:        *codeableConcept(immunization.VaccineName, immunization.VaccineCode),
+		Patient:            patient,
+		OccurrenceDateTime: formatTime(immunization.OccurrenceDate),
+		LotNumber:          immunization.LotNumber,
+	}
+	if immunization.Performer != "" {
+		resource.Performer = []ImmunizationPerformer{{Actor: Reference{Display: immunization.Performer}}}
+	}
+	if immunization.DoseNumber > 0 {
+		resource.ProtocolApplied = []ImmunizationProtocol{{DoseNumberPositiveInt: immunization.DoseNumber}}
+	}
+	if immunization.Notes != "" {
+		resource.Note = []Annotation{{Text: immunization.Notes}}
+	}
+	return resource
+}
+
+func quantity(value float64, unit string) *Quantity {
+	q := &Quantity{Value: value, Unit: unit}
+	if code, ok := ucumCodes[unit]; ok {
+		q.System, q.Code = UCUMSystem, code
+	}
+	return q
+}
+
+func vitalSignResource(vital models.VitalSign, subject Reference) Observation {
+	code := VitalCodes[vital.Type]
+	observation := Observation{
+		Resource:          Resource{ResourceType: "Observation", ID: vital.ID},
+		Status:            "final",
+		Category:          []CodeableConcept{{Coding: []Coding{{System: ObservationCategorySystem, Code: "vital-signs", Display: "Vital Signs"}}}},
+		Code:              CodeableConcept{Coding: []Coding{code}, Text: code.Display},
+		Subject:           &subject,
+		EffectiveDateTime: formatTime(vital.MeasuredAt),
+	}
+	if vital.Type == "blood-pressure" && vital.Diastolic != nil {
+		observation.Component = []ObservationComponent{
+			{Code: CodeableConcept{Coding: []Coding{systolicCode}}, ValueQuantity: quantity(vital.Value, vital.Unit)},
+			{Code: CodeableConcept{Coding: []Coding{diastolicCode}}, ValueQuantity: quantity(*vital.Diastolic, vital.Unit)},
+		}
+	} else {
+		observation.ValueQuantity = quantity(vital.Value, vital.Unit)
+	}
+	if vital.Notes != "" {
+		observation.Note = []Annotation{{Text: vital.Notes}}
+	}
+	return observation
+}
+
+func labResultResource(result models.LabObservation, subject Reference) Observation {
+	system := result.CodingSystem
+	if uri, ok := hl7CodingSystems[system]; ok {
+		system = uri
+	}
+	status, ok := observationStatuses[result.Status]
+	if !ok {
+		status = "unknown"
+	}
+	observation := Observation{
+		Resource:          Resource{ResourceType: "Observation", ID: resourceID(result.ID)},
+		Status:            status,
+		Category:          []CodeableConcept{{Coding: []Coding{{System: ObservationCategorySystem, Code: "laboratory", Display: "Laboratory"}}}},
+		Code:              CodeableConcept{Coding: []Coding{{System: system, Code: result.Code, Display: result.Display}}, Text: result.Display},
+		Subject:           &subject,
+		EffectiveDateTime: formatTime(result.ObservedAt),
+	}
+	if result.NumericValue != nil {
+		observation.ValueQuantity = quantity(*result.NumericValue, result.Unit)
+	} else {
+		observation.ValueString = result.Value
+	}
+	if result.AbnormalFlag != "" {
+		observation.Interpretation = []CodeableConcept{{Coding: []Coding{{System: InterpretationSystem, Code: result.AbnormalFlag}}}}
+	}
+	if result.ReferenceRange != "" {
+		observation.ReferenceRange = []ObservationReferenceRange{{Text: result.ReferenceRange}}
+	}
+	return observation
+}
+
+var invalidIDChars = regexp.MustCompile(`[^A-Za-z0-9\-.]`)
+
+// resourceID makes a stored ID usable as a FHIR id.
+func resourceID(id string) string {
+	id = invalidIDChars.ReplaceAllString(id, "-")
+	if len(id) > 64 {
+		id = id[:64]
+	}
+	return id
+}
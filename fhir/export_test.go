@@ -0,0 +1,201 @@
+package fhir
+
+import (
+	"encoding/json"
+	"life-signal/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testRecord(now time.Time) PatientRecord {
+	userID := "52e6cc3d-664b-45f5-8d2c-e29e521ca5f1"
+	ended := now.AddDate(0, -1, 0)
+	diastolic := 80.0
+	potassium := 4.2
+	return PatientRecord{
+		User: models.UserDetails{ID: userID, Username: "asha", FirstName: "Asha", LastName: "Rao", Email: "asha@example.com", Phone: "+15555550100"},
+		History: &models.MedicalHistory{
+			UserID:    userID,
+			CreatedAt: now.AddDate(-1, 0, 0),
+			MedicalIssues: []models.Issue{
+				{Condition: "Type 2 diabetes", ConditionCode: &models.Coding{System: "http://snomed.info/sct", Code: "44054006"}, Severity: "Moderate", StartDate: now.AddDate(-2, 0, 0)},
+				{Condition: "Sprained ankle", Severity: "Mild", StartDate: now.AddDate(0, -3, 0), EndDate: &ended},
+			},
+			Prescriptions: []models.Prescription{
+				{MedicationName: "Metformin 500 mg", Dosage: "1 tablet twice daily", StartDate: now.AddDate(-2, 0, 0)},
+				{MedicationName: "Ibuprofen", Dosage: "as needed", StartDate: now.AddDate(0, -3, 0), EndDate: &ended},
+			},
+			Allergies: []models.Allergy{
+				{Substance: "Penicillin", Category: "medication", Criticality: "high", Reaction: "Hives", Severity: "Moderate"},
+				{Substance: "Peanuts", Category: "food", EndDate: &ended},
+			},
+			Immunizations: []models.Immunization{
+				{VaccineName: "Influenza", OccurrenceDate: now.AddDate(0, -6, 0), DoseNumber: 1, LotNumber: "FL123"},
+			},
+			Appointments: []models.Appointment{
+				{DoctorID: "doctor-1", DoctorName: "Dr. Mehta", AppointmentDate: now.AddDate(0, -2, 0), Notes: "Follow-up"},
+				{DoctorID: "doctor-1", DoctorName: "Dr. Mehta", AppointmentDate: now.AddDate(0, 1, 0)},
+				{DoctorID: "unknown", DoctorName: "Dr. Who", AppointmentDate: now.AddDate(0, 2, 0)},
+			},
+		},
+		Doctors: map[string]models.Doctor{
+			"doctor-1": {ID: "doctor-1", FirstName: "Ravi", LastName: "Mehta", Speciality: "Endocrinology", Qualifications: []string{"MBBS", "MD"}},
+		},
+		VitalSigns: []models.VitalSign{
+			{ID: "vital-1", Type: "blood-pressure", Value: 120, Diastolic: &diastolic, Unit: "mmHg", MeasuredAt: now.AddDate(0, 0, -1)},
+			{ID: "vital-2", Type: "heart-rate", Value: 72, Unit: "/min", MeasuredAt: now.AddDate(0, 0, -1)},
+		},
+		LabResults: []models.LabObservation{
+			{ID: "MSG^0001-1", Code: "2823-3", Display: "Potassium", CodingSystem: "LN", Value: "4.2", NumericValue: &potassium, Unit: "mmol/L", ReferenceRange: "3.5-5.1", Status: "F", ObservedAt: now.AddDate(0, 0, -2)},
+			{ID: "MSG^0001-2", Code: "5778-6", Display: "Color of Urine", CodingSystem: "LN", Value: "Yellow", Status: "P", AbnormalFlag: "N", ObservedAt: now.AddDate(0, 0, -2)},
+		},
+	}
+}
+
+func TestExportBundleIsValidR4(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	bundle, err := ExportBundle(testRecord(now), now)
+	if err != nil {
+		t.Fatalf("ExportBundle: %v", err)
+	}
+	if err := ValidateBundle(bundle); err != nil {
+		t.Fatalf("exported bundle is not valid R4: %v", err)
+	}
+
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		t.Fatalf("marshal bundle: %v", err)
+	}
+	var decoded Bundle
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("round trip: %v", err)
+	}
+	if err := ValidateBundle(&decoded); err != nil {
+		t.Fatalf("round-tripped bundle is not valid R4: %v", err)
+	}
+
+	if bundle.Type != "collection" || bundle.Total == nil || *bundle.Total != len(bundle.Entry) {
+		t.Errorf("bundle type %q total %v, want collection with total %d", bundle.Type, bundle.Total, len(bundle.Entry))
+	}
+	counts := map[string]int{}
+	ids := map[string]bool{}
+	for i, entry := range bundle.Entry {
+		var header Resource
+		if err := json.Unmarshal(entry.Resource, &header); err != nil {
+			t.Fatalf("entry %d: %v", i, err)
+		}
+		if !idPattern.MatchString(header.ID) {
+			t.Errorf("entry %d: %s.id %q is not a valid id", i, header.ResourceType, header.ID)
+		}
+		key := header.ResourceType + "/" + header.ID
+		if ids[key] {
+			t.Errorf("entry %d: %s appears twice", i, key)
+		}
+		ids[key] = true
+		counts[header.ResourceType]++
+	}
+	want := map[string]int{
+		"Patient": 1, "Condition": 2, "MedicationStatement": 2, "AllergyIntolerance": 2, "Immunization": 1,
+		"Encounter": 1, "Appointment": 2, "Practitioner": 1, "Observation": 4,
+	}
+	for resourceType, count := range want {
+		if counts[resourceType] != count {
+			t.Errorf("%d %s resources, want %d", counts[resourceType], resourceType, count)
+		}
+	}
+
+	for _, entry := range bundle.Entry {
+		for _, ref := range referencesIn(t, entry.Resource) {
+			if strings.HasPrefix(ref, "Patient/") || strings.HasPrefix(ref, "Practitioner/") {
+				if !ids[ref] {
+					t.Errorf("reference %s does not resolve inside the bundle", ref)
+				}
+			}
+		}
+	}
+}
+
+func TestExportBundleWithoutHistory(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	record := testRecord(now)
+	record.History = nil
+	bundle, err := ExportBundle(record, now)
+	if err != nil {
+		t.Fatalf("ExportBundle: %v", err)
+	}
+	if err := ValidateBundle(bundle); err != nil {
+		t.Fatalf("exported bundle is not valid R4: %v", err)
+	}
+	if got := len(bundle.Entry); got != 5 {
+		t.Errorf("%d entries, want the patient and 4 observations", got)
+	}
+}
+
+// referencesIn collects every "reference" value in a resource.
+func referencesIn(t *testing.T, raw json.RawMessage) []string {
+	t.Helper()
+	var tree any
+	if err := json.Unmarshal(raw, &tree); err != nil {
+		t.Fatalf("decode resource: %v", err)
+	}
+	var refs []string
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			for key, child := range v {
+				if s, ok := child.(string); ok && key == "reference" {
+					refs = append(refs, s)
+				}
+				walk(child)
+			}
+		case []any:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(tree)
+	return refs
+}
+
+func TestValidateResourceRejectsInvalidR4(t *testing.T) {
+	tests := []struct {
+		name     string
+		resource string
+		want     string
+	}{
+		{"missing resourceType", `{"id":"a"}`, "resourceType is required"},
+		{"invalid id", `{"resourceType":"Patient","id":"a b"}`, "not a valid id"},
+		{"condition without subject", `{"resourceType":"Condition"}`, "Condition.subject is required"},
+		{"abated condition still active", `{"resourceType":"Condition","subject":{"reference":"Patient/p"},"clinicalStatus":{"coding":[{"code":"active"}]},"abatementDateTime":"2026-01-01"}`, "con-4"},
+		{"medication given twice", `{"resourceType":"MedicationStatement","status":"active","subject":{"reference":"Patient/p"},"medicationCodeableConcept":{"text":"x"},"medicationReference":{"reference":"Medication/m"}}`, "exactly once"},
+		{"medication missing", `{"resourceType":"MedicationStatement","status":"active","subject":{"reference":"Patient/p"}}`, "exactly once"},
+		{"bad medication status", `{"resourceType":"MedicationStatement","status":"taking","subject":{"reference":"Patient/p"},"medicationCodeableConcept":{"text":"x"}}`, "status"},
+		{"appointment with start only", `{"resourceType":"Appointment","status":"booked","start":"2026-01-01T10:00:00Z","participant":[{"status":"accepted"}]}`, "app-2"},
+		{"booked appointment without times", `{"resourceType":"Appointment","status":"booked","participant":[{"status":"accepted"}]}`, "app-3"},
+		{"entered-in-error allergy with status", `{"resourceType":"AllergyIntolerance","patient":{"reference":"Patient/p"},"clinicalStatus":{"coding":[{"code":"active"}]},"verificationStatus":{"coding":[{"code":"entered-in-error"}]}}`, "ait-2"},
+		{"allergy reaction without manifestation", `{"resourceType":"AllergyIntolerance","patient":{"reference":"Patient/p"},"reaction":[{}]}`, "manifestation"},
+		{"immunization without date", `{"resourceType":"Immunization","status":"completed","vaccineCode":{"text":"Flu"},"patient":{"reference":"Patient/p"}}`, "occurrence"},
+		{"observation with two values", `{"resourceType":"Observation","status":"final","code":{"text":"x"},"valueQuantity":{"value":1},"valueString":"1"}`, "at most once"},
+		{"observation component repeating its code", `{"resourceType":"Observation","status":"final","code":{"coding":[{"system":"s","code":"c"}]},"valueQuantity":{"value":1},"component":[{"code":{"coding":[{"system":"s","code":"c"}]}}]}`, "obs-7"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateResource(json.RawMessage(test.resource))
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("ValidateResource = %v, want an error containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateBundleRejectsBadType(t *testing.T) {
+	if err := ValidateBundle(&Bundle{ResourceType: "Bundle", Type: "folder"}); err == nil {
+		t.Error("ValidateBundle accepted an unknown bundle type")
+	}
+	if err := ValidateBundle(&Bundle{ResourceType: "Patient", Type: "collection"}); err == nil {
+		t.Error("ValidateBundle accepted a resource that is not a Bundle")
+	}
+}
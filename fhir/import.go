@@ -0,0 +1,540 @@
+package fhir
+
+import (
+	"encoding/json"
+	"fmt"
+	"life-signal/models"
+	"life-signal/terminology"
+	"strings"
+	"time"
+)
+
+// ImportItem describes what happened to one bundle entry.
+type ImportItem struct {
+	Entry        int    `json:"entry"`
+	ResourceType string `json:"resource_type"`
+	ID           string `json:"id,omitempty"`
+	MappedTo     string `json:"mapped_to,omitempty"`
+	Reason       string `json:"reason,omitempty"`
+}
+
+type ImportReport struct {
+	Mapped      []ImportItem `json:"mapped"`
+	Skipped     []ImportItem `json:"skipped"`
+	Unsupported []ImportItem `json:"unsupported"`
+}
+
+// ImportBundle merges the clinical resources of bundle into a copy of history
+// and reports how each entry was handled. Entries that duplicate something
+// already in the history, or earlier in the bundle, are skipped.
+func ImportBundle(bundle *Bundle, history models.MedicalHistory) (models.MedicalHistory, ImportReport) {
+	report := ImportReport{Mapped: []ImportItem{}, Skipped: []ImportItem{}, Unsupported: []ImportItem{}}
+	merged := history
+	merged.MedicalIssues = append([]models.Issue{}, history.MedicalIssues...)
+	merged.Prescriptions = append([]models.Prescription{}, history.Prescriptions...)
+	merged.Appointments = append([]models.Appointment{}, history.Appointments...)
+	merged.Allergies = append([]models.Allergy{}, history.Allergies...)
+	merged.Immunizations = append([]models.Immunization{}, history.Immunizations...)
+
+	seen := map[string]bool{}
+	for _, issue := range merged.MedicalIssues {
+		seen[issueKey(issue)] = true
+	}
+	for _, prescription := range merged.Prescriptions {
+		seen[prescriptionKey(prescription)] = true
+	}
+	for _, appointment := range merged.Appointments {
+		seen[appointmentKey(appointment)] = true
+	}
+	for _, allergy := range merged.Allergies {
+		seen[allergyKey(allergy)] = true
+	}
+	for _, immunization := range merged.Immunizations {
+		seen[immunizationKey(immunization)] = true
+	}
+
+	// Practitioners are resolved first so encounters can name their doctor
+	// regardless of entry order.
+	practitioners := map[string]string{}
+	for _, entry := range bundle.Entry {
+		var practitioner Practitioner
+		if json.Unmarshal(entry.Resource, &practitioner) == nil && practitioner.ResourceType == "Practitioner" && practitioner.ID != "" {
+			practitioners["Practitioner/"+practitioner.ID] = practitionerName(practitioner)
+		}
+	}
+
+	for i, entry := range bundle.Entry {
+		var header Resource
+		if err := json.Unmarshal(entry.Resource, &header); err != nil {
+			report.Skipped = append(report.Skipped, ImportItem{Entry: i, Reason: "invalid resource: " + err.Error()})
+			continue
+		}
+		item := ImportItem{Entry: i, ResourceType: header.ResourceType, ID: header.ID}
+		if err := ValidateResource(entry.Resource); err != nil {
+			item.Reason = err.Error()
+			report.Skipped = append(report.Skipped, item)
+			continue
+		}
+
+		var key string
+		var reason string
+		switch header.ResourceType {
+		case "Condition":
+			var condition Condition
+			_ = json.Unmarshal(entry.Resource, &condition)
+			issue, err := issueFromCondition(condition)
+			if err != nil {
+				reason = err.Error()
+				break
+			}
+			key = issueKey(issue)
+			if !seen[key] {
+				merged.MedicalIssues = append(merged.MedicalIssues, issue)
+				item.MappedTo = fmt.Sprintf("medical_issues[%d]", len(merged.MedicalIssues)-1)
+			}
+		case "MedicationStatement", "MedicationRequest":
+			prescription, err := prescriptionFromResource(header.ResourceType, entry.Resource)
+			if err != nil {
+				reason = err.Error()
+				break
+			}
+			key = prescriptionKey(prescription)
+			if !seen[key] {
+				merged.Prescriptions = append(merged.Prescriptions, prescription)
+				item.MappedTo = fmt.Sprintf("prescriptions[%d]", len(merged.Prescriptions)-1)
+			}
+		case "Encounter":
+			var encounter Encounter
+			_ = json.Unmarshal(entry.Resource, &encounter)
+			appointment, err := appointmentFromEncounter(encounter, practitioners)
+			if err != nil {
+				reason = err.Error()
+				break
+			}
+			key = appointmentKey(appointment)
+			if !seen[key] {
+				merged.Appointments = append(merged.Appointments, appointment)
+				item.MappedTo = fmt.Sprintf("appointments[%d]", len(merged.Appointments)-1)
+			}
+		case "AllergyIntolerance":
+			var resource AllergyIntolerance
+			_ = json.Unmarshal(entry.Resource, &resource)
+			allergy, err := allergyFromResource(resource)
+			if err != nil {
+				reason = err.Error()
+				break
+			}
+			key = allergyKey(allergy)
+			if !seen[key] {
+				merged.Allergies = append(merged.Allergies, allergy)
+				item.MappedTo = fmt.Sprintf("allergies[%d]", len(merged.Allergies)-1)
+			}
+		case "Immunization":
+			var resource Immunization
+			_ = json.Unmarshal(entry.Resource, &resource)
+			immunization, err := immunizationFromResource(resource)
+			if err != nil {
+				reason = err.Error()
+				break
+			}
+			key = immunizationKey(immunization)
+			if !seen[key] {
+				merged.Immunizations = append(merged.Immunizations, immunization)
+				item.MappedTo = fmt.Sprintf("immunizations[%d]", len(merged.Immunizations)-1)
+			}
+		case "Observation":
+			item.Reason = "observations are recorded through the vitals and lab result endpoints"
+			report.Unsupported = append(report.Unsupported, item)
+			continue
+		case "Practitioner":
+			item.MappedTo = "appointments.doctor_name"
+			report.Mapped = append(report.Mapped, item)
+			continue
+		case "Patient":
+			item.Reason = "patient demographics are managed by the account and not imported"
+			report.Skipped = append(report.Skipped, item)
+			continue
+		default:
+			item.Reason = "resource type is not supported"
+			report.Unsupported = append(report.Unsupported, item)
+			continue
+		}
+
+		switch {
+		case reason != "":
+			item.Reason = reason
+			report.Skipped = append(report.Skipped, item)
+		case item.MappedTo == "":
+			item.Reason = "duplicate of an existing entry"
+			report.Skipped = append(report.Skipped, item)
+		default:
+			seen[key] = true
+			report.Mapped = append(report.Mapped, item)
+		}
+	}
+	return merged, report
+}
+
+// EntryCount is the number of entries a history holds across every list
+// ImportBundle can add to, used to tell whether an import changed anything.
+func EntryCount(history models.MedicalHistory) int {
+	return len(history.MedicalIssues) + len(history.Prescriptions) + len(history.Appointments) +
+		len(history.Allergies) + len(history.Immunizations)
+}
+
+// ParseDateTime accepts the FHIR date and dateTime formats, including the
+// partial YYYY and YYYY-MM forms.
+func ParseDateTime(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02", "2006-01", "2006"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid FHIR dateTime %q", value)
+}
+
+func parseOptionalDateTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := ParseDateTime(value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func conceptText(concept *CodeableConcept) string {
+	if concept == nil {
+		return ""
+	}
+	if concept.Text != "" {
+		return concept.Text
+	}
+	for _, coding := range concept.Coding {
+		if coding.Display != "" {
+			return coding.Display
+		}
+	}
+	for _, coding := range concept.Coding {
+		if coding.Code != "" {
+			return coding.Code
+		}
+	}
+	return ""
+}
+
+// knownCoding keeps the first coding that exists in the local terminology,
+// using our display for it. Codings we cannot validate are dropped; the text
+// is kept either way.
+func knownCoding(concept *CodeableConcept, systems ...string) *models.Coding {
+	if concept == nil {
+		return nil
+	}
+	for _, coding := range concept.Coding {
+		for _, system := range systems {
+			if coding.System != system {
+				continue
+			}
+			if known, ok := terminology.Current().Lookup(system, coding.Code); ok {
+				return &models.Coding{System: known.System, Code: known.Code, Display: known.Display}
+			}
+		}
+	}
+	return nil
+}
+
+func severityFromConcept(concept *CodeableConcept) string {
+	if concept == nil {
+		return ""
+	}
+	for severity, coding := range SeverityCodes {
+		for _, candidate := range concept.Coding {
+			if candidate.System == coding.System && candidate.Code == coding.Code {
+				return severity
+			}
+		}
+	}
+	text := strings.ToLower(conceptText(concept))
+	for severity := range SeverityCodes {
+		if strings.ToLower(severity) == text {
+			return severity
+		}
+	}
+	return ""
+}
+
+func hasVerification(concept *CodeableConcept, code string) bool {
+	return hasCode(concept, codeSet(code))
+}
+
+func issueFromCondition(condition Condition) (models.Issue, error) {
+	if hasVerification(condition.VerificationStatus, "entered-in-error") || hasVerification(condition.VerificationStatus, "refuted") {
+		return models.Issue{}, fmt.Errorf("condition is entered-in-error or refuted")
+	}
+	name := conceptText(condition.Code)
+	if name == "" {
+		return models.Issue{}, fmt.Errorf("condition has no code or text")
+	}
+	onset := condition.OnsetDateTime
+	if onset == "" {
+		onset = condition.RecordedDate
+	}
+	if onset == "" {
+		return models.Issue{}, fmt.Errorf("condition has no onsetDateTime or recordedDate")
+	}
+	start, err := ParseDateTime(onset)
+	if err != nil {
+		return models.Issue{}, err
+	}
+	end, err := parseOptionalDateTime(condition.AbatementDateTime)
+	if err != nil {
+		return models.Issue{}, err
+	}
+	if end != nil && end.Before(start) {
+		return models.Issue{}, fmt.Errorf("abatementDateTime is before onset")
+	}
+	notes := make([]string, 0, len(condition.Note))
+	for _, note := range condition.Note {
+		notes = append(notes, note.Text)
+	}
+	return models.Issue{
+		Condition:     name,
+		ConditionCode: knownCoding(condition.Code, terminology.ICD10, terminology.SNOMED),
+		Severity:      severityFromConcept(condition.Severity),
+		Notes:         strings.Join(notes, "\n"),
+		StartDate:     start,
+		EndDate:       end,
+	}, nil
+}
+
+func prescriptionFromResource(resourceType string, raw json.RawMessage) (models.Prescription, error) {
+	var (
+		status   string
+		concept  *CodeableConcept
+		dosages  []Dosage
+		startRaw string
+		endRaw   string
+	)
+	if resourceType == "MedicationStatement" {
+		var statement MedicationStatement
+		_ = json.Unmarshal(raw, &statement)
+		status, concept, dosages = statement.Status, statement.MedicationCodeableConcept, statement.Dosage
+		startRaw = statement.EffectiveDateTime
+		if statement.EffectivePeriod != nil {
+			startRaw, endRaw = statement.EffectivePeriod.Start, statement.EffectivePeriod.End
+		}
+	} else {
+		var request MedicationRequest
+		_ = json.Unmarshal(raw, &request)
+		status, concept, dosages = request.Status, request.MedicationCodeableConcept, request.DosageInstruction
+		startRaw = request.AuthoredOn
+		if request.DispenseRequest != nil && request.DispenseRequest.ValidityPeriod != nil {
+			if request.DispenseRequest.ValidityPeriod.Start != "" {
+				startRaw = request.DispenseRequest.ValidityPeriod.Start
+			}
+			endRaw = request.DispenseRequest.ValidityPeriod.End
+		}
+	}
+
+	switch status {
+	case "entered-in-error", "not-taken", "draft", "cancelled":
+		return models.Prescription{}, fmt.Errorf("%s has status %s", resourceType, status)
+	}
+	if concept == nil {
+		return models.Prescription{}, fmt.Errorf("medicationReference is not supported, only medicationCodeableConcept")
+	}
+	name := conceptText(concept)
+	if name == "" {
+		return models.Prescription{}, fmt.Errorf("medication has no code or text")
+	}
+	if startRaw == "" {
+		return models.Prescription{}, fmt.Errorf("%s has no start date", resourceType)
+	}
+	start, err := ParseDateTime(startRaw)
+	if err != nil {
+		return models.Prescription{}, err
+	}
+	end, err := parseOptionalDateTime(endRaw)
+	if err != nil {
+		return models.Prescription{}, err
+	}
+	if end != nil && end.Before(start) {
+		return models.Prescription{}, fmt.Errorf("%s ends before it starts", resourceType)
+	}
+	prescription := models.Prescription{
+		MedicationName: name,
+		MedicationCode: knownCoding(concept, terminology.RxNorm),
+		StartDate:      start,
+		EndDate:        end,
+	}
+	if len(dosages) > 0 {
+		prescription.Dosage = dosages[0].Text
+	}
+	return prescription, nil
+}
+
+func appointmentFromEncounter(encounter Encounter, practitioners map[string]string) (models.Appointment, error) {
+	switch encounter.Status {
+	case "cancelled", "entered-in-error":
+		return models.Appointment{}, fmt.Errorf("encounter has status %s", encounter.Status)
+	}
+	if encounter.Period == nil || encounter.Period.Start == "" {
+		return models.Appointment{}, fmt.Errorf("encounter has no period.start")
+	}
+	date, err := ParseDateTime(encounter.Period.Start)
+	if err != nil {
+		return models.Appointment{}, err
+	}
+	appointment := models.Appointment{AppointmentDate: date}
+	for _, participant := range encounter.Participant {
+		if participant.Individual == nil || !strings.HasPrefix(participant.Individual.Reference, "Practitioner/") {
+			continue
+		}
+		appointment.DoctorID = strings.TrimPrefix(participant.Individual.Reference, "Practitioner/")
+		appointment.DoctorName = participant.Individual.Display
+		if name, ok := practitioners[participant.Individual.Reference]; ok && name != "" {
+			appointment.DoctorName = name
+		}
+		break
+	}
+	if appointment.DoctorID == "" {
+		return models.Appointment{}, fmt.Errorf("encounter has no practitioner participant")
+	}
+	reasons := make([]string, 0, len(encounter.ReasonCode)+len(encounter.Type))
+	for _, concept := range append(append([]CodeableConcept{}, encounter.ReasonCode...), encounter.Type...) {
+		if text := conceptText(&concept); text != "" {
+			reasons = append(reasons, text)
+		}
+	}
+	appointment.Notes = strings.Join(reasons, "; ")
+	return appointment, nil
+}
+
+var allergySeverities = map[string]string{"mild": "Mild", "moderate": "Moderate", "severe": "Severe"}
+
+func allergyFromResource(resource AllergyIntolerance) (models.Allergy, error) {
+	if hasVerification(resource.VerificationStatus, "entered-in-error") || hasVerification(resource.VerificationStatus, "refuted") {
+		return models.Allergy{}, fmt.Errorf("allergy is entered-in-error or refuted")
+	}
+	substance := conceptText(resource.Code)
+	if substance == "" {
+		return models.Allergy{}, fmt.Errorf("allergy has no code or text")
+	}
+	onset, err := parseOptionalDateTime(resource.OnsetDateTime)
+	if err != nil {
+		return models.Allergy{}, err
+	}
+	allergy := models.Allergy{
+		Substance:   substance,
+		Code:        knownCoding(resource.Code, terminology.SNOMED, terminology.ICD10, terminology.RxNorm),
+		Criticality: resource.Criticality,
+		OnsetDate:   onset,
+	}
+	if len(resource.Category) > 0 {
+		allergy.Category = resource.Category[0]
+	}
+	if len(resource.Reaction) > 0 {
+		reaction := resource.Reaction[0]
+		manifestations := make([]string, 0, len(reaction.Manifestation))
+		for _, manifestation := range reaction.Manifestation {
+			if text := conceptText(&manifestation); text != "" {
+				manifestations = append(manifestations, text)
+			}
+		}
+		allergy.Reaction = strings.Join(manifestations, ", ")
+		if allergy.Reaction != "" {
+			allergy.Severity = allergySeverities[reaction.Severity]
+		}
+	}
+	if hasCode(resource.ClinicalStatus, codeSet("inactive", "resolved")) {
+		// R4 has no end date for allergies; the record date is the best we have.
+		end := time.Now()
+		if resource.RecordedDate != "" {
+			if recorded, err := ParseDateTime(resource.RecordedDate); err == nil {
+				end = recorded
+			}
+		}
+		if onset != nil && end.Before(*onset) {
+			end = *onset
+		}
+		allergy.EndDate = &end
+	}
+	notes := make([]string, 0, len(resource.Note))
+	for _, note := range resource.Note {
+		notes = append(notes, note.Text)
+	}
+	allergy.Notes = strings.Join(notes, "\n")
+	return allergy, nil
+}
+
+func immunizationFromResource(resource Immunization) (models.Immunization, error) {
+	if resource.Status != "completed" {
+		return models.Immunization{}, fmt.Errorf("immunization has status %s", resource.Status)
+	}
+	name := conceptText(&resource.VaccineCode)
+	if name == "" {
+		return models.Immunization{}, fmt.Errorf("immunization has no vaccine code or text")
+	}
+	date, err := ParseDateTime(resource.OccurrenceDateTime)
+	if err != nil {
+		return models.Immunization{}, err
+	}
+	immunization := models.Immunization{
+		VaccineName:    name,
+		VaccineCode:    knownCoding(&resource.VaccineCode, terminology.CVX),
+		OccurrenceDate: date,
+		LotNumber:      resource.LotNumber,
+	}
+	if len(resource.Performer) > 0 {
+		immunization.Performer = resource.Performer[0].Actor.Display
+	}
+	if len(resource.ProtocolApplied) > 0 {
+		immunization.DoseNumber = resource.ProtocolApplied[0].DoseNumberPositiveInt
+	}
+	notes := make([]string, 0, len(resource.Note))
+	for _, note := range resource.Note {
+		notes = append(notes, note.Text)
+	}
+	immunization.Notes = strings.Join(notes, "\n")
+	return immunization, nil
+}
+
+func practitionerName(practitioner Practitioner) string {
+	if len(practitioner.Name) == 0 {
+		return ""
+	}
+	name := practitioner.Name[0]
+	if name.Text != "" {
+		return name.Text
+	}
+	parts := append(append([]string{}, name.Prefix...), name.Given...)
+	if name.Family != "" {
+		parts = append(parts, name.Family)
+	}
+	return strings.Join(parts, " ")
+}
+
+func dayKey(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+func issueKey(issue models.Issue) string {
+	return "issue|" + strings.ToLower(strings.TrimSpace(issue.Condition)) + "|" + dayKey(issue.StartDate)
+}
+
+func prescriptionKey(prescription models.Prescription) string {
+	return "prescription|" + strings.ToLower(strings.TrimSpace(prescription.MedicationName)) + "|" + dayKey(prescription.StartDate)
+}
+
+func appointmentKey(appointment models.Appointment) string {
+	return "appointment|" + strings.ToLower(appointment.DoctorID) + "|" + appointment.AppointmentDate.UTC().Format("2006-01-02T15:04")
+}
+
+func allergyKey(allergy models.Allergy) string {
+	return "allergy|" + strings.ToLower(strings.TrimSpace(allergy.Substance))
+}
+
+func immunizationKey(immunization models.Immunization) string {
+	return "immunization|" + strings.ToLower(strings.TrimSpace(immunization.VaccineName)) + "|" + dayKey(immunization.OccurrenceDate)
+}
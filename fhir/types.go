@@ -0,0 +1,249 @@
+package fhir
+
+import "encoding/json"
+
+// The types below cover the subset of FHIR R4 that life-signal exchanges.
+// Fields follow the R4 JSON representation so they can be marshalled as-is.
+
+type Bundle struct {
+	ResourceType string        `json:"resourceType"`
+	ID           string        `json:"id,omitempty"`
+	Meta         *Meta         `json:"meta,omitempty"`
+	Type         string        `json:"type"`
+	Timestamp    string        `json:"timestamp,omitempty"`
+	Total        *int          `json:"total,omitempty"`
+	Entry        []BundleEntry `json:"entry,omitempty"`
+}
+
+type BundleEntry struct {
+	FullURL  string          `json:"fullUrl,omitempty"`
+	Resource json.RawMessage `json:"resource"`
+}
+
+type Meta struct {
+	LastUpdated string   `json:"lastUpdated,omitempty"`
+	Profile     []string `json:"profile,omitempty"`
+}
+
+type Coding struct {
+	System  string `json:"system,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+type CodeableConcept struct {
+	Coding []Coding `json:"coding,omitempty"`
+	Text   string   `json:"text,omitempty"`
+}
+
+type Reference struct {
+	Reference string `json:"reference,omitempty"`
+	Display   string `json:"display,omitempty"`
+}
+
+type Identifier struct {
+	System string `json:"system,omitempty"`
+	Value  string `json:"value,omitempty"`
+}
+
+type HumanName struct {
+	Use    string   `json:"use,omitempty"`
+	Text   string   `json:"text,omitempty"`
+	Family string   `json:"family,omitempty"`
+	Given  []string `json:"given,omitempty"`
+	Prefix []string `json:"prefix,omitempty"`
+}
+
+type ContactPoint struct {
+	System string `json:"system,omitempty"`
+	Value  string `json:"value,omitempty"`
+	Use    string `json:"use,omitempty"`
+}
+
+type Address struct {
+	Text string `json:"text,omitempty"`
+}
+
+type Period struct {
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+}
+
+type Annotation struct {
+	Text string `json:"text"`
+}
+
+type Dosage struct {
+	Text string `json:"text,omitempty"`
+}
+
+// Resource carries the fields shared by every resource, enough to dispatch
+// on resourceType before decoding the full payload.
+type Resource struct {
+	ResourceType string `json:"resourceType"`
+	ID           string `json:"id,omitempty"`
+	Meta         *Meta  `json:"meta,omitempty"`
+}
+
+type Patient struct {
+	Resource
+	Identifier []Identifier   `json:"identifier,omitempty"`
+	Active     *bool          `json:"active,omitempty"`
+	Name       []HumanName    `json:"name,omitempty"`
+	Telecom    []ContactPoint `json:"telecom,omitempty"`
+}
+
+type Practitioner struct {
+	Resource
+	Identifier    []Identifier                `json:"identifier,omitempty"`
+	Name          []HumanName                 `json:"name,omitempty"`
+	Telecom       []ContactPoint              `json:"telecom,omitempty"`
+	Address       []Address                   `json:"address,omitempty"`
+	Qualification []PractitionerQualification `json:"qualification,omitempty"`
+	Communication []CodeableConcept           `json:"communication,omitempty"`
+}
+
+type PractitionerQualification struct {
+	Code CodeableConcept `json:"code"`
+}
+
+type Condition struct {
+	Resource
+	ClinicalStatus     *CodeableConcept `json:"clinicalStatus,omitempty"`
+	VerificationStatus *CodeableConcept `json:"verificationStatus,omitempty"`
+	Severity           *CodeableConcept `json:"severity,omitempty"`
+	Code               *CodeableConcept `json:"code,omitempty"`
+	Subject            Reference        `json:"subject"`
+	OnsetDateTime      string           `json:"onsetDateTime,omitempty"`
+	AbatementDateTime  string           `json:"abatementDateTime,omitempty"`
+	RecordedDate       string           `json:"recordedDate,omitempty"`
+	Note               []Annotation     `json:"note,omitempty"`
+}
+
+type MedicationStatement struct {
+	Resource
+	Status                    string           `json:"status"`
+	MedicationCodeableConcept *CodeableConcept `json:"medicationCodeableConcept,omitempty"`
+	MedicationReference       *Reference       `json:"medicationReference,omitempty"`
+	Subject                   Reference        `json:"subject"`
+	EffectiveDateTime         string           `json:"effectiveDateTime,omitempty"`
+	EffectivePeriod           *Period          `json:"effectivePeriod,omitempty"`
+	Dosage                    []Dosage         `json:"dosage,omitempty"`
+}
+
+type MedicationRequest struct {
+	Resource
+	Status                    string           `json:"status"`
+	Intent                    string           `json:"intent"`
+	MedicationCodeableConcept *CodeableConcept `json:"medicationCodeableConcept,omitempty"`
+	MedicationReference       *Reference       `json:"medicationReference,omitempty"`
+	Subject                   Reference        `json:"subject"`
+	AuthoredOn                string           `json:"authoredOn,omitempty"`
+	DosageInstruction         []Dosage         `json:"dosageInstruction,omitempty"`
+	DispenseRequest           *DispenseRequest `json:"dispenseRequest,omitempty"`
+}
+
+type DispenseRequest struct {
+	ValidityPeriod *Period `json:"validityPeriod,omitempty"`
+}
+
+type Encounter struct {
+	Resource
+	Status      string                 `json:"status"`
+	Class       Coding                 `json:"class"`
+	Type        []CodeableConcept      `json:"type,omitempty"`
+	Subject     *Reference             `json:"subject,omitempty"`
+	Participant []EncounterParticipant `json:"participant,omitempty"`
+	Period      *Period                `json:"period,omitempty"`
+	ReasonCode  []CodeableConcept      `json:"reasonCode,omitempty"`
+}
+
+type EncounterParticipant struct {
+	Individual *Reference `json:"individual,omitempty"`
+}
+
+type Appointment struct {
+	Resource
+	Status      string                   `json:"status"`
+	Description string                   `json:"description,omitempty"`
+	Start       string                   `json:"start,omitempty"`
+	End         string                   `json:"end,omitempty"`
+	Comment     string                   `json:"comment,omitempty"`
+	Participant []AppointmentParticipant `json:"participant"`
+}
+
+type AppointmentParticipant struct {
+	Actor  *Reference `json:"actor,omitempty"`
+	Status string     `json:"status"`
+}
+
+type Quantity struct {
+	Value  float64 `json:"value"`
+	Unit   string  `json:"unit,omitempty"`
+	System string  `json:"system,omitempty"`
+	Code   string  `json:"code,omitempty"`
+}
+
+type AllergyIntolerance struct {
+	Resource
+	ClinicalStatus     *CodeableConcept  `json:"clinicalStatus,omitempty"`
+	VerificationStatus *CodeableConcept  `json:"verificationStatus,omitempty"`
+	Category           []string          `json:"category,omitempty"`
+	Criticality        string            `json:"criticality,omitempty"`
+	Code               *CodeableConcept  `json:"code,omitempty"`
+	Patient            Reference         `json:"patient"`
+	OnsetDateTime      string            `json:"onsetDateTime,omitempty"`
+	RecordedDate       string            `json:"recordedDate,omitempty"`
+	Note               []Annotation      `json:"note,omitempty"`
+	Reaction           []AllergyReaction `json:"reaction,omitempty"`
+}
+
+type AllergyReaction struct {
+	Manifestation []CodeableConcept `json:"manifestation"`
+	Severity      string            `json:"severity,omitempty"`
+}
+
+type Immunization struct {
+	Resource
+	Status             string                  `json:"status"`
+	VaccineCode        CodeableConcept         `json:"vaccineCode"`
+	Patient            Reference               `json:"patient"`
+	OccurrenceDateTime string                  `json:"occurrenceDateTime,omitempty"`
+	LotNumber          string                  `json:"lotNumber,omitempty"`
+	Performer          []ImmunizationPerformer `json:"performer,omitempty"`
+	Note               []Annotation            `json:"note,omitempty"`
+	ProtocolApplied    []ImmunizationProtocol  `json:"protocolApplied,omitempty"`
+}
+
+type ImmunizationPerformer struct {
+	Actor Reference `json:"actor"`
+}
+
+type ImmunizationProtocol struct {
+	DoseNumberPositiveInt int    `json:"doseNumberPositiveInt,omitempty"`
+	DoseNumberString      string `json:"doseNumberString,omitempty"`
+}
+
+type Observation struct {
+	Resource
+	Status            string                      `json:"status"`
+	Category          []CodeableConcept           `json:"category,omitempty"`
+	Code              CodeableConcept             `json:"code"`
+	Subject           *Reference                  `json:"subject,omitempty"`
+	EffectiveDateTime string                      `json:"effectiveDateTime,omitempty"`
+	ValueQuantity     *Quantity                   `json:"valueQuantity,omitempty"`
+	ValueString       string                      `json:"valueString,omitempty"`
+	Interpretation    []CodeableConcept           `json:"interpretation,omitempty"`
+	Note              []Annotation                `json:"note,omitempty"`
+	ReferenceRange    []ObservationReferenceRange `json:"referenceRange,omitempty"`
+	Component         []ObservationComponent      `json:"component,omitempty"`
+}
+
+type ObservationReferenceRange struct {
+	Text string `json:"text,omitempty"`
+}
+
+type ObservationComponent struct {
+	Code          CodeableConcept `json:"code"`
+	ValueQuantity *Quantity       `json:"valueQuantity,omitempty"`
+}
@@ -0,0 +1,298 @@
+package fhir
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9\-.]{1,64}$`)
+
+var (
+	bundleTypes              = codeSet("document", "message", "transaction", "transaction-response", "batch", "batch-response", "history", "searchset", "collection")
+	conditionClinicalCodes   = codeSet("active", "recurrence", "relapse", "inactive", "remission", "resolved")
+	conditionInactiveCodes   = codeSet("inactive", "remission", "resolved")
+	medicationStatementCodes = codeSet("active", "completed", "entered-in-error", "intended", "stopped", "on-hold", "unknown", "not-taken")
+	medicationRequestCodes   = codeSet("active", "on-hold", "cancelled", "completed", "entered-in-error", "stopped", "draft", "unknown")
+	medicationRequestIntents = codeSet("proposal", "plan", "order", "original-order", "reflex-order", "filler-order", "instance-order", "option")
+	encounterStatusCodes     = codeSet("planned", "arrived", "triaged", "in-progress", "onleave", "finished", "cancelled", "entered-in-error", "unknown")
+	appointmentStatusCodes   = codeSet("proposed", "pending", "booked", "arrived", "fulfilled", "cancelled", "noshow", "entered-in-error", "checked-in", "waitlist")
+	participationStatusCodes = codeSet("accepted", "declined", "tentative", "needs-action")
+	allergyClinicalCodes     = codeSet("active", "inactive", "resolved")
+	allergyCategories        = codeSet("food", "medication", "environment", "biologic")
+	allergyCriticalities     = codeSet("low", "high", "unable-to-assess")
+	reactionSeverities       = codeSet("mild", "moderate", "severe")
+	immunizationStatusCodes  = codeSet("completed", "entered-in-error", "not-done")
+	observationStatusCodes   = codeSet("registered", "preliminary", "final", "amended", "corrected", "cancelled", "entered-in-error", "unknown")
+)
+
+func codeSet(codes ...string) map[string]bool {
+	set := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		set[code] = true
+	}
+	return set
+}
+
+// ValidateBundle checks a bundle and its entries against the cardinality,
+// required value sets and invariants R4 defines for the resources we map.
+// Resource types life-signal does not know are only checked for a valid id.
+func ValidateBundle(bundle *Bundle) error {
+	if bundle.ResourceType != "Bundle" {
+		return fmt.Errorf("resourceType must be Bundle, got %q", bundle.ResourceType)
+	}
+	if !bundleTypes[bundle.Type] {
+		return fmt.Errorf("Bundle.type %q is not a valid bundle type", bundle.Type)
+	}
+	for i, entry := range bundle.Entry {
+		if err := ValidateResource(entry.Resource); err != nil {
+			return fmt.Errorf("Bundle.entry[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// ValidateResource validates a single raw resource.
+func ValidateResource(raw json.RawMessage) error {
+	var header Resource
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return fmt.Errorf("invalid resource: %w", err)
+	}
+	if header.ResourceType == "" {
+		return fmt.Errorf("resourceType is required")
+	}
+	if header.ID != "" && !idPattern.MatchString(header.ID) {
+		return fmt.Errorf("%s.id %q is not a valid id", header.ResourceType, header.ID)
+	}
+
+	switch header.ResourceType {
+	case "Patient":
+		var patient Patient
+		return decodeResource(raw, &patient)
+	case "Practitioner":
+		var practitioner Practitioner
+		if err := decodeResource(raw, &practitioner); err != nil {
+			return err
+		}
+		for i, qualification := range practitioner.Qualification {
+			if len(qualification.Code.Coding) == 0 && qualification.Code.Text == "" {
+				return fmt.Errorf("Practitioner.qualification[%d].code is required", i)
+			}
+		}
+	case "Condition":
+		var condition Condition
+		if err := decodeResource(raw, &condition); err != nil {
+			return err
+		}
+		return validateCondition(condition)
+	case "MedicationStatement":
+		var statement MedicationStatement
+		if err := decodeResource(raw, &statement); err != nil {
+			return err
+		}
+		if !medicationStatementCodes[statement.Status] {
+			return fmt.Errorf("MedicationStatement.status %q is not valid", statement.Status)
+		}
+		if err := validateMedication("MedicationStatement", statement.MedicationCodeableConcept, statement.MedicationReference); err != nil {
+			return err
+		}
+		if statement.Subject.Reference == "" {
+			return fmt.Errorf("MedicationStatement.subject is required")
+		}
+	case "MedicationRequest":
+		var request MedicationRequest
+		if err := decodeResource(raw, &request); err != nil {
+			return err
+		}
+		if !medicationRequestCodes[request.Status] {
+			return fmt.Errorf("MedicationRequest.status %q is not valid", request.Status)
+		}
+		if !medicationRequestIntents[request.Intent] {
+			return fmt.Errorf("MedicationRequest.intent %q is not valid", request.Intent)
+		}
+		if err := validateMedication("MedicationRequest", request.MedicationCodeableConcept, request.MedicationReference); err != nil {
+			return err
+		}
+		if request.Subject.Reference == "" {
+			return fmt.Errorf("MedicationRequest.subject is required")
+		}
+	case "Encounter":
+		var encounter Encounter
+		if err := decodeResource(raw, &encounter); err != nil {
+			return err
+		}
+		if !encounterStatusCodes[encounter.Status] {
+			return fmt.Errorf("Encounter.status %q is not valid", encounter.Status)
+		}
+		if encounter.Class.Code == "" {
+			return fmt.Errorf("Encounter.class is required")
+		}
+	case "Appointment":
+		var appointment Appointment
+		if err := decodeResource(raw, &appointment); err != nil {
+			return err
+		}
+		return validateAppointment(appointment)
+	case "AllergyIntolerance":
+		var allergy AllergyIntolerance
+		if err := decodeResource(raw, &allergy); err != nil {
+			return err
+		}
+		return validateAllergy(allergy)
+	case "Immunization":
+		var immunization Immunization
+		if err := decodeResource(raw, &immunization); err != nil {
+			return err
+		}
+		if !immunizationStatusCodes[immunization.Status] {
+			return fmt.Errorf("Immunization.status %q is not valid", immunization.Status)
+		}
+		if len(immunization.VaccineCode.Coding) == 0 && immunization.VaccineCode.Text == "" {
+			return fmt.Errorf("Immunization.vaccineCode is required")
+		}
+		if immunization.Patient.Reference == "" {
+			return fmt.Errorf("Immunization.patient is required")
+		}
+		if immunization.OccurrenceDateTime == "" {
+			return fmt.Errorf("Immunization.occurrence[x] is required")
+		}
+	case "Observation":
+		var observation Observation
+		if err := decodeResource(raw, &observation); err != nil {
+			return err
+		}
+		return validateObservation(observation)
+	}
+	return nil
+}
+
+// decodeResource decodes into the typed resource. Unknown elements are allowed,
+// since our types only model a subset of each resource.
+func decodeResource(raw json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("invalid resource: %w", err)
+	}
+	return nil
+}
+
+func hasCode(concept *CodeableConcept, codes map[string]bool) bool {
+	if concept == nil {
+		return false
+	}
+	for _, coding := range concept.Coding {
+		if codes[coding.Code] {
+			return true
+		}
+	}
+	return false
+}
+
+func validateCondition(condition Condition) error {
+	if condition.Subject.Reference == "" {
+		return fmt.Errorf("Condition.subject is required")
+	}
+	if condition.ClinicalStatus != nil {
+		if !hasCode(condition.ClinicalStatus, conditionClinicalCodes) {
+			return fmt.Errorf("Condition.clinicalStatus is not a condition-clinical code")
+		}
+	}
+	// con-4: if abated, the clinical status must be inactive, resolved or remission.
+	if condition.AbatementDateTime != "" && condition.ClinicalStatus != nil {
+		if !hasCode(condition.ClinicalStatus, conditionInactiveCodes) {
+			return fmt.Errorf("Condition with abatement must have an inactive clinicalStatus (con-4)")
+		}
+	}
+	return nil
+}
+
+// validateMedication enforces medication[x] being present exactly once.
+func validateMedication(resourceType string, concept *CodeableConcept, reference *Reference) error {
+	if (concept == nil) == (reference == nil) {
+		return fmt.Errorf("%s.medication[x] must be given exactly once", resourceType)
+	}
+	return nil
+}
+
+func validateAppointment(appointment Appointment) error {
+	if !appointmentStatusCodes[appointment.Status] {
+		return fmt.Errorf("Appointment.status %q is not valid", appointment.Status)
+	}
+	if len(appointment.Participant) == 0 {
+		return fmt.Errorf("Appointment.participant is required")
+	}
+	for i, participant := range appointment.Participant {
+		if !participationStatusCodes[participant.Status] {
+			return fmt.Errorf("Appointment.participant[%d].status %q is not valid", i, participant.Status)
+		}
+	}
+	// app-2: either start and end are specified, or neither.
+	if (appointment.Start == "") != (appointment.End == "") {
+		return fmt.Errorf("Appointment start and end must both be given or both omitted (app-2)")
+	}
+	// app-3: only proposed or cancelled appointments can be missing times.
+	if appointment.Start == "" && appointment.Status != "proposed" && appointment.Status != "cancelled" && appointment.Status != "waitlist" {
+		return fmt.Errorf("Appointment.start is required for status %q (app-3)", appointment.Status)
+	}
+	return nil
+}
+
+func validateAllergy(allergy AllergyIntolerance) error {
+	if allergy.Patient.Reference == "" {
+		return fmt.Errorf("AllergyIntolerance.patient is required")
+	}
+	if allergy.ClinicalStatus != nil && !hasCode(allergy.ClinicalStatus, allergyClinicalCodes) {
+		return fmt.Errorf("AllergyIntolerance.clinicalStatus is not an allergyintolerance-clinical code")
+	}
+	// ait-1 and ait-2: clinicalStatus is required unless the record is
+	// entered-in-error, and forbidden when it is.
+	enteredInError := hasCode(allergy.VerificationStatus, codeSet("entered-in-error"))
+	if enteredInError && allergy.ClinicalStatus != nil {
+		return fmt.Errorf("AllergyIntolerance.clinicalStatus must be absent when entered-in-error (ait-2)")
+	}
+	if !enteredInError && allergy.ClinicalStatus == nil && allergy.VerificationStatus != nil {
+		return fmt.Errorf("AllergyIntolerance.clinicalStatus is required (ait-1)")
+	}
+	for _, category := range allergy.Category {
+		if !allergyCategories[category] {
+			return fmt.Errorf("AllergyIntolerance.category %q is not valid", category)
+		}
+	}
+	if allergy.Criticality != "" && !allergyCriticalities[allergy.Criticality] {
+		return fmt.Errorf("AllergyIntolerance.criticality %q is not valid", allergy.Criticality)
+	}
+	for i, reaction := range allergy.Reaction {
+		if len(reaction.Manifestation) == 0 {
+			return fmt.Errorf("AllergyIntolerance.reaction[%d].manifestation is required", i)
+		}
+		if reaction.Severity != "" && !reactionSeverities[reaction.Severity] {
+			return fmt.Errorf("AllergyIntolerance.reaction[%d].severity %q is not valid", i, reaction.Severity)
+		}
+	}
+	return nil
+}
+
+func validateObservation(observation Observation) error {
+	if !observationStatusCodes[observation.Status] {
+		return fmt.Errorf("Observation.status %q is not valid", observation.Status)
+	}
+	if len(observation.Code.Coding) == 0 && observation.Code.Text == "" {
+		return fmt.Errorf("Observation.code is required")
+	}
+	if observation.ValueQuantity != nil && observation.ValueString != "" {
+		return fmt.Errorf("Observation.value[x] must be given at most once")
+	}
+	for i, component := range observation.Component {
+		if len(component.Code.Coding) == 0 && component.Code.Text == "" {
+			return fmt.Errorf("Observation.component[%d].code is required", i)
+		}
+		// obs-7: a value must not repeat the observation's own code.
+		for _, coding := range component.Code.Coding {
+			for _, own := range observation.Code.Coding {
+				if coding.System == own.System && coding.Code == own.Code && observation.ValueQuantity != nil {
+					return fmt.Errorf("Observation.component[%d] repeats Observation.code while a value is given (obs-7)", i)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+// Package geocode turns clinic addresses into coordinates without calling
+// out to a network service.
+package geocode
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"life-signal/helpers"
+	"life-signal/models"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+var ErrNotFound = errors.New("address not found")
+
+// Geocoder resolves a free-text address to a point.
+type Geocoder interface {
+	Geocode(ctx context.Context, address string) (models.GeoPoint, error)
+}
+
+//go:embed data/gazetteer.json
+var bundled []byte
+
+type Place struct {
+	Address string  `json:"address"`
+	Lat     float64 `json:"lat"`
+	Lng     float64 `json:"lng"`
+}
+
+// Gazetteer is a Geocoder backed by a fixed list of known addresses.
+type Gazetteer struct {
+	Version string  `json:"version"`
+	Places  []Place `json:"places"`
+
+	index map[string]models.GeoPoint
+}
+
+var (
+	current Geocoder = mustParse(bundled)
+	mu      sync.RWMutex
+)
+
+// Init switches to the gazetteer at path, keeping the bundled one when path
+// is empty.
+func Init(path string) error {
+	g, err := Load(path)
+	if err != nil {
+		return err
+	}
+	Configure(g)
+	return nil
+}
+
+// Configure replaces the geocoder returned by Current.
+func Configure(g Geocoder) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = g
+}
+
+func Current() Geocoder {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+func Load(path string) (*Gazetteer, error) {
+	if path == "" {
+		return parse(bundled)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gazetteer: %w", err)
+	}
+	return parse(data)
+}
+
+func mustParse(data []byte) *Gazetteer {
+	g, err := parse(data)
+	if err != nil {
+		panic(fmt.Sprintf("bundled gazetteer is invalid: %v", err))
+	}
+	return g
+}
+
+func parse(data []byte) (*Gazetteer, error) {
+	var g Gazetteer
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, fmt.Errorf("failed to parse gazetteer: %w", err)
+	}
+	if g.Version == "" {
+		return nil, fmt.Errorf("gazetteer has no version")
+	}
+	g.index = map[string]models.GeoPoint{}
+	for i, place := range g.Places {
+		point := Point(place.Lat, place.Lng)
+		if err := helpers.ValidateLocation(point); err != nil {
+			return nil, fmt.Errorf("place %d: %w", i, err)
+		}
+		key := normalize(place.Address)
+		if key == "" {
+			return nil, fmt.Errorf("place %d has no address", i)
+		}
+		g.index[key] = point
+	}
+	return &g, nil
+}
+
+// Point returns the GeoJSON point at lat, lng.
+func Point(lat, lng float64) models.GeoPoint {
+	return models.GeoPoint{Type: "Point", Coordinates: []float64{lng, lat}}
+}
+
+// abbreviations folds the common spellings of street types together so
+// "123 Main Street" finds "123 Main St".
+var abbreviations = map[string]string{
+	"street":    "st",
+	"avenue":    "ave",
+	"av":        "ave",
+	"drive":     "dr",
+	"road":      "rd",
+	"boulevard": "blvd",
+	"lane":      "ln",
+	"court":     "ct",
+	"place":     "pl",
+}
+
+// normalize lowercases an address, drops punctuation and abbreviates street
+// types.
+func normalize(address string) string {
+	words := strings.FieldsFunc(strings.ToLower(address), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		if short, ok := abbreviations[word]; ok {
+			words[i] = short
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// Geocode looks the address up as written, then without its trailing
+// comma-separated parts, so "123 Main St, Springfield" matches "123 Main St".
+func (g *Gazetteer) Geocode(ctx context.Context, address string) (models.GeoPoint, error) {
+	parts := strings.Split(address, ",")
+	for n := len(parts); n > 0; n-- {
+		if point, ok := g.index[normalize(strings.Join(parts[:n], ","))]; ok {
+			return point, nil
+		}
+	}
+	return models.GeoPoint{}, ErrNotFound
+}
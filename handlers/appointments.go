@@ -0,0 +1,426 @@
+package handlers
+
+import (
+	"life-signal/billing"
+	"life-signal/database"
+	"life-signal/helpers"
+	"life-signal/models"
+	"life-signal/notify"
+	"life-signal/reminders"
+	"life-signal/schedule"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func isActiveBooking(booking *models.Booking) bool {
+	return booking.Status == models.BookingRequested || booking.Status == models.BookingConfirmed
+}
+
+// bookableSlot finds the slot of the doctor's schedule starting at start
+// and answers 404, 422 or 500 itself when there is none in the future.
+func bookableSlot(c *gin.Context, db *mongo.Client, handler, doctorID string, start time.Time) (models.Slot, string, bool) {
+	s, ok := findDoctorSchedule(c, db, handler, doctorID)
+	if !ok {
+		return models.Slot{}, "", false
+	}
+	plan, err := schedule.Compile(*s)
+	if err != nil {
+		slog.Error(handler+" failed: Stored schedule is invalid", "doctorID", doctorID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return models.Slot{}, "", false
+	}
+	slot, ok := plan.Slot(start)
+	if !ok {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "start is not the start of one of the doctor's slots"})
+		return models.Slot{}, "", false
+	}
+	if !slot.Start.After(time.Now()) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "start must be in the future"})
+		return models.Slot{}, "", false
+	}
+	return slot, s.TimeZone, true
+}
+
+// findBooking loads the booking with the :id of the request among those
+// matching owner and answers 404 or 500 itself when it cannot.
+func findBooking(c *gin.Context, db *mongo.Client, handler string, owner bson.M) (*models.Booking, bool) {
+	owner["_id"] = c.Param("id")
+	booking, err := database.FindBooking(c, database.GetCollection(db, "life-signal", "appointments"), owner)
+	if err != nil {
+		slog.Error(handler+" failed: Database query error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return nil, false
+	}
+	if booking == nil {
+		slog.Warn(handler+" failed: Appointment not found", "appointmentID", c.Param("id"))
+		c.JSON(http.StatusNotFound, gin.H{"error": "Appointment not found"})
+		return nil, false
+	}
+	return booking, true
+}
+
+// saveBooking writes booking as the successor of version expected and
+// answers 409 or 500 itself when the write fails.
+func saveBooking(c *gin.Context, db *mongo.Client, handler string, booking *models.Booking, expected int64) bool {
+	err := database.UpdateBooking(c, database.GetCollection(db, "life-signal", "appointments"), booking, expected)
+	if err == database.ErrSlotTaken {
+		slog.Warn(handler+" failed: Slot taken", "appointmentID", booking.ID, "start", booking.Start)
+		c.JSON(http.StatusConflict, gin.H{"error": "That slot has just been booked by someone else"})
+		return false
+	}
+	if err == database.ErrVersionConflict {
+		slog.Warn(handler+" failed: Version conflict", "appointmentID", booking.ID)
+		c.JSON(http.StatusConflict, gin.H{"error": "The appointment was changed in the meantime, reload it and try again"})
+		return false
+	}
+	if err != nil {
+		slog.Error(handler+" failed: Database update error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save appointment"})
+		return false
+	}
+	return true
+}
+
+// bindBookingUpdate reads the optional body of a booking change.
+func bindBookingUpdate(c *gin.Context, handler string) (models.BookingUpdateReq, bool) {
+	var update models.BookingUpdateReq
+	if c.Request.ContentLength == 0 {
+		return update, true
+	}
+	if err := c.ShouldBindJSON(&update); err != nil {
+		slog.Warn(handler+" failed: Invalid request body", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return update, false
+	}
+	return update, true
+}
+
+// parseBookingFilters reads ?status=, ?from= and ?to= of a booking listing.
+func parseBookingFilters(c *gin.Context, filter bson.M) (time.Time, time.Time, bool) {
+	if status := c.Query("status"); status != "" {
+		switch status {
+		case models.BookingRequested, models.BookingConfirmed, models.BookingCancelled, models.BookingCompleted, models.BookingNoShow:
+			filter["status"] = status
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be requested, confirmed, cancelled, completed or no-show"})
+			return time.Time{}, time.Time{}, false
+		}
+	}
+	from, err := parseTimelineDate(c.Query("from"), false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be a date or an RFC 3339 timestamp"})
+		return time.Time{}, time.Time{}, false
+	}
+	to, err := parseTimelineDate(c.Query("to"), true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must be a date or an RFC 3339 timestamp"})
+		return time.Time{}, time.Time{}, false
+	}
+	return from, to, true
+}
+
+// BookAppointment reserves one of a verified doctor's free slots for the
+// caller. The booking waits as requested until the doctor confirms it.
+func BookAppointment(c *gin.Context, db *mongo.Client) {
+	userID := c.GetString("userID")
+	var req models.BookingReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		slog.Warn("BookAppointment failed: Invalid request body", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.DoctorID == "" || req.Start.IsZero() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "doctor_id and start are required"})
+		return
+	}
+	doctor, ok := findVerifiedDoctor(c, db, "BookAppointment", req.DoctorID)
+	if !ok {
+		return
+	}
+	if doctor.UserID == userID {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Doctors cannot book appointments with themselves"})
+		return
+	}
+	slot, timeZone, ok := bookableSlot(c, db, "BookAppointment", doctor.ID, req.Start)
+	if !ok {
+		return
+	}
+	discountCode := strings.ToUpper(strings.TrimSpace(req.DiscountCode))
+	if discountCode != "" {
+		_, err := database.FindUsableDiscount(c, database.GetCollection(db, "life-signal", "discounts"), discountCode)
+		if err == database.ErrDiscountUnavailable {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "The discount code is unknown, expired or used up"})
+			return
+		}
+		if err != nil {
+			slog.Error("BookAppointment failed: Database query error", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+	}
+	booking := models.Booking{
+		ID:           uuid.New().String(),
+		UserID:       userID,
+		DoctorID:     doctor.ID,
+		DoctorName:   "Dr. " + doctor.FirstName + " " + doctor.LastName,
+		Start:        slot.Start,
+		End:          slot.End,
+		TimeZone:     timeZone,
+		Status:       models.BookingRequested,
+		Reason:       req.Reason,
+		DiscountCode: discountCode,
+	}
+	err := database.InsertBooking(c, database.GetCollection(db, "life-signal", "appointments"), &booking)
+	if err == database.ErrSlotTaken {
+		slog.Warn("BookAppointment failed: Slot taken", "doctorID", doctor.ID, "start", slot.Start)
+		c.JSON(http.StatusConflict, gin.H{"error": "That slot has just been booked by someone else"})
+		return
+	}
+	if err != nil {
+		slog.Error("BookAppointment failed: Database insert error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to book appointment"})
+		return
+	}
+	notifyBooking(c, db, booking, notify.BookingBooked)
+	var invoice *models.Invoice
+	if billing.Current().IssueOn == billing.IssueOnBooked {
+		invoice = issueInvoice(c, db, "BookAppointment", booking)
+	}
+	slog.Info("BookAppointment successful", "userID", userID, "appointmentID", booking.ID, "doctorID", doctor.ID)
+	c.Header("ETag", helpers.VersionETag(booking.Version))
+	c.JSON(http.StatusCreated, gin.H{"appointment": booking, "invoice": invoice})
+}
+
+// GetMyAppointments lists the caller's bookings, filtered by ?status=,
+// ?from= and ?to= on the start.
+func GetMyAppointments(c *gin.Context, db *mongo.Client) {
+	filter := bson.M{"user_id": c.GetString("userID")}
+	from, to, ok := parseBookingFilters(c, filter)
+	if !ok {
+		return
+	}
+	bookings, err := database.FindBookings(c, database.GetCollection(db, "life-signal", "appointments"), filter, from, to)
+	if err != nil {
+		slog.Error("GetMyAppointments failed: Database query error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	slog.Info("GetMyAppointments successful", "count", len(bookings))
+	c.JSON(http.StatusOK, gin.H{"appointments": bookings})
+}
+
+func GetMyAppointment(c *gin.Context, db *mongo.Client) {
+	booking, ok := findBooking(c, db, "GetMyAppointment", bson.M{"user_id": c.GetString("userID")})
+	if !ok {
+		return
+	}
+	if notModified(c, helpers.VersionETag(booking.Version)) {
+		return
+	}
+	slog.Info("GetMyAppointment successful", "appointmentID", booking.ID)
+	c.JSON(http.StatusOK, gin.H{"appointment": booking})
+}
+
+// CancelMyAppointment cancels one of the caller's bookings, with an optional
+// reason, until it starts. Inside the cancellation window only part of the
+// payment is refunded; the response gives the share as refund_percent.
+func CancelMyAppointment(c *gin.Context, db *mongo.Client) {
+	update, ok := bindBookingUpdate(c, "CancelMyAppointment")
+	if !ok {
+		return
+	}
+	booking, ok := findBooking(c, db, "CancelMyAppointment", bson.M{"user_id": c.GetString("userID")})
+	if !ok {
+		return
+	}
+	if !isActiveBooking(booking) {
+		c.JSON(http.StatusConflict, gin.H{"error": "An appointment that is " + booking.Status + " cannot be cancelled"})
+		return
+	}
+	now := time.Now()
+	if !now.Before(booking.Start) {
+		c.JSON(http.StatusConflict, gin.H{"error": "An appointment that has started cannot be cancelled, please contact the clinic"})
+		return
+	}
+	expected := booking.Version
+	booking.Status = models.BookingCancelled
+	booking.CancelledBy = "patient"
+	booking.CancellationReason = update.Reason
+	booking.CancelledAt = &now
+	if !saveBooking(c, db, "CancelMyAppointment", booking, expected) {
+		return
+	}
+	notifyBooking(c, db, *booking, notify.BookingCancelled)
+	settleCancellation(c, db, "CancelMyAppointment", *booking)
+	refundPercent := billing.RefundPercent(booking)
+	slog.Info("CancelMyAppointment successful", "appointmentID", booking.ID, "refundPercent", refundPercent)
+	c.JSON(http.StatusOK, gin.H{"appointment": booking, "refund_percent": refundPercent})
+}
+
+// RescheduleMyAppointment moves one of the caller's bookings to another free
+// slot of the same doctor. It keeps its ID and needs confirming again. The
+// old and new times are swapped in one write, so the booking never loses its
+// slot to a failed move.
+func RescheduleMyAppointment(c *gin.Context, db *mongo.Client) {
+	update, ok := bindBookingUpdate(c, "RescheduleMyAppointment")
+	if !ok {
+		return
+	}
+	if update.Start.IsZero() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start is required"})
+		return
+	}
+	booking, ok := findBooking(c, db, "RescheduleMyAppointment", bson.M{"user_id": c.GetString("userID")})
+	if !ok {
+		return
+	}
+	if !isActiveBooking(booking) {
+		c.JSON(http.StatusConflict, gin.H{"error": "An appointment that is " + booking.Status + " cannot be rescheduled"})
+		return
+	}
+	if time.Now().After(booking.Start.Add(-billing.CancellationWindow())) {
+		c.JSON(http.StatusConflict, gin.H{"error": "It is too late to reschedule this appointment online, please contact the clinic"})
+		return
+	}
+	slot, timeZone, ok := bookableSlot(c, db, "RescheduleMyAppointment", booking.DoctorID, update.Start)
+	if !ok {
+		return
+	}
+	expected := booking.Version
+	booking.Start = slot.Start
+	booking.End = slot.End
+	booking.TimeZone = timeZone
+	booking.Status = models.BookingRequested
+	booking.ConfirmedAt = nil
+	booking.Reschedules++
+	if !saveBooking(c, db, "RescheduleMyAppointment", booking, expected) {
+		return
+	}
+	notifyBooking(c, db, *booking, notify.BookingRescheduled)
+	slog.Info("RescheduleMyAppointment successful", "appointmentID", booking.ID, "start", booking.Start)
+	c.JSON(http.StatusOK, gin.H{"appointment": booking})
+}
+
+// GetDoctorAppointments lists the calling doctor's bookings with the same
+// filters as GetMyAppointments.
+func GetDoctorAppointments(c *gin.Context, db *mongo.Client) {
+	filter := bson.M{"doctor_id": c.GetString("doctorID")}
+	from, to, ok := parseBookingFilters(c, filter)
+	if !ok {
+		return
+	}
+	bookings, err := database.FindBookings(c, database.GetCollection(db, "life-signal", "appointments"), filter, from, to)
+	if err != nil {
+		slog.Error("GetDoctorAppointments failed: Database query error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	slog.Info("GetDoctorAppointments successful", "count", len(bookings))
+	c.JSON(http.StatusOK, gin.H{"appointments": bookings})
+}
+
+// bookingTransitions are the status changes a doctor can make, with the
+// states they apply to and whether the visit must have started.
+var bookingTransitions = map[string]struct {
+	from    []string
+	started bool
+}{
+	models.BookingConfirmed: {from: []string{models.BookingRequested}},
+	models.BookingCancelled: {from: []string{models.BookingRequested, models.BookingConfirmed}},
+	models.BookingCompleted: {from: []string{models.BookingConfirmed}, started: true},
+	models.BookingNoShow:    {from: []string{models.BookingConfirmed}, started: true},
+}
+
+// changeDoctorAppointment moves one of the calling doctor's bookings to
+// status. Cancelling is only possible before the visit ends. A completed
+// visit is recorded in the patient's medical history; should that fail, the
+// record-appointments command catches up later.
+func changeDoctorAppointment(c *gin.Context, db *mongo.Client, handler, status string) {
+	update, ok := bindBookingUpdate(c, handler)
+	if !ok {
+		return
+	}
+	booking, ok := findBooking(c, db, handler, bson.M{"doctor_id": c.GetString("doctorID")})
+	if !ok {
+		return
+	}
+	transition := bookingTransitions[status]
+	allowed := false
+	for _, from := range transition.from {
+		allowed = allowed || booking.Status == from
+	}
+	if !allowed {
+		c.JSON(http.StatusConflict, gin.H{"error": "An appointment that is " + booking.Status + " cannot become " + status})
+		return
+	}
+	now := time.Now()
+	if transition.started && now.Before(booking.Start) {
+		c.JSON(http.StatusConflict, gin.H{"error": "The appointment has not started yet"})
+		return
+	}
+	if status == models.BookingCancelled && now.After(booking.End) {
+		c.JSON(http.StatusConflict, gin.H{"error": "The appointment is already over"})
+		return
+	}
+
+	expected := booking.Version
+	booking.Status = status
+	switch status {
+	case models.BookingConfirmed:
+		booking.ConfirmedAt = &now
+	case models.BookingCancelled:
+		booking.CancelledBy = "doctor"
+		booking.CancellationReason = update.Reason
+		booking.CancelledAt = &now
+	case models.BookingCompleted:
+		booking.Notes = update.Notes
+		booking.CompletedAt = &now
+	}
+	if !saveBooking(c, db, handler, booking, expected) {
+		return
+	}
+	switch status {
+	case models.BookingConfirmed:
+		notifyBooking(c, db, *booking, notify.BookingConfirmed)
+		if err := reminders.ScheduleAppointmentReminders(c, db, *booking); err != nil {
+			slog.Warn(handler+": Failed to schedule reminders", "appointmentID", booking.ID, "error", err)
+		}
+	case models.BookingCancelled:
+		notifyBooking(c, db, *booking, notify.BookingCancelled)
+		settleCancellation(c, db, handler, *booking)
+	case models.BookingCompleted:
+		histories := database.GetCollection(db, "life-signal", "user-medical-history")
+		bookings := database.GetCollection(db, "life-signal", "appointments")
+		if err := database.RecordCompletedBooking(c, histories, bookings, booking); err != nil {
+			slog.Warn(handler+": Failed to record appointment in medical history", "appointmentID", booking.ID, "error", err)
+		}
+		if billing.Current().IssueOn == billing.IssueOnCompleted {
+			issueInvoice(c, db, handler, *booking)
+		}
+	}
+	slog.Info(handler+" successful", "appointmentID", booking.ID, "status", status)
+	c.JSON(http.StatusOK, gin.H{"appointment": booking})
+}
+
+func ConfirmAppointment(c *gin.Context, db *mongo.Client) {
+	changeDoctorAppointment(c, db, "ConfirmAppointment", models.BookingConfirmed)
+}
+
+func CancelDoctorAppointment(c *gin.Context, db *mongo.Client) {
+	changeDoctorAppointment(c, db, "CancelDoctorAppointment", models.BookingCancelled)
+}
+
+func CompleteAppointment(c *gin.Context, db *mongo.Client) {
+	changeDoctorAppointment(c, db, "CompleteAppointment", models.BookingCompleted)
+}
+
+func MarkAppointmentNoShow(c *gin.Context, db *mongo.Client) {
+	changeDoctorAppointment(c, db, "MarkAppointmentNoShow", models.BookingNoShow)
+}
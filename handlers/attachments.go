@@ -0,0 +1,430 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"life-signal/database"
+	"life-signal/helpers"
+	"life-signal/models"
+	"life-signal/storage"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultMaxAttachmentBytes = 20 << 20
+	downloadURLTTL            = 5 * time.Minute
+)
+
+// allowedAttachmentTypes are the sniffed content types we accept: scans,
+// PDFs such as discharge summaries and lab reports, and plain text.
+var allowedAttachmentTypes = map[string]bool{
+	"application/pdf": true,
+	"image/jpeg":      true,
+	"image/png":       true,
+	"text/plain":      true,
+}
+
+var errInvalidLink = errors.New("invalid link")
+
+func maxAttachmentBytes() int64 {
+	if raw := os.Getenv("MAX_ATTACHMENT_BYTES"); raw != "" {
+		if limit, err := strconv.ParseInt(raw, 10, 64); err == nil && limit > 0 {
+			return limit
+		}
+	}
+	return defaultMaxAttachmentBytes
+}
+
+func attachmentDownloadURL(attachment models.Attachment) string {
+	return helpers.SignDownloadURL("/files/"+attachment.ID, time.Now().Add(downloadURLTTL))
+}
+
+// parseAttachmentLink reads a link such as "medical_issues/<id>" or
+// "appointments/<id>", naming the entry an upload belongs to.
+func parseAttachmentLink(link string) (list string, id string, err error) {
+	list, id, found := strings.Cut(link, "/")
+	if !found || (list != "medical_issues" && list != "appointments") || id == "" {
+		return "", "", fmt.Errorf("%w: link must be medical_issues/<id> or appointments/<id>", errInvalidLink)
+	}
+	return list, id, nil
+}
+
+// linkAttachment adds attachmentID to the issue or appointment with entryID.
+func linkAttachment(history *models.MedicalHistory, list, entryID, attachmentID string) error {
+	switch list {
+	case "medical_issues":
+		for i := range history.MedicalIssues {
+			if issue := &history.MedicalIssues[i]; issue.ID == entryID {
+				issue.AttachmentIDs = append(append([]string{}, issue.AttachmentIDs...), attachmentID)
+				return nil
+			}
+		}
+	case "appointments":
+		for i := range history.Appointments {
+			if appointment := &history.Appointments[i]; appointment.ID == entryID {
+				appointment.AttachmentIDs = append(append([]string{}, appointment.AttachmentIDs...), attachmentID)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: %s/%s does not exist", errInvalidLink, list, entryID)
+}
+
+// unlinkAttachment removes attachmentID from every entry and reports whether
+// any referred to it.
+func unlinkAttachment(history *models.MedicalHistory, attachmentID string) bool {
+	removed := false
+	without := func(ids []string) []string {
+		kept := []string{}
+		for _, id := range ids {
+			if id == attachmentID {
+				removed = true
+				continue
+			}
+			kept = append(kept, id)
+		}
+		if len(kept) == 0 {
+			return nil
+		}
+		return kept
+	}
+	for i := range history.MedicalIssues {
+		history.MedicalIssues[i].AttachmentIDs = without(history.MedicalIssues[i].AttachmentIDs)
+	}
+	for i := range history.Appointments {
+		history.Appointments[i].AttachmentIDs = without(history.Appointments[i].AttachmentIDs)
+	}
+	return removed
+}
+
+// checkAttachmentLinks verifies that every attachment_ids entry in history
+// names an attachment of the same user. It answers 422 itself when not.
+func checkAttachmentLinks(c *gin.Context, db *mongo.Client, handler string, history *models.MedicalHistory) bool {
+	ids := map[string]bool{}
+	for _, issue := range history.MedicalIssues {
+		for _, id := range issue.AttachmentIDs {
+			ids[id] = true
+		}
+	}
+	for _, appointment := range history.Appointments {
+		for _, id := range appointment.AttachmentIDs {
+			ids[id] = true
+		}
+	}
+	if len(ids) == 0 {
+		return true
+	}
+	list := make([]string, 0, len(ids))
+	for id := range ids {
+		list = append(list, id)
+	}
+	collection := database.GetCollection(db, "life-signal", "attachments")
+	count, err := collection.CountDocuments(c, bson.M{"_id": bson.M{"$in": list}, "user_id": history.UserID})
+	if err != nil {
+		slog.Error(handler+" failed: Error checking attachments", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save medical history"})
+		return false
+	}
+	if count != int64(len(list)) {
+		slog.Warn(handler+" failed: Unknown attachment", "userID", history.UserID)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "attachment_ids refer to attachments that do not exist"})
+		return false
+	}
+	return true
+}
+
+// receiveUpload reads the multipart "file" of the request after checking its
+// size and sniffed content type and scanning it. It answers the request
+// itself and returns ok=false when the upload is refused.
+func receiveUpload(c *gin.Context, handler string) (header *multipart.FileHeader, data []byte, contentType string, scanned bool, ok bool) {
+	limit := maxAttachmentBytes()
+	// Leave room for the multipart envelope around the file itself.
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit+1<<20)
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Attachments are limited to %d bytes", limit)})
+			return nil, nil, "", false, false
+		}
+		slog.Warn(handler+" failed: Missing file", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A multipart file field named file is required"})
+		return nil, nil, "", false, false
+	}
+	if header.Size > limit {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Attachments are limited to %d bytes", limit)})
+		return nil, nil, "", false, false
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		slog.Error(handler+" failed: Error opening upload", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read upload"})
+		return nil, nil, "", false, false
+	}
+	defer file.Close()
+	data, err = io.ReadAll(io.LimitReader(file, limit+1))
+	if err != nil {
+		slog.Error(handler+" failed: Error reading upload", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read upload"})
+		return nil, nil, "", false, false
+	}
+	if int64(len(data)) > limit {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Attachments are limited to %d bytes", limit)})
+		return nil, nil, "", false, false
+	}
+	if len(data) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Uploaded file is empty"})
+		return nil, nil, "", false, false
+	}
+
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	if !allowedAttachmentTypes[sniffed] {
+		slog.Warn(handler+" failed: Content type not allowed", "contentType", sniffed)
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Only PDF, JPEG, PNG and plain text files can be attached"})
+		return nil, nil, "", false, false
+	}
+	if declared, _, err := mime.ParseMediaType(header.Header.Get("Content-Type")); err == nil &&
+		declared != "application/octet-stream" && declared != sniffed {
+		slog.Warn(handler+" failed: Content type mismatch", "declared", declared, "sniffed", sniffed)
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": fmt.Sprintf("File content is %s but was sent as %s", sniffed, declared)})
+		return nil, nil, "", false, false
+	}
+
+	_, isNoop := storage.CurrentScanner().(storage.NoopScanner)
+	if err := storage.CurrentScanner().Scan(c, bytes.NewReader(data)); err != nil {
+		var infected *storage.InfectedError
+		if errors.As(err, &infected) {
+			slog.Warn(handler+" failed: Infected file", "signature", infected.Signature)
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "File was rejected by the virus scanner"})
+			return nil, nil, "", false, false
+		}
+		slog.Error(handler+" failed: Virus scan unavailable", "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Virus scanning is unavailable, please retry later"})
+		return nil, nil, "", false, false
+	}
+	return header, data, sniffed, !isNoop, true
+}
+
+// storeAttachment saves an upload received by receiveUpload as an attachment
+// of userID, contents first so a stored record always has its blob.
+func storeAttachment(c *gin.Context, db *mongo.Client, userID, description string, header *multipart.FileHeader, data []byte, contentType string, scanned bool) (models.Attachment, error) {
+	sum := sha256.Sum256(data)
+	attachment := models.Attachment{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		FileName:    header.Filename,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		SHA256:      hex.EncodeToString(sum[:]),
+		Description: description,
+		Scanned:     scanned,
+		UploadedAt:  time.Now(),
+	}
+	attachment.StorageKey = "users/" + userID + "/" + attachment.ID
+
+	sealed, err := database.EncryptAttachment(c, &attachment, data)
+	if err != nil {
+		return attachment, err
+	}
+	store := storage.Current()
+	if err := store.Put(c, attachment.StorageKey, bytes.NewReader(sealed), int64(len(sealed)), attachment.ContentType); err != nil {
+		return attachment, fmt.Errorf("failed to store attachment contents: %w", err)
+	}
+	collection := database.GetCollection(db, "life-signal", "attachments")
+	if _, err := collection.InsertOne(c, attachment); err != nil {
+		store.Delete(c, attachment.StorageKey)
+		return attachment, fmt.Errorf("failed to save attachment: %w", err)
+	}
+	return attachment, nil
+}
+
+// UploadAttachment stores a multipart "file" after checking its size and
+// sniffed content type and scanning it. An optional "link" form field such
+// as "appointments/<id>" attaches it to that history entry.
+func UploadAttachment(c *gin.Context, db *mongo.Client) {
+	userID := c.Param("userid")
+	header, data, contentType, scanned, ok := receiveUpload(c, "UploadAttachment")
+	if !ok {
+		return
+	}
+	var linkList, linkID string
+	if link := c.PostForm("link"); link != "" {
+		var err error
+		if linkList, linkID, err = parseAttachmentLink(link); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	attachment, err := storeAttachment(c, db, userID, c.PostForm("description"), header, data, contentType, scanned)
+	if err != nil {
+		slog.Error("UploadAttachment failed: Error storing attachment", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store attachment"})
+		return
+	}
+
+	response := gin.H{"attachment": attachment, "download_url": attachmentDownloadURL(attachment)}
+	if linkList != "" {
+		historyCollection := database.GetCollection(db, "life-signal", "user-medical-history")
+		history, err := database.UpdateMedicalHistory(c, historyCollection, userID, func(history *models.MedicalHistory) error {
+			return linkAttachment(history, linkList, linkID, attachment.ID)
+		})
+		if err != nil {
+			database.GetCollection(db, "life-signal", "attachments").DeleteOne(c, bson.M{"_id": attachment.ID})
+			storage.Current().Delete(c, attachment.StorageKey)
+			if errors.Is(err, errInvalidLink) {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+				return
+			}
+			slog.Error("UploadAttachment failed: Error linking attachment", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link attachment"})
+			return
+		}
+		c.Header("ETag", helpers.VersionETag(history.Version))
+		response["version"] = history.Version
+	}
+
+	slog.Info("UploadAttachment successful", "userID", userID, "attachmentID", attachment.ID, "size", attachment.Size)
+	c.JSON(http.StatusCreated, response)
+}
+
+func GetAttachments(c *gin.Context, db *mongo.Client) {
+	userID := c.Param("userid")
+	collection := database.GetCollection(db, "life-signal", "attachments")
+	cursor, err := collection.Find(c, bson.M{"user_id": userID}, options.Find().SetSort(bson.D{{Key: "uploaded_at", Value: -1}}))
+	if err != nil {
+		slog.Error("GetAttachments failed: Database error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	defer cursor.Close(c)
+	attachments := []models.Attachment{}
+	if err := cursor.All(c, &attachments); err != nil {
+		slog.Error("GetAttachments failed: Error decoding attachments", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	slog.Info("GetAttachments successful", "userID", userID, "count", len(attachments))
+	c.JSON(http.StatusOK, gin.H{"attachments": attachments})
+}
+
+// GetAttachment returns an attachment's metadata with a download URL that is
+// valid for a few minutes without a bearer token.
+func GetAttachment(c *gin.Context, db *mongo.Client) {
+	collection := database.GetCollection(db, "life-signal", "attachments")
+	var attachment models.Attachment
+	err := collection.FindOne(c, bson.M{"_id": c.Param("id"), "user_id": c.Param("userid")}).Decode(&attachment)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+	if err != nil {
+		slog.Error("GetAttachment failed: Database error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"attachment":   attachment,
+		"download_url": attachmentDownloadURL(attachment),
+		"expires_in":   int(downloadURLTTL.Seconds()),
+	})
+}
+
+// DeleteAttachment removes an attachment and every history link to it.
+func DeleteAttachment(c *gin.Context, db *mongo.Client) {
+	userID := c.Param("userid")
+	collection := database.GetCollection(db, "life-signal", "attachments")
+	var attachment models.Attachment
+	err := collection.FindOne(c, bson.M{"_id": c.Param("id"), "user_id": userID}).Decode(&attachment)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+	if err != nil {
+		slog.Error("DeleteAttachment failed: Database error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	historyCollection := database.GetCollection(db, "life-signal", "user-medical-history")
+	current, err := database.FindMedicalHistory(c, historyCollection, userID)
+	if err == nil && current != nil && unlinkAttachment(current, attachment.ID) {
+		_, err = database.UpdateMedicalHistory(c, historyCollection, userID, func(history *models.MedicalHistory) error {
+			unlinkAttachment(history, attachment.ID)
+			return nil
+		})
+	}
+	if err != nil {
+		slog.Error("DeleteAttachment failed: Error unlinking attachment", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete attachment"})
+		return
+	}
+	if _, err := collection.DeleteOne(c, bson.M{"_id": attachment.ID}); err != nil {
+		slog.Error("DeleteAttachment failed: Database error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete attachment"})
+		return
+	}
+	if err := storage.Current().Delete(c, attachment.StorageKey); err != nil {
+		slog.Warn("DeleteAttachment: Blob left behind", "key", attachment.StorageKey, "error", err)
+	}
+	slog.Info("DeleteAttachment successful", "userID", userID, "attachmentID", attachment.ID)
+	c.Status(http.StatusNoContent)
+}
+
+// DownloadAttachment serves attachment contents for a signed URL issued by
+// GetAttachment or UploadAttachment. The signature stands in for a token.
+func DownloadAttachment(c *gin.Context, db *mongo.Client) {
+	id := c.Param("id")
+	if err := helpers.VerifyDownloadURL("/files/"+id, c.Query("expires"), c.Query("signature")); err != nil {
+		slog.Warn("DownloadAttachment failed: Bad signature", "attachmentID", id, "error", err)
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	collection := database.GetCollection(db, "life-signal", "attachments")
+	var attachment models.Attachment
+	err := collection.FindOne(c, bson.M{"_id": id}).Decode(&attachment)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+	if err != nil {
+		slog.Error("DownloadAttachment failed: Database error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	data, err := database.ReadAttachment(c, &attachment)
+	if err == storage.ErrNotFound {
+		slog.Error("DownloadAttachment failed: Blob missing", "attachmentID", id)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment contents are missing"})
+		return
+	}
+	if err != nil {
+		slog.Error("DownloadAttachment failed: Blob store error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.DataFromReader(http.StatusOK, int64(len(data)), attachment.ContentType, bytes.NewReader(data), map[string]string{
+		"Content-Disposition":    mime.FormatMediaType("attachment", map[string]string{"filename": attachment.FileName}),
+		"Cache-Control":          "private, no-store",
+		"X-Content-Type-Options": "nosniff",
+	})
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"life-signal/bulk"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// bulkParams reads the :kind parameter and ?format=, which defaults to ndjson.
+func bulkParams(c *gin.Context) (bulk.Kind, bulk.Format, bool) {
+	kind, err := bulk.ParseKind(c.Param("kind"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return "", "", false
+	}
+	format, err := bulk.ParseFormat(c.DefaultQuery("format", string(bulk.NDJSON)))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", "", false
+	}
+	return kind, format, true
+}
+
+// ExportRecords streams a whole collection as CSV or NDJSON. Errors after the
+// first record has been sent can only be logged, so clients should compare
+// the X-Record-Count trailer with what they received.
+func ExportRecords(c *gin.Context, db *mongo.Client) {
+	kind, format, ok := bulkParams(c)
+	if !ok {
+		return
+	}
+	filename := string(kind) + "-" + time.Now().UTC().Format("2006-01-02") + "." + string(format)
+	c.Header("Content-Type", format.ContentType())
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Header("Cache-Control", "private, no-store")
+	c.Header("Trailer", "X-Record-Count")
+	c.Status(http.StatusOK)
+
+	// Every exported medical history is audited as a read of its patient.
+	var subjects []string
+	var onRecord func(string)
+	if kind == bulk.MedicalHistories {
+		onRecord = func(userID string) {
+			if subjects = append(subjects, userID); len(subjects) == auditBatchSize {
+				recordAccess(c, db, "ExportRecords", subjects)
+				subjects = subjects[:0]
+			}
+		}
+	}
+	count, err := bulk.Export(c, db, kind, format, c.Writer, onRecord)
+	recordAccess(c, db, "ExportRecords", subjects)
+	if err != nil {
+		slog.Error("ExportRecords failed: Export aborted", "kind", kind, "written", count, "error", err)
+		return
+	}
+	c.Writer.Header().Set("X-Record-Count", strconv.Itoa(count))
+	slog.Info("ExportRecords successful", "kind", kind, "format", format, "count", count)
+}
+
+// ImportRecords reads CSV or NDJSON from the request body and upserts it in
+// batches. The response is NDJSON, streamed as the import runs: one line per
+// rejected row or interaction warning, a progress line per batch and a final
+// summary line.
+func ImportRecords(c *gin.Context, db *mongo.Client) {
+	kind, format, ok := bulkParams(c)
+	if !ok {
+		return
+	}
+	dryRun := c.Query("dry_run") == "true"
+	batchSize := bulk.DefaultBatchSize
+	if raw := c.Query("batch_size"); raw != "" {
+		size, err := strconv.Atoi(raw)
+		if err != nil || size < 1 || size > bulk.MaxBatchSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "batch_size must be between 1 and 5000"})
+			return
+		}
+		batchSize = size
+	}
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+	encoder := json.NewEncoder(c.Writer)
+	emit := func(line gin.H) {
+		encoder.Encode(line)
+		c.Writer.Flush()
+	}
+
+	override := c.Query("override_interactions") == "true"
+	stats, err := bulk.Import(c, db, kind, format, c.Request.Body, bulk.Options{
+		DryRun:               dryRun,
+		BatchSize:            batchSize,
+		OverrideInteractions: override,
+		OnError: func(rowErr bulk.RowError) {
+			emit(gin.H{"type": "error", "line": rowErr.Line, "id": rowErr.ID, "error": rowErr.Error})
+		},
+		OnWarning: func(warning bulk.RowError) {
+			emit(gin.H{"type": "warning", "line": warning.Line, "id": warning.ID, "warning": warning.Error})
+		},
+		OnProgress: func(stats bulk.Stats) {
+			emit(gin.H{"type": "progress", "stats": stats})
+		},
+	})
+	if err != nil {
+		slog.Error("ImportRecords failed: Import aborted", "kind", kind, "error", err)
+		emit(gin.H{"type": "aborted", "error": err.Error(), "stats": stats})
+		return
+	}
+	if override && stats.Warnings > 0 {
+		slog.Warn("ImportRecords: Interaction check overridden", "kind", kind, "warnings", stats.Warnings, "by", c.GetString("userID"))
+	}
+	slog.Info("ImportRecords successful", "kind", kind, "rows", stats.Rows, "invalid", stats.Invalid, "failed", stats.Failed, "dryRun", dryRun)
+	emit(gin.H{"type": "summary", "stats": stats})
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"life-signal/database"
+	"life-signal/ical"
+	"life-signal/models"
+	"life-signal/notify"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// feedHistory is how far back a calendar feed lists appointments.
+const feedHistory = 90 * 24 * time.Hour
+
+func hashFeedToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// notifyBooking queues a message about the change to booking. A failure is
+// only logged, as the change itself has already been saved.
+func notifyBooking(c *gin.Context, db *mongo.Client, booking models.Booking, change string) {
+	if err := notify.QueueBookingUpdate(c, db, booking, change); err != nil {
+		slog.Warn("Failed to queue appointment notification", "appointmentID", booking.ID, "change", change, "error", err)
+	}
+}
+
+// createCalendarFeed issues a new feed URL for owner, revoking the old one.
+func createCalendarFeed(c *gin.Context, db *mongo.Client, handler, owner, ownerID string) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		slog.Error(handler+" failed: Error generating token", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create calendar feed"})
+		return
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+	feed := models.CalendarFeed{
+		ID:        owner + ":" + ownerID,
+		Owner:     owner,
+		OwnerID:   ownerID,
+		TokenHash: hashFeedToken(token),
+		CreatedAt: time.Now(),
+	}
+	collection := database.GetCollection(db, "life-signal", "calendar-feeds")
+	if _, err := collection.ReplaceOne(c, bson.M{"_id": feed.ID}, feed, options.Replace().SetUpsert(true)); err != nil {
+		slog.Error(handler+" failed: Database update error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create calendar feed"})
+		return
+	}
+	url := publicBaseURL(c) + "/calendar/" + token + ".ics"
+	slog.Info(handler+" successful", "owner", owner, "ownerID", ownerID)
+	c.JSON(http.StatusCreated, gin.H{
+		"feed":       feed,
+		"url":        url,
+		"webcal_url": "webcal://" + strings.SplitN(url, "://", 2)[1],
+	})
+}
+
+func revokeCalendarFeed(c *gin.Context, db *mongo.Client, handler, owner, ownerID string) {
+	collection := database.GetCollection(db, "life-signal", "calendar-feeds")
+	result, err := collection.DeleteOne(c, bson.M{"_id": owner + ":" + ownerID})
+	if err != nil {
+		slog.Error(handler+" failed: Database delete error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke calendar feed"})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "There is no calendar feed to revoke"})
+		return
+	}
+	slog.Info(handler+" successful", "owner", owner, "ownerID", ownerID)
+	c.Status(http.StatusNoContent)
+}
+
+// CreateMyCalendarFeed returns a secret URL calendar apps can subscribe to
+// for the caller's appointments. Calling it again replaces the URL.
+func CreateMyCalendarFeed(c *gin.Context, db *mongo.Client) {
+	createCalendarFeed(c, db, "CreateMyCalendarFeed", ical.ForPatient, c.GetString("userID"))
+}
+
+func RevokeMyCalendarFeed(c *gin.Context, db *mongo.Client) {
+	revokeCalendarFeed(c, db, "RevokeMyCalendarFeed", ical.ForPatient, c.GetString("userID"))
+}
+
+// CreateDoctorCalendarFeed is CreateMyCalendarFeed for the calling doctor's
+// practice.
+func CreateDoctorCalendarFeed(c *gin.Context, db *mongo.Client) {
+	createCalendarFeed(c, db, "CreateDoctorCalendarFeed", ical.ForDoctor, c.GetString("doctorID"))
+}
+
+func RevokeDoctorCalendarFeed(c *gin.Context, db *mongo.Client) {
+	revokeCalendarFeed(c, db, "RevokeDoctorCalendarFeed", ical.ForDoctor, c.GetString("doctorID"))
+}
+
+// GetCalendarFeed serves the appointments behind a feed token as an
+// iCalendar object. The token in the URL is the only credential, as calendar
+// apps cannot send an Authorization header. Cancelled appointments stay in
+// the feed so subscribers remove them.
+func GetCalendarFeed(c *gin.Context, db *mongo.Client) {
+	token := strings.TrimSuffix(c.Param("token"), ".ics")
+	var feed models.CalendarFeed
+	err := database.GetCollection(db, "life-signal", "calendar-feeds").FindOne(c, bson.M{"token_hash": hashFeedToken(token)}).Decode(&feed)
+	if err == mongo.ErrNoDocuments {
+		slog.Warn("GetCalendarFeed failed: Unknown token")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar feed not found"})
+		return
+	}
+	if err != nil {
+		slog.Error("GetCalendarFeed failed: Database query error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	filter := bson.M{"user_id": feed.OwnerID}
+	if feed.Owner == ical.ForDoctor {
+		filter = bson.M{"doctor_id": feed.OwnerID}
+	}
+	bookings, err := database.FindBookings(c, database.GetCollection(db, "life-signal", "appointments"), filter, time.Now().Add(-feedHistory), time.Time{})
+	if err != nil {
+		slog.Error("GetCalendarFeed failed: Error loading appointments", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	doctors, err := bookingDoctors(c, db, bookings)
+	if err != nil {
+		slog.Error("GetCalendarFeed failed: Error loading doctors", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	cal := ical.Calendar{Name: "Life Signal appointments"}
+	for _, booking := range bookings {
+		cal.Events = append(cal.Events, ical.BookingEvent(booking, doctors[booking.DoctorID], feed.Owner))
+	}
+	data, err := ical.Encode(cal)
+	if err != nil {
+		slog.Error("GetCalendarFeed failed: Error encoding calendar", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	slog.Info("GetCalendarFeed successful", "owner", feed.Owner, "ownerID", feed.OwnerID, "count", len(bookings))
+	c.Header("Cache-Control", "private, no-cache")
+	c.Data(http.StatusOK, ical.ContentType, data)
+}
+
+// bookingDoctors loads the doctors of bookings by ID.
+func bookingDoctors(ctx context.Context, db *mongo.Client, bookings []models.Booking) (map[string]*models.Doctor, error) {
+	ids := bson.A{}
+	for _, booking := range bookings {
+		ids = append(ids, booking.DoctorID)
+	}
+	doctors := map[string]*models.Doctor{}
+	if len(ids) == 0 {
+		return doctors, nil
+	}
+	cursor, err := database.GetCollection(db, "life-signal", "doctors").Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	var found []models.Doctor
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	for i := range found {
+		doctors[found[i].ID] = &found[i]
+	}
+	return doctors, nil
+}
+
+// GetMyAppointmentInvite downloads one of the caller's bookings as an .ics
+// file to import into a calendar.
+func GetMyAppointmentInvite(c *gin.Context, db *mongo.Client) {
+	booking, ok := findBooking(c, db, "GetMyAppointmentInvite", bson.M{"user_id": c.GetString("userID")})
+	if !ok {
+		return
+	}
+	doctors, err := bookingDoctors(c, db, []models.Booking{*booking})
+	if err != nil {
+		slog.Error("GetMyAppointmentInvite failed: Error loading doctor", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	event := ical.BookingEvent(*booking, doctors[booking.DoctorID], ical.ForPatient)
+	data, err := ical.Encode(ical.Calendar{Method: ical.MethodPublish, Events: []ical.Event{event}})
+	if err != nil {
+		slog.Error("GetMyAppointmentInvite failed: Error encoding calendar", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	slog.Info("GetMyAppointmentInvite successful", "appointmentID", booking.ID)
+	c.Header("Content-Disposition", `attachment; filename="appointment.ics"`)
+	c.Data(http.StatusOK, ical.ContentType, data)
+}
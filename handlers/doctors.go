@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"life-signal/database"
+	"life-signal/geocode"
+	"life-signal/helpers"
+	"life-signal/models"
+	"life-signal/search"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultDoctorLimit = 20
+	maxDoctorLimit     = 100
+)
+
+// defaultDescending is the order used for each sort key when ?order= is left
+// out: best rated, cheapest and most experienced first.
+var defaultDescending = map[string]bool{
+	"rating":     true,
+	"fee":        false,
+	"experience": true,
+}
+
+func optionalFloat(c *gin.Context, name string, min, max float64) (*float64, error) {
+	raw := c.Query(name)
+	if raw == "" {
+		return nil, nil
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil || value < min || value > max {
+		return nil, fmt.Errorf("%s must be a number between %g and %g", name, min, max)
+	}
+	return &value, nil
+}
+
+func optionalInt(c *gin.Context, name string) (*int, error) {
+	raw := c.Query(name)
+	if raw == "" {
+		return nil, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 0 {
+		return nil, fmt.Errorf("%s must be a whole number of years", name)
+	}
+	return &value, nil
+}
+
+// parseDoctorQuery reads the filters, sort and page of a doctor listing.
+// Patients only ever see verified doctors.
+func parseDoctorQuery(c *gin.Context) (database.DoctorQuery, error) {
+	q := database.DoctorQuery{
+		Status:        models.DoctorVerified,
+		Speciality:    strings.TrimSpace(c.Query("speciality")),
+		Language:      strings.TrimSpace(c.Query("language")),
+		Qualification: strings.TrimSpace(c.Query("qualification")),
+		Service:       strings.TrimSpace(c.Query("service")),
+		Cursor:        c.Query("cursor"),
+		Limit:         defaultDoctorLimit,
+	}
+	if day := strings.TrimSpace(c.Query("day")); day != "" {
+		for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+			if strings.EqualFold(day, weekday.String()) {
+				q.Day = weekday.String()
+			}
+		}
+		if q.Day == "" {
+			return q, fmt.Errorf("day must be a day of the week such as Monday")
+		}
+	}
+
+	var err error
+	if q.MinRating, err = optionalFloat(c, "min_rating", 0, 5); err != nil {
+		return q, err
+	}
+	if q.MaxFee, err = optionalFloat(c, "max_fee", 0, 1e9); err != nil {
+		return q, err
+	}
+	if q.MinExperience, err = optionalInt(c, "min_experience"); err != nil {
+		return q, err
+	}
+	if q.MaxExperience, err = optionalInt(c, "max_experience"); err != nil {
+		return q, err
+	}
+	if q.MinExperience != nil && q.MaxExperience != nil && *q.MinExperience > *q.MaxExperience {
+		return q, fmt.Errorf("min_experience must not be greater than max_experience")
+	}
+
+	q.Sort = c.Query("sort")
+	if _, ok := database.DoctorSortFields[q.Sort]; q.Sort != "" && !ok {
+		return q, fmt.Errorf("sort must be rating, fee or experience")
+	}
+	switch c.Query("order") {
+	case "":
+		q.Descending = defaultDescending[q.Sort]
+	case "asc":
+	case "desc":
+		q.Descending = true
+	default:
+		return q, fmt.Errorf("order must be asc or desc")
+	}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || limit < 1 || limit > maxDoctorLimit {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxDoctorLimit)
+		}
+		q.Limit = limit
+	}
+	return q, nil
+}
+
+// hideReview removes what only the doctor and reviewers should see from a
+// doctor shown to patients.
+func hideReview(doctor *models.Doctor) {
+	doctor.Credentials = nil
+	doctor.Verification = models.Verification{Status: doctor.Verification.Status}
+}
+
+// doctorPage is the page metadata of a doctor listing.
+func doctorPage(q database.DoctorQuery, count int, total int64, next string) gin.H {
+	order := "asc"
+	if q.Descending {
+		order = "desc"
+	}
+	return gin.H{
+		"limit":       q.Limit,
+		"count":       count,
+		"total":       total,
+		"sort":        q.Sort,
+		"order":       order,
+		"has_more":    next != "",
+		"next_cursor": next,
+	}
+}
+
+// GetAllDoctors lists doctors a page at a time. It filters on ?speciality=,
+// ?language=, ?qualification=, ?service=, ?day=, ?min_rating=, ?max_fee=,
+// ?min_experience= and ?max_experience=, sorts on ?sort=rating|fee|experience
+// with ?order=asc|desc, and pages with ?limit= and the ?cursor= returned in
+// the previous page's metadata.
+func GetAllDoctors(c *gin.Context, db *mongo.Client) {
+	q, err := parseDoctorQuery(c)
+	if err != nil {
+		slog.Warn("GetAllDoctors failed: Invalid query", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	doctorCollection := database.GetCollection(db, "life-signal", "doctors")
+	doctors, next, err := database.FindDoctors(c, doctorCollection, q)
+	if err == database.ErrInvalidCursor {
+		slog.Warn("GetAllDoctors failed: Invalid cursor")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cursor is invalid or belongs to another sort order"})
+		return
+	}
+	if err != nil {
+		slog.Error("Error fetching doctors", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	total, err := database.CountDoctors(c, doctorCollection, q)
+	if err != nil {
+		slog.Error("Error counting doctors", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	for i := range doctors {
+		hideReview(&doctors[i])
+	}
+
+	page := doctorPage(q, len(doctors), total, next)
+	etag, err := helpers.ContentETag(gin.H{"doctors": doctors, "page": page})
+	if err != nil {
+		slog.Error("Error computing doctors ETag", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if notModified(c, etag) {
+		return
+	}
+	slog.Info("Doctors retrieved successfully", "count", len(doctors), "total", total)
+	c.JSON(http.StatusOK, gin.H{"doctors": doctors, "page": page})
+}
+
+const (
+	defaultSearchRadiusKm = 10
+	maxSearchRadiusKm     = 500
+)
+
+// SearchDoctorsNearby finds doctors whose clinic is within ?radius_km= of
+// ?lat= and ?lng=, nearest first, with the distance in metres. It accepts the
+// same filters and ?limit= as GetAllDoctors; results are always sorted by
+// distance, so there is no cursor.
+func SearchDoctorsNearby(c *gin.Context, db *mongo.Client) {
+	lat, err := optionalFloat(c, "lat", -90, 90)
+	if err == nil && lat == nil {
+		err = fmt.Errorf("lat is required")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	lng, err := optionalFloat(c, "lng", -180, 180)
+	if err == nil && lng == nil {
+		err = fmt.Errorf("lng is required")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	radius, err := optionalFloat(c, "radius_km", 0.001, maxSearchRadiusKm)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	radiusKm := float64(defaultSearchRadiusKm)
+	if radius != nil {
+		radiusKm = *radius
+	}
+	q, err := parseDoctorQuery(c)
+	if err == nil && (q.Sort != "" || q.Cursor != "") {
+		err = fmt.Errorf("nearby results are sorted by distance and cannot take sort or cursor")
+	}
+	if err != nil {
+		slog.Warn("SearchDoctorsNearby failed: Invalid query", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	doctorCollection := database.GetCollection(db, "life-signal", "doctors")
+	doctors, err := database.FindDoctorsNear(c, doctorCollection, q, geocode.Point(*lat, *lng), radiusKm*1000)
+	if err != nil {
+		slog.Error("SearchDoctorsNearby failed: Database query error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	for i := range doctors {
+		hideReview(&doctors[i].Doctor)
+	}
+	slog.Info("SearchDoctorsNearby successful", "count", len(doctors), "radiusKm", radiusKm)
+	c.JSON(http.StatusOK, gin.H{
+		"doctors": doctors,
+		"page":    gin.H{"limit": q.Limit, "count": len(doctors), "radius_km": radiusKm},
+	})
+}
+
+type doctorMatch struct {
+	database.ScoredDoctor
+	Highlights map[string]string `json:"highlights"`
+}
+
+// SearchDoctors runs a free-text search over doctor names, specialities,
+// services, profiles and clinic names. ?q= is corrected for typos and
+// expanded with synonyms before it reaches the text index, and each result
+// carries its score and the matching fields with the hits marked. Results are
+// ranked by relevance unless ?sort= asks otherwise; filters, ?limit= and
+// ?cursor= work as in GetAllDoctors.
+func SearchDoctors(c *gin.Context, db *mongo.Client) {
+	text := strings.TrimSpace(c.Query("q"))
+	if text == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+	if len(text) > 200 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q must be at most 200 characters"})
+		return
+	}
+	q, err := parseDoctorQuery(c)
+	if err != nil {
+		slog.Warn("SearchDoctors failed: Invalid query", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if q.Sort == "" {
+		q.Sort, q.Descending = database.RelevanceSort, true
+	}
+
+	doctorCollection := database.GetCollection(db, "life-signal", "doctors")
+	vocabulary, err := search.CachedVocabulary(c, func(ctx context.Context) ([]string, error) {
+		return database.DoctorVocabulary(ctx, doctorCollection)
+	})
+	if err != nil {
+		slog.Error("SearchDoctors failed: Error loading vocabulary", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	expansion := search.Current().Expand(text, vocabulary)
+	if len(expansion.Terms) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q must contain a word to search for"})
+		return
+	}
+	q.Text = expansion.Text()
+
+	doctors, next, err := database.SearchDoctors(c, doctorCollection, q)
+	if err == database.ErrInvalidCursor {
+		slog.Warn("SearchDoctors failed: Invalid cursor")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cursor is invalid or belongs to another search order"})
+		return
+	}
+	if err != nil {
+		slog.Error("SearchDoctors failed: Database query error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	total, err := database.CountDoctors(c, doctorCollection, q)
+	if err != nil {
+		slog.Error("SearchDoctors failed: Error counting doctors", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	matches := make([]doctorMatch, len(doctors))
+	for i, doctor := range doctors {
+		hideReview(&doctor.Doctor)
+		matches[i] = doctorMatch{ScoredDoctor: doctor, Highlights: search.Highlight(doctor.Doctor, expansion.Terms)}
+	}
+	slog.Info("SearchDoctors successful", "query", text, "count", len(doctors), "total", total)
+	c.JSON(http.StatusOK, gin.H{
+		"doctors": matches,
+		"query":   expansion,
+		"page":    doctorPage(q, len(doctors), total, next),
+	})
+}
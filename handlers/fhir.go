@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"life-signal/database"
+	"life-signal/dosage"
+	"life-signal/fhir"
+	"life-signal/helpers"
+	"life-signal/models"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const fhirContentType = "application/fhir+json"
+
+func ExportFHIRBundle(c *gin.Context, db *mongo.Client) {
+	userID := c.Param("userid")
+	if userID == "" {
+		slog.Warn("ExportFHIRBundle failed: Missing user ID")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID is required"})
+		return
+	}
+	userCollection := database.GetCollection(db, "life-signal", "users")
+	user, err := database.FindUser(c, userCollection, bson.M{"_id": userID})
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			slog.Warn("ExportFHIRBundle failed: User not found", "userID", userID)
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		} else {
+			slog.Error("ExportFHIRBundle failed: Database query error", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export record"})
+		}
+		return
+	}
+
+	historyCollection := database.GetCollection(db, "life-signal", "user-medical-history")
+	history, err := database.FindMedicalHistory(c, historyCollection, userID)
+	if err != nil {
+		slog.Error("ExportFHIRBundle failed: Error fetching medical history", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export record"})
+		return
+	}
+
+	doctors := map[string]models.Doctor{}
+	if history != nil && len(history.Appointments) > 0 {
+		doctorIDs := make([]string, 0, len(history.Appointments))
+		for _, appointment := range history.Appointments {
+			doctorIDs = append(doctorIDs, appointment.DoctorID)
+		}
+		doctorCollection := database.GetCollection(db, "life-signal", "doctors")
+		cursor, err := doctorCollection.Find(c, bson.M{"_id": bson.M{"$in": doctorIDs}})
+		if err != nil {
+			slog.Error("ExportFHIRBundle failed: Error fetching doctors", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export record"})
+			return
+		}
+		defer cursor.Close(c)
+		var found []models.Doctor
+		if err := cursor.All(c, &found); err != nil {
+			slog.Error("ExportFHIRBundle failed: Error decoding doctors", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export record"})
+			return
+		}
+		for _, doctor := range found {
+			doctors[doctor.ID] = doctor
+		}
+	}
+
+	vitals, err := database.FindVitalSigns(c, database.GetCollection(db, "life-signal", "vital-signs"), userID, "", 0)
+	if err != nil {
+		slog.Error("ExportFHIRBundle failed: Error fetching vital signs", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export record"})
+		return
+	}
+	labs, err := database.FindLabObservations(c, database.GetCollection(db, "life-signal", "lab-observations"), userID, "", 0)
+	if err != nil {
+		slog.Error("ExportFHIRBundle failed: Error fetching lab results", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export record"})
+		return
+	}
+
+	record := fhir.PatientRecord{User: *user, History: history, Doctors: doctors, VitalSigns: vitals, LabResults: labs}
+	bundle, err := fhir.ExportBundle(record, time.Now())
+	if err == nil {
+		err = fhir.ValidateBundle(bundle)
+	}
+	if err != nil {
+		slog.Error("ExportFHIRBundle failed: Error building bundle", "userID", userID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export record"})
+		return
+	}
+
+	slog.Info("ExportFHIRBundle successful", "userID", userID, "entries", len(bundle.Entry))
+	c.Header("Content-Type", fhirContentType)
+	c.JSON(http.StatusOK, bundle)
+}
+
+// ImportFHIRBundle merges an R4 Bundle into the user's medical history,
+// guarded by If-Match and the interaction check like every other history
+// write. With ?dry_run=true the merge is reported but not saved, and no
+// precondition is needed.
+func ImportFHIRBundle(c *gin.Context, db *mongo.Client) {
+	userID := c.Param("userid")
+	if userID == "" {
+		slog.Warn("ImportFHIRBundle failed: Missing user ID")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID is required"})
+		return
+	}
+	var bundle fhir.Bundle
+	if err := c.ShouldBindJSON(&bundle); err != nil {
+		slog.Error("ImportFHIRBundle failed: Invalid request body", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid FHIR Bundle"})
+		return
+	}
+	if bundle.ResourceType != "Bundle" {
+		slog.Warn("ImportFHIRBundle failed: Not a bundle", "resourceType", bundle.ResourceType)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "resourceType must be Bundle"})
+		return
+	}
+	dryRun := c.Query("dry_run") == "true"
+	var expectedVersion int64
+	var wildcard bool
+	if !dryRun {
+		var ok bool
+		if expectedVersion, wildcard, ok = requireIfMatch(c, "ImportFHIRBundle"); !ok {
+			return
+		}
+	}
+
+	historyCollection := database.GetCollection(db, "life-signal", "user-medical-history")
+	current, err := database.FindMedicalHistory(c, historyCollection, userID)
+	if err != nil {
+		slog.Error("ImportFHIRBundle failed: Error fetching medical history", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import bundle"})
+		return
+	}
+	if !dryRun && database.CheckVersion(current, expectedVersion, wildcard) == database.ErrVersionConflict {
+		slog.Warn("ImportFHIRBundle failed: Version conflict", "userID", userID, "ifMatch", c.GetHeader("If-Match"))
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Medical history was modified by another request"})
+		return
+	}
+	base := models.MedicalHistory{UserID: userID}
+	if current != nil {
+		base = *current
+	}
+	merged, report := fhir.ImportBundle(&bundle, base)
+	dosage.NormalizeHistory(&merged)
+	if err := helpers.ValidateMedicalHistory(merged, userID); err != nil {
+		slog.Warn("ImportFHIRBundle failed: Merged history is invalid", "userID", userID, "error", err)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "report": report})
+		return
+	}
+
+	warnings, ok := checkInteractions(c, "ImportFHIRBundle", current, &merged)
+	if !ok {
+		return
+	}
+
+	changed := fhir.EntryCount(merged) != fhir.EntryCount(base)
+	if changed && !dryRun {
+		err = database.WriteMedicalHistory(c, historyCollection, current, &merged)
+		if err == database.ErrVersionConflict {
+			slog.Warn("ImportFHIRBundle failed: Version conflict", "userID", userID, "ifMatch", c.GetHeader("If-Match"))
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Medical history was modified by another request"})
+			return
+		}
+		if err != nil {
+			slog.Error("ImportFHIRBundle failed: Database error", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import bundle"})
+			return
+		}
+		c.Header("ETag", helpers.VersionETag(merged.Version))
+	}
+
+	slog.Info("ImportFHIRBundle successful", "userID", userID, "dryRun", dryRun,
+		"mapped", len(report.Mapped), "skipped", len(report.Skipped), "unsupported", len(report.Unsupported))
+	c.JSON(http.StatusOK, gin.H{"report": report, "dry_run": dryRun, "version": merged.Version, "warnings": warnings})
+}
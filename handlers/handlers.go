@@ -3,8 +3,11 @@ package handlers
 import (
 	"fmt"
 	"life-signal/database"
+	"life-signal/dosage"
+	"life-signal/geocode"
 	"life-signal/helpers"
 	"life-signal/models"
+	"life-signal/terminology"
 	"log/slog"
 	"net/http"
 	"strings"
@@ -17,7 +20,6 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func Register(c *gin.Context, db *mongo.Client) {
@@ -28,17 +30,16 @@ func Register(c *gin.Context, db *mongo.Client) {
 		return
 	}
 	userCollection := database.GetCollection(db, "life-signal", "users")
-	var existingUser models.UserDetails
-	err := userCollection.FindOne(c, bson.M{
+	existingUser, err := database.FindUser(c, userCollection, bson.M{
 		"$or": []bson.M{
-			{"email": payload.Email},
-			{"phone": payload.Phone},
+			database.EmailFilter(payload.Email),
+			database.PhoneFilter(payload.Phone),
 			{"username": payload.Username},
 		},
-	}).Decode(&existingUser)
+	})
 
 	if err == nil {
-		if existingUser.Email == payload.Email {
+		if strings.EqualFold(existingUser.Email, payload.Email) {
 			c.JSON(http.StatusConflict, gin.H{"error": "A user with this email already exists"})
 			return
 		} else if existingUser.Phone == payload.Phone {
@@ -79,10 +80,16 @@ func Register(c *gin.Context, db *mongo.Client) {
 		FirstName:    payload.FirstName,
 		LastName:     payload.LastName,
 		PasswordHash: passwordHash,
+		Version:      1,
 		CreatedAt:    time.Now(),
 		UpdatedAt:    time.Now(),
 	}
-	_, err = userCollection.InsertOne(c, user)
+	err = database.InsertUser(c, userCollection, &user)
+	if mongo.IsDuplicateKeyError(err) {
+		slog.Warn("Registration failed: Concurrent registration with the same details", "username", payload.Username)
+		c.JSON(http.StatusConflict, gin.H{"error": "A user with these details already exists"})
+		return
+	}
 	if err != nil {
 		slog.Error("Registration failed: Error inserting user into database", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert user into database"})
@@ -105,8 +112,7 @@ func GetUserDetails(c *gin.Context, db *mongo.Client) {
 		return
 	}
 	userCollection := database.GetCollection(db, "life-signal", "users")
-	var user models.UserDetails
-	err := userCollection.FindOne(c, bson.M{"_id": userID}).Decode(&user)
+	user, err := database.FindUser(c, userCollection, bson.M{"_id": userID})
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			slog.Warn("GetUserDetails failed: User not found", "userID", userID)
@@ -118,6 +124,9 @@ func GetUserDetails(c *gin.Context, db *mongo.Client) {
 		return
 	}
 	user.PasswordHash = ""
+	if notModified(c, helpers.VersionETag(user.Version)) {
+		return
+	}
 	slog.Info("GetUserDetails successful", "userID", userID)
 	c.JSON(http.StatusOK, gin.H{"user": user})
 }
@@ -129,8 +138,7 @@ func Login(c *gin.Context, db *mongo.Client) {
 		return
 	}
 	userCollection := database.GetCollection(db, "life-signal", "users")
-	var user models.UserDetails
-	err := userCollection.FindOne(c, bson.M{"phone": login.PhoneNumber}).Decode(&user)
+	user, err := database.FindUser(c, userCollection, database.PhoneFilter(login.PhoneNumber))
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			slog.Warn("Login failed: No user found", "phone_number", login.PhoneNumber)
@@ -246,7 +254,6 @@ func GenerateRandomDoctor(c *gin.Context, db *mongo.Client) {
 		ClinicName:     randString(clinicNames),
 		ClinicAddress:  randString(clinicAddresses),
 		ProfilePicture: randString(profilePictures),
-		Rating:         float32(randFloat(3.5, 5.0)),
 		Experience:     randInt(1, 30),
 		Availability:   randSubset(availability, randInt(2, 5)),
 		Fee:            randFloat(50, 500),
@@ -261,7 +268,11 @@ func GenerateRandomDoctor(c *gin.Context, db *mongo.Client) {
 			Instagram: "",
 			Website:   "",
 		},
-		CreatedAt: time.Now(),
+		CreatedAt:    time.Now(),
+		Verification: models.Verification{Status: models.DoctorVerified},
+	}
+	if location, err := geocode.Current().Geocode(c, doctor.ClinicAddress); err == nil {
+		doctor.Location = &location
 	}
 
 	doctorCollection := database.GetCollection(db, "life-signal", "doctors")
@@ -286,6 +297,8 @@ func GenerateUserMedicalHistory(c *gin.Context, db *mongo.Client) {
 	conditions := []string{"Hypertension", "Diabetes", "Asthma", "Arthritis", "Migraine"}
 	severities := []string{"Mild", "Moderate", "Severe"}
 	medications := []string{"Paracetamol", "Ibuprofen", "Metformin", "Insulin", "Aspirin"}
+	routes := []string{"PO", "PO", "PO", "SC"}
+	frequencies := []string{"QD", "BID", "TID", "Q6H PRN", "QHS"}
 	doctors := []struct {
 		ID   string
 		Name string
@@ -311,21 +324,32 @@ func GenerateUserMedicalHistory(c *gin.Context, db *mongo.Client) {
 		endTime := randTime()
 		return &endTime
 	}
+	codeFor := func(text, system string) *models.Coding {
+		concept, ok := terminology.Current().Match(text, system)
+		if !ok {
+			return nil
+		}
+		return &models.Coding{System: concept.System, Code: concept.Code, Display: concept.Display}
+	}
 	issues := make([]models.Issue, randInt(1, 5))
 	for i := range issues {
+		condition := randString(conditions)
 		issues[i] = models.Issue{
-			Condition: randString(conditions),
-			Severity:  randString(severities),
-			Notes:     "Generated medical history notes.",
-			StartDate: randTime(),
-			EndDate:   randPtrTime(),
+			Condition:     condition,
+			ConditionCode: codeFor(condition, terminology.ICD10),
+			Severity:      randString(severities),
+			Notes:         "Generated medical history notes.",
+			StartDate:     randTime(),
+			EndDate:       randPtrTime(),
 		}
 	}
 	prescriptions := make([]models.Prescription, randInt(1, 5))
 	for i := range prescriptions {
+		medication := randString(medications)
 		prescriptions[i] = models.Prescription{
-			MedicationName: randString(medications),
-			Dosage:         fmt.Sprintf("%d mg", randInt(100, 500)),
+			MedicationName: medication,
+			MedicationCode: codeFor(medication, terminology.RxNorm),
+			Dosage:         fmt.Sprintf("%d mg %s %s x %d days", randInt(100, 500), randString(routes), randString(frequencies), randInt(3, 30)),
 			StartDate:      randTime(),
 			EndDate:        randPtrTime(),
 		}
@@ -340,17 +364,55 @@ func GenerateUserMedicalHistory(c *gin.Context, db *mongo.Client) {
 			Notes:           "Routine checkup.",
 		}
 	}
+	allergens := []struct {
+		Substance, Allergy, Category, Reaction string
+	}{
+		{"Penicillin", "Penicillin allergy", "medication", "Hives"},
+		{"Peanut", "Peanut allergy", "food", "Anaphylaxis"},
+		{"Shellfish", "Shellfish allergy", "food", "Swelling of the lips"},
+	}
+	allergies := make([]models.Allergy, randInt(0, 2))
+	for i := range allergies {
+		allergen := allergens[rand.Intn(len(allergens))]
+		allergies[i] = models.Allergy{
+			Substance: allergen.Substance,
+			Code:      codeFor(allergen.Allergy, terminology.SNOMED),
+			Category:  allergen.Category,
+			Reaction:  allergen.Reaction,
+			Severity:  randString(severities),
+		}
+	}
+	vaccines := []string{"Flu vaccine", "Tdap", "COVID-19 vaccine", "Hep B"}
+	immunizations := make([]models.Immunization, randInt(0, 3))
+	for i := range immunizations {
+		vaccine := randString(vaccines)
+		immunizations[i] = models.Immunization{
+			VaccineName:    vaccine,
+			VaccineCode:    codeFor(vaccine, terminology.CVX),
+			OccurrenceDate: randTime(),
+			DoseNumber:     1,
+		}
+	}
+	for i := range prescriptions {
+		dosage.Normalize(&prescriptions[i])
+	}
 	medicalHistory := models.MedicalHistory{
-		ID:            primitive.NewObjectID().Hex(),
 		UserID:        userID,
 		MedicalIssues: issues,
 		Prescriptions: prescriptions,
 		Appointments:  appointments,
-		CreatedAt:     time.Now(),
+		Allergies:     allergies,
+		Immunizations: immunizations,
 	}
 
 	historyCollection := database.GetCollection(db, "life-signal", "user-medical-history")
-	if _, err := historyCollection.InsertOne(c, medicalHistory); err != nil {
+	err := database.WriteMedicalHistory(c, historyCollection, nil, &medicalHistory)
+	if err == database.ErrVersionConflict {
+		slog.Warn("Medical history already exists", "userID", userID)
+		c.JSON(http.StatusConflict, gin.H{"error": "Medical history already exists"})
+		return
+	}
+	if err != nil {
 		slog.Error("Failed to add medical history", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add medical history"})
 		return
@@ -368,22 +430,55 @@ func GetUserMedicalHistory(c *gin.Context, db *mongo.Client) {
 	}
 
 	historyCollection := database.GetCollection(db, "life-signal", "user-medical-history")
-	var medicalHistory models.MedicalHistory
-
-	err := historyCollection.FindOne(c, bson.M{"user_id": userID}).Decode(&medicalHistory)
+	medicalHistory, err := database.FindMedicalHistory(c, historyCollection, userID)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			slog.Warn("No medical history found", "userID", userID)
-			c.JSON(http.StatusNotFound, gin.H{"error": "No medical history found"})
-		} else {
-			slog.Error("Error fetching medical history", "error", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		slog.Error("Error fetching medical history", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if medicalHistory == nil {
+		slog.Warn("No medical history found", "userID", userID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "No medical history found"})
+		return
+	}
+
+	// ?include=vitals,labs adds measurements that live outside the versioned
+	// history document, so such responses are never answered with 304.
+	include := map[string]bool{}
+	for _, name := range strings.Split(c.Query("include"), ",") {
+		include[strings.TrimSpace(name)] = true
+	}
+	if !include["vitals"] && !include["labs"] {
+		if notModified(c, helpers.VersionETag(medicalHistory.Version)) {
+			return
 		}
+		slog.Info("Medical history retrieved successfully", "userID", userID)
+		c.JSON(http.StatusOK, gin.H{"medical_history": medicalHistory})
 		return
 	}
 
-	slog.Info("Medical history retrieved successfully", "userID", userID)
-	c.JSON(http.StatusOK, gin.H{"medical_history": medicalHistory})
+	response := gin.H{"medical_history": medicalHistory}
+	if include["vitals"] {
+		vitals, err := database.LatestVitalSigns(c, database.GetCollection(db, "life-signal", "vital-signs"), userID)
+		if err != nil {
+			slog.Error("Error fetching vital signs", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+		response["latest_vital_signs"] = vitals
+	}
+	if include["labs"] {
+		labs, err := database.FindLabObservations(c, database.GetCollection(db, "life-signal", "lab-observations"), userID, "", defaultRecordLimit)
+		if err != nil {
+			slog.Error("Error fetching lab results", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+		response["recent_lab_results"] = labs
+	}
+	c.Header("ETag", helpers.VersionETag(medicalHistory.Version))
+	slog.Info("Medical history retrieved successfully", "userID", userID, "include", c.Query("include"))
+	c.JSON(http.StatusOK, response)
 }
 func SetUserMedicalHistory(c *gin.Context, db *mongo.Client) {
 	userID := c.Param("userid")
@@ -403,41 +498,49 @@ func SetUserMedicalHistory(c *gin.Context, db *mongo.Client) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "UserID in the payload does not match the route parameter"})
 		return
 	}
+	dosage.NormalizeHistory(&payload)
+	if err := helpers.ValidateMedicalHistory(payload, userID); err != nil {
+		slog.Warn("SetUserMedicalHistory failed: Invalid medical history", "error", err)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkAttachmentLinks(c, db, "SetUserMedicalHistory", &payload) {
+		return
+	}
+	expectedVersion, wildcard, ok := requireIfMatch(c, "SetUserMedicalHistory")
+	if !ok {
+		return
+	}
 	historyCollection := database.GetCollection(db, "life-signal", "user-medical-history")
-	payload.ID = uuid.New().String()
-	payload.CreatedAt = time.Now()
-	_, err := historyCollection.UpdateOne(
-		c,
-		bson.M{"user_id": userID},
-		bson.M{"$set": payload},
-		options.Update().SetUpsert(true),
-	)
+	current, err := database.FindMedicalHistory(c, historyCollection, userID)
 	if err != nil {
 		slog.Error("SetUserMedicalHistory failed: Database error", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save medical history"})
 		return
 	}
-
-	slog.Info("SetUserMedicalHistory successful", "userID", userID)
-	c.JSON(http.StatusOK, gin.H{"message": "Medical history saved successfully"})
-}
-func GetAllDoctors(c *gin.Context, db *mongo.Client) {
-	doctorCollection := database.GetCollection(db, "life-signal", "doctors")
-	cursor, err := doctorCollection.Find(c, bson.M{})
-	if err != nil {
-		slog.Error("Error fetching doctors", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	err = database.CheckVersion(current, expectedVersion, wildcard)
+	if err == database.ErrVersionConflict {
+		slog.Warn("SetUserMedicalHistory failed: Version conflict", "userID", userID, "ifMatch", c.GetHeader("If-Match"))
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Medical history was modified by another request"})
 		return
 	}
-	defer cursor.Close(c)
-
-	var doctors []models.Doctor
-	if err = cursor.All(c, &doctors); err != nil {
-		slog.Error("Error decoding doctors", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	warnings, ok := checkInteractions(c, "SetUserMedicalHistory", current, &payload)
+	if !ok {
+		return
+	}
+	err = database.WriteMedicalHistory(c, historyCollection, current, &payload)
+	if err == database.ErrVersionConflict {
+		slog.Warn("SetUserMedicalHistory failed: Version conflict", "userID", userID, "ifMatch", c.GetHeader("If-Match"))
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Medical history was modified by another request"})
+		return
+	}
+	if err != nil {
+		slog.Error("SetUserMedicalHistory failed: Database error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save medical history"})
 		return
 	}
 
-	slog.Info("Doctors retrieved successfully", "count", len(doctors))
-	c.JSON(http.StatusOK, gin.H{"doctors": doctors})
+	slog.Info("SetUserMedicalHistory successful", "userID", userID, "version", payload.Version)
+	c.Header("ETag", helpers.VersionETag(payload.Version))
+	c.JSON(http.StatusOK, gin.H{"message": "Medical history saved successfully", "version": payload.Version, "warnings": warnings})
 }
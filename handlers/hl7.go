@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"life-signal/database"
+	"life-signal/models"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func GetHL7DeadLetters(c *gin.Context, db *mongo.Client) {
+	collection := database.GetCollection(db, "life-signal", "hl7-dead-letters")
+	findOptions := options.Find().SetSort(bson.D{{Key: "received_at", Value: -1}}).SetLimit(100)
+	cursor, err := collection.Find(c, bson.M{}, findOptions)
+	if err != nil {
+		slog.Error("Error fetching HL7 dead letters", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	defer cursor.Close(c)
+
+	letters := []models.HL7DeadLetter{}
+	if err = cursor.All(c, &letters); err != nil {
+		slog.Error("Error decoding HL7 dead letters", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	var subjects []string
+	for _, letter := range letters {
+		if letter.UserID != "" && !slices.Contains(subjects, letter.UserID) {
+			subjects = append(subjects, letter.UserID)
+		}
+	}
+	recordAccess(c, db, "GetHL7DeadLetters", subjects)
+
+	slog.Info("HL7 dead letters retrieved successfully", "count", len(letters))
+	c.JSON(http.StatusOK, gin.H{"dead_letters": letters})
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"life-signal/database"
+	"life-signal/interactions"
+	"life-signal/models"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type interactionCheckRequest struct {
+	UserID        string                `json:"user_id"`
+	Prescriptions []models.Prescription `json:"prescriptions" binding:"required"`
+	Allergies     []models.Coding       `json:"allergies"`
+}
+
+// checkInteractions checks prescriptions added by a write against the
+// patient's other active prescriptions and allergies. It answers 409 itself
+// when a finding blocks the write, unless ?override_interactions=true, and
+// otherwise returns the warnings.
+func checkInteractions(c *gin.Context, handler string, current, next *models.MedicalHistory) ([]interactions.Finding, bool) {
+	dataset := interactions.Current()
+	findings := dataset.CheckWrite(current, next)
+	if !interactions.HasBlocking(findings) {
+		return findings, true
+	}
+	if c.Query("override_interactions") == "true" {
+		slog.Warn(handler+": Blocking interaction overridden", "userID", next.UserID, "by", c.GetString("userID"), "findings", len(findings))
+		return findings, true
+	}
+	slog.Warn(handler+" failed: Blocking interaction", "userID", next.UserID, "findings", len(findings))
+	c.JSON(http.StatusConflict, gin.H{
+		"error":           "Prescription conflicts with the patient's medications or allergies",
+		"interactions":    findings,
+		"dataset_version": dataset.Version,
+	})
+	return nil, false
+}
+
+// CheckInteractions lets clinicians check prescriptions before writing them.
+// With a user_id the patient's active prescriptions and recorded allergies are
+// included alongside any allergies given in the request.
+func CheckInteractions(c *gin.Context, db *mongo.Client) {
+	var request interactionCheckRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		slog.Warn("CheckInteractions failed: Invalid request body", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	dataset := interactions.Current()
+	allergies := request.Allergies
+	var active []models.Prescription
+	if request.UserID != "" {
+		historyCollection := database.GetCollection(db, "life-signal", "user-medical-history")
+		history, err := database.FindMedicalHistory(c, historyCollection, request.UserID)
+		if err != nil {
+			slog.Error("CheckInteractions failed: Database error", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch medical history"})
+			return
+		}
+		active = interactions.ActivePrescriptions(history)
+		allergies = append(allergies, dataset.Allergies(history)...)
+	}
+
+	findings := dataset.Check(request.Prescriptions, active, allergies)
+	slog.Info("CheckInteractions successful", "userID", request.UserID, "findings", len(findings))
+	c.JSON(http.StatusOK, gin.H{
+		"findings":        findings,
+		"blocking":        interactions.HasBlocking(findings),
+		"dataset_version": dataset.Version,
+	})
+}
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"life-signal/billing"
+	"life-signal/database"
+	"life-signal/models"
+	"life-signal/payments"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var discountCodePattern = regexp.MustCompile(`^[A-Z0-9_-]{3,32}$`)
+
+// maxIdempotencyKey leaves room within the provider's 255 character limit
+// for the invoice ID the key is scoped to.
+const maxIdempotencyKey = 200
+
+// issueInvoice issues the invoice of booking, leaving it to the job queue
+// when that fails so the booking itself still goes through.
+func issueInvoice(c *gin.Context, db *mongo.Client, handler string, booking models.Booking) *models.Invoice {
+	invoice, err := billing.Issue(c, db, &booking)
+	if err == nil {
+		return invoice
+	}
+	slog.Warn(handler+": Failed to issue invoice, queueing it", "appointmentID", booking.ID, "error", err)
+	if err := billing.QueueIssue(c, db, booking); err != nil {
+		slog.Error(handler+": Failed to queue invoice", "appointmentID", booking.ID, "error", err)
+	}
+	return nil
+}
+
+// settleCancellation queues voiding or refunding the invoice of a cancelled
+// booking.
+func settleCancellation(c *gin.Context, db *mongo.Client, handler string, booking models.Booking) {
+	if err := billing.QueueCancellation(c, db, booking); err != nil {
+		slog.Error(handler+": Failed to queue invoice settlement", "appointmentID", booking.ID, "error", err)
+	}
+}
+
+// findInvoice loads the invoice in the :id path parameter, restricted to
+// owner, answering 404 or 500 itself when it cannot.
+func findInvoice(c *gin.Context, db *mongo.Client, handler string, owner bson.M) (*models.Invoice, bool) {
+	filter := bson.M{"_id": c.Param("id")}
+	for key, value := range owner {
+		filter[key] = value
+	}
+	invoice, err := database.FindInvoice(c, database.GetCollection(db, "life-signal", "invoices"), filter)
+	if err != nil {
+		slog.Error(handler+" failed: Database query error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return nil, false
+	}
+	if invoice == nil {
+		slog.Warn(handler+" failed: Invoice not found", "invoiceID", c.Param("id"))
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
+		return nil, false
+	}
+	return invoice, true
+}
+
+// listInvoices answers the invoices matching filter, narrowed by ?status=.
+func listInvoices(c *gin.Context, db *mongo.Client, handler string, filter bson.M) {
+	if status := c.Query("status"); status != "" {
+		switch status {
+		case models.InvoiceOpen, models.InvoicePaid, models.InvoiceVoid, models.InvoicePartiallyRefunded, models.InvoiceRefunded:
+			filter["status"] = status
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+			return
+		}
+	}
+	invoices, err := database.FindInvoices(c, database.GetCollection(db, "life-signal", "invoices"), filter)
+	if err != nil {
+		slog.Error(handler+" failed: Database query error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	slog.Info(handler+" successful", "count", len(invoices))
+	c.JSON(http.StatusOK, gin.H{"invoices": invoices})
+}
+
+// GetMyInvoices lists the caller's invoices, newest first, filtered by
+// ?status=.
+func GetMyInvoices(c *gin.Context, db *mongo.Client) {
+	listInvoices(c, db, "GetMyInvoices", bson.M{"user_id": c.GetString("userID")})
+}
+
+func GetMyInvoice(c *gin.Context, db *mongo.Client) {
+	invoice, ok := findInvoice(c, db, "GetMyInvoice", bson.M{"user_id": c.GetString("userID")})
+	if !ok {
+		return
+	}
+	slog.Info("GetMyInvoice successful", "invoiceID", invoice.ID)
+	c.JSON(http.StatusOK, gin.H{"invoice": invoice})
+}
+
+// PayMyInvoice starts paying one of the caller's open invoices and returns
+// the client secret the patient's app completes the payment with. The
+// invoice is marked paid when the provider's webhook confirms it.
+func PayMyInvoice(c *gin.Context, db *mongo.Client) {
+	invoice, ok := findInvoice(c, db, "PayMyInvoice", bson.M{"user_id": c.GetString("userID")})
+	if !ok {
+		return
+	}
+	payment, err := billing.Pay(c, db, invoice)
+	if errors.Is(err, billing.ErrNotPayable) {
+		c.JSON(http.StatusConflict, gin.H{"error": "An invoice that is " + invoice.Status + " cannot be paid"})
+		return
+	}
+	if errors.Is(err, payments.ErrNotConfigured) {
+		slog.Warn("PayMyInvoice failed: No payment provider configured")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Online payment is not available"})
+		return
+	}
+	if err != nil {
+		slog.Error("PayMyInvoice failed: Payment provider error", "invoiceID", invoice.ID, "error", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to start payment"})
+		return
+	}
+	slog.Info("PayMyInvoice successful", "invoiceID", invoice.ID, "paymentID", payment.ID)
+	c.JSON(http.StatusOK, gin.H{"payment": gin.H{
+		"id":            payment.ID,
+		"provider":      payments.Current().Name(),
+		"status":        payment.Status,
+		"amount":        payment.Amount,
+		"currency":      payment.Currency,
+		"client_secret": payment.ClientSecret,
+	}})
+}
+
+// GetDoctorInvoices lists the invoices for the calling doctor's
+// appointments, filtered by ?status=.
+func GetDoctorInvoices(c *gin.Context, db *mongo.Client) {
+	listInvoices(c, db, "GetDoctorInvoices", bson.M{"doctor_id": c.GetString("doctorID")})
+}
+
+// RefundInvoice refunds part or all of what was paid on an invoice, outside
+// the cancellation policy. The client's Idempotency-Key header makes a
+// retried request refund once.
+func RefundInvoice(c *gin.Context, db *mongo.Client) {
+	idempotencyKey := strings.TrimSpace(c.GetHeader("Idempotency-Key"))
+	if idempotencyKey == "" || len(idempotencyKey) > maxIdempotencyKey {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("an Idempotency-Key header of at most %d characters is required", maxIdempotencyKey)})
+		return
+	}
+	var req models.RefundReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		slog.Warn("RefundInvoice failed: Invalid request body", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Amount < 0 || req.Reason == "" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "reason is required and amount cannot be negative"})
+		return
+	}
+	invoice, ok := findInvoice(c, db, "RefundInvoice", bson.M{})
+	if !ok {
+		return
+	}
+	key := "refund-" + invoice.ID + "-" + idempotencyKey
+	refunded, err := billing.Refund(c, db, invoice.ID, req.Amount, req.Reason, key)
+	switch {
+	case errors.Is(err, billing.ErrNothingToRefund):
+		c.JSON(http.StatusConflict, gin.H{"error": "Nothing is left to refund on this invoice"})
+		return
+	case errors.Is(err, billing.ErrRefundTooLarge):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "amount exceeds what is left to refund"})
+		return
+	case errors.Is(err, payments.ErrNotConfigured):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Online payment is not available"})
+		return
+	case err != nil:
+		slog.Error("RefundInvoice failed: Payment provider error", "invoiceID", invoice.ID, "error", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to refund invoice"})
+		return
+	}
+	slog.Info("RefundInvoice successful", "invoiceID", invoice.ID, "amount", req.Amount, "by", c.GetString("userID"))
+	c.JSON(http.StatusOK, gin.H{"invoice": refunded})
+}
+
+// CreateDiscount adds a discount code taking either a percentage or a fixed
+// amount, in the billing currency's minor unit, off invoices.
+func CreateDiscount(c *gin.Context, db *mongo.Client) {
+	var req models.DiscountReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		slog.Warn("CreateDiscount failed: Invalid request body", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	code := strings.ToUpper(strings.TrimSpace(req.Code))
+	if !discountCodePattern.MatchString(code) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "code must be 3 to 32 letters, digits, dashes or underscores"})
+		return
+	}
+	if (req.PercentOff > 0) == (req.AmountOff > 0) || req.PercentOff < 0 || req.PercentOff > 100 || req.AmountOff < 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Set either percent_off between 1 and 100 or a positive amount_off"})
+		return
+	}
+	if req.MaxRedemptions < 0 || (req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now())) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "max_redemptions cannot be negative and expires_at must be in the future"})
+		return
+	}
+	discount := models.Discount{
+		Code:           code,
+		Description:    strings.TrimSpace(req.Description),
+		PercentOff:     req.PercentOff,
+		AmountOff:      req.AmountOff,
+		ExpiresAt:      req.ExpiresAt,
+		MaxRedemptions: req.MaxRedemptions,
+		Active:         true,
+	}
+	if discount.AmountOff > 0 {
+		discount.Currency = billing.Current().Currency
+	}
+	err := database.InsertDiscount(c, database.GetCollection(db, "life-signal", "discounts"), &discount)
+	if err == database.ErrDiscountExists {
+		c.JSON(http.StatusConflict, gin.H{"error": "That discount code already exists"})
+		return
+	}
+	if err != nil {
+		slog.Error("CreateDiscount failed: Database insert error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create discount"})
+		return
+	}
+	slog.Info("CreateDiscount successful", "code", discount.Code, "by", c.GetString("userID"))
+	c.JSON(http.StatusCreated, gin.H{"discount": discount})
+}
+
+func GetDiscounts(c *gin.Context, db *mongo.Client) {
+	discounts, err := database.FindDiscounts(c, database.GetCollection(db, "life-signal", "discounts"))
+	if err != nil {
+		slog.Error("GetDiscounts failed: Database query error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	slog.Info("GetDiscounts successful", "count", len(discounts))
+	c.JSON(http.StatusOK, gin.H{"discounts": discounts})
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"life-signal/jobs"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const deadJobLimit = 100
+
+// GetDeadJobs lists the most recent background jobs that failed on every
+// attempt.
+func GetDeadJobs(c *gin.Context, db *mongo.Client) {
+	dead, err := jobs.FindDeadJobs(c, db, deadJobLimit)
+	if err != nil {
+		slog.Error("GetDeadJobs failed: Database query error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	slog.Info("GetDeadJobs successful", "count", len(dead))
+	c.JSON(http.StatusOK, gin.H{"jobs": dead})
+}
+
+// RetryDeadJob puts a dead job back in the queue to run now.
+func RetryDeadJob(c *gin.Context, db *mongo.Client) {
+	job, err := jobs.RetryDeadJob(c, db, c.Param("id"))
+	if err == jobs.ErrNotDead {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dead job not found"})
+		return
+	}
+	if err != nil {
+		slog.Error("RetryDeadJob failed: Database update error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry job"})
+		return
+	}
+	slog.Info("RetryDeadJob successful", "jobID", job.ID, "kind", job.Kind)
+	c.JSON(http.StatusOK, gin.H{"job": job})
+}
@@ -0,0 +1,457 @@
+package handlers
+
+import (
+	"fmt"
+	"life-signal/database"
+	"life-signal/geocode"
+	"life-signal/helpers"
+	"life-signal/models"
+	"life-signal/schedule"
+	"life-signal/storage"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// credentialKinds are the documents a doctor can upload for review.
+var credentialKinds = map[string]bool{
+	"licence":   true,
+	"degree":    true,
+	"identity":  true,
+	"insurance": true,
+	"other":     true,
+}
+
+// applyDoctorProfile copies the editable fields onto doctor and reports
+// whether anything a reviewer vouched for, the licence or qualifications,
+// changed. A new clinic address is geocoded again.
+func applyDoctorProfile(c *gin.Context, doctor *models.Doctor, profile models.DoctorProfileReq) bool {
+	credentialsChanged := doctor.LicenceNumber != strings.TrimSpace(profile.LicenceNumber) ||
+		!slices.Equal(doctor.Qualifications, profile.Qualifications)
+	if doctor.ClinicAddress != profile.ClinicAddress || doctor.Location == nil {
+		doctor.Location = nil
+		if location, err := geocode.Current().Geocode(c, profile.ClinicAddress); err == nil {
+			doctor.Location = &location
+		}
+	}
+	doctor.FirstName = strings.TrimSpace(profile.FirstName)
+	doctor.LastName = strings.TrimSpace(profile.LastName)
+	doctor.Speciality = strings.TrimSpace(profile.Speciality)
+	doctor.Phone = profile.Phone
+	doctor.Email = profile.Email
+	doctor.ClinicName = profile.ClinicName
+	doctor.ClinicAddress = profile.ClinicAddress
+	doctor.ProfilePicture = profile.ProfilePicture
+	doctor.Experience = profile.Experience
+	doctor.Fee = profile.Fee
+	doctor.Availability = profile.Availability
+	doctor.Languages = profile.Languages
+	doctor.Qualifications = profile.Qualifications
+	doctor.Services = profile.Services
+	doctor.About = profile.About
+	doctor.SocialLinks = profile.SocialLinks
+	doctor.LicenceNumber = strings.TrimSpace(profile.LicenceNumber)
+	return credentialsChanged
+}
+
+// findDoctor loads a doctor by ID and answers 404 or 500 itself when it
+// cannot.
+func findDoctor(c *gin.Context, db *mongo.Client, handler, doctorID string) (*models.Doctor, bool) {
+	var doctor models.Doctor
+	err := database.GetCollection(db, "life-signal", "doctors").FindOne(c, bson.M{"_id": doctorID}).Decode(&doctor)
+	if err == mongo.ErrNoDocuments {
+		slog.Warn(handler+" failed: Doctor not found", "doctorID", doctorID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Doctor not found"})
+		return nil, false
+	}
+	if err != nil {
+		slog.Error(handler+" failed: Database query error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return nil, false
+	}
+	return &doctor, true
+}
+
+// doctorProfileUpdate sets the fields applyDoctorProfile edits, leaving the
+// rating, reviews and credentials to the writes that own them.
+func doctorProfileUpdate(doctor *models.Doctor) bson.M {
+	set := bson.M{
+		"first_name":      doctor.FirstName,
+		"last_name":       doctor.LastName,
+		"speciality":      doctor.Speciality,
+		"phone":           doctor.Phone,
+		"email":           doctor.Email,
+		"clinic_name":     doctor.ClinicName,
+		"clinic_address":  doctor.ClinicAddress,
+		"profile_picture": doctor.ProfilePicture,
+		"experience":      doctor.Experience,
+		"fee":             doctor.Fee,
+		"availability":    doctor.Availability,
+		"languages":       doctor.Languages,
+		"qualifications":  doctor.Qualifications,
+		"services":        doctor.Services,
+		"about":           doctor.About,
+		"social_links":    doctor.SocialLinks,
+		"licence_number":  doctor.LicenceNumber,
+		"verification":    doctor.Verification,
+	}
+	update := bson.M{"$set": set}
+	if doctor.Location != nil {
+		set["location"] = doctor.Location
+	} else {
+		update["$unset"] = bson.M{"location": ""}
+	}
+	return update
+}
+
+// saveDoctor applies update to doctor as long as its verification status is
+// still expected, so a doctor's edit and an admin's review cannot overwrite
+// each other, and reloads doctor from the result. It answers 409 or 500
+// itself when the write fails.
+func saveDoctor(c *gin.Context, db *mongo.Client, handler string, doctor *models.Doctor, expected string, update bson.M) bool {
+	collection := database.GetCollection(db, "life-signal", "doctors")
+	err := collection.FindOneAndUpdate(c,
+		bson.M{"_id": doctor.ID, "verification.status": expected},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(doctor)
+	if err == mongo.ErrNoDocuments {
+		slog.Warn(handler+" failed: Verification status changed", "doctorID", doctor.ID)
+		c.JSON(http.StatusConflict, gin.H{"error": "The profile was reviewed or changed in the meantime, reload it and try again"})
+		return false
+	}
+	if err != nil {
+		slog.Error(handler+" failed: Database update error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save doctor profile"})
+		return false
+	}
+	return true
+}
+
+// StartDoctorOnboarding turns the caller into a doctor with a draft profile.
+// The profile stays hidden from patients until an admin has verified it.
+func StartDoctorOnboarding(c *gin.Context, db *mongo.Client) {
+	userID := c.GetString("userID")
+	var profile models.DoctorProfileReq
+	if err := c.ShouldBindJSON(&profile); err != nil {
+		slog.Warn("StartDoctorOnboarding failed: Invalid request body", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	now := time.Now()
+	doctor := models.Doctor{
+		ID:           uuid.New().String(),
+		UserID:       userID,
+		CreatedAt:    now,
+		Verification: models.Verification{Status: models.DoctorDraft},
+	}
+	applyDoctorProfile(c, &doctor, profile)
+	if err := helpers.ValidateDoctor(doctor); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Claiming the role first means two concurrent requests cannot both
+	// create a profile for the same user.
+	userCollection := database.GetCollection(db, "life-signal", "users")
+	result, err := userCollection.UpdateOne(c,
+		bson.M{"_id": userID, "doctor_id": bson.M{"$exists": false}},
+		bson.M{
+			"$set": bson.M{"role": models.RoleDoctor, "doctor_id": doctor.ID, "updated_at": now},
+			"$inc": bson.M{"version": 1},
+		})
+	if err != nil {
+		slog.Error("StartDoctorOnboarding failed: Database update error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start onboarding"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		if _, err := database.FindUser(c, userCollection, bson.M{"_id": userID}); err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		slog.Warn("StartDoctorOnboarding failed: Already a doctor", "userID", userID)
+		c.JSON(http.StatusConflict, gin.H{"error": "This account already has a doctor profile"})
+		return
+	}
+	doctorCollection := database.GetCollection(db, "life-signal", "doctors")
+	if _, err := doctorCollection.InsertOne(c, doctor); err != nil {
+		slog.Error("StartDoctorOnboarding failed: Error saving doctor", "error", err)
+		userCollection.UpdateOne(c, bson.M{"_id": userID, "doctor_id": doctor.ID}, bson.M{
+			"$unset": bson.M{"role": "", "doctor_id": ""},
+			"$inc":   bson.M{"version": 1},
+		})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start onboarding"})
+		return
+	}
+	slog.Info("StartDoctorOnboarding successful", "userID", userID, "doctorID", doctor.ID)
+	c.JSON(http.StatusCreated, gin.H{"doctor": doctor})
+}
+
+func GetMyDoctorProfile(c *gin.Context, db *mongo.Client) {
+	doctor, ok := findDoctor(c, db, "GetMyDoctorProfile", c.GetString("doctorID"))
+	if !ok {
+		return
+	}
+	slog.Info("GetMyDoctorProfile successful", "doctorID", doctor.ID)
+	c.JSON(http.StatusOK, gin.H{"doctor": doctor})
+}
+
+// UpdateMyDoctorProfile replaces the caller's editable profile. A profile
+// waiting for review or rejected cannot be edited. Changing the licence or
+// qualifications of a verified profile sends it back for review, hiding it
+// from patients until approved again.
+func UpdateMyDoctorProfile(c *gin.Context, db *mongo.Client) {
+	var profile models.DoctorProfileReq
+	if err := c.ShouldBindJSON(&profile); err != nil {
+		slog.Warn("UpdateMyDoctorProfile failed: Invalid request body", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	doctor, ok := findDoctor(c, db, "UpdateMyDoctorProfile", c.GetString("doctorID"))
+	if !ok {
+		return
+	}
+	status := doctor.Verification.Status
+	if status == models.DoctorPending || status == models.DoctorRejected {
+		c.JSON(http.StatusConflict, gin.H{"error": "A profile that is " + status + " cannot be edited"})
+		return
+	}
+	credentialsChanged := applyDoctorProfile(c, doctor, profile)
+	// Once published, the schedule decides which days are listed.
+	s, err := database.FindSchedule(c, database.GetCollection(db, "life-signal", "doctor-schedules"), doctor.ID)
+	if err != nil {
+		slog.Error("UpdateMyDoctorProfile failed: Database query error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if s != nil {
+		if plan, err := schedule.Compile(*s); err == nil {
+			doctor.Availability = plan.Days()
+		}
+	}
+	if credentialsChanged && status == models.DoctorVerified {
+		now := time.Now()
+		doctor.Verification = models.Verification{Status: models.DoctorPending, SubmittedAt: &now}
+	}
+	if err := helpers.ValidateDoctor(*doctor); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if !saveDoctor(c, db, "UpdateMyDoctorProfile", doctor, status, doctorProfileUpdate(doctor)) {
+		return
+	}
+	slog.Info("UpdateMyDoctorProfile successful", "doctorID", doctor.ID, "status", doctor.Verification.Status)
+	c.JSON(http.StatusOK, gin.H{"doctor": doctor})
+}
+
+// UploadDoctorCredential stores a multipart "file" with a "kind" such as
+// licence or degree and lists it on the caller's profile for reviewers.
+func UploadDoctorCredential(c *gin.Context, db *mongo.Client) {
+	header, data, contentType, scanned, ok := receiveUpload(c, "UploadDoctorCredential")
+	if !ok {
+		return
+	}
+	kind := c.PostForm("kind")
+	if !credentialKinds[kind] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be licence, degree, identity, insurance or other"})
+		return
+	}
+	doctor, ok := findDoctor(c, db, "UploadDoctorCredential", c.GetString("doctorID"))
+	if !ok {
+		return
+	}
+	status := doctor.Verification.Status
+	if status == models.DoctorPending || status == models.DoctorRejected {
+		c.JSON(http.StatusConflict, gin.H{"error": "Documents cannot be added to a profile that is " + status})
+		return
+	}
+
+	attachment, err := storeAttachment(c, db, doctor.UserID, "Doctor credential: "+kind, header, data, contentType, scanned)
+	if err != nil {
+		slog.Error("UploadDoctorCredential failed: Error storing attachment", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store document"})
+		return
+	}
+	credential := models.Credential{AttachmentID: attachment.ID, Kind: kind, FileName: attachment.FileName, UploadedAt: attachment.UploadedAt}
+	collection := database.GetCollection(db, "life-signal", "doctors")
+	result, err := collection.UpdateOne(c,
+		bson.M{"_id": doctor.ID, "verification.status": status},
+		bson.M{"$push": bson.M{"credentials": credential}})
+	if err == nil && result.MatchedCount == 0 {
+		err = mongo.ErrNoDocuments
+	}
+	if err != nil {
+		slog.Error("UploadDoctorCredential failed: Error linking document", "error", err)
+		database.GetCollection(db, "life-signal", "attachments").DeleteOne(c, bson.M{"_id": attachment.ID})
+		storage.Current().Delete(c, attachment.StorageKey)
+		c.JSON(http.StatusConflict, gin.H{"error": "The profile was reviewed or changed in the meantime, reload it and try again"})
+		return
+	}
+	slog.Info("UploadDoctorCredential successful", "doctorID", doctor.ID, "attachmentID", attachment.ID, "kind", kind)
+	c.JSON(http.StatusCreated, gin.H{"credential": credential, "download_url": attachmentDownloadURL(attachment)})
+}
+
+// SubmitDoctorProfile sends a draft, or a profile sent back for changes, to
+// the admin review queue. It needs a licence number, a qualification and at
+// least one uploaded document.
+func SubmitDoctorProfile(c *gin.Context, db *mongo.Client) {
+	doctor, ok := findDoctor(c, db, "SubmitDoctorProfile", c.GetString("doctorID"))
+	if !ok {
+		return
+	}
+	status := doctor.Verification.Status
+	if status != models.DoctorDraft && status != models.DoctorChangesRequested {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only a draft or a profile with requested changes can be submitted, this one is " + status})
+		return
+	}
+	missing := []string{}
+	if doctor.LicenceNumber == "" {
+		missing = append(missing, "licence_number")
+	}
+	if len(doctor.Qualifications) == 0 {
+		missing = append(missing, "qualifications")
+	}
+	if len(doctor.Credentials) == 0 {
+		missing = append(missing, "credentials")
+	}
+	if len(missing) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "The profile is incomplete", "missing": missing})
+		return
+	}
+	now := time.Now()
+	doctor.Verification.Status = models.DoctorPending
+	doctor.Verification.SubmittedAt = &now
+	if !saveDoctor(c, db, "SubmitDoctorProfile", doctor, status, bson.M{"$set": bson.M{"verification": doctor.Verification}}) {
+		return
+	}
+	slog.Info("SubmitDoctorProfile successful", "doctorID", doctor.ID)
+	c.JSON(http.StatusOK, gin.H{"doctor": doctor})
+}
+
+// reviewDecisions maps what an admin can decide to the resulting status.
+var reviewDecisions = map[string]string{
+	"approve":         models.DoctorVerified,
+	"reject":          models.DoctorRejected,
+	"request-changes": models.DoctorChangesRequested,
+}
+
+// ListDoctorReviews pages through doctors in one verification state, pending
+// by default, as in GetAllDoctors.
+func ListDoctorReviews(c *gin.Context, db *mongo.Client) {
+	status := c.DefaultQuery("status", models.DoctorPending)
+	switch status {
+	case models.DoctorDraft, models.DoctorPending, models.DoctorChangesRequested, models.DoctorVerified, models.DoctorRejected:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be draft, pending, changes-requested, verified or rejected"})
+		return
+	}
+	q := database.DoctorQuery{Status: status, Cursor: c.Query("cursor"), Limit: defaultDoctorLimit}
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || limit < 1 || limit > maxDoctorLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxDoctorLimit)})
+			return
+		}
+		q.Limit = limit
+	}
+	doctorCollection := database.GetCollection(db, "life-signal", "doctors")
+	doctors, next, err := database.FindDoctors(c, doctorCollection, q)
+	if err == database.ErrInvalidCursor {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cursor is invalid"})
+		return
+	}
+	if err != nil {
+		slog.Error("ListDoctorReviews failed: Database query error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	total, err := database.CountDoctors(c, doctorCollection, q)
+	if err != nil {
+		slog.Error("ListDoctorReviews failed: Error counting doctors", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	slog.Info("ListDoctorReviews successful", "status", status, "count", len(doctors))
+	c.JSON(http.StatusOK, gin.H{"doctors": doctors, "page": doctorPage(q, len(doctors), total, next)})
+}
+
+// GetDoctorReview returns a doctor with short-lived download links for the
+// documents they uploaded.
+func GetDoctorReview(c *gin.Context, db *mongo.Client) {
+	doctor, ok := findDoctor(c, db, "GetDoctorReview", c.Param("id"))
+	if !ok {
+		return
+	}
+	ids := make([]string, len(doctor.Credentials))
+	for i, credential := range doctor.Credentials {
+		ids[i] = credential.AttachmentID
+	}
+	var attachments []models.Attachment
+	cursor, err := database.GetCollection(db, "life-signal", "attachments").Find(c, bson.M{"_id": bson.M{"$in": ids}})
+	if err == nil {
+		err = cursor.All(c, &attachments)
+	}
+	if err != nil {
+		slog.Error("GetDoctorReview failed: Error fetching documents", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	downloads := map[string]string{}
+	for _, attachment := range attachments {
+		downloads[attachment.ID] = attachmentDownloadURL(attachment)
+	}
+	slog.Info("GetDoctorReview successful", "doctorID", doctor.ID)
+	c.JSON(http.StatusOK, gin.H{"doctor": doctor, "download_urls": downloads})
+}
+
+type doctorReviewRequest struct {
+	Decision string `json:"decision"`
+	Notes    string `json:"notes"`
+}
+
+// ReviewDoctor records an admin's decision on a pending profile. Rejecting
+// or requesting changes needs notes telling the doctor why.
+func ReviewDoctor(c *gin.Context, db *mongo.Client) {
+	var request doctorReviewRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	status, ok := reviewDecisions[request.Decision]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "decision must be approve, reject or request-changes"})
+		return
+	}
+	request.Notes = strings.TrimSpace(request.Notes)
+	if status != models.DoctorVerified && request.Notes == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "notes are required when rejecting or requesting changes"})
+		return
+	}
+	doctor, ok := findDoctor(c, db, "ReviewDoctor", c.Param("id"))
+	if !ok {
+		return
+	}
+	if doctor.Verification.Status != models.DoctorPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only pending profiles can be reviewed, this one is " + doctor.Verification.Status})
+		return
+	}
+	now := time.Now()
+	doctor.Verification.Status = status
+	doctor.Verification.ReviewedAt = &now
+	doctor.Verification.ReviewedBy = c.GetString("userID")
+	doctor.Verification.Notes = request.Notes
+	if !saveDoctor(c, db, "ReviewDoctor", doctor, models.DoctorPending, bson.M{"$set": bson.M{"verification": doctor.Verification}}) {
+		return
+	}
+	slog.Info("ReviewDoctor successful", "doctorID", doctor.ID, "decision", request.Decision)
+	c.JSON(http.StatusOK, gin.H{"doctor": doctor})
+}
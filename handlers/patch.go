@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"life-signal/database"
+	"life-signal/dosage"
+	"life-signal/helpers"
+	"life-signal/models"
+	"log/slog"
+	"mime"
+	"net/http"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	jsonPatchContentType  = "application/json-patch+json"
+	mergePatchContentType = "application/merge-patch+json"
+)
+
+// PatchUserMedicalHistory applies an RFC 6902 JSON Patch or an RFC 7396 JSON
+// Merge Patch to a user's medical history, guarded by If-Match.
+func PatchUserMedicalHistory(c *gin.Context, db *mongo.Client) {
+	userID := c.Param("userid")
+	if userID == "" {
+		slog.Error("PatchUserMedicalHistory failed: UserID is required")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "UserID is required"})
+		return
+	}
+	contentType, _, err := mime.ParseMediaType(c.ContentType())
+	if err != nil || (contentType != jsonPatchContentType && contentType != mergePatchContentType) {
+		slog.Warn("PatchUserMedicalHistory failed: Unsupported content type", "contentType", c.ContentType())
+		c.Header("Accept-Patch", jsonPatchContentType+", "+mergePatchContentType)
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be " + jsonPatchContentType + " or " + mergePatchContentType})
+		return
+	}
+	patchBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		slog.Error("PatchUserMedicalHistory failed: Invalid request body", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	expectedVersion, wildcard, ok := requireIfMatch(c, "PatchUserMedicalHistory")
+	if !ok {
+		return
+	}
+
+	historyCollection := database.GetCollection(db, "life-signal", "user-medical-history")
+	current, err := database.FindMedicalHistory(c, historyCollection, userID)
+	if err != nil {
+		slog.Error("PatchUserMedicalHistory failed: Database error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save medical history"})
+		return
+	}
+	if current == nil {
+		slog.Warn("PatchUserMedicalHistory failed: No medical history found", "userID", userID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "No medical history found"})
+		return
+	}
+	if err := database.CheckVersion(current, expectedVersion, wildcard); err != nil {
+		slog.Warn("PatchUserMedicalHistory failed: Version conflict", "userID", userID, "ifMatch", c.GetHeader("If-Match"))
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Medical history was modified by another request"})
+		return
+	}
+
+	original, err := json.Marshal(current)
+	if err != nil {
+		slog.Error("PatchUserMedicalHistory failed: Error encoding medical history", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save medical history"})
+		return
+	}
+	var patched []byte
+	if contentType == jsonPatchContentType {
+		patch, decodeErr := jsonpatch.DecodePatch(patchBody)
+		if decodeErr != nil {
+			slog.Warn("PatchUserMedicalHistory failed: Invalid JSON Patch", "error", decodeErr)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON Patch document"})
+			return
+		}
+		patched, err = patch.Apply(original)
+	} else {
+		patched, err = jsonpatch.MergePatch(original, patchBody)
+	}
+	if err != nil {
+		slog.Warn("PatchUserMedicalHistory failed: Patch could not be applied", "error", err)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Patch could not be applied", "details": err.Error()})
+		return
+	}
+
+	var next models.MedicalHistory
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&next); err != nil {
+		slog.Warn("PatchUserMedicalHistory failed: Patched document is invalid", "error", err)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Patched medical history is invalid", "details": err.Error()})
+		return
+	}
+	dosage.NormalizeHistory(&next)
+	if err := helpers.ValidateMedicalHistory(next, userID); err != nil {
+		slog.Warn("PatchUserMedicalHistory failed: Invalid medical history", "error", err)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !checkAttachmentLinks(c, db, "PatchUserMedicalHistory", &next) {
+		return
+	}
+	warnings, ok := checkInteractions(c, "PatchUserMedicalHistory", current, &next)
+	if !ok {
+		return
+	}
+	err = database.WriteMedicalHistory(c, historyCollection, current, &next)
+	if err == database.ErrVersionConflict {
+		slog.Warn("PatchUserMedicalHistory failed: Version conflict", "userID", userID)
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Medical history was modified by another request"})
+		return
+	}
+	if err != nil {
+		slog.Error("PatchUserMedicalHistory failed: Database error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save medical history"})
+		return
+	}
+
+	slog.Info("PatchUserMedicalHistory successful", "userID", userID, "version", next.Version)
+	c.Header("ETag", helpers.VersionETag(next.Version))
+	c.JSON(http.StatusOK, gin.H{"medical_history": next, "warnings": warnings})
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"life-signal/billing"
+	"life-signal/database"
+	"life-signal/models"
+	"life-signal/payments"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const maxWebhookBody = 1 << 20
+
+// PaymentWebhook receives events from the payment provider. The signature is
+// checked against the raw body before anything is read from it, and each
+// event id is applied once: a redelivered event is acknowledged without
+// being applied again, while one whose processing failed is answered 500 so
+// the provider retries it.
+func PaymentWebhook(c *gin.Context, db *mongo.Client) {
+	payload, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookBody))
+	if err != nil {
+		slog.Warn("PaymentWebhook failed: Unreadable body", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	provider := payments.Current()
+	event, err := provider.ParseWebhook(payload, c.Request.Header)
+	if errors.Is(err, payments.ErrNotConfigured) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Online payment is not available"})
+		return
+	}
+	if err != nil {
+		slog.Warn("PaymentWebhook failed: Invalid event", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook"})
+		return
+	}
+
+	events := database.GetCollection(db, "life-signal", "payment-events")
+	id := provider.Name() + ":" + event.ID
+	processed, err := database.ClaimPaymentEvent(c, events, models.PaymentEvent{ID: id, Provider: provider.Name(), Type: event.Type})
+	if err != nil {
+		slog.Error("PaymentWebhook failed: Database insert error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if processed {
+		slog.Info("PaymentWebhook successful: Duplicate event", "eventID", event.ID)
+		c.JSON(http.StatusOK, gin.H{"received": true})
+		return
+	}
+	if err := billing.HandleEvent(c, db, event); err != nil {
+		slog.Error("PaymentWebhook failed: Applying event", "eventID", event.ID, "type", event.Type, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process event"})
+		return
+	}
+	if err := database.MarkPaymentEventProcessed(c, events, id); err != nil {
+		slog.Warn("PaymentWebhook: Failed to mark event processed", "eventID", event.ID, "error", err)
+	}
+	slog.Info("PaymentWebhook successful", "eventID", event.ID, "type", event.Type)
+	c.JSON(http.StatusOK, gin.H{"received": true})
+}
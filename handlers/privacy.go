@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"life-signal/database"
+	"life-signal/models"
+	"life-signal/privacy"
+	"log/slog"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ExportMyData streams a zip of everything stored about the caller. Errors
+// after the first byte has been sent can only be logged, leaving the client
+// with a truncated archive that fails to open.
+func ExportMyData(c *gin.Context, db *mongo.Client) {
+	userID := c.GetString("userID")
+	userCollection := database.GetCollection(db, "life-signal", "users")
+	if _, err := database.FindUser(c, userCollection, bson.M{"_id": userID}); err != nil {
+		if err == mongo.ErrNoDocuments {
+			slog.Warn("ExportMyData failed: User not found", "userID", userID)
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		} else {
+			slog.Error("ExportMyData failed: Database query error", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export data"})
+		}
+		return
+	}
+
+	filename := "life-signal-export-" + time.Now().UTC().Format("2006-01-02") + ".zip"
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Header("Cache-Control", "private, no-store")
+	c.Status(http.StatusOK)
+	if err := privacy.Export(c, db, userID, c.Writer); err != nil {
+		slog.Error("ExportMyData failed: Export aborted", "userID", userID, "error", err)
+		return
+	}
+	slog.Info("ExportMyData successful", "userID", userID)
+}
+
+// RequestErasure schedules the caller's account for erasure once the grace
+// period has passed. Asking again while a request is pending changes nothing.
+func RequestErasure(c *gin.Context, db *mongo.Client) {
+	userID := c.GetString("userID")
+	userCollection := database.GetCollection(db, "life-signal", "users")
+	now := time.Now().UTC()
+	due := now.Add(privacy.GracePeriod())
+	result, err := userCollection.UpdateOne(c,
+		bson.M{"_id": userID, "erasure_due_at": bson.M{"$exists": false}},
+		bson.M{
+			"$set": bson.M{"erasure_requested_at": now, "erasure_due_at": due, "updated_at": now},
+			"$inc": bson.M{"version": 1},
+		})
+	if err != nil {
+		slog.Error("RequestErasure failed: Database update error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request erasure"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		user, err := database.FindUser(c, userCollection, bson.M{"_id": userID})
+		if err == mongo.ErrNoDocuments {
+			slog.Warn("RequestErasure failed: User not found", "userID", userID)
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if err != nil || user.ErasureDueAt == nil {
+			slog.Error("RequestErasure failed: Database query error", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request erasure"})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"message": "Erasure already requested", "erasure_due_at": user.ErasureDueAt})
+		return
+	}
+
+	auditLog := database.GetCollection(db, "life-signal", "audit-log")
+	entry := models.AuditEntry{Type: models.AuditErasureRequested, ActorID: userID, SubjectID: userID, At: now}
+	if err := database.RecordAudit(c, auditLog, entry); err != nil {
+		slog.Warn("RequestErasure: Could not record request", "userID", userID, "error", err)
+	}
+	slog.Info("RequestErasure successful", "userID", userID, "dueAt", due)
+	c.JSON(http.StatusAccepted, gin.H{"message": "Erasure scheduled", "erasure_due_at": due})
+}
+
+// CancelErasure withdraws a pending erasure request.
+func CancelErasure(c *gin.Context, db *mongo.Client) {
+	userID := c.GetString("userID")
+	userCollection := database.GetCollection(db, "life-signal", "users")
+	result, err := userCollection.UpdateOne(c,
+		bson.M{"_id": userID, "erasure_due_at": bson.M{"$exists": true}},
+		bson.M{
+			"$unset": bson.M{"erasure_requested_at": "", "erasure_due_at": ""},
+			"$set":   bson.M{"updated_at": time.Now().UTC()},
+			"$inc":   bson.M{"version": 1},
+		})
+	if err != nil {
+		slog.Error("CancelErasure failed: Database update error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel erasure"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		slog.Warn("CancelErasure failed: No pending erasure", "userID", userID)
+		c.JSON(http.StatusConflict, gin.H{"error": "No erasure is pending"})
+		return
+	}
+
+	auditLog := database.GetCollection(db, "life-signal", "audit-log")
+	entry := models.AuditEntry{Type: models.AuditErasureCancelled, ActorID: userID, SubjectID: userID}
+	if err := database.RecordAudit(c, auditLog, entry); err != nil {
+		slog.Warn("CancelErasure: Could not record cancellation", "userID", userID, "error", err)
+	}
+	slog.Info("CancelErasure successful", "userID", userID)
+	c.JSON(http.StatusOK, gin.H{"message": "Erasure cancelled"})
+}
+
+const auditBatchSize = 500
+
+// recordAccess audits a request that read the records of several patients,
+// one entry per patient.
+func recordAccess(c *gin.Context, db *mongo.Client, handler string, subjects []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	auditLog := database.GetCollection(db, "life-signal", "audit-log")
+	entry := models.AuditEntry{
+		Type:    models.AuditAccess,
+		ActorID: c.GetString("userID"),
+		Method:  c.Request.Method,
+		Path:    c.Request.URL.Path,
+		Status:  c.Writer.Status(),
+		IP:      c.ClientIP(),
+	}
+	if err := database.RecordAudits(ctx, auditLog, entry, subjects); err != nil {
+		slog.Warn(handler+": Could not record access", "subjects", len(subjects), "error", err)
+	}
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"life-signal/database"
+	"life-signal/helpers"
+	"life-signal/models"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultRecordLimit = 50
+	maxRecordLimit     = 500
+)
+
+// recordLimit reads ?limit= for the vitals and lab result listings. It
+// answers the request itself and returns ok=false when the value is invalid.
+func recordLimit(c *gin.Context) (int64, bool) {
+	raw := c.Query("limit")
+	if raw == "" {
+		return defaultRecordLimit, true
+	}
+	limit, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || limit < 1 || limit > maxRecordLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+		return 0, false
+	}
+	return limit, true
+}
+
+func CreateVitalSign(c *gin.Context, db *mongo.Client) {
+	userID := c.Param("userid")
+	var vital models.VitalSign
+	if err := c.ShouldBindJSON(&vital); err != nil {
+		slog.Warn("CreateVitalSign failed: Invalid request body", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if err := helpers.ValidateVitalSign(&vital); err != nil {
+		slog.Warn("CreateVitalSign failed: Invalid vital sign", "error", err)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	vital.ID = uuid.New().String()
+	vital.UserID = userID
+	vital.CreatedAt = time.Now()
+
+	collection := database.GetCollection(db, "life-signal", "vital-signs")
+	if err := database.InsertVitalSign(c, collection, &vital); err != nil {
+		slog.Error("CreateVitalSign failed: Database error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save vital sign"})
+		return
+	}
+	slog.Info("CreateVitalSign successful", "userID", userID, "type", vital.Type)
+	c.JSON(http.StatusCreated, gin.H{"vital_sign": vital})
+}
+
+// GetVitalSigns lists a user's vital signs, newest first, optionally for one
+// ?type= only.
+func GetVitalSigns(c *gin.Context, db *mongo.Client) {
+	userID := c.Param("userid")
+	vitalType := c.Query("type")
+	if _, ok := helpers.VitalTypes[vitalType]; vitalType != "" && !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown vital sign type"})
+		return
+	}
+	limit, ok := recordLimit(c)
+	if !ok {
+		return
+	}
+	collection := database.GetCollection(db, "life-signal", "vital-signs")
+	vitals, err := database.FindVitalSigns(c, collection, userID, vitalType, limit)
+	if err != nil {
+		slog.Error("GetVitalSigns failed: Database error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	slog.Info("GetVitalSigns successful", "userID", userID, "count", len(vitals))
+	c.JSON(http.StatusOK, gin.H{"vital_signs": vitals})
+}
+
+// CreateLabResult records a lab result entered by hand.
+func CreateLabResult(c *gin.Context, db *mongo.Client) {
+	userID := c.Param("userid")
+	var observation models.LabObservation
+	if err := c.ShouldBindJSON(&observation); err != nil {
+		slog.Warn("CreateLabResult failed: Invalid request body", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if err := helpers.ValidateLabObservation(&observation); err != nil {
+		slog.Warn("CreateLabResult failed: Invalid lab result", "error", err)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	observation.ID = uuid.New().String()
+	observation.UserID = userID
+	observation.SourceMessageID = ""
+	observation.CreatedAt = time.Now()
+
+	collection := database.GetCollection(db, "life-signal", "lab-observations")
+	if err := database.SaveLabObservation(c, collection, &observation); err != nil {
+		slog.Error("CreateLabResult failed: Database error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save lab result"})
+		return
+	}
+	slog.Info("CreateLabResult successful", "userID", userID, "code", observation.Code)
+	c.JSON(http.StatusCreated, gin.H{"lab_result": observation})
+}
+
+// GetLabResults lists a user's lab results, newest first, optionally for one
+// test ?code= only.
+func GetLabResults(c *gin.Context, db *mongo.Client) {
+	userID := c.Param("userid")
+	limit, ok := recordLimit(c)
+	if !ok {
+		return
+	}
+	collection := database.GetCollection(db, "life-signal", "lab-observations")
+	observations, err := database.FindLabObservations(c, collection, userID, c.Query("code"), limit)
+	if err != nil {
+		slog.Error("GetLabResults failed: Database error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	slog.Info("GetLabResults successful", "userID", userID, "count", len(observations))
+	c.JSON(http.StatusOK, gin.H{"lab_results": observations})
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"life-signal/database"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type medicationRemindersRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// SetMedicationReminders turns the caller's medication reminders on or off.
+// They are off until the patient opts in, as the emails name the drug.
+func SetMedicationReminders(c *gin.Context, db *mongo.Client) {
+	userID := c.GetString("userID")
+	var request medicationRemindersRequest
+	if err := c.ShouldBindJSON(&request); err != nil || request.Enabled == nil {
+		slog.Warn("SetMedicationReminders failed: Invalid request body", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "enabled is required"})
+		return
+	}
+	update := bson.M{
+		"$set": bson.M{"updated_at": time.Now().UTC()},
+		"$inc": bson.M{"version": 1},
+	}
+	if *request.Enabled {
+		update["$set"].(bson.M)["medication_reminders"] = true
+	} else {
+		update["$unset"] = bson.M{"medication_reminders": ""}
+	}
+	userCollection := database.GetCollection(db, "life-signal", "users")
+	result, err := userCollection.UpdateOne(c, bson.M{"_id": userID}, update)
+	if err != nil {
+		slog.Error("SetMedicationReminders failed: Database update error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update medication reminders"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		slog.Warn("SetMedicationReminders failed: User not found", "userID", userID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	slog.Info("SetMedicationReminders successful", "userID", userID, "enabled", *request.Enabled)
+	c.JSON(http.StatusOK, gin.H{"medication_reminders": *request.Enabled})
+}
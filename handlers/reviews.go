@@ -0,0 +1,577 @@
+package handlers
+
+import (
+	"fmt"
+	"life-signal/database"
+	"life-signal/helpers"
+	"life-signal/models"
+	"life-signal/reviews"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultReviewLimit = 20
+	maxReviewLimit     = 100
+	maxReviewText      = 2000
+	maxReportAttempts  = 3
+)
+
+// reportReasons are the grounds on which a review can be reported.
+var reportReasons = map[string]bool{
+	"spam":                 true,
+	"offensive":            true,
+	"personal-information": true,
+	"conflict-of-interest": true,
+	"not-a-patient":        true,
+	"other":                true,
+}
+
+func validateReview(req *models.ReviewReq) error {
+	if req.Rating < 1 || req.Rating > 5 {
+		return fmt.Errorf("rating must be between 1 and 5")
+	}
+	req.Comment = strings.TrimSpace(req.Comment)
+	if utf8.RuneCountInString(req.Comment) > maxReviewText {
+		return fmt.Errorf("comment must be at most %d characters", maxReviewText)
+	}
+	return nil
+}
+
+// reviewAuthorName is how a review's author is shown: their first name and
+// the initial of their last name.
+func reviewAuthorName(user *models.UserDetails) string {
+	name := strings.TrimSpace(user.FirstName)
+	if name == "" {
+		return "Verified patient"
+	}
+	if last := strings.TrimSpace(user.LastName); last != "" {
+		initial, _ := utf8.DecodeRuneInString(last)
+		name += " " + string(initial) + "."
+	}
+	return name
+}
+
+// publicReview strips what only the author and admins may see from a review.
+func publicReview(review *models.Review) {
+	review.UserID = ""
+	review.BookingID = ""
+	review.ReportCount = 0
+	review.ReportsResolved = 0
+	review.ModerationNote = ""
+	review.ModeratedBy = ""
+	review.ModeratedAt = nil
+}
+
+// parseReviewPage reads ?limit= and the ?cursor= of a review listing.
+func parseReviewPage(c *gin.Context) (string, int64, bool) {
+	limit := int64(defaultReviewLimit)
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || parsed < 1 || parsed > maxReviewLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxReviewLimit)})
+			return "", 0, false
+		}
+		limit = parsed
+	}
+	cursor := c.Query("cursor")
+	if _, err := primitive.ObjectIDFromHex(cursor); cursor != "" && err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cursor is invalid"})
+		return "", 0, false
+	}
+	return cursor, limit, true
+}
+
+func reviewPage(limit int64, count int, next string) gin.H {
+	return gin.H{"limit": limit, "count": count, "has_more": next != "", "next_cursor": next}
+}
+
+// listReviews answers with a page of the reviews matching filter. Unless
+// full is set, the reviews are stripped down to what anyone may see.
+func listReviews(c *gin.Context, db *mongo.Client, handler string, filter bson.M, full bool) ([]models.Review, gin.H, bool) {
+	cursor, limit, ok := parseReviewPage(c)
+	if !ok {
+		return nil, nil, false
+	}
+	found, next, err := database.FindReviews(c, database.GetCollection(db, "life-signal", "reviews"), filter, cursor, limit)
+	if err != nil {
+		slog.Error(handler+" failed: Database query error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return nil, nil, false
+	}
+	if !full {
+		for i := range found {
+			publicReview(&found[i])
+		}
+	}
+	return found, reviewPage(limit, len(found), next), true
+}
+
+// findReview loads the review with the :id of the request among those
+// matching filter and answers 404 or 500 itself when it cannot.
+func findReview(c *gin.Context, db *mongo.Client, handler string, filter bson.M) (*models.Review, bool) {
+	filter["_id"] = c.Param("id")
+	review, err := database.FindReview(c, database.GetCollection(db, "life-signal", "reviews"), filter)
+	if err != nil {
+		slog.Error(handler+" failed: Database query error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return nil, false
+	}
+	if review == nil {
+		slog.Warn(handler+" failed: Review not found", "reviewID", c.Param("id"))
+		c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+		return nil, false
+	}
+	return review, true
+}
+
+// saveReview writes review as the successor of version expected and answers
+// conflictStatus or 500 itself when the write fails.
+func saveReview(c *gin.Context, db *mongo.Client, handler string, review *models.Review, expected int64, conflictStatus int) bool {
+	err := database.UpdateReview(c, database.GetCollection(db, "life-signal", "reviews"), review, expected)
+	if err == database.ErrVersionConflict {
+		slog.Warn(handler+" failed: Version conflict", "reviewID", review.ID)
+		c.JSON(conflictStatus, gin.H{"error": "The review was changed in the meantime, reload it and try again"})
+		return false
+	}
+	if err != nil {
+		slog.Error(handler+" failed: Database update error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save review"})
+		return false
+	}
+	return true
+}
+
+// syncRating brings the doctor's rating up to date with a review that was
+// just written. A failure is left to the sync job rather than failing a
+// write that has already happened.
+func syncRating(c *gin.Context, db *mongo.Client, handler string, review *models.Review) {
+	if err := reviews.Sync(c, db, review.ID); err != nil {
+		slog.Warn(handler+": Rating sync deferred", "reviewID", review.ID, "doctorID", review.DoctorID, "error", err)
+		return
+	}
+	review.RatingSynced = true
+}
+
+// CreateReview rates a doctor the caller had a completed appointment with.
+// Each patient reviews a doctor once and edits that review afterwards.
+func CreateReview(c *gin.Context, db *mongo.Client) {
+	userID := c.GetString("userID")
+	var req models.ReviewReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		slog.Warn("CreateReview failed: Invalid request body", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if err := validateReview(&req); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	doctor, ok := findVerifiedDoctor(c, db, "CreateReview", c.Param("id"))
+	if !ok {
+		return
+	}
+	completed, err := database.FindBooking(c, database.GetCollection(db, "life-signal", "appointments"), bson.M{
+		"user_id":   userID,
+		"doctor_id": doctor.ID,
+		"status":    models.BookingCompleted,
+	})
+	if err != nil {
+		slog.Error("CreateReview failed: Database query error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if completed == nil {
+		slog.Warn("CreateReview failed: No completed appointment", "userID", userID, "doctorID", doctor.ID)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only patients with a completed appointment with this doctor can review them"})
+		return
+	}
+	user, err := database.FindUser(c, database.GetCollection(db, "life-signal", "users"), bson.M{"_id": userID})
+	if err != nil {
+		slog.Error("CreateReview failed: Database query error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	review := models.Review{
+		ID:         primitive.NewObjectID().Hex(),
+		DoctorID:   doctor.ID,
+		UserID:     userID,
+		BookingID:  completed.ID,
+		AuthorName: reviewAuthorName(user),
+		Rating:     req.Rating,
+		Comment:    req.Comment,
+		Status:     models.ReviewPublished,
+	}
+	if reviews.Premoderated() {
+		review.Status = models.ReviewPending
+	}
+	err = database.InsertReview(c, database.GetCollection(db, "life-signal", "reviews"), &review)
+	if err == database.ErrAlreadyReviewed {
+		slog.Warn("CreateReview failed: Already reviewed", "userID", userID, "doctorID", doctor.ID)
+		c.JSON(http.StatusConflict, gin.H{"error": "You have already reviewed this doctor, edit your review instead"})
+		return
+	}
+	if err != nil {
+		slog.Error("CreateReview failed: Database insert error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save review"})
+		return
+	}
+	syncRating(c, db, "CreateReview", &review)
+	slog.Info("CreateReview successful", "reviewID", review.ID, "doctorID", doctor.ID, "status", review.Status)
+	c.Header("ETag", helpers.VersionETag(review.Version))
+	c.JSON(http.StatusCreated, gin.H{"review": review})
+}
+
+// GetDoctorReviews lists a verified doctor's published reviews, newest
+// first, a page at a time, with the doctor's rating.
+func GetDoctorReviews(c *gin.Context, db *mongo.Client) {
+	doctor, ok := findVerifiedDoctor(c, db, "GetDoctorReviews", c.Param("id"))
+	if !ok {
+		return
+	}
+	found, page, ok := listReviews(c, db, "GetDoctorReviews", bson.M{"doctor_id": doctor.ID, "status": models.ReviewPublished}, false)
+	if !ok {
+		return
+	}
+	slog.Info("GetDoctorReviews successful", "doctorID", doctor.ID, "count", len(found))
+	c.JSON(http.StatusOK, gin.H{
+		"doctor_id":    doctor.ID,
+		"rating":       doctor.Rating,
+		"review_count": doctor.ReviewCount,
+		"reviews":      found,
+		"page":         page,
+	})
+}
+
+// GetMyReviews lists the reviews the caller wrote, in any state.
+func GetMyReviews(c *gin.Context, db *mongo.Client) {
+	found, page, ok := listReviews(c, db, "GetMyReviews", bson.M{"user_id": c.GetString("userID")}, true)
+	if !ok {
+		return
+	}
+	slog.Info("GetMyReviews successful", "count", len(found))
+	c.JSON(http.StatusOK, gin.H{"reviews": found, "page": page})
+}
+
+// UpdateMyReview changes the rating and comment of one of the caller's
+// reviews. If-Match must carry its current version. A rejected review goes
+// back to the moderation queue, as does any edit under pre-moderation; a
+// flagged one stays flagged.
+func UpdateMyReview(c *gin.Context, db *mongo.Client) {
+	expected, wildcard, ok := requireIfMatch(c, "UpdateMyReview")
+	if !ok {
+		return
+	}
+	var req models.ReviewReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		slog.Warn("UpdateMyReview failed: Invalid request body", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if err := validateReview(&req); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	review, ok := findReview(c, db, "UpdateMyReview", bson.M{"user_id": c.GetString("userID")})
+	if !ok {
+		return
+	}
+	if wildcard {
+		expected = review.Version
+	}
+	if expected != review.Version {
+		slog.Warn("UpdateMyReview failed: Version conflict", "reviewID", review.ID, "ifMatch", c.GetHeader("If-Match"))
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Review was modified by another request"})
+		return
+	}
+	review.Rating = req.Rating
+	review.Comment = req.Comment
+	switch {
+	case review.Status == models.ReviewRejected:
+		review.Status = models.ReviewPending
+	case review.Status == models.ReviewPublished && reviews.Premoderated():
+		review.Status = models.ReviewPending
+	}
+	if !saveReview(c, db, "UpdateMyReview", review, expected, http.StatusPreconditionFailed) {
+		return
+	}
+	syncRating(c, db, "UpdateMyReview", review)
+	slog.Info("UpdateMyReview successful", "reviewID", review.ID, "status", review.Status)
+	c.Header("ETag", helpers.VersionETag(review.Version))
+	c.JSON(http.StatusOK, gin.H{"review": review})
+}
+
+// DeleteMyReview deletes one of the caller's reviews and the reports about
+// it. The review is taken out of the doctor's rating first, so a failure
+// cannot leave a deleted review counted.
+func DeleteMyReview(c *gin.Context, db *mongo.Client) {
+	review, ok := findReview(c, db, "DeleteMyReview", bson.M{"user_id": c.GetString("userID")})
+	if !ok {
+		return
+	}
+	if err := reviews.Withdraw(c, db, review); err != nil {
+		slog.Error("DeleteMyReview failed: Rating update error", "reviewID", review.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete review"})
+		return
+	}
+	if _, err := database.GetCollection(db, "life-signal", "reviews").DeleteOne(c, bson.M{"_id": review.ID}); err != nil {
+		slog.Error("DeleteMyReview failed: Database delete error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete review"})
+		return
+	}
+	if _, err := database.GetCollection(db, "life-signal", "review-reports").DeleteMany(c, bson.M{"review_id": review.ID}); err != nil {
+		slog.Warn("DeleteMyReview: Failed to delete reports", "reviewID", review.ID, "error", err)
+	}
+	slog.Info("DeleteMyReview successful", "reviewID", review.ID, "doctorID", review.DoctorID)
+	c.Status(http.StatusNoContent)
+}
+
+// ReportReview reports a visible review as abusive. Each user reports a
+// review once; once the reports an admin has not dealt with reach the flag
+// threshold, a published review is taken down as flagged.
+func ReportReview(c *gin.Context, db *mongo.Client) {
+	userID := c.GetString("userID")
+	var req models.ReviewReportReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		slog.Warn("ReportReview failed: Invalid request body", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if !reportReasons[req.Reason] {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "reason must be spam, offensive, personal-information, conflict-of-interest, not-a-patient or other"})
+		return
+	}
+	req.Details = strings.TrimSpace(req.Details)
+	if utf8.RuneCountInString(req.Details) > maxReviewText {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("details must be at most %d characters", maxReviewText)})
+		return
+	}
+	review, ok := findReview(c, db, "ReportReview", bson.M{"status": bson.M{"$in": bson.A{models.ReviewPublished, models.ReviewFlagged}}})
+	if !ok {
+		return
+	}
+	if review.UserID == userID {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "You cannot report your own review"})
+		return
+	}
+
+	reports := database.GetCollection(db, "life-signal", "review-reports")
+	report := models.ReviewReport{ID: uuid.New().String(), ReviewID: review.ID, UserID: userID, Reason: req.Reason, Details: req.Details}
+	err := database.InsertReviewReport(c, reports, &report)
+	if err == database.ErrAlreadyReported {
+		c.JSON(http.StatusConflict, gin.H{"error": "You have already reported this review"})
+		return
+	}
+	if err != nil {
+		slog.Error("ReportReview failed: Database insert error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save report"})
+		return
+	}
+
+	// The count is taken from the reports themselves, so concurrent reports
+	// only cost a retry. A failure leaves the report stored and the count
+	// to be corrected by the next report.
+	for attempt := 0; attempt < maxReportAttempts; attempt++ {
+		count, err := reports.CountDocuments(c, bson.M{"review_id": review.ID})
+		if err != nil {
+			slog.Warn("ReportReview: Failed to count reports", "reviewID", review.ID, "error", err)
+			break
+		}
+		expected := review.Version
+		review.ReportCount = int(count)
+		flagged := review.Status == models.ReviewPublished && review.ReportCount-review.ReportsResolved >= reviews.FlagThreshold()
+		if flagged {
+			review.Status = models.ReviewFlagged
+		}
+		err = database.UpdateReview(c, database.GetCollection(db, "life-signal", "reviews"), review, expected)
+		if err == nil {
+			if flagged {
+				slog.Info("ReportReview: Review flagged", "reviewID", review.ID, "reports", review.ReportCount)
+			}
+			syncRating(c, db, "ReportReview", review)
+			break
+		}
+		if err != database.ErrVersionConflict {
+			slog.Warn("ReportReview: Failed to update report count", "reviewID", review.ID, "error", err)
+			break
+		}
+		if review, err = database.FindReview(c, database.GetCollection(db, "life-signal", "reviews"), bson.M{"_id": report.ReviewID}); err != nil || review == nil {
+			break
+		}
+	}
+	slog.Info("ReportReview successful", "reviewID", report.ReviewID, "reason", report.Reason)
+	c.JSON(http.StatusCreated, gin.H{"report": report})
+}
+
+// doctorVisibleReviews are the states of the reviews a doctor sees of their
+// own: pending and rejected ones were never shown to anyone.
+var doctorVisibleReviews = bson.A{models.ReviewPublished, models.ReviewFlagged}
+
+// GetMyDoctorReviews lists the published and flagged reviews of the calling
+// doctor, or only those with ?status=.
+func GetMyDoctorReviews(c *gin.Context, db *mongo.Client) {
+	filter := bson.M{"doctor_id": c.GetString("doctorID"), "status": bson.M{"$in": doctorVisibleReviews}}
+	if status := c.Query("status"); status != "" {
+		if status != models.ReviewPublished && status != models.ReviewFlagged {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be published or flagged"})
+			return
+		}
+		filter["status"] = status
+	}
+	found, page, ok := listReviews(c, db, "GetMyDoctorReviews", filter, false)
+	if !ok {
+		return
+	}
+	slog.Info("GetMyDoctorReviews successful", "count", len(found))
+	c.JSON(http.StatusOK, gin.H{"reviews": found, "page": page})
+}
+
+// ReplyToReview sets the calling doctor's public reply to one of their
+// reviews, replacing any earlier reply.
+func ReplyToReview(c *gin.Context, db *mongo.Client) {
+	var req models.ReviewReplyReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		slog.Warn("ReplyToReview failed: Invalid request body", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	text := strings.TrimSpace(req.Text)
+	if text == "" || utf8.RuneCountInString(text) > maxReviewText {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("text is required and must be at most %d characters", maxReviewText)})
+		return
+	}
+	review, ok := findReview(c, db, "ReplyToReview", bson.M{"doctor_id": c.GetString("doctorID"), "status": bson.M{"$in": doctorVisibleReviews}})
+	if !ok {
+		return
+	}
+	now := time.Now()
+	expected := review.Version
+	if review.Reply == nil {
+		review.Reply = &models.ReviewReply{CreatedAt: now}
+	}
+	review.Reply.Text = text
+	review.Reply.UpdatedAt = now
+	if !saveReview(c, db, "ReplyToReview", review, expected, http.StatusConflict) {
+		return
+	}
+	syncRating(c, db, "ReplyToReview", review)
+	publicReview(review)
+	slog.Info("ReplyToReview successful", "reviewID", review.ID)
+	c.JSON(http.StatusOK, gin.H{"review": review})
+}
+
+// DeleteReviewReply removes the calling doctor's reply to one of their
+// reviews.
+func DeleteReviewReply(c *gin.Context, db *mongo.Client) {
+	review, ok := findReview(c, db, "DeleteReviewReply", bson.M{"doctor_id": c.GetString("doctorID"), "status": bson.M{"$in": doctorVisibleReviews}})
+	if !ok {
+		return
+	}
+	if review.Reply == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "The review has no reply"})
+		return
+	}
+	expected := review.Version
+	review.Reply = nil
+	if !saveReview(c, db, "DeleteReviewReply", review, expected, http.StatusConflict) {
+		return
+	}
+	syncRating(c, db, "DeleteReviewReply", review)
+	slog.Info("DeleteReviewReply successful", "reviewID", review.ID)
+	c.Status(http.StatusNoContent)
+}
+
+// GetReviewQueue lists the reviews waiting for moderation, pending and
+// flagged ones unless ?status= asks for another state, each with the abuse
+// reports about it.
+func GetReviewQueue(c *gin.Context, db *mongo.Client) {
+	filter := bson.M{"status": bson.M{"$in": bson.A{models.ReviewPending, models.ReviewFlagged}}}
+	if status := c.Query("status"); status != "" {
+		switch status {
+		case models.ReviewPending, models.ReviewPublished, models.ReviewFlagged, models.ReviewRejected:
+			filter["status"] = status
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, published, flagged or rejected"})
+			return
+		}
+	}
+	found, page, ok := listReviews(c, db, "GetReviewQueue", filter, true)
+	if !ok {
+		return
+	}
+	ids := make([]string, len(found))
+	for i := range found {
+		ids[i] = found[i].ID
+	}
+	reports, err := database.FindReviewReports(c, database.GetCollection(db, "life-signal", "review-reports"), ids)
+	if err != nil {
+		slog.Error("GetReviewQueue failed: Database query error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	byReview := map[string][]models.ReviewReport{}
+	for _, report := range reports {
+		byReview[report.ReviewID] = append(byReview[report.ReviewID], report)
+	}
+	queue := make([]gin.H, len(found))
+	for i := range found {
+		queue[i] = gin.H{"review": found[i], "reports": nonNilReports(byReview[found[i].ID])}
+	}
+	slog.Info("GetReviewQueue successful", "count", len(found))
+	c.JSON(http.StatusOK, gin.H{"reviews": queue, "page": page})
+}
+
+func nonNilReports(reports []models.ReviewReport) []models.ReviewReport {
+	if reports == nil {
+		return []models.ReviewReport{}
+	}
+	return reports
+}
+
+// ModerateReview publishes or rejects a review. The reports about it so far
+// count as dealt with, so a republished review is only flagged again by new
+// ones.
+func ModerateReview(c *gin.Context, db *mongo.Client) {
+	var req models.ReviewModerationReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		slog.Warn("ModerateReview failed: Invalid request body", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.Status != models.ReviewPublished && req.Status != models.ReviewRejected {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "status must be published or rejected"})
+		return
+	}
+	review, ok := findReview(c, db, "ModerateReview", bson.M{})
+	if !ok {
+		return
+	}
+	if review.Status == req.Status {
+		c.JSON(http.StatusConflict, gin.H{"error": "The review is already " + req.Status})
+		return
+	}
+	now := time.Now()
+	expected := review.Version
+	review.Status = req.Status
+	review.ModerationNote = strings.TrimSpace(req.Note)
+	review.ModeratedBy = c.GetString("userID")
+	review.ModeratedAt = &now
+	review.ReportsResolved = review.ReportCount
+	if !saveReview(c, db, "ModerateReview", review, expected, http.StatusConflict) {
+		return
+	}
+	syncRating(c, db, "ModerateReview", review)
+	slog.Info("ModerateReview successful", "reviewID", review.ID, "status", review.Status)
+	c.JSON(http.StatusOK, gin.H{"review": review})
+}
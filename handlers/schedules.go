@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"fmt"
+	"life-signal/database"
+	"life-signal/helpers"
+	"life-signal/models"
+	"life-signal/schedule"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultSlotDays = 7
+	maxSlotDays     = 31
+)
+
+// findDoctorSchedule loads the schedule of a doctor and answers 404 or 500
+// itself when there is none.
+func findDoctorSchedule(c *gin.Context, db *mongo.Client, handler, doctorID string) (*models.Schedule, bool) {
+	s, err := database.FindSchedule(c, database.GetCollection(db, "life-signal", "doctor-schedules"), doctorID)
+	if err != nil {
+		slog.Error(handler+" failed: Database query error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return nil, false
+	}
+	if s == nil {
+		slog.Warn(handler+" failed: Schedule not found", "doctorID", doctorID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "The doctor has not published a schedule"})
+		return nil, false
+	}
+	return s, true
+}
+
+// findVerifiedDoctor loads a doctor patients may see, answering 404 for one
+// that is not verified.
+func findVerifiedDoctor(c *gin.Context, db *mongo.Client, handler, doctorID string) (*models.Doctor, bool) {
+	doctor, ok := findDoctor(c, db, handler, doctorID)
+	if !ok {
+		return nil, false
+	}
+	if doctor.Verification.Status != models.DoctorVerified {
+		slog.Warn(handler+" failed: Doctor not verified", "doctorID", doctorID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Doctor not found"})
+		return nil, false
+	}
+	return doctor, true
+}
+
+func GetMySchedule(c *gin.Context, db *mongo.Client) {
+	s, ok := findDoctorSchedule(c, db, "GetMySchedule", c.GetString("doctorID"))
+	if !ok {
+		return
+	}
+	if notModified(c, helpers.VersionETag(s.Version)) {
+		return
+	}
+	slog.Info("GetMySchedule successful", "doctorID", s.DoctorID)
+	c.JSON(http.StatusOK, gin.H{"schedule": s})
+}
+
+// SetMySchedule replaces the caller's schedule. If-Match must carry the
+// current version, or 0 to create the first schedule. The weekdays with
+// hours become the doctor's listed availability.
+func SetMySchedule(c *gin.Context, db *mongo.Client) {
+	doctorID := c.GetString("doctorID")
+	expected, wildcard, ok := requireIfMatch(c, "SetMySchedule")
+	if !ok {
+		return
+	}
+	var next models.Schedule
+	if err := c.ShouldBindJSON(&next); err != nil {
+		slog.Warn("SetMySchedule failed: Invalid request body", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	plan, err := schedule.Compile(next)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	collection := database.GetCollection(db, "life-signal", "doctor-schedules")
+	if wildcard {
+		current, err := database.FindSchedule(c, collection, doctorID)
+		if err != nil {
+			slog.Error("SetMySchedule failed: Database query error", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save schedule"})
+			return
+		}
+		if current == nil {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": "There is no schedule to replace"})
+			return
+		}
+		expected = current.Version
+	}
+	next.DoctorID = doctorID
+	err = database.WriteSchedule(c, collection, &next, expected)
+	if err == database.ErrVersionConflict {
+		slog.Warn("SetMySchedule failed: Version conflict", "doctorID", doctorID, "ifMatch", c.GetHeader("If-Match"))
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Schedule was modified by another request"})
+		return
+	}
+	if err != nil {
+		slog.Error("SetMySchedule failed: Database update error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save schedule"})
+		return
+	}
+
+	// The listing filters on availability, so keep it in step. A failure
+	// here only leaves the listing stale until the next save.
+	doctorCollection := database.GetCollection(db, "life-signal", "doctors")
+	if _, err := doctorCollection.UpdateOne(c, bson.M{"_id": doctorID}, bson.M{"$set": bson.M{"availability": plan.Days()}}); err != nil {
+		slog.Warn("SetMySchedule: Failed to update availability", "doctorID", doctorID, "error", err)
+	}
+	slog.Info("SetMySchedule successful", "doctorID", doctorID, "version", next.Version)
+	c.Header("ETag", helpers.VersionETag(next.Version))
+	c.JSON(http.StatusOK, gin.H{"schedule": next})
+}
+
+func GetDoctorSchedule(c *gin.Context, db *mongo.Client) {
+	doctor, ok := findVerifiedDoctor(c, db, "GetDoctorSchedule", c.Param("id"))
+	if !ok {
+		return
+	}
+	s, ok := findDoctorSchedule(c, db, "GetDoctorSchedule", doctor.ID)
+	if !ok {
+		return
+	}
+	if notModified(c, helpers.VersionETag(s.Version)) {
+		return
+	}
+	slog.Info("GetDoctorSchedule successful", "doctorID", doctor.ID)
+	c.JSON(http.StatusOK, gin.H{"schedule": s})
+}
+
+// parseSlotDates reads ?from= and ?to=, inclusive dates in the doctor's time
+// zone, and returns the instants bounding them. From defaults to today and
+// to to a week later.
+func parseSlotDates(c *gin.Context, location *time.Location) (time.Time, time.Time, error) {
+	today := time.Now().In(location)
+	from := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, location)
+	if raw := c.Query("from"); raw != "" {
+		date, err := time.ParseInLocation("2006-01-02", raw, location)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("from must be a date written as YYYY-MM-DD")
+		}
+		from = date
+	}
+	to := from.AddDate(0, 0, defaultSlotDays-1)
+	if raw := c.Query("to"); raw != "" {
+		date, err := time.ParseInLocation("2006-01-02", raw, location)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("to must be a date written as YYYY-MM-DD")
+		}
+		to = date
+	}
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("to must not be before from")
+	}
+	if to.After(from.AddDate(0, 0, maxSlotDays-1)) {
+		return time.Time{}, time.Time{}, fmt.Errorf("the range may cover at most %d days", maxSlotDays)
+	}
+	return from, to.AddDate(0, 0, 1), nil
+}
+
+// GetDoctorSlots lists a doctor's free slots between two dates. Slots that
+// have already started or overlap a booked appointment are left out.
+func GetDoctorSlots(c *gin.Context, db *mongo.Client) {
+	doctor, ok := findVerifiedDoctor(c, db, "GetDoctorSlots", c.Param("id"))
+	if !ok {
+		return
+	}
+	s, ok := findDoctorSchedule(c, db, "GetDoctorSlots", doctor.ID)
+	if !ok {
+		return
+	}
+	plan, err := schedule.Compile(*s)
+	if err != nil {
+		slog.Error("GetDoctorSlots failed: Stored schedule is invalid", "doctorID", doctor.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	from, to, err := parseSlotDates(c, plan.Location())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if now := time.Now(); from.Before(now) {
+		from = now
+	}
+	busy := []models.Slot{}
+	if from.Before(to) {
+		busy, err = database.FindBusySlots(c, database.GetCollection(db, "life-signal", "appointments"), doctor.ID, from, to)
+		if err != nil {
+			slog.Error("GetDoctorSlots failed: Error loading appointments", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+	}
+	slots := plan.Slots(from, to, busy)
+	slog.Info("GetDoctorSlots successful", "doctorID", doctor.ID, "count", len(slots))
+	c.JSON(http.StatusOK, gin.H{
+		"doctor_id":    doctor.ID,
+		"time_zone":    s.TimeZone,
+		"slot_minutes": s.SlotMinutes,
+		"slots":        slots,
+	})
+}
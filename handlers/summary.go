@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"life-signal/database"
+	"life-signal/models"
+	"life-signal/summary"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// publicBaseURL is PUBLIC_BASE_URL, or the scheme and host the request came
+// in on when that is unset.
+func publicBaseURL(c *gin.Context) string {
+	if base := os.Getenv("PUBLIC_BASE_URL"); base != "" {
+		return strings.TrimRight(base, "/")
+	}
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// ExportHealthSummary renders a printable PDF of the user's details, active
+// issues, current prescriptions, allergies and recent appointments.
+func ExportHealthSummary(c *gin.Context, db *mongo.Client) {
+	userID := c.Param("userid")
+	userCollection := database.GetCollection(db, "life-signal", "users")
+	user, err := database.FindUser(c, userCollection, bson.M{"_id": userID})
+	if err == mongo.ErrNoDocuments {
+		slog.Warn("ExportHealthSummary failed: User not found", "userID", userID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		slog.Error("ExportHealthSummary failed: Database query error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export summary"})
+		return
+	}
+	historyCollection := database.GetCollection(db, "life-signal", "user-medical-history")
+	history, err := database.FindMedicalHistory(c, historyCollection, userID)
+	if err != nil {
+		slog.Error("ExportHealthSummary failed: Error fetching medical history", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export summary"})
+		return
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	s := summary.Collect(*user, history, now)
+	s.ID = uuid.New().String()
+	s.VerifyURL = publicBaseURL(c) + "/verify/summaries/" + s.ID
+	var document bytes.Buffer
+	if err := summary.Render(s, &document); err != nil {
+		slog.Error("ExportHealthSummary failed: Error rendering PDF", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export summary"})
+		return
+	}
+
+	digest := sha256.Sum256(document.Bytes())
+	record := models.HealthSummary{ID: s.ID, UserID: userID, SHA256: hex.EncodeToString(digest[:]), GeneratedAt: now}
+	summaryCollection := database.GetCollection(db, "life-signal", "health-summaries")
+	if _, err := summaryCollection.InsertOne(c, record); err != nil {
+		slog.Error("ExportHealthSummary failed: Error saving summary record", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export summary"})
+		return
+	}
+
+	slog.Info("ExportHealthSummary successful", "userID", userID, "summaryID", s.ID)
+	filename := "health-summary-" + now.Format("2006-01-02") + ".pdf"
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Header("Cache-Control", "private, no-store")
+	c.Data(http.StatusOK, "application/pdf", document.Bytes())
+}
+
+// VerifyHealthSummary is the public page the QR code on a summary points to.
+// It confirms the summary was issued without revealing its contents.
+func VerifyHealthSummary(c *gin.Context, db *mongo.Client) {
+	id := c.Param("id")
+	collection := database.GetCollection(db, "life-signal", "health-summaries")
+	var record models.HealthSummary
+	err := collection.FindOne(c, bson.M{"_id": id}).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"valid": false, "error": "Summary not found"})
+		return
+	}
+	if err != nil {
+		slog.Error("VerifyHealthSummary failed: Database error", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	initials := ""
+	userCollection := database.GetCollection(db, "life-signal", "users")
+	if user, err := database.FindUser(c, userCollection, bson.M{"_id": record.UserID}); err == nil {
+		for _, name := range []string{user.FirstName, user.LastName} {
+			if runes := []rune(name); len(runes) > 0 {
+				initials += strings.ToUpper(string(runes[0])) + "."
+			}
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"valid":        true,
+		"id":           record.ID,
+		"patient":      initials,
+		"generated_at": record.GeneratedAt,
+		"sha256":       record.SHA256,
+	})
+}
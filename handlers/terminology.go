@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"life-signal/terminology"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxTerminologyResults = 50
+
+// SearchTerminology serves code autocomplete, e.g. ?q=diab&system=icd10&limit=10.
+func SearchTerminology(c *gin.Context) {
+	query := c.Query("q")
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter q is required"})
+		return
+	}
+	limit := 10
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxTerminologyResults {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 50"})
+			return
+		}
+		limit = parsed
+	}
+
+	terms := terminology.Current()
+	concepts := terms.Search(query, c.Query("system"), limit)
+	slog.Info("Terminology search", "query", query, "system", c.Query("system"), "count", len(concepts))
+	c.JSON(http.StatusOK, gin.H{"concepts": concepts, "version": terms.Version})
+}
+
+func LookupTerminologyCode(c *gin.Context) {
+	terms := terminology.Current()
+	concept, ok := terms.Lookup(c.Param("system"), c.Param("code"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Code not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"concept": concept, "version": terms.Version})
+}
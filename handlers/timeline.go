@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"life-signal/database"
+	"life-signal/timeline"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// parseTimelineDate accepts RFC 3339 timestamps or plain dates. A plain ?to=
+// date includes the whole day.
+func parseTimelineDate(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed, nil
+	}
+	parsed, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		parsed = parsed.AddDate(0, 0, 1)
+	}
+	return parsed, nil
+}
+
+// GetTimeline merges a user's clinical records into one chronological feed.
+// It supports ?from=, ?to=, ?type= (event types or categories, comma
+// separated), ?order=asc|desc, ?limit=, ?cursor= and ?group_by=month|condition.
+func GetTimeline(c *gin.Context, db *mongo.Client) {
+	userID := c.Param("userid")
+	from, err := parseTimelineDate(c.Query("from"), false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be a date or an RFC 3339 timestamp"})
+		return
+	}
+	to, err := parseTimelineDate(c.Query("to"), true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must be a date or an RFC 3339 timestamp"})
+		return
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+
+	categories := map[string]bool{}
+	for _, category := range timeline.Categories {
+		categories[category] = true
+	}
+	types := map[string]bool{}
+	for _, name := range strings.Split(c.Query("type"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := timeline.Categories[name]; !ok && !categories[name] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown event type " + name})
+			return
+		}
+		types[name] = true
+	}
+	// wants reports whether any requested type belongs to category, so
+	// collections nobody asked for are not queried.
+	wants := func(category string) bool {
+		if len(types) == 0 || types[category] {
+			return true
+		}
+		for eventType := range types {
+			if timeline.Categories[eventType] == category {
+				return true
+			}
+		}
+		return false
+	}
+
+	order := c.DefaultQuery("order", "desc")
+	if order != "asc" && order != "desc" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order must be asc or desc"})
+		return
+	}
+	groupBy := c.Query("group_by")
+	if groupBy != "" && groupBy != "month" && groupBy != "condition" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "group_by must be month or condition"})
+		return
+	}
+	limit, ok := recordLimit(c)
+	if !ok {
+		return
+	}
+
+	historyCollection := database.GetCollection(db, "life-signal", "user-medical-history")
+	history, err := database.FindMedicalHistory(c, historyCollection, userID)
+	if err != nil {
+		slog.Error("GetTimeline failed: Error fetching medical history", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	sources := timeline.Sources{History: history}
+	if wants("vital-sign") {
+		sources.VitalSigns, err = database.FindUserVitalSigns(c, database.GetCollection(db, "life-signal", "vital-signs"), userID, from, to)
+	}
+	if err == nil && wants("lab-result") {
+		sources.LabResults, err = database.FindUserLabObservations(c, database.GetCollection(db, "life-signal", "lab-observations"), userID, from, to)
+	}
+	if err == nil && wants("attachment") {
+		err = database.FindUserRecords(c, database.GetCollection(db, "life-signal", "attachments"), userID, "uploaded_at", from, to, &sources.Attachments)
+	}
+	if err != nil {
+		slog.Error("GetTimeline failed: Error fetching records", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	events, next, err := timeline.Page(timeline.Build(sources), timeline.Query{
+		From:      from,
+		To:        to,
+		Types:     types,
+		Ascending: order == "asc",
+		Cursor:    c.Query("cursor"),
+		Limit:     int(limit),
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{"next_cursor": next}
+	switch groupBy {
+	case "month":
+		response["groups"] = timeline.GroupByMonth(events)
+	case "condition":
+		response["groups"] = timeline.GroupByCondition(events)
+	default:
+		response["events"] = events
+	}
+	slog.Info("GetTimeline successful", "userID", userID, "count", len(events))
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"life-signal/helpers"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requireIfMatch reads the If-Match precondition of a write request, where
+// If-None-Match: * asks to create the resource, the same as version 0.
+func requireIfMatch(c *gin.Context, handler string) (version int64, wildcard bool, ok bool) {
+	header := c.GetHeader("If-Match")
+	if header == "" && strings.TrimSpace(c.GetHeader("If-None-Match")) == "*" {
+		return 0, false, true
+	}
+	if header == "" {
+		slog.Warn(handler+" failed: Missing If-Match header", "path", c.Request.URL.Path)
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match header, or If-None-Match: * to create, is required"})
+		return 0, false, false
+	}
+	version, wildcard, err := helpers.ParseVersionETag(header)
+	if err != nil {
+		slog.Warn(handler+" failed: Malformed If-Match header", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return 0, false, false
+	}
+	return version, wildcard, true
+}
+
+// notModified answers 304 when the request's If-None-Match header matches etag.
+func notModified(c *gin.Context, etag string) bool {
+	c.Header("ETag", etag)
+	if header := c.GetHeader("If-None-Match"); header != "" && helpers.ETagMatches(header, etag) {
+		c.Status(http.StatusNotModified)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package helpers
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func VersionETag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+func ContentETag(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to compute ETag: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("\"%x\"", sum[:16]), nil
+}
+
+// ParseVersionETag reads the version out of an If-Match header. A "*" value
+// is reported through wildcard so callers can accept any existing version.
+func ParseVersionETag(header string) (version int64, wildcard bool, err error) {
+	header = strings.TrimSpace(header)
+	if header == "*" {
+		return 0, true, nil
+	}
+	header = strings.TrimPrefix(header, "W/")
+	unquoted, err := strconv.Unquote(header)
+	if err != nil {
+		return 0, false, fmt.Errorf("malformed ETag %q", header)
+	}
+	version, err = strconv.ParseInt(unquoted, 10, 64)
+	if err != nil || version < 0 {
+		return 0, false, fmt.Errorf("malformed ETag %q", header)
+	}
+	return version, false, nil
+}
+
+// ETagMatches reports whether etag appears in a comma separated
+// If-None-Match header, using weak comparison.
+func ETagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package helpers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// CheckDownloadKey makes sure download links are signed with their own key,
+// so one leaked secret cannot both mint sessions and open attachments.
+func CheckDownloadKey() error {
+	secret := os.Getenv("ATTACHMENT_URL_SECRET")
+	if secret == "" {
+		return fmt.Errorf("ATTACHMENT_URL_SECRET environment variable is not set")
+	}
+	if secret == os.Getenv("JWT_SECRET_KEY") {
+		return fmt.Errorf("ATTACHMENT_URL_SECRET must differ from JWT_SECRET_KEY")
+	}
+	return nil
+}
+
+func downloadKey() []byte {
+	if err := CheckDownloadKey(); err != nil {
+		log.Fatal(err)
+	}
+	return []byte(os.Getenv("ATTACHMENT_URL_SECRET"))
+}
+
+func downloadSignature(path string, expires int64) string {
+	mac := hmac.New(sha256.New, downloadKey())
+	fmt.Fprintf(mac, "%s\n%d", path, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignDownloadURL returns path with expires and signature query parameters,
+// so it can be fetched without a bearer token until expiresAt.
+func SignDownloadURL(path string, expiresAt time.Time) string {
+	expires := expiresAt.Unix()
+	return fmt.Sprintf("%s?expires=%d&signature=%s", path, expires, downloadSignature(path, expires))
+}
+
+// VerifyDownloadURL checks the parameters SignDownloadURL added to path.
+func VerifyDownloadURL(path, expiresParam, signature string) error {
+	expires, err := strconv.ParseInt(expiresParam, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid expires parameter")
+	}
+	if time.Now().Unix() > expires {
+		return fmt.Errorf("download link has expired")
+	}
+	if !hmac.Equal([]byte(signature), []byte(downloadSignature(path, expires))) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package helpers
+
+import (
+	"fmt"
+	"life-signal/dosage"
+	"life-signal/models"
+	"life-signal/terminology"
+	"strconv"
+	"strings"
+)
+
+var (
+	validSeverities           = map[string]bool{"Mild": true, "Moderate": true, "Severe": true}
+	validAllergyCategories    = map[string]bool{"food": true, "medication": true, "environment": true, "biologic": true}
+	validAllergyCriticalities = map[string]bool{"low": true, "high": true, "unable-to-assess": true}
+	validAbnormalFlags        = map[string]bool{"L": true, "H": true, "LL": true, "HH": true, "N": true, "A": true, "AA": true, "<": true, ">": true}
+)
+
+// ValidateMedicalHistory checks the rules a stored medical history must
+// satisfy, whatever route it was written through.
+func ValidateMedicalHistory(history models.MedicalHistory, userID string) error {
+	if history.UserID != userID {
+		return fmt.Errorf("user_id must be %q", userID)
+	}
+	for i, issue := range history.MedicalIssues {
+		if strings.TrimSpace(issue.Condition) == "" {
+			return fmt.Errorf("medical_issues[%d].condition is required", i)
+		}
+		if err := validateCoding(issue.ConditionCode, terminology.ICD10, terminology.SNOMED); err != nil {
+			return fmt.Errorf("medical_issues[%d].condition_code: %w", i, err)
+		}
+		if issue.Severity != "" && !validSeverities[issue.Severity] {
+			return fmt.Errorf("medical_issues[%d].severity must be one of Mild, Moderate, Severe", i)
+		}
+		if issue.StartDate.IsZero() {
+			return fmt.Errorf("medical_issues[%d].start_date is required", i)
+		}
+		if issue.EndDate != nil && issue.EndDate.Before(issue.StartDate) {
+			return fmt.Errorf("medical_issues[%d].end_date is before start_date", i)
+		}
+	}
+	for i, prescription := range history.Prescriptions {
+		if strings.TrimSpace(prescription.MedicationName) == "" {
+			return fmt.Errorf("prescriptions[%d].medication_name is required", i)
+		}
+		if err := validateCoding(prescription.MedicationCode, terminology.RxNorm); err != nil {
+			return fmt.Errorf("prescriptions[%d].medication_code: %w", i, err)
+		}
+		if prescription.StructuredDosage != nil {
+			if err := dosage.Validate(*prescription.StructuredDosage); err != nil {
+				return fmt.Errorf("prescriptions[%d].structured_dosage: %w", i, err)
+			}
+		}
+		if prescription.StartDate.IsZero() {
+			return fmt.Errorf("prescriptions[%d].start_date is required", i)
+		}
+		if prescription.EndDate != nil && prescription.EndDate.Before(prescription.StartDate) {
+			return fmt.Errorf("prescriptions[%d].end_date is before start_date", i)
+		}
+	}
+	for i, appointment := range history.Appointments {
+		if strings.TrimSpace(appointment.DoctorID) == "" {
+			return fmt.Errorf("appointments[%d].doctor_id is required", i)
+		}
+		if appointment.AppointmentDate.IsZero() {
+			return fmt.Errorf("appointments[%d].appointment_date is required", i)
+		}
+	}
+	for i, allergy := range history.Allergies {
+		if strings.TrimSpace(allergy.Substance) == "" {
+			return fmt.Errorf("allergies[%d].substance is required", i)
+		}
+		if err := validateCoding(allergy.Code, terminology.SNOMED, terminology.ICD10, terminology.RxNorm); err != nil {
+			return fmt.Errorf("allergies[%d].code: %w", i, err)
+		}
+		if allergy.Category != "" && !validAllergyCategories[allergy.Category] {
+			return fmt.Errorf("allergies[%d].category must be one of food, medication, environment, biologic", i)
+		}
+		if allergy.Criticality != "" && !validAllergyCriticalities[allergy.Criticality] {
+			return fmt.Errorf("allergies[%d].criticality must be one of low, high, unable-to-assess", i)
+		}
+		if allergy.Severity != "" && !validSeverities[allergy.Severity] {
+			return fmt.Errorf("allergies[%d].severity must be one of Mild, Moderate, Severe", i)
+		}
+		if allergy.Severity != "" && allergy.Reaction == "" {
+			return fmt.Errorf("allergies[%d].severity needs a reaction", i)
+		}
+		if allergy.OnsetDate != nil && allergy.EndDate != nil && allergy.EndDate.Before(*allergy.OnsetDate) {
+			return fmt.Errorf("allergies[%d].end_date is before onset_date", i)
+		}
+	}
+	for i, immunization := range history.Immunizations {
+		if strings.TrimSpace(immunization.VaccineName) == "" {
+			return fmt.Errorf("immunizations[%d].vaccine_name is required", i)
+		}
+		if err := validateCoding(immunization.VaccineCode, terminology.CVX); err != nil {
+			return fmt.Errorf("immunizations[%d].vaccine_code: %w", i, err)
+		}
+		if immunization.OccurrenceDate.IsZero() {
+			return fmt.Errorf("immunizations[%d].occurrence_date is required", i)
+		}
+		if immunization.DoseNumber < 0 {
+			return fmt.Errorf("immunizations[%d].dose_number cannot be negative", i)
+		}
+	}
+	return nil
+}
+
+// ValidateLabObservation checks a lab result entered through the API. A
+// numeric value is parsed from Value when NumericValue is not given.
+func ValidateLabObservation(observation *models.LabObservation) error {
+	if strings.TrimSpace(observation.Code) == "" {
+		return fmt.Errorf("code is required")
+	}
+	if strings.TrimSpace(observation.Value) == "" && observation.NumericValue == nil {
+		return fmt.Errorf("value is required")
+	}
+	if observation.Value == "" {
+		observation.Value = strconv.FormatFloat(*observation.NumericValue, 'f', -1, 64)
+	} else if observation.NumericValue == nil {
+		if numeric, err := strconv.ParseFloat(strings.TrimSpace(observation.Value), 64); err == nil {
+			observation.NumericValue = &numeric
+		}
+	}
+	if observation.AbnormalFlag != "" && !validAbnormalFlags[observation.AbnormalFlag] {
+		return fmt.Errorf("abnormal_flag must be one of L, H, LL, HH, N, A, AA, <, >")
+	}
+	if observation.ObservedAt.IsZero() {
+		return fmt.Errorf("observed_at is required")
+	}
+	if observation.Status == "" {
+		observation.Status = "F"
+	}
+	return nil
+}
+
+// ValidateDoctor checks a doctor profile written through bulk imports or
+// doctor onboarding.
+func ValidateDoctor(doctor models.Doctor) error {
+	if strings.TrimSpace(doctor.FirstName) == "" || strings.TrimSpace(doctor.LastName) == "" {
+		return fmt.Errorf("first_name and last_name are required")
+	}
+	if strings.TrimSpace(doctor.Speciality) == "" {
+		return fmt.Errorf("speciality is required")
+	}
+	if doctor.Email != "" && !strings.Contains(doctor.Email, "@") {
+		return fmt.Errorf("email %q is not an email address", doctor.Email)
+	}
+	if doctor.Rating < 0 || doctor.Rating > 5 {
+		return fmt.Errorf("rating must be between 0 and 5")
+	}
+	if doctor.Experience < 0 {
+		return fmt.Errorf("experience cannot be negative")
+	}
+	if doctor.Fee < 0 {
+		return fmt.Errorf("fee cannot be negative")
+	}
+	switch doctor.Verification.Status {
+	case "", models.DoctorDraft, models.DoctorPending, models.DoctorChangesRequested, models.DoctorVerified, models.DoctorRejected:
+	default:
+		return fmt.Errorf("verification status %q is not one of draft, pending, changes-requested, verified or rejected", doctor.Verification.Status)
+	}
+	if doctor.Location != nil {
+		return ValidateLocation(*doctor.Location)
+	}
+	return nil
+}
+
+// ValidateLocation checks a GeoJSON point, which lists longitude first.
+func ValidateLocation(point models.GeoPoint) error {
+	if point.Type != "Point" || len(point.Coordinates) != 2 {
+		return fmt.Errorf("location must be a GeoJSON Point with longitude and latitude")
+	}
+	if lng, lat := point.Coordinates[0], point.Coordinates[1]; lng < -180 || lng > 180 || lat < -90 || lat > 90 {
+		return fmt.Errorf("location is out of range: longitude must be within 180 and latitude within 90 degrees")
+	}
+	return nil
+}
+
+// validateCoding checks that a coding uses one of the allowed systems and
+// exists in the loaded terminology. A nil coding is valid: codes are optional.
+func validateCoding(coding *models.Coding, systems ...string) error {
+	if coding == nil {
+		return nil
+	}
+	allowed := false
+	for _, candidate := range systems {
+		if coding.System == candidate {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("system must be one of %s", strings.Join(systems, ", "))
+	}
+	concept, ok := terminology.Current().Lookup(coding.System, coding.Code)
+	if !ok {
+		return fmt.Errorf("unknown code %q in %s", coding.Code, coding.System)
+	}
+	if coding.Display != "" && !strings.EqualFold(coding.Display, concept.Display) {
+		return fmt.Errorf("display for %s is %q", coding.Code, concept.Display)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package helpers
+
+import (
+	"fmt"
+	"life-signal/models"
+	"sort"
+	"strings"
+)
+
+type vitalUnit struct {
+	min, max float64
+}
+
+// VitalTypes lists the vital signs we record with, for each, the accepted
+// units and a plausible range used to catch typing mistakes.
+var VitalTypes = map[string]map[string]vitalUnit{
+	"blood-pressure": {"mmHg": {20, 300}},
+	"heart-rate":     {"/min": {20, 300}},
+	"spo2":           {"%": {50, 100}},
+	"glucose":        {"mg/dL": {10, 1000}, "mmol/L": {0.5, 55}},
+	"weight":         {"kg": {0.5, 500}, "lb": {1, 1100}},
+}
+
+var vitalUnitAliases = map[string]string{
+	"mmhg": "mmHg", "mm[hg]": "mmHg",
+	"/min": "/min", "bpm": "/min", "beats/min": "/min",
+	"%":     "%",
+	"mg/dl": "mg/dL", "mmol/l": "mmol/L",
+	"kg": "kg", "lb": "lb", "lbs": "lb", "[lb_av]": "lb",
+}
+
+// ValidateVitalSign checks a vital sign and normalizes its unit spelling.
+func ValidateVitalSign(vital *models.VitalSign) error {
+	units, ok := VitalTypes[vital.Type]
+	if !ok {
+		types := make([]string, 0, len(VitalTypes))
+		for name := range VitalTypes {
+			types = append(types, name)
+		}
+		sort.Strings(types)
+		return fmt.Errorf("type must be one of %s", strings.Join(types, ", "))
+	}
+	if unit, ok := vitalUnitAliases[strings.ToLower(strings.TrimSpace(vital.Unit))]; ok {
+		vital.Unit = unit
+	}
+	bounds, ok := units[vital.Unit]
+	if !ok {
+		accepted := make([]string, 0, len(units))
+		for unit := range units {
+			accepted = append(accepted, unit)
+		}
+		sort.Strings(accepted)
+		return fmt.Errorf("unit for %s must be one of %s", vital.Type, strings.Join(accepted, ", "))
+	}
+	if vital.Value < bounds.min || vital.Value > bounds.max {
+		return fmt.Errorf("value %g %s is outside the plausible range for %s", vital.Value, vital.Unit, vital.Type)
+	}
+	if vital.Type == "blood-pressure" {
+		if vital.Diastolic == nil {
+			return fmt.Errorf("diastolic is required for blood-pressure")
+		}
+		if *vital.Diastolic < 10 || *vital.Diastolic >= vital.Value {
+			return fmt.Errorf("diastolic must be positive and below the systolic value")
+		}
+	} else if vital.Diastolic != nil {
+		return fmt.Errorf("diastolic is only allowed for blood-pressure")
+	}
+	if vital.MeasuredAt.IsZero() {
+		return fmt.Errorf("measured_at is required")
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package hl7
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Acknowledgment codes for MSA-1.
+const (
+	AcceptAccept = "AA"
+	AcceptError  = "AE"
+	AcceptReject = "AR"
+)
+
+// Error condition codes from HL7 table 0357, used in ERR-3.
+const (
+	ErrSegmentSequence  = "100"
+	ErrRequiredField    = "101"
+	ErrUnsupportedType  = "200"
+	ErrUnknownKey       = "204"
+	ErrApplicationError = "207"
+)
+
+var errorConditions = map[string]string{
+	ErrSegmentSequence:  "Segment sequence error",
+	ErrRequiredField:    "Required field missing",
+	ErrUnsupportedType:  "Unsupported message type",
+	ErrUnknownKey:       "Unknown key identifier",
+	ErrApplicationError: "Application internal error",
+}
+
+// BuildACK answers the original message with an ACK. Sender and receiver are
+// swapped from the original MSH. When the message could not be parsed,
+// original is nil and a bare ACK is produced so the sender still gets a NAK.
+// errorCode is only used for AE and AR acknowledgments.
+func BuildACK(original *Message, code, errorCode, text string) string {
+	delims := DefaultDelimiters
+	var sendingApp, sendingFacility, receivingApp, receivingFacility, trigger, controlID, version string
+	if original != nil {
+		delims = original.Delimiters
+		msh := original.First("MSH")
+		receivingApp, receivingFacility = msh.Field(3), msh.Field(4)
+		sendingApp, sendingFacility = msh.Field(5), msh.Field(6)
+		_, trigger = original.Type()
+		controlID = original.ControlID()
+		version = msh.Field(12)
+	}
+	if sendingApp == "" {
+		sendingApp = "LIFE-SIGNAL"
+	}
+	if version == "" {
+		version = "2.5.1"
+	}
+
+	field := string(delims.Field)
+	encoding := string([]byte{delims.Component, delims.Repetition, delims.Escape, delims.Subcomponent})
+	messageType := "ACK"
+	if trigger != "" {
+		messageType = strings.Join([]string{"ACK", trigger, "ACK"}, string(delims.Component))
+	}
+	segments := []string{
+		strings.Join([]string{"MSH" + field + encoding, sendingApp, sendingFacility, receivingApp, receivingFacility,
+			formatTimestamp(time.Now()), "", messageType, strings.ReplaceAll(uuid.New().String(), "-", "")[:20], "P", version}, field),
+		strings.Join([]string{"MSA", code, delims.EscapeText(controlID), delims.EscapeText(text)}, field),
+	}
+	if code != AcceptAccept {
+		condition := strings.Join([]string{errorCode, errorConditions[errorCode], "HL70357"}, string(delims.Component))
+		segments = append(segments, strings.Join([]string{"ERR", "", "", condition, "E", "", "", "", delims.EscapeText(text)}, field))
+	}
+	return strings.Join(segments, "\r") + "\r"
+}
@@ -0,0 +1,317 @@
+package hl7
+
+import (
+	"context"
+	"fmt"
+	"life-signal/database"
+	"life-signal/interactions"
+	"life-signal/models"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const maxWriteAttempts = 3
+
+var patientClasses = map[string]string{
+	"E": "Emergency admission",
+	"I": "Inpatient admission",
+	"O": "Outpatient visit",
+	"P": "Preadmission",
+	"R": "Recurring patient visit",
+}
+
+// Ingester applies ADT^A01, ADT^A08 and ORU^R01 messages to the database.
+// Messages that cannot be parsed or applied are recorded in the dead-letter
+// collection by control ID and reason; the message itself is not kept, as it
+// carries the patient's details.
+type Ingester struct {
+	DB *mongo.Client
+}
+
+type ingestError struct {
+	ack       string
+	condition string
+	err       error
+}
+
+func reject(condition string, format string, args ...interface{}) *ingestError {
+	return &ingestError{ack: AcceptReject, condition: condition, err: fmt.Errorf(format, args...)}
+}
+
+func fail(condition string, format string, args ...interface{}) *ingestError {
+	return &ingestError{ack: AcceptError, condition: condition, err: fmt.Errorf(format, args...)}
+}
+
+// Handle is a HandlerFunc for Server.
+func (i *Ingester) Handle(ctx context.Context, raw string) string {
+	message, err := Parse(raw)
+	if err != nil {
+		slog.Warn("HL7 message could not be parsed", "error", err)
+		i.deadLetter(ctx, nil, "", err)
+		return BuildACK(nil, AcceptReject, ErrSegmentSequence, err.Error())
+	}
+
+	code, trigger := message.Type()
+	admission := code == "ADT" && (trigger == "A01" || trigger == "A08")
+	var user *models.UserDetails
+	var ingestErr *ingestError
+	if admission || (code == "ORU" && trigger == "R01") {
+		user, ingestErr = i.findPatient(ctx, message)
+	} else {
+		ingestErr = reject(ErrUnsupportedType, "unsupported message type %s^%s", code, trigger)
+	}
+	if ingestErr == nil {
+		if admission {
+			ingestErr = i.ingestADT(ctx, message, user, trigger == "A01")
+		} else {
+			ingestErr = i.ingestORU(ctx, message, user)
+		}
+	}
+	if ingestErr != nil {
+		slog.Warn("HL7 message rejected", "type", code+"^"+trigger, "controlID", message.ControlID(), "error", ingestErr.err)
+		userID := ""
+		if user != nil {
+			userID = user.ID
+		}
+		i.deadLetter(ctx, message, userID, ingestErr.err)
+		return BuildACK(message, ingestErr.ack, ingestErr.condition, ingestErr.err.Error())
+	}
+	slog.Info("HL7 message accepted", "type", code+"^"+trigger, "controlID", message.ControlID())
+	return BuildACK(message, AcceptAccept, "", "Message accepted")
+}
+
+func (i *Ingester) deadLetter(ctx context.Context, message *Message, userID string, cause error) {
+	letter := models.HL7DeadLetter{
+		ID:         uuid.New().String(),
+		UserID:     userID,
+		Error:      cause.Error(),
+		ReceivedAt: time.Now(),
+	}
+	if message != nil {
+		code, trigger := message.Type()
+		letter.ControlID = message.ControlID()
+		letter.MessageType = code + "^" + trigger
+		letter.SendingApplication, letter.SendingFacility = sender(message)
+	}
+	collection := database.GetCollection(i.DB, "life-signal", "hl7-dead-letters")
+	if _, err := collection.InsertOne(ctx, letter); err != nil {
+		slog.Error("Failed to store HL7 dead letter", "error", err)
+	}
+}
+
+// findPatient matches PID-3 identifiers against user IDs, then PID-13 phone
+// numbers against user phones.
+func (i *Ingester) findPatient(ctx context.Context, message *Message) (*models.UserDetails, *ingestError) {
+	pid := message.First("PID")
+	if pid == nil {
+		return nil, reject(ErrRequiredField, "PID segment is required")
+	}
+	var ids, phones []string
+	for _, repetition := range message.Repetitions(pid.Field(3)) {
+		if id := message.Component(repetition, 1); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	for _, repetition := range message.Repetitions(pid.Field(13)) {
+		if phone := message.Component(repetition, 1); phone != "" {
+			phones = append(phones, phone)
+		}
+	}
+	if len(ids) == 0 && len(phones) == 0 {
+		return nil, reject(ErrRequiredField, "PID-3 or PID-13 is required to identify the patient")
+	}
+
+	userCollection := database.GetCollection(i.DB, "life-signal", "users")
+	for _, filter := range []bson.M{{"_id": bson.M{"$in": ids}}, database.PhoneFilter(phones...)} {
+		user, err := database.FindUser(ctx, userCollection, filter)
+		if err == nil {
+			return user, nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return nil, fail(ErrApplicationError, "failed to look up patient: %v", err)
+		}
+	}
+	return nil, fail(ErrUnknownKey, "no user matches the PID-3 identifiers or PID-13 phone numbers")
+}
+
+func (i *Ingester) ingestADT(ctx context.Context, message *Message, user *models.UserDetails, admit bool) *ingestError {
+	name := message.Repetitions(message.First("PID").Field(5))
+	if len(name) > 0 {
+		family, given := message.Component(name[0], 1), message.Component(name[0], 2)
+		if (family != "" && family != user.LastName) || (given != "" && given != user.FirstName) {
+			update := bson.M{"updated_at": time.Now()}
+			if family != "" {
+				update["last_name"] = family
+			}
+			if given != "" {
+				update["first_name"] = given
+			}
+			userCollection := database.GetCollection(i.DB, "life-signal", "users")
+			_, err := userCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": update, "$inc": bson.M{"version": 1}})
+			if err != nil {
+				return fail(ErrApplicationError, "failed to update patient: %v", err)
+			}
+		}
+	}
+	if !admit {
+		return nil
+	}
+
+	pv1 := message.First("PV1")
+	if pv1 == nil {
+		return reject(ErrRequiredField, "PV1 segment is required for ADT^A01")
+	}
+	doctors := message.Repetitions(pv1.Field(7))
+	if len(doctors) == 0 || message.Component(doctors[0], 1) == "" {
+		slog.Info("HL7 admission without attending doctor, no appointment recorded", "controlID", message.ControlID())
+		return nil
+	}
+	doctor := doctors[0]
+	nameParts := []string{}
+	for _, component := range []int{6, 3, 2} {
+		if part := message.Component(doctor, component); part != "" {
+			nameParts = append(nameParts, part)
+		}
+	}
+
+	admittedAt, err := firstTimestamp(message.Unescape(pv1.Field(44)), message.Field("EVN", 2), message.Field("MSH", 7))
+	if err != nil {
+		return reject(ErrRequiredField, "admission time: %v", err)
+	}
+	notes := patientClasses[message.Unescape(pv1.Field(2))]
+	if notes == "" {
+		notes = "Admission"
+	}
+	appointment := models.Appointment{
+		DoctorID:        message.Component(doctor, 1),
+		DoctorName:      strings.Join(nameParts, " "),
+		AppointmentDate: admittedAt,
+		Notes:           fmt.Sprintf("%s (HL7 %s)", notes, message.ControlID()),
+	}
+	return i.appendAppointment(ctx, user.ID, appointment)
+}
+
+func (i *Ingester) appendAppointment(ctx context.Context, userID string, appointment models.Appointment) *ingestError {
+	historyCollection := database.GetCollection(i.DB, "life-signal", "user-medical-history")
+	for attempt := 0; attempt < maxWriteAttempts; attempt++ {
+		current, err := database.FindMedicalHistory(ctx, historyCollection, userID)
+		if err != nil {
+			return fail(ErrApplicationError, "failed to fetch medical history: %v", err)
+		}
+		next := models.MedicalHistory{UserID: userID}
+		if current != nil {
+			next = *current
+		}
+		for _, existing := range next.Appointments {
+			if existing.DoctorID == appointment.DoctorID && existing.AppointmentDate.Equal(appointment.AppointmentDate) {
+				return nil
+			}
+		}
+		next.Appointments = append(append([]models.Appointment{}, next.Appointments...), appointment)
+		findings := interactions.Current().CheckWrite(current, &next)
+		if interactions.HasBlocking(findings) {
+			return fail(ErrApplicationError, "medical history has %d blocking medication interactions", len(findings))
+		}
+		if len(findings) > 0 {
+			slog.Warn("HL7 message adds interacting prescriptions", "userID", userID, "findings", len(findings))
+		}
+		err = database.WriteMedicalHistory(ctx, historyCollection, current, &next)
+		if err == database.ErrVersionConflict {
+			continue
+		}
+		if err != nil {
+			return fail(ErrApplicationError, "failed to save medical history: %v", err)
+		}
+		return nil
+	}
+	return fail(ErrApplicationError, "medical history kept changing, gave up after %d attempts", maxWriteAttempts)
+}
+
+func (i *Ingester) ingestORU(ctx context.Context, message *Message, user *models.UserDetails) *ingestError {
+	observations := message.All("OBX")
+	if len(observations) == 0 {
+		return reject(ErrRequiredField, "ORU^R01 carries no OBX segments")
+	}
+
+	collection := database.GetCollection(i.DB, "life-signal", "lab-observations")
+	now := time.Now()
+	for n, obx := range observations {
+		identifier := obx.Field(3)
+		observation := models.LabObservation{
+			ID:              observationID(message, n),
+			UserID:          user.ID,
+			Code:            message.Component(identifier, 1),
+			Display:         message.Component(identifier, 2),
+			CodingSystem:    message.Component(identifier, 3),
+			Unit:            message.Component(obx.Field(6), 1),
+			ReferenceRange:  message.Unescape(obx.Field(7)),
+			Status:          message.Unescape(obx.Field(11)),
+			SourceMessageID: message.ControlID(),
+			CreatedAt:       now,
+		}
+		if observation.Code == "" {
+			return reject(ErrRequiredField, "OBX %d: OBX-3 observation identifier is required", n+1)
+		}
+		if flags := message.Repetitions(obx.Field(8)); len(flags) > 0 {
+			observation.AbnormalFlag = message.Unescape(flags[0])
+		}
+
+		value := obx.Field(5)
+		switch message.Unescape(obx.Field(2)) {
+		case "CE", "CWE", "CNE":
+			observation.Value = message.Component(value, 2)
+			if observation.Value == "" {
+				observation.Value = message.Component(value, 1)
+			}
+		case "NM":
+			observation.Value = message.Unescape(value)
+			numeric, err := strconv.ParseFloat(strings.TrimSpace(observation.Value), 64)
+			if err != nil {
+				return reject(ErrRequiredField, "OBX %d: OBX-5 is not numeric", n+1)
+			}
+			observation.NumericValue = &numeric
+		default:
+			observation.Value = message.Unescape(value)
+		}
+
+		observedAt, err := firstTimestamp(message.Unescape(obx.Field(14)), message.Field("OBR", 7), message.Field("MSH", 7))
+		if err != nil {
+			return reject(ErrRequiredField, "OBX %d: observation time: %v", n+1, err)
+		}
+		observation.ObservedAt = observedAt
+
+		if err := database.SaveLabObservation(ctx, collection, &observation); err != nil {
+			return fail(ErrApplicationError, "failed to store observation: %v", err)
+		}
+	}
+	return nil
+}
+
+// sender returns the namespace IDs of MSH-3 and MSH-4.
+func sender(message *Message) (application, facility string) {
+	msh := message.First("MSH")
+	return message.Component(msh.Field(3), 1), message.Component(msh.Field(4), 1)
+}
+
+// observationID identifies OBX n of message. Control IDs are only unique per
+// sender, so the sending application and facility are part of it.
+func observationID(message *Message, n int) string {
+	application, facility := sender(message)
+	return fmt.Sprintf("%s^%s^%s-%d", application, facility, message.ControlID(), n+1)
+}
+
+func firstTimestamp(values ...string) (time.Time, error) {
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		return ParseTimestamp(value)
+	}
+	return time.Time{}, fmt.Errorf("no timestamp given")
+}
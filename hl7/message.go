@@ -0,0 +1,222 @@
+package hl7
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Delimiters are the encoding characters declared in MSH-1 and MSH-2.
+type Delimiters struct {
+	Field        byte
+	Component    byte
+	Repetition   byte
+	Escape       byte
+	Subcomponent byte
+}
+
+var DefaultDelimiters = Delimiters{Field: '|', Component: '^', Repetition: '~', Escape: '\\', Subcomponent: '&'}
+
+type Segment struct {
+	Name   string
+	fields []string
+}
+
+type Message struct {
+	Delimiters Delimiters
+	Segments   []Segment
+}
+
+// Parse splits a raw HL7 v2 message into segments and fields. Segments may be
+// separated by CR, LF or CRLF.
+func Parse(raw string) (*Message, error) {
+	raw = strings.ReplaceAll(raw, "\r\n", "\r")
+	raw = strings.ReplaceAll(raw, "\n", "\r")
+	lines := strings.Split(strings.Trim(raw, "\r"), "\r")
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "MSH") || len(lines[0]) < 8 {
+		return nil, fmt.Errorf("message does not start with an MSH segment")
+	}
+
+	header := lines[0]
+	delims := Delimiters{
+		Field:        header[3],
+		Component:    header[4],
+		Repetition:   header[5],
+		Escape:       header[6],
+		Subcomponent: header[7],
+	}
+	message := &Message{Delimiters: delims}
+	for i, line := range lines {
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, string(delims.Field))
+		if len(fields[0]) != 3 {
+			return nil, fmt.Errorf("segment %d has an invalid name %q", i+1, fields[0])
+		}
+		message.Segments = append(message.Segments, Segment{Name: fields[0], fields: fields})
+	}
+
+	if message.Field("MSH", 9) == "" {
+		return nil, fmt.Errorf("MSH-9 message type is required")
+	}
+	if message.Field("MSH", 10) == "" {
+		return nil, fmt.Errorf("MSH-10 message control ID is required")
+	}
+	return message, nil
+}
+
+// Field returns field n of the segment using HL7 numbering, so that MSH-1 is
+// the field separator and MSH-2 the encoding characters. The value is not
+// unescaped.
+func (s Segment) Field(n int) string {
+	if s.Name == "MSH" {
+		if n == 1 {
+			return s.fields[0][3:4]
+		}
+		n--
+	}
+	if n <= 0 || n >= len(s.fields) {
+		return ""
+	}
+	return s.fields[n]
+}
+
+// First returns the first segment called name, or nil.
+func (m *Message) First(name string) *Segment {
+	for i := range m.Segments {
+		if m.Segments[i].Name == name {
+			return &m.Segments[i]
+		}
+	}
+	return nil
+}
+
+// All returns every segment called name in message order.
+func (m *Message) All(name string) []Segment {
+	var segments []Segment
+	for _, segment := range m.Segments {
+		if segment.Name == name {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// Field returns the unescaped value of field n of the first segment called name.
+func (m *Message) Field(name string, n int) string {
+	segment := m.First(name)
+	if segment == nil {
+		return ""
+	}
+	return m.Unescape(segment.Field(n))
+}
+
+// Repetitions splits a raw field value into its repetitions.
+func (m *Message) Repetitions(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, string(m.Delimiters.Repetition))
+}
+
+// Component returns the unescaped, 1-based component n of a raw field value.
+func (m *Message) Component(value string, n int) string {
+	components := strings.Split(value, string(m.Delimiters.Component))
+	if n <= 0 || n > len(components) {
+		return ""
+	}
+	return m.Unescape(components[n-1])
+}
+
+// Type returns the message code and trigger event from MSH-9, e.g. ADT and A01.
+func (m *Message) Type() (code, trigger string) {
+	raw := m.First("MSH").Field(9)
+	return m.Component(raw, 1), m.Component(raw, 2)
+}
+
+func (m *Message) ControlID() string {
+	return m.Field("MSH", 10)
+}
+
+// Unescape resolves the standard \F\, \S\, \T\, \R\ and \E\ escape sequences.
+func (m *Message) Unescape(value string) string {
+	escape := m.Delimiters.Escape
+	if strings.IndexByte(value, escape) < 0 {
+		return value
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != escape {
+			b.WriteByte(value[i])
+			continue
+		}
+		end := strings.IndexByte(value[i+1:], escape)
+		if end < 0 {
+			b.WriteString(value[i:])
+			break
+		}
+		switch sequence := value[i+1 : i+1+end]; sequence {
+		case "F":
+			b.WriteByte(m.Delimiters.Field)
+		case "S":
+			b.WriteByte(m.Delimiters.Component)
+		case "T":
+			b.WriteByte(m.Delimiters.Subcomponent)
+		case "R":
+			b.WriteByte(m.Delimiters.Repetition)
+		case "E":
+			b.WriteByte(escape)
+		default:
+			// Formatting and hex sequences are dropped rather than guessed at.
+		}
+		i += end + 1
+	}
+	return b.String()
+}
+
+// EscapeText is the inverse of Unescape for text written into outgoing messages.
+func (d Delimiters) EscapeText(value string) string {
+	replacer := strings.NewReplacer(
+		string(d.Escape), string([]byte{d.Escape, 'E', d.Escape}),
+		string(d.Field), string([]byte{d.Escape, 'F', d.Escape}),
+		string(d.Component), string([]byte{d.Escape, 'S', d.Escape}),
+		string(d.Subcomponent), string([]byte{d.Escape, 'T', d.Escape}),
+		string(d.Repetition), string([]byte{d.Escape, 'R', d.Escape}),
+	)
+	return replacer.Replace(value)
+}
+
+// ParseTimestamp reads an HL7 DTM/TS value such as 20240131083000+0100.
+// Precision can stop at any component; a missing offset means UTC.
+func ParseTimestamp(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, fmt.Errorf("empty timestamp")
+	}
+	offset := ""
+	if i := strings.IndexAny(value, "+-"); i >= 0 {
+		value, offset = value[:i], value[i:]
+	}
+	if i := strings.IndexByte(value, '.'); i >= 0 {
+		value = value[:i]
+	}
+	layouts := map[int]string{4: "2006", 6: "200601", 8: "20060102", 10: "2006010215", 12: "200601021504", 14: "20060102150405"}
+	layout, ok := layouts[len(value)]
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid HL7 timestamp %q", value)
+	}
+	if offset != "" {
+		value += offset
+		layout += "-0700"
+	}
+	t, err := time.Parse(layout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid HL7 timestamp %q", value)
+	}
+	return t, nil
+}
+
+func formatTimestamp(t time.Time) string {
+	return t.Format("20060102150405-0700")
+}
@@ -0,0 +1,188 @@
+package hl7
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+)
+
+// MLLP framing bytes: <VT> message <FS><CR>.
+const (
+	startBlock     = 0x0b
+	endBlock       = 0x1c
+	carriageReturn = 0x0d
+
+	maxFrameSize = 1 << 20
+)
+
+// ReadFrame reads one MLLP framed message, discarding any bytes before the
+// start block.
+func ReadFrame(r *bufio.Reader) (string, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b == startBlock {
+			break
+		}
+	}
+	var frame []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				return "", io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		if b == endBlock {
+			next, err := r.ReadByte()
+			if err != nil {
+				return "", err
+			}
+			if next != carriageReturn {
+				return "", fmt.Errorf("MLLP end block not followed by carriage return")
+			}
+			return string(frame), nil
+		}
+		frame = append(frame, b)
+		if len(frame) > maxFrameSize {
+			return "", fmt.Errorf("MLLP frame exceeds %d bytes", maxFrameSize)
+		}
+	}
+}
+
+func WriteFrame(w io.Writer, message string) error {
+	frame := make([]byte, 0, len(message)+3)
+	frame = append(frame, startBlock)
+	frame = append(frame, message...)
+	frame = append(frame, endBlock, carriageReturn)
+	_, err := w.Write(frame)
+	return err
+}
+
+// HandlerFunc processes one raw message and returns the raw acknowledgment.
+type HandlerFunc func(ctx context.Context, raw string) string
+
+// Server accepts MLLP connections and answers each framed message with the
+// acknowledgment produced by Handler. Connections from addresses outside
+// AllowedSources are closed unread.
+type Server struct {
+	Addr           string
+	Handler        HandlerFunc
+	IdleTimeout    time.Duration
+	AllowedSources []*net.IPNet
+	TLSConfig      *tls.Config
+}
+
+// ParseSources parses a comma separated list of IP addresses and CIDR ranges.
+func ParseSources(spec string) ([]*net.IPNet, error) {
+	var sources []*net.IPNet
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid source %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			sources = append(sources, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source %q: %w", entry, err)
+		}
+		sources = append(sources, network)
+	}
+	return sources, nil
+}
+
+// ListenAndServe blocks until ctx is cancelled or the listener fails.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for MLLP on %s: %w", s.Addr, err)
+	}
+	if s.TLSConfig != nil {
+		listener = tls.NewListener(listener, s.TLSConfig)
+	}
+	return s.Serve(ctx, listener)
+}
+
+func (s *Server) allowed(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, source := range s.AllowedSources {
+		if source.Contains(tcp.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	slog.Info("MLLP listener started", "addr", listener.Addr().String())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return fmt.Errorf("MLLP accept failed: %w", err)
+		}
+		go s.serveConn(ctx, conn)
+	}
+}
+
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	if !s.allowed(conn.RemoteAddr()) {
+		slog.Warn("MLLP connection refused", "remote", conn.RemoteAddr().String())
+		return
+	}
+	idle := s.IdleTimeout
+	if idle == 0 {
+		idle = 5 * time.Minute
+	}
+	reader := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(idle))
+		raw, err := ReadFrame(reader)
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				slog.Warn("MLLP connection closed", "remote", conn.RemoteAddr().String(), "error", err)
+			}
+			return
+		}
+		ack := s.Handler(ctx, raw)
+		if err := WriteFrame(conn, ack); err != nil {
+			slog.Warn("MLLP failed to send acknowledgment", "remote", conn.RemoteAddr().String(), "error", err)
+			return
+		}
+	}
+}
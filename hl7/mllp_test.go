@@ -0,0 +1,186 @@
+package hl7
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testORU = "MSH|^~\\&|LAB|CITY HOSPITAL|LIFE-SIGNAL|CLINIC|20261019120000||ORU^R01|MSG0001|P|2.5.1\r" +
+	"PID|1||52e6cc3d^^^LS||Rao^Asha\r" +
+	"OBX|1|NM|2823-3^Potassium^LN||4.2|mmol/L|3.5-5.1||||F|||20261019110000\r"
+
+// stubHandler answers like the Ingester without touching a database.
+func stubHandler(ctx context.Context, raw string) string {
+	message, err := Parse(raw)
+	if err != nil {
+		return BuildACK(nil, AcceptReject, ErrSegmentSequence, err.Error())
+	}
+	if code, trigger := message.Type(); code != "ORU" || trigger != "R01" {
+		return BuildACK(message, AcceptReject, ErrUnsupportedType, "unsupported message type "+code+"^"+trigger)
+	}
+	return BuildACK(message, AcceptAccept, "", "Message accepted")
+}
+
+func startServer(t *testing.T, allowed string) net.Addr {
+	t.Helper()
+	sources, err := ParseSources(allowed)
+	if err != nil {
+		t.Fatalf("ParseSources: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	server := &Server{Handler: stubHandler, AllowedSources: sources, IdleTimeout: 5 * time.Second}
+	go func() { done <- server.Serve(ctx, listener) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	})
+	return listener.Addr()
+}
+
+func exchange(t *testing.T, conn net.Conn, reader *bufio.Reader, message string) *Message {
+	t.Helper()
+	if err := WriteFrame(conn, message); err != nil {
+		t.Fatalf("WriteFrame: %v", err)
+	}
+	raw, err := ReadFrame(reader)
+	if err != nil {
+		t.Fatalf("ReadFrame: %v", err)
+	}
+	ack, err := Parse(raw)
+	if err != nil {
+		t.Fatalf("acknowledgment does not parse: %v\n%q", err, raw)
+	}
+	return ack
+}
+
+func TestServerAcknowledgesOverSocket(t *testing.T) {
+	addr := startServer(t, "127.0.0.1")
+	conn, err := net.DialTimeout("tcp", addr.String(), 5*time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	ack := exchange(t, conn, reader, testORU)
+	if got := ack.Field("MSA", 1); got != AcceptAccept {
+		t.Errorf("MSA-1 = %q, want AA", got)
+	}
+	if got := ack.Field("MSA", 2); got != "MSG0001" {
+		t.Errorf("MSA-2 = %q, want the original control ID", got)
+	}
+	if app, facility := ack.Field("MSH", 5), ack.Field("MSH", 6); app != "LAB" || facility != "CITY HOSPITAL" {
+		t.Errorf("ACK is addressed to %s/%s, want the sender LAB/CITY HOSPITAL", app, facility)
+	}
+	if code, trigger := ack.Type(); code != "ACK" || trigger != "R01" {
+		t.Errorf("ACK type %s^%s, want ACK^R01", code, trigger)
+	}
+	if ack.First("ERR") != nil {
+		t.Error("AA acknowledgment carries an ERR segment")
+	}
+
+	unsupported := strings.Replace(testORU, "ORU^R01|MSG0001", "ADT^A03|MSG0002", 1)
+	nak := exchange(t, conn, reader, unsupported)
+	if got := nak.Field("MSA", 1); got != AcceptReject {
+		t.Errorf("MSA-1 = %q, want AR", got)
+	}
+	if got := nak.Field("MSA", 2); got != "MSG0002" {
+		t.Errorf("MSA-2 = %q, want MSG0002", got)
+	}
+	if got := nak.Component(nak.First("ERR").Field(3), 1); got != ErrUnsupportedType {
+		t.Errorf("ERR-3 = %q, want %s", got, ErrUnsupportedType)
+	}
+
+	nak = exchange(t, conn, reader, "PID|1||x\r")
+	if got := nak.Field("MSA", 1); got != AcceptReject {
+		t.Errorf("MSA-1 = %q for an unparseable message, want AR", got)
+	}
+	if got := nak.Component(nak.First("ERR").Field(3), 1); got != ErrSegmentSequence {
+		t.Errorf("ERR-3 = %q, want %s", got, ErrSegmentSequence)
+	}
+}
+
+func TestServerRefusesUnlistedSource(t *testing.T) {
+	addr := startServer(t, "192.0.2.0/24")
+	conn, err := net.DialTimeout("tcp", addr.String(), 5*time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	WriteFrame(conn, testORU)
+	if _, err := ReadFrame(bufio.NewReader(conn)); !errors.Is(err, io.EOF) && !isReset(err) {
+		t.Errorf("ReadFrame = %v, want the connection closed unanswered", err)
+	}
+}
+
+func isReset(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && !opErr.Timeout()
+}
+
+func TestParseSources(t *testing.T) {
+	sources, err := ParseSources(" 10.1.2.3, 192.168.0.0/16 ,::1,")
+	if err != nil {
+		t.Fatalf("ParseSources: %v", err)
+	}
+	if len(sources) != 3 {
+		t.Fatalf("%d sources, want 3", len(sources))
+	}
+	server := &Server{AllowedSources: sources}
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"10.1.2.4", false},
+		{"192.168.44.1", true},
+		{"::1", true},
+		{"127.0.0.1", false},
+	}
+	for _, test := range tests {
+		if got := server.allowed(&net.TCPAddr{IP: net.ParseIP(test.ip)}); got != test.want {
+			t.Errorf("allowed(%s) = %v, want %v", test.ip, got, test.want)
+		}
+	}
+	if (&Server{}).allowed(&net.TCPAddr{IP: net.ParseIP("127.0.0.1")}) {
+		t.Error("a server without sources accepted a connection")
+	}
+
+	for _, spec := range []string{"10.0.0.300", "10.0.0.0/33", "lab.example.com"} {
+		if _, err := ParseSources(spec); err == nil {
+			t.Errorf("ParseSources(%q) accepted an invalid source", spec)
+		}
+	}
+}
+
+func TestObservationIDIncludesSender(t *testing.T) {
+	first, err := Parse(testORU)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	second, err := Parse(strings.Replace(testORU, "LAB|CITY HOSPITAL", "LAB|COUNTY HOSPITAL", 1))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if got := observationID(first, 0); got != "LAB^CITY HOSPITAL^MSG0001-1" {
+		t.Errorf("observationID = %q", got)
+	}
+	if observationID(first, 0) == observationID(second, 0) {
+		t.Error("two senders reusing a control ID share observation IDs")
+	}
+}
@@ -0,0 +1,62 @@
+package ical
+
+import (
+	"life-signal/models"
+	"strings"
+)
+
+// Audiences a booking event is written for.
+const (
+	ForPatient = "patient"
+	ForDoctor  = "doctor"
+)
+
+var bookingStatuses = map[string]string{
+	models.BookingRequested: "TENTATIVE",
+	models.BookingConfirmed: "CONFIRMED",
+	models.BookingCompleted: "CONFIRMED",
+	models.BookingNoShow:    "CONFIRMED",
+	models.BookingCancelled: "CANCELLED",
+}
+
+// BookingEvent describes booking for the patient's or the doctor's calendar.
+// Every write to a booking bumps its version, so the version doubles as the
+// SEQUENCE and a calendar always takes the latest state. Doctors' calendars
+// are often shared with staff, so their events name neither the patient nor
+// the reason for the visit. doctor may be nil when it no longer exists.
+func BookingEvent(booking models.Booking, doctor *models.Doctor, audience string) Event {
+	event := Event{
+		UID:      booking.ID + "@life-signal",
+		Sequence: booking.Version - 1,
+		Stamp:    booking.UpdatedAt,
+		Start:    booking.Start,
+		End:      booking.End,
+		Status:   bookingStatuses[booking.Status],
+	}
+	if audience == ForDoctor {
+		event.Summary = "Patient appointment"
+		event.Description = "Appointment " + booking.ID
+	} else {
+		event.Summary = "Appointment with " + booking.DoctorName
+		event.Description = booking.Reason
+	}
+	if booking.Status == models.BookingCancelled {
+		event.Summary = "Cancelled: " + event.Summary
+	}
+	if doctor != nil {
+		parts := []string{}
+		for _, part := range []string{doctor.ClinicName, doctor.ClinicAddress} {
+			if part != "" {
+				parts = append(parts, part)
+			}
+		}
+		event.Location = strings.Join(parts, ", ")
+		if doctor.Location != nil && len(doctor.Location.Coordinates) == 2 {
+			event.Geo = []float64{doctor.Location.Coordinates[1], doctor.Location.Coordinates[0]}
+		}
+		if doctor.Email != "" {
+			event.Organizer = &Person{Name: booking.DoctorName, Email: doctor.Email}
+		}
+	}
+	return event
+}
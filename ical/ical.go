@@ -0,0 +1,175 @@
+// Package ical writes appointments as RFC 5545 iCalendar events, for
+// invitations sent with notifications and for subscribable feeds.
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// ContentType is the media type of an iCalendar object.
+	ContentType = "text/calendar; charset=utf-8"
+	prodID      = "-//Life Signal//Appointments//EN"
+	stampLayout = "20060102T150405Z"
+	maxLine     = 75
+)
+
+// Methods of an iTIP message. Feeds have none.
+const (
+	MethodPublish = "PUBLISH"
+	MethodRequest = "REQUEST"
+	MethodCancel  = "CANCEL"
+)
+
+// Event is one VEVENT. Calendar clients match updates to an event by UID
+// and apply them only when Sequence is higher than what they have.
+type Event struct {
+	UID         string
+	Sequence    int64
+	Stamp       time.Time
+	Start       time.Time
+	End         time.Time
+	Status      string
+	Summary     string
+	Description string
+	Location    string
+	// Geo is latitude then longitude, or nil.
+	Geo       []float64
+	URL       string
+	Organizer *Person
+	Attendees []Person
+}
+
+type Person struct {
+	Name  string
+	Email string
+}
+
+// Calendar is a VCALENDAR holding events.
+type Calendar struct {
+	Name   string
+	Method string
+	Events []Event
+}
+
+// escape quotes a TEXT value.
+func escape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`).Replace(text)
+}
+
+// quoteParam quotes a parameter value, which may not hold double quotes.
+func quoteParam(value string) string {
+	return `"` + strings.ReplaceAll(value, `"`, "'") + `"`
+}
+
+func utc(t time.Time) string {
+	return t.UTC().Format(stampLayout)
+}
+
+type writer struct {
+	w   *bufio.Writer
+	err error
+}
+
+// line writes a content line, folded so no line exceeds 75 octets without
+// splitting a UTF-8 sequence.
+func (w *writer) line(name, value string) {
+	if w.err != nil {
+		return
+	}
+	text := name + ":" + value
+	limit := maxLine
+	for len(text) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		if _, w.err = w.w.WriteString(text[:cut] + "\r\n "); w.err != nil {
+			return
+		}
+		text = text[cut:]
+		// The leading space of a continuation counts towards its length.
+		limit = maxLine - 1
+	}
+	_, w.err = w.w.WriteString(text + "\r\n")
+}
+
+func (w *writer) person(name string, p Person) {
+	property := name
+	if p.Name != "" {
+		property += ";CN=" + quoteParam(p.Name)
+	}
+	w.line(property, "mailto:"+p.Email)
+}
+
+func (w *writer) event(e Event) {
+	w.line("BEGIN", "VEVENT")
+	w.line("UID", escape(e.UID))
+	w.line("SEQUENCE", fmt.Sprint(e.Sequence))
+	w.line("DTSTAMP", utc(e.Stamp))
+	w.line("DTSTART", utc(e.Start))
+	w.line("DTEND", utc(e.End))
+	if e.Status != "" {
+		w.line("STATUS", e.Status)
+	}
+	w.line("SUMMARY", escape(e.Summary))
+	if e.Description != "" {
+		w.line("DESCRIPTION", escape(e.Description))
+	}
+	if e.Location != "" {
+		w.line("LOCATION", escape(e.Location))
+	}
+	if len(e.Geo) == 2 {
+		w.line("GEO", fmt.Sprintf("%g;%g", e.Geo[0], e.Geo[1]))
+	}
+	if e.URL != "" {
+		w.line("URL", e.URL)
+	}
+	if e.Organizer != nil && e.Organizer.Email != "" {
+		w.person("ORGANIZER", *e.Organizer)
+	}
+	for _, attendee := range e.Attendees {
+		if attendee.Email != "" {
+			w.person("ATTENDEE;ROLE=REQ-PARTICIPANT", attendee)
+		}
+	}
+	w.line("END", "VEVENT")
+}
+
+// Write encodes cal as an iCalendar object. Times are written in UTC, so no
+// VTIMEZONE is needed.
+func Write(out io.Writer, cal Calendar) error {
+	w := &writer{w: bufio.NewWriter(out)}
+	w.line("BEGIN", "VCALENDAR")
+	w.line("VERSION", "2.0")
+	w.line("PRODID", prodID)
+	w.line("CALSCALE", "GREGORIAN")
+	if cal.Method != "" {
+		w.line("METHOD", cal.Method)
+	}
+	if cal.Name != "" {
+		w.line("X-WR-CALNAME", escape(cal.Name))
+	}
+	for _, e := range cal.Events {
+		w.event(e)
+	}
+	w.line("END", "VCALENDAR")
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
+
+// Encode returns cal as an iCalendar object.
+func Encode(cal Calendar) ([]byte, error) {
+	var b strings.Builder
+	if err := Write(&b, cal); err != nil {
+		return nil, err
+	}
+	return []byte(b.String()), nil
+}
@@ -0,0 +1,334 @@
+package interactions
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"life-signal/models"
+	"life-signal/terminology"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type Severity string
+
+const (
+	Contraindicated Severity = "contraindicated"
+	Major           Severity = "major"
+	Moderate        Severity = "moderate"
+	Minor           Severity = "minor"
+)
+
+var severityRank = map[Severity]int{Contraindicated: 0, Major: 1, Moderate: 2, Minor: 3}
+
+// Blocking severities stop a write; the others are returned as warnings.
+func (s Severity) Blocking() bool {
+	return s == Contraindicated || s == Major
+}
+
+//go:embed data/interactions.json
+var bundled []byte
+
+type Interaction struct {
+	Drugs       []string `json:"drugs"`
+	Severity    Severity `json:"severity"`
+	Description string   `json:"description"`
+}
+
+type AllergyRule struct {
+	Allergies   []models.Coding `json:"allergies"`
+	Drugs       []string        `json:"drugs"`
+	Severity    Severity        `json:"severity"`
+	Description string          `json:"description"`
+}
+
+// Dataset is a versioned interaction knowledge base. Drugs are RxNorm codes.
+type Dataset struct {
+	Version                  string        `json:"version"`
+	Interactions             []Interaction `json:"interactions"`
+	AllergyContraindications []AllergyRule `json:"allergy_contraindications"`
+
+	pairs    map[string][]int
+	allergen map[string]bool
+}
+
+type Finding struct {
+	Kind        string   `json:"kind"`
+	Severity    Severity `json:"severity"`
+	Blocking    bool     `json:"blocking"`
+	Medications []string `json:"medications"`
+	Allergy     string   `json:"allergy,omitempty"`
+	Description string   `json:"description"`
+}
+
+var (
+	current = mustParse(bundled)
+	mu      sync.RWMutex
+)
+
+// Init switches to the interaction dataset at path, keeping the bundled one
+// when path is empty.
+func Init(path string) error {
+	d, err := Load(path)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	current = d
+	return nil
+}
+
+// Current returns the interaction dataset checks run against.
+func Current() *Dataset {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+func Load(path string) (*Dataset, error) {
+	if path == "" {
+		return parse(bundled)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read interaction dataset: %w", err)
+	}
+	return parse(data)
+}
+
+func mustParse(data []byte) *Dataset {
+	d, err := parse(data)
+	if err != nil {
+		panic(fmt.Sprintf("bundled interaction dataset is invalid: %v", err))
+	}
+	return d
+}
+
+func parse(data []byte) (*Dataset, error) {
+	var d Dataset
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("failed to parse interaction dataset: %w", err)
+	}
+	if d.Version == "" {
+		return nil, fmt.Errorf("interaction dataset has no version")
+	}
+	d.pairs = map[string][]int{}
+	for i, interaction := range d.Interactions {
+		if len(interaction.Drugs) != 2 {
+			return nil, fmt.Errorf("interaction %d must name exactly two drugs", i)
+		}
+		if _, ok := severityRank[interaction.Severity]; !ok {
+			return nil, fmt.Errorf("interaction %d has unknown severity %q", i, interaction.Severity)
+		}
+		key := pairKey(interaction.Drugs[0], interaction.Drugs[1])
+		d.pairs[key] = append(d.pairs[key], i)
+	}
+	d.allergen = map[string]bool{}
+	for i, rule := range d.AllergyContraindications {
+		if _, ok := severityRank[rule.Severity]; !ok {
+			return nil, fmt.Errorf("allergy rule %d has unknown severity %q", i, rule.Severity)
+		}
+		for _, coding := range rule.Allergies {
+			d.allergen[coding.System+"|"+coding.Code] = true
+		}
+	}
+	return &d, nil
+}
+
+func pairKey(a, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return a + "|" + b
+}
+
+// drugCode identifies a prescription by its RxNorm code, coding free text
+// through the terminology when no code was recorded.
+func drugCode(prescription models.Prescription) string {
+	if prescription.MedicationCode != nil && prescription.MedicationCode.System == terminology.RxNorm {
+		return prescription.MedicationCode.Code
+	}
+	if concept, ok := terminology.Current().Match(prescription.MedicationName, terminology.RxNorm); ok {
+		return concept.Code
+	}
+	return ""
+}
+
+// IsAllergy reports whether coding names an allergy the dataset has rules for.
+func (d *Dataset) IsAllergy(coding models.Coding) bool {
+	return d.allergen[coding.System+"|"+coding.Code]
+}
+
+// ActivePrescriptions returns the prescriptions without an end date.
+func ActivePrescriptions(history *models.MedicalHistory) []models.Prescription {
+	if history == nil {
+		return nil
+	}
+	var active []models.Prescription
+	for _, prescription := range history.Prescriptions {
+		if prescription.EndDate == nil {
+			active = append(active, prescription)
+		}
+	}
+	return active
+}
+
+// Allergies returns the allergies recorded in history: unresolved allergies,
+// and unresolved issues coded with an allergy concept known to the dataset.
+func (d *Dataset) Allergies(history *models.MedicalHistory) []models.Coding {
+	if history == nil {
+		return nil
+	}
+	var allergies []models.Coding
+	for _, allergy := range history.Allergies {
+		if allergy.EndDate == nil && allergy.Code != nil && d.IsAllergy(*allergy.Code) {
+			allergies = append(allergies, *allergy.Code)
+		}
+	}
+	for _, issue := range history.MedicalIssues {
+		if issue.EndDate == nil && issue.ConditionCode != nil && d.IsAllergy(*issue.ConditionCode) {
+			allergies = append(allergies, *issue.ConditionCode)
+		}
+	}
+	return allergies
+}
+
+// Check reports interactions between each candidate and the other candidates
+// or the active prescriptions, and contraindications against allergies.
+func (d *Dataset) Check(candidates, active []models.Prescription, allergies []models.Coding) []Finding {
+	findings := []Finding{}
+	seen := map[string]bool{}
+
+	others := append(append([]models.Prescription{}, active...), candidates...)
+	for i, candidate := range candidates {
+		code := drugCode(candidate)
+		if code == "" {
+			continue
+		}
+		for j, other := range others {
+			if j == len(active)+i {
+				continue
+			}
+			otherCode := drugCode(other)
+			if otherCode == "" {
+				continue
+			}
+			for _, index := range d.pairs[pairKey(code, otherCode)] {
+				interaction := d.Interactions[index]
+				key := fmt.Sprintf("ddi|%d|%s", index, pairKey(strings.ToLower(candidate.MedicationName), strings.ToLower(other.MedicationName)))
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				findings = append(findings, Finding{
+					Kind:        "drug-drug",
+					Severity:    interaction.Severity,
+					Blocking:    interaction.Severity.Blocking(),
+					Medications: []string{candidate.MedicationName, other.MedicationName},
+					Description: interaction.Description,
+				})
+			}
+		}
+
+		for _, rule := range d.AllergyContraindications {
+			if !contains(rule.Drugs, code) {
+				continue
+			}
+			for _, allergy := range allergies {
+				if !containsCoding(rule.Allergies, allergy) {
+					continue
+				}
+				key := fmt.Sprintf("allergy|%s|%s", strings.ToLower(candidate.MedicationName), allergy.Code)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				label := allergy.Display
+				if label == "" {
+					label = allergy.Code
+					if concept, ok := terminology.Current().Lookup(allergy.System, allergy.Code); ok {
+						label = concept.Display
+					}
+				}
+				findings = append(findings, Finding{
+					Kind:        "drug-allergy",
+					Severity:    rule.Severity,
+					Blocking:    rule.Severity.Blocking(),
+					Medications: []string{candidate.MedicationName},
+					Allergy:     label,
+					Description: rule.Description,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return severityRank[findings[i].Severity] < severityRank[findings[j].Severity]
+	})
+	return findings
+}
+
+func prescriptionKey(prescription models.Prescription) string {
+	return strings.ToLower(strings.TrimSpace(prescription.MedicationName)) + "|" + prescription.StartDate.UTC().Format("2006-01-02")
+}
+
+// CheckWrite checks the active prescriptions next adds to current against
+// the patient's other active prescriptions and allergies.
+func (d *Dataset) CheckWrite(current, next *models.MedicalHistory) []Finding {
+	existing := map[string]bool{}
+	if current != nil {
+		for _, prescription := range current.Prescriptions {
+			existing[prescriptionKey(prescription)] = true
+		}
+	}
+	var added, active []models.Prescription
+	for _, prescription := range ActivePrescriptions(next) {
+		if existing[prescriptionKey(prescription)] {
+			active = append(active, prescription)
+		} else {
+			added = append(added, prescription)
+		}
+	}
+	if len(added) == 0 {
+		return []Finding{}
+	}
+	return d.Check(added, active, d.Allergies(next))
+}
+
+func (f Finding) String() string {
+	subject := strings.Join(f.Medications, " + ")
+	if f.Allergy != "" {
+		subject += " with " + f.Allergy + " allergy"
+	}
+	return fmt.Sprintf("%s (%s): %s", subject, f.Severity, f.Description)
+}
+
+func HasBlocking(findings []Finding) bool {
+	for _, finding := range findings {
+		if finding.Blocking {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsCoding(codings []models.Coding, coding models.Coding) bool {
+	for _, candidate := range codings {
+		if candidate.System == coding.System && candidate.Code == coding.Code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package interactions
+
+import (
+	"life-signal/models"
+	"life-signal/terminology"
+	"strings"
+	"testing"
+	"time"
+)
+
+func prescription(name, code string, start time.Time) models.Prescription {
+	return models.Prescription{
+		MedicationName: name,
+		MedicationCode: &models.Coding{System: terminology.RxNorm, Code: code},
+		Dosage:         "1 tablet daily",
+		StartDate:      start,
+	}
+}
+
+func TestCheckWrite(t *testing.T) {
+	dataset, err := Load("")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	start := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+	ended := start.AddDate(0, 1, 0)
+	warfarin := prescription("Warfarin", "11289", start)
+	aspirin := prescription("Aspirin", "1191", start.AddDate(0, 0, 7))
+	amoxicillin := prescription("Amoxicillin", "723", start.AddDate(0, 0, 7))
+	stoppedAspirin := aspirin
+	stoppedAspirin.EndDate = &ended
+	penicillinAllergy := models.Allergy{Substance: "Penicillin", Code: &models.Coding{System: "http://snomed.info/sct", Code: "91936005"}}
+
+	current := &models.MedicalHistory{UserID: "u1", Prescriptions: []models.Prescription{warfarin}, Allergies: []models.Allergy{penicillinAllergy}}
+	tests := []struct {
+		name     string
+		current  *models.MedicalHistory
+		added    []models.Prescription
+		want     []string
+		blocking bool
+	}{
+		{"unchanged history", current, nil, nil, false},
+		{"new drug interacting with an active one", current, []models.Prescription{aspirin}, []string{"drug-drug"}, true},
+		{"new drug already ended", current, []models.Prescription{stoppedAspirin}, nil, false},
+		{"new drug contraindicated by an allergy", current, []models.Prescription{amoxicillin}, []string{"drug-allergy"}, true},
+		{"first history checks everything", nil, []models.Prescription{warfarin, aspirin}, []string{"drug-drug"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			next := &models.MedicalHistory{UserID: "u1", Allergies: current.Allergies}
+			if test.current != nil {
+				next.Prescriptions = append(next.Prescriptions, test.current.Prescriptions...)
+			}
+			next.Prescriptions = append(next.Prescriptions, test.added...)
+
+			findings := dataset.CheckWrite(test.current, next)
+			var kinds []string
+			for _, finding := range findings {
+				kinds = append(kinds, finding.Kind)
+			}
+			if strings.Join(kinds, ",") != strings.Join(test.want, ",") {
+				t.Errorf("findings %v, want %v", kinds, test.want)
+			}
+			if HasBlocking(findings) != test.blocking {
+				t.Errorf("HasBlocking = %v, want %v", HasBlocking(findings), test.blocking)
+			}
+		})
+	}
+}
+
+func TestFindingString(t *testing.T) {
+	finding := Finding{Kind: "drug-allergy", Severity: Contraindicated, Medications: []string{"Amoxicillin"}, Allergy: "Penicillin", Description: "Avoid."}
+	if got, want := finding.String(), "Amoxicillin with Penicillin allergy (contraindicated): Avoid."; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,130 @@
+// Package jobs runs background work stored in MongoDB. Any number of
+// replicas may run a Scheduler; leases make sure each job runs on one of
+// them at a time.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"life-signal/database"
+	"life-signal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	DefaultMaxAttempts = 5
+	baseBackoff        = 30 * time.Second
+	maxBackoff         = time.Hour
+)
+
+// ErrNotDead is returned when retrying a dead job that does not exist.
+var ErrNotDead = errors.New("dead job not found")
+
+func Collection(db *mongo.Client) *mongo.Collection {
+	return database.GetCollection(db, "life-signal", "jobs")
+}
+
+func DeadLetters(db *mongo.Client) *mongo.Collection {
+	return database.GetCollection(db, "life-signal", "job-dead-letters")
+}
+
+// Enqueue adds a job of kind to run at runAt. A job with a key is only ever
+// enqueued once: enqueueing the same kind and key again does nothing, which
+// makes it safe to enqueue from code that may run twice.
+func Enqueue(ctx context.Context, db *mongo.Client, kind, key string, runAt time.Time, payload map[string]string) error {
+	now := time.Now()
+	job := models.Job{
+		ID:          uuid.New().String(),
+		Kind:        kind,
+		Payload:     payload,
+		Status:      models.JobPending,
+		RunAt:       runAt,
+		MaxAttempts: DefaultMaxAttempts,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	collection := Collection(db)
+	if key == "" {
+		if _, err := collection.InsertOne(ctx, job); err != nil {
+			return fmt.Errorf("failed to enqueue %s job: %w", kind, err)
+		}
+		return nil
+	}
+	job.ID = kind + ":" + key
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{"$setOnInsert": job}, options.Update().SetUpsert(true))
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("failed to enqueue %s job: %w", kind, err)
+	}
+	return nil
+}
+
+// SetPayload records key in the payload of a running job, so a retry can
+// tell which of its steps already succeeded.
+func SetPayload(ctx context.Context, db *mongo.Client, job *models.Job, key, value string) error {
+	_, err := Collection(db).UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{"$set": bson.M{"payload." + key: value}})
+	if err != nil {
+		return fmt.Errorf("failed to update job %s: %w", job.ID, err)
+	}
+	if job.Payload == nil {
+		job.Payload = map[string]string{}
+	}
+	job.Payload[key] = value
+	return nil
+}
+
+// backoff is the delay before retrying a job that failed its attempt-th
+// time: 30s doubling up to an hour.
+func backoff(attempt int) time.Duration {
+	delay := baseBackoff
+	for i := 1; i < attempt && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
+
+// FindDeadJobs returns the dead jobs, most recent first.
+func FindDeadJobs(ctx context.Context, db *mongo.Client, limit int64) ([]models.DeadJob, error) {
+	cursor, err := DeadLetters(db).Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "died_at", Value: -1}}).SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	dead := []models.DeadJob{}
+	if err := cursor.All(ctx, &dead); err != nil {
+		return nil, err
+	}
+	return dead, nil
+}
+
+// RetryDeadJob puts a dead job back in the queue with fresh attempts.
+func RetryDeadJob(ctx context.Context, db *mongo.Client, id string) (*models.Job, error) {
+	var dead models.DeadJob
+	if err := DeadLetters(db).FindOne(ctx, bson.M{"_id": id}).Decode(&dead); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNotDead
+		}
+		return nil, err
+	}
+	job := dead.Job
+	now := time.Now()
+	job.Status = models.JobPending
+	job.RunAt = now
+	job.Attempts = 0
+	job.LeaseOwner = ""
+	job.LeaseUntil = nil
+	job.LastError = ""
+	job.FinishedAt = nil
+	job.UpdatedAt = now
+	if _, err := Collection(db).ReplaceOne(ctx, bson.M{"_id": job.ID}, job, options.Replace().SetUpsert(true)); err != nil {
+		return nil, fmt.Errorf("failed to requeue job: %w", err)
+	}
+	if _, err := DeadLetters(db).DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		return nil, fmt.Errorf("failed to remove dead job: %w", err)
+	}
+	return &job, nil
+}
@@ -0,0 +1,113 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"life-signal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// queue stores the jobs a scheduler works through.
+type queue interface {
+	// schedule makes sure a periodic job of kind exists.
+	schedule(ctx context.Context, kind string, interval time.Duration) error
+	// claim leases to owner until leaseUntil the most overdue job of kinds
+	// that is pending and due, or running with an expired lease, or returns
+	// nil when there is none.
+	claim(ctx context.Context, kinds []string, owner string, now, leaseUntil time.Time) (*models.Job, error)
+	// renew extends the lease owner holds on the job with id, reporting
+	// false when another owner took it over.
+	renew(ctx context.Context, id, owner string, leaseUntil time.Time) (bool, error)
+	// release stores the outcome of a run and drops owner's lease, unless
+	// another owner took it over.
+	release(ctx context.Context, job *models.Job, owner string) error
+	// bury keeps a job that failed on every attempt in the dead letters.
+	bury(ctx context.Context, dead models.DeadJob) error
+}
+
+// mongoQueue keeps jobs in the jobs collection.
+type mongoQueue struct {
+	db *mongo.Client
+}
+
+func (q mongoQueue) schedule(ctx context.Context, kind string, interval time.Duration) error {
+	now := time.Now()
+	_, err := Collection(q.db).UpdateOne(ctx, bson.M{"_id": "periodic:" + kind}, bson.M{
+		"$setOnInsert": bson.M{
+			"kind":         kind,
+			"status":       models.JobPending,
+			"run_at":       now,
+			"attempts":     0,
+			"max_attempts": DefaultMaxAttempts,
+			"created_at":   now,
+		},
+		"$set": bson.M{"interval_seconds": int64(interval / time.Second), "updated_at": now},
+	}, options.Update().SetUpsert(true))
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	return nil
+}
+
+func (q mongoQueue) claim(ctx context.Context, kinds []string, owner string, now, leaseUntil time.Time) (*models.Job, error) {
+	filter := bson.M{
+		"kind": bson.M{"$in": kinds},
+		"$or": bson.A{
+			bson.M{"status": models.JobPending, "run_at": bson.M{"$lte": now}},
+			bson.M{"status": models.JobRunning, "lease_until": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{"status": models.JobRunning, "lease_owner": owner, "lease_until": leaseUntil, "updated_at": now},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "run_at", Value: 1}}).SetReturnDocument(options.After)
+	var job models.Job
+	err := Collection(q.db).FindOneAndUpdate(ctx, filter, update, opts).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (q mongoQueue) renew(ctx context.Context, id, owner string, leaseUntil time.Time) (bool, error) {
+	result, err := Collection(q.db).UpdateOne(ctx,
+		bson.M{"_id": id, "lease_owner": owner},
+		bson.M{"$set": bson.M{"lease_until": leaseUntil}})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func (q mongoQueue) release(ctx context.Context, job *models.Job, owner string) error {
+	set := bson.M{
+		"status":     job.Status,
+		"run_at":     job.RunAt,
+		"attempts":   job.Attempts,
+		"last_error": job.LastError,
+		"updated_at": job.UpdatedAt,
+	}
+	if job.FinishedAt != nil {
+		set["finished_at"] = job.FinishedAt
+	}
+	_, err := Collection(q.db).UpdateOne(ctx,
+		bson.M{"_id": job.ID, "lease_owner": owner},
+		bson.M{"$set": set, "$unset": bson.M{"lease_owner": "", "lease_until": ""}})
+	return err
+}
+
+func (q mongoQueue) bury(ctx context.Context, dead models.DeadJob) error {
+	_, err := DeadLetters(q.db).ReplaceOne(ctx, bson.M{"_id": dead.ID}, dead, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to dead-letter job %s: %w", dead.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"life-signal/models"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Handler does the work of a job. An error makes the job run again later,
+// until it runs out of attempts.
+type Handler func(ctx context.Context, job *models.Job) error
+
+type periodic struct {
+	kind     string
+	interval time.Duration
+}
+
+// Scheduler claims due jobs of the kinds it has handlers for and runs them.
+type Scheduler struct {
+	DB *mongo.Client
+	// Owner names this replica in leases.
+	Owner string
+	// Lease is how long a claimed job is reserved; it is renewed while the
+	// job runs, so it only bounds how soon a crashed replica's job is retried.
+	Lease time.Duration
+	// Poll is how long an idle worker waits before looking for work again.
+	Poll    time.Duration
+	Workers int
+
+	handlers map[string]Handler
+	periodic []periodic
+	queue    queue
+}
+
+// NewScheduler returns a scheduler with defaults: a one-minute lease, a
+// five-second poll and JOBS_WORKERS workers, 2 when unset.
+func NewScheduler(db *mongo.Client) (*Scheduler, error) {
+	host, _ := os.Hostname()
+	workers := 2
+	if raw := os.Getenv("JOBS_WORKERS"); raw != "" {
+		var err error
+		workers, err = strconv.Atoi(raw)
+		if err != nil || workers < 1 {
+			return nil, fmt.Errorf("JOBS_WORKERS must be a whole number of at least 1")
+		}
+	}
+	return &Scheduler{
+		DB:       db,
+		Owner:    host + "-" + uuid.New().String()[:8],
+		Lease:    time.Minute,
+		Poll:     5 * time.Second,
+		Workers:  workers,
+		handlers: map[string]Handler{},
+		queue:    mongoQueue{db},
+	}, nil
+}
+
+// Handle runs handler for jobs of kind.
+func (s *Scheduler) Handle(kind string, handler Handler) {
+	s.handlers[kind] = handler
+}
+
+// Every runs handler every interval on one replica at a time.
+func (s *Scheduler) Every(kind string, interval time.Duration, handler Handler) {
+	s.handlers[kind] = handler
+	s.periodic = append(s.periodic, periodic{kind, interval})
+}
+
+// schedulePeriodic makes sure a job exists for every periodic kind. Each is
+// a single document, so however many replicas start only one runs it.
+func (s *Scheduler) schedulePeriodic(ctx context.Context) error {
+	for _, p := range s.periodic {
+		if err := s.queue.schedule(ctx, p.kind, p.interval); err != nil {
+			return fmt.Errorf("failed to schedule %s: %w", p.kind, err)
+		}
+	}
+	return nil
+}
+
+// Run works through due jobs until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) error {
+	if s.Workers < 1 {
+		return fmt.Errorf("the scheduler needs at least one worker")
+	}
+	if err := s.schedulePeriodic(ctx); err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < s.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				ran, err := s.RunOnce(ctx)
+				if err != nil {
+					slog.Error("Job scheduler failed", "error", err)
+				}
+				if !ran {
+					select {
+					case <-ctx.Done():
+					case <-time.After(s.Poll):
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// claim leases the most overdue job this scheduler can run: a pending one
+// that is due, or a running one whose lease expired.
+func (s *Scheduler) claim(ctx context.Context) (*models.Job, error) {
+	kinds := make([]string, 0, len(s.handlers))
+	for kind := range s.handlers {
+		kinds = append(kinds, kind)
+	}
+	now := time.Now()
+	job, err := s.queue.claim(ctx, kinds, s.Owner, now, now.Add(s.Lease))
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim job: %w", err)
+	}
+	return job, nil
+}
+
+// heartbeat renews the lease on job until done is closed, and cancels the
+// job's context when another replica took the lease over.
+func (s *Scheduler) heartbeat(ctx context.Context, cancel context.CancelFunc, job *models.Job, done <-chan struct{}) {
+	ticker := time.NewTicker(s.Lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			held, err := s.queue.renew(ctx, job.ID, s.Owner, time.Now().Add(s.Lease))
+			if err == nil && !held {
+				slog.Warn("Job lease lost", "jobID", job.ID, "kind", job.Kind)
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// RunOnce claims and runs one job, reporting whether there was one.
+func (s *Scheduler) RunOnce(ctx context.Context) (bool, error) {
+	job, err := s.claim(ctx)
+	if err != nil || job == nil {
+		return false, err
+	}
+	jobCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go s.heartbeat(ctx, cancel, job, done)
+	started := time.Now()
+	runErr := s.run(jobCtx, job)
+	close(done)
+	cancel()
+	if runErr != nil {
+		slog.Warn("Job failed", "jobID", job.ID, "kind", job.Kind, "attempt", job.Attempts, "error", runErr)
+	} else {
+		slog.Info("Job successful", "jobID", job.ID, "kind", job.Kind, "duration", time.Since(started))
+	}
+	return true, s.finish(ctx, job, runErr)
+}
+
+// run calls the job's handler, turning a panic into an error so one bad job
+// cannot stop the worker.
+func (s *Scheduler) run(ctx context.Context, job *models.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return s.handlers[job.Kind](ctx, job)
+}
+
+// finish records the outcome of a run, as long as this scheduler still holds
+// the lease. A periodic job is scheduled for its next run, or retried sooner
+// after a failure; any other job is done, retried with backoff, or moved to
+// the dead letters after its last attempt.
+func (s *Scheduler) finish(ctx context.Context, job *models.Job, runErr error) error {
+	now := time.Now()
+	next, dead := settle(*job, runErr, now)
+	if dead {
+		if err := s.queue.bury(ctx, models.DeadJob{Job: next, DiedAt: now}); err != nil {
+			return err
+		}
+		slog.Error("Job moved to dead letters", "jobID", job.ID, "kind", job.Kind, "error", runErr)
+	}
+	return s.queue.release(ctx, &next, s.Owner)
+}
+
+// settle returns job as it stands after a run that failed with runErr, or
+// succeeded when runErr is nil, and whether it goes to the dead letters.
+func settle(job models.Job, runErr error, now time.Time) (models.Job, bool) {
+	interval := time.Duration(job.IntervalSeconds) * time.Second
+	job.LeaseOwner = ""
+	job.LeaseUntil = nil
+	job.UpdatedAt = now
+	job.LastError = ""
+
+	if runErr == nil {
+		if interval > 0 {
+			job.Status = models.JobPending
+			job.RunAt = now.Add(interval)
+			job.Attempts = 0
+			return job, false
+		}
+		job.Status = models.JobDone
+		job.FinishedAt = &now
+		return job, false
+	}
+
+	job.LastError = runErr.Error()
+	if interval > 0 || job.Attempts < job.MaxAttempts {
+		job.Status = models.JobPending
+		job.RunAt = now.Add(backoff(job.Attempts))
+		if interval > 0 {
+			job.RunAt = now.Add(min(backoff(job.Attempts), interval))
+		}
+		return job, false
+	}
+	// Done rather than deleted, so a keyed job is not enqueued again.
+	job.Status = models.JobDone
+	job.FinishedAt = &now
+	return job, true
+}
@@ -0,0 +1,335 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"life-signal/models"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memQueue keeps jobs in memory, claiming them the way mongoQueue does.
+type memQueue struct {
+	mu   sync.Mutex
+	jobs map[string]*models.Job
+	dead []models.DeadJob
+}
+
+func newMemQueue(jobs ...models.Job) *memQueue {
+	q := &memQueue{jobs: map[string]*models.Job{}}
+	for i := range jobs {
+		q.jobs[jobs[i].ID] = &jobs[i]
+	}
+	return q
+}
+
+func (q *memQueue) job(id string) models.Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return *q.jobs[id]
+}
+
+func (q *memQueue) schedule(ctx context.Context, kind string, interval time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	id := "periodic:" + kind
+	if _, ok := q.jobs[id]; !ok {
+		q.jobs[id] = &models.Job{ID: id, Kind: kind, Status: models.JobPending, RunAt: time.Now(), MaxAttempts: DefaultMaxAttempts}
+	}
+	q.jobs[id].IntervalSeconds = int64(interval / time.Second)
+	return nil
+}
+
+func (q *memQueue) claim(ctx context.Context, kinds []string, owner string, now, leaseUntil time.Time) (*models.Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var due []*models.Job
+	for _, job := range q.jobs {
+		if !slices.Contains(kinds, job.Kind) {
+			continue
+		}
+		pending := job.Status == models.JobPending && !job.RunAt.After(now)
+		expired := job.Status == models.JobRunning && job.LeaseUntil != nil && job.LeaseUntil.Before(now)
+		if pending || expired {
+			due = append(due, job)
+		}
+	}
+	if len(due) == 0 {
+		return nil, nil
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].RunAt.Before(due[j].RunAt) })
+	job := due[0]
+	job.Status = models.JobRunning
+	job.LeaseOwner = owner
+	job.LeaseUntil = &leaseUntil
+	job.Attempts++
+	claimed := *job
+	return &claimed, nil
+}
+
+func (q *memQueue) renew(ctx context.Context, id, owner string, leaseUntil time.Time) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job := q.jobs[id]
+	if job.LeaseOwner != owner {
+		return false, nil
+	}
+	job.LeaseUntil = &leaseUntil
+	return true, nil
+}
+
+func (q *memQueue) release(ctx context.Context, job *models.Job, owner string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.jobs[job.ID].LeaseOwner != owner {
+		return nil
+	}
+	released := *job
+	q.jobs[job.ID] = &released
+	return nil
+}
+
+func (q *memQueue) bury(ctx context.Context, dead models.DeadJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.dead = append(q.dead, dead)
+	return nil
+}
+
+func newTestScheduler(q *memQueue, owner string) *Scheduler {
+	return &Scheduler{Owner: owner, Lease: time.Minute, Poll: time.Millisecond, Workers: 1, handlers: map[string]Handler{}, queue: q}
+}
+
+func TestRunOnceClaimsDueJobs(t *testing.T) {
+	now := time.Now()
+	expired := now.Add(-time.Second)
+	live := now.Add(time.Minute)
+	q := newMemQueue(
+		models.Job{ID: "later", Kind: "send", Status: models.JobPending, RunAt: now.Add(time.Hour), MaxAttempts: 5},
+		models.Job{ID: "other-kind", Kind: "unknown", Status: models.JobPending, RunAt: now.Add(-3 * time.Hour), MaxAttempts: 5},
+		models.Job{ID: "due", Kind: "send", Status: models.JobPending, RunAt: now.Add(-time.Minute), MaxAttempts: 5},
+		models.Job{ID: "crashed", Kind: "send", Status: models.JobRunning, RunAt: now.Add(-2 * time.Hour), LeaseOwner: "gone", LeaseUntil: &expired, Attempts: 1, MaxAttempts: 5},
+		models.Job{ID: "busy", Kind: "send", Status: models.JobRunning, RunAt: now.Add(-4 * time.Hour), LeaseOwner: "peer", LeaseUntil: &live, Attempts: 1, MaxAttempts: 5},
+		models.Job{ID: "finished", Kind: "send", Status: models.JobDone, RunAt: now.Add(-5 * time.Hour), MaxAttempts: 5},
+	)
+	s := newTestScheduler(q, "me")
+	var ran []string
+	s.Handle("send", func(ctx context.Context, job *models.Job) error {
+		ran = append(ran, job.ID)
+		return nil
+	})
+
+	for {
+		found, err := s.RunOnce(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found {
+			break
+		}
+	}
+	if want := []string{"crashed", "due"}; !slices.Equal(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+	if job := q.job("crashed"); job.Status != models.JobDone || job.Attempts != 2 || job.LeaseOwner != "" {
+		t.Errorf("expired lease job = %+v, want done after its second attempt with no lease", job)
+	}
+	if job := q.job("busy"); job.Status != models.JobRunning || job.LeaseOwner != "peer" {
+		t.Errorf("live lease job = %+v, want still leased to peer", job)
+	}
+	if job := q.job("later"); job.Status != models.JobPending || job.Attempts != 0 {
+		t.Errorf("future job = %+v, want untouched", job)
+	}
+}
+
+func TestHeartbeatRenewsLease(t *testing.T) {
+	q := newMemQueue(models.Job{ID: "slow", Kind: "slow", Status: models.JobPending, RunAt: time.Now(), MaxAttempts: 5})
+	s := newTestScheduler(q, "me")
+	s.Lease = 30 * time.Millisecond
+	var leases []time.Time
+	s.Handle("slow", func(ctx context.Context, job *models.Job) error {
+		for range 3 {
+			time.Sleep(s.Lease / 2)
+			if lease := q.job(job.ID).LeaseUntil; lease != nil {
+				leases = append(leases, *lease)
+			}
+		}
+		return ctx.Err()
+	})
+
+	if _, err := s.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(leases) != 3 || !leases[2].After(leases[0]) {
+		t.Errorf("leases while running = %v, want renewed", leases)
+	}
+	if job := q.job("slow"); job.Status != models.JobDone {
+		t.Errorf("status = %s, want %s", job.Status, models.JobDone)
+	}
+}
+
+func TestHeartbeatCancelsJobWhoseLeaseWasTaken(t *testing.T) {
+	q := newMemQueue(models.Job{ID: "slow", Kind: "slow", Status: models.JobPending, RunAt: time.Now(), MaxAttempts: 5})
+	s := newTestScheduler(q, "me")
+	s.Lease = 30 * time.Millisecond
+	s.Handle("slow", func(ctx context.Context, job *models.Job) error {
+		q.mu.Lock()
+		q.jobs[job.ID].LeaseOwner = "peer"
+		q.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+			return errors.New("not cancelled")
+		}
+	})
+
+	if _, err := s.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	job := q.job("slow")
+	if job.Status != models.JobRunning || job.LeaseOwner != "peer" || job.LastError != "" {
+		t.Errorf("job = %+v, want left to the replica holding the lease", job)
+	}
+}
+
+func TestFailingJobBacksOffThenDies(t *testing.T) {
+	q := newMemQueue(models.Job{ID: "flaky", Kind: "flaky", Status: models.JobPending, RunAt: time.Now(), MaxAttempts: 3})
+	s := newTestScheduler(q, "me")
+	s.Handle("flaky", func(ctx context.Context, job *models.Job) error {
+		return errors.New("upstream down")
+	})
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		before := time.Now()
+		if found, err := s.RunOnce(context.Background()); err != nil || !found {
+			t.Fatalf("attempt %d: RunOnce = %t, %v", attempt, found, err)
+		}
+		job := q.job("flaky")
+		if job.LastError != "upstream down" || job.Attempts != attempt {
+			t.Errorf("attempt %d: job = %+v", attempt, job)
+		}
+		if attempt == 3 {
+			break
+		}
+		if job.Status != models.JobPending || job.RunAt.Before(before.Add(backoff(attempt))) {
+			t.Errorf("attempt %d: job = %+v, want pending for %v", attempt, job, backoff(attempt))
+		}
+		// Make the retry due now.
+		q.mu.Lock()
+		q.jobs["flaky"].RunAt = time.Now()
+		q.mu.Unlock()
+	}
+
+	job := q.job("flaky")
+	if job.Status != models.JobDone || job.FinishedAt == nil {
+		t.Errorf("job = %+v, want done", job)
+	}
+	if len(q.dead) != 1 || q.dead[0].ID != "flaky" || q.dead[0].LastError != "upstream down" {
+		t.Errorf("dead letters = %+v, want the flaky job", q.dead)
+	}
+	if found, _ := s.RunOnce(context.Background()); found {
+		t.Error("RunOnce ran a dead job again")
+	}
+}
+
+func TestPanickingJobFails(t *testing.T) {
+	q := newMemQueue(models.Job{ID: "bad", Kind: "bad", Status: models.JobPending, RunAt: time.Now(), MaxAttempts: 5})
+	s := newTestScheduler(q, "me")
+	s.Handle("bad", func(ctx context.Context, job *models.Job) error {
+		panic("nil map")
+	})
+
+	if _, err := s.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if job := q.job("bad"); job.Status != models.JobPending || job.LastError != "panic: nil map" {
+		t.Errorf("job = %+v, want pending with the panic recorded", job)
+	}
+}
+
+func TestSettle(t *testing.T) {
+	now := time.Date(2025, time.March, 10, 12, 0, 0, 0, time.UTC)
+	lease := now.Add(time.Minute)
+	running := models.Job{ID: "j", Status: models.JobRunning, Attempts: 2, MaxAttempts: 3, LeaseOwner: "me", LeaseUntil: &lease, LastError: "earlier"}
+	periodic := running
+	periodic.IntervalSeconds = 60
+	periodic.Attempts = 9
+	failed := errors.New("boom")
+
+	tests := []struct {
+		name       string
+		job        models.Job
+		runErr     error
+		wantStatus string
+		wantRunAt  time.Time
+		wantError  string
+		wantDead   bool
+	}{
+		{"success", running, nil, models.JobDone, time.Time{}, "", false},
+		{"failure retries after backoff", running, failed, models.JobPending, now.Add(backoff(2)), "boom", false},
+		{"failure on the last attempt dies", models.Job{Attempts: 3, MaxAttempts: 3}, failed, models.JobDone, time.Time{}, "boom", true},
+		{"periodic success runs again after its interval", periodic, nil, models.JobPending, now.Add(time.Minute), "", false},
+		{"periodic failure retries within its interval", periodic, failed, models.JobPending, now.Add(time.Minute), "boom", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, dead := settle(tt.job, tt.runErr, now)
+			if got.Status != tt.wantStatus || !got.RunAt.Equal(tt.wantRunAt) || got.LastError != tt.wantError || dead != tt.wantDead {
+				t.Errorf("settle = %s at %v error %q dead %t, want %s at %v error %q dead %t",
+					got.Status, got.RunAt, got.LastError, dead, tt.wantStatus, tt.wantRunAt, tt.wantError, tt.wantDead)
+			}
+			if got.LeaseOwner != "" || got.LeaseUntil != nil {
+				t.Errorf("settle kept the lease %q until %v", got.LeaseOwner, got.LeaseUntil)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{100, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempt); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestNewScheduler(t *testing.T) {
+	tests := []struct {
+		env         string
+		wantWorkers int
+		wantErr     bool
+	}{
+		{"", 2, false},
+		{"4", 4, false},
+		{"0", 0, true},
+		{"-1", 0, true},
+		{"two", 0, true},
+	}
+	for _, tt := range tests {
+		t.Setenv("JOBS_WORKERS", tt.env)
+		s, err := NewScheduler(nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewScheduler with JOBS_WORKERS=%q error = %v, want error %t", tt.env, err, tt.wantErr)
+			continue
+		}
+		if err == nil && s.Workers != tt.wantWorkers {
+			t.Errorf("NewScheduler with JOBS_WORKERS=%q has %d workers, want %d", tt.env, s.Workers, tt.wantWorkers)
+		}
+	}
+}
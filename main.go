@@ -1,9 +1,28 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"life-signal/billing"
+	"life-signal/commands"
 	"life-signal/database"
+	"life-signal/encryption"
+	"life-signal/geocode"
+	"life-signal/helpers"
+	"life-signal/hl7"
+	"life-signal/interactions"
+	"life-signal/jobs"
+	"life-signal/notify"
+	"life-signal/payments"
+	"life-signal/privacy"
+	"life-signal/reminders"
+	"life-signal/reviews"
 	"life-signal/routes"
+	"life-signal/search"
+	"life-signal/storage"
+	"life-signal/terminology"
 	"log"
+	"os"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -14,6 +33,30 @@ func main() {
 	if err != nil {
 		log.Fatalf("Error loading .env file: %v", err)
 	}
+	if err := terminology.Init(os.Getenv("TERMINOLOGY_FILE")); err != nil {
+		log.Fatalf("Failed to load terminology: %v", err)
+	}
+	if err := interactions.Init(os.Getenv("INTERACTIONS_FILE")); err != nil {
+		log.Fatalf("Failed to load interaction dataset: %v", err)
+	}
+	if err := geocode.Init(os.Getenv("GAZETTEER_FILE")); err != nil {
+		log.Fatalf("Failed to load gazetteer: %v", err)
+	}
+	if err := search.Init(os.Getenv("SYNONYMS_FILE")); err != nil {
+		log.Fatalf("Failed to load search synonyms: %v", err)
+	}
+	if err := notify.Init(); err != nil {
+		log.Fatalf("Failed to configure notifications: %v", err)
+	}
+	if err := payments.Init(); err != nil {
+		log.Fatalf("Failed to configure payments: %v", err)
+	}
+	if err := billing.Init(); err != nil {
+		log.Fatalf("Failed to configure billing: %v", err)
+	}
+	if err := storage.Init(context.Background()); err != nil {
+		log.Fatalf("Failed to configure attachment storage: %v", err)
+	}
 	client, err := database.ConnectDB()
 	if err != nil {
 		log.Fatalf("Failed to connect to MongoDB: %v", err)
@@ -23,6 +66,74 @@ func main() {
 			log.Printf("Error disconnecting database: %v", err)
 		}
 	}()
+	if len(os.Args) > 1 && commands.RunsBeforeKeys(os.Args[1]) {
+		if err := commands.Run(client, os.Args[1:]); err != nil {
+			log.Fatalf("Command %s failed: %v", os.Args[1], err)
+		}
+		return
+	}
+	keyCollection := database.GetCollection(client, "life-signal", "data-keys")
+	if err := encryption.Init(keyCollection, os.Getenv("MASTER_KEY_FILE")); err != nil {
+		log.Fatalf("Failed to load encryption keys: %v", err)
+	}
+	if len(os.Args) > 1 && commands.RunsBeforeIndexes(os.Args[1]) {
+		if err := commands.Run(client, os.Args[1:]); err != nil {
+			log.Fatalf("Command %s failed: %v", os.Args[1], err)
+		}
+		return
+	}
+	if err := database.EnsureIndexes(client); err != nil {
+		log.Fatalf("Failed to create indexes: %v", err)
+	}
+	if len(os.Args) > 1 {
+		if err := commands.Run(client, os.Args[1:]); err != nil {
+			log.Fatalf("Command %s failed: %v", os.Args[1], err)
+		}
+		return
+	}
+
+	if err := helpers.CheckDownloadKey(); err != nil {
+		log.Fatalf("Invalid attachment link configuration: %v", err)
+	}
+	scheduler, err := jobs.NewScheduler(client)
+	if err != nil {
+		log.Fatalf("Invalid job scheduler configuration: %v", err)
+	}
+
+	if addr := os.Getenv("HL7_MLLP_ADDR"); addr != "" {
+		sources, err := hl7.ParseSources(os.Getenv("HL7_ALLOWED_SOURCES"))
+		if err != nil {
+			log.Fatalf("Invalid HL7_ALLOWED_SOURCES: %v", err)
+		}
+		if len(sources) == 0 {
+			log.Fatalf("HL7_ALLOWED_SOURCES must list the addresses allowed to send HL7 messages")
+		}
+		ingester := &hl7.Ingester{DB: client}
+		server := &hl7.Server{Addr: addr, Handler: ingester.Handle, AllowedSources: sources}
+		if certFile := os.Getenv("HL7_TLS_CERT_FILE"); certFile != "" {
+			certificate, err := tls.LoadX509KeyPair(certFile, os.Getenv("HL7_TLS_KEY_FILE"))
+			if err != nil {
+				log.Fatalf("Failed to load HL7 TLS certificate: %v", err)
+			}
+			server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12}
+		}
+		go func() {
+			if err := server.ListenAndServe(context.Background()); err != nil {
+				log.Printf("HL7 listener stopped: %v", err)
+			}
+		}()
+	}
+
+	notify.Register(scheduler)
+	reminders.Register(scheduler)
+	privacy.Register(scheduler)
+	reviews.Register(scheduler)
+	billing.Register(scheduler)
+	go func() {
+		if err := scheduler.Run(context.Background()); err != nil {
+			log.Printf("Job scheduler stopped: %v", err)
+		}
+	}()
 
 	router := gin.Default()
 
@@ -1,11 +1,19 @@
 package middleware
 
 import (
+	"context"
+	"life-signal/database"
 	"life-signal/helpers"
+	"life-signal/models"
+	"log/slog"
 	"net/http"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func AuthMiddleware() gin.HandlerFunc {
@@ -32,3 +40,74 @@ func AuthMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// AdminMiddleware lets through only the users listed in ADMIN_USER_IDS,
+// separated by commas. It must run after AuthMiddleware.
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetString("userID")
+		for _, admin := range strings.Split(os.Getenv("ADMIN_USER_IDS"), ",") {
+			if admin = strings.TrimSpace(admin); admin != "" && admin == userID {
+				c.Next()
+				return
+			}
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		c.Abort()
+	}
+}
+
+// AuditMiddleware records every request about a user's records in the audit
+// log once it has been handled: who made it, on whose records and how it
+// ended. Routes under /v1/me are about the caller; handlers that read many
+// users' records audit each of them themselves. It must run after
+// AuthMiddleware.
+func AuditMiddleware(db *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		actor := c.GetString("userID")
+		subject := c.Param("userid")
+		if subject == "" && strings.HasPrefix(c.FullPath(), "/v1/me") {
+			subject = actor
+		}
+		if subject == "" {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := database.RecordAudit(ctx, database.GetCollection(db, "life-signal", "audit-log"), models.AuditEntry{
+			Type:      models.AuditAccess,
+			ActorID:   actor,
+			SubjectID: subject,
+			Method:    c.Request.Method,
+			Path:      c.Request.URL.Path,
+			Status:    c.Writer.Status(),
+			IP:        c.ClientIP(),
+		})
+		if err != nil {
+			slog.Warn("AuditMiddleware failed: Could not record access", "subject", subject, "error", err)
+		}
+	}
+}
+
+// DoctorMiddleware lets through only users with a doctor profile and sets
+// "doctorID" to it. It must run after AuthMiddleware.
+func DoctorMiddleware(db *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userCollection := database.GetCollection(db, "life-signal", "users")
+		user, err := database.FindUser(c, userCollection, bson.M{"_id": c.GetString("userID")})
+		if err != nil && err != mongo.ErrNoDocuments {
+			slog.Error("DoctorMiddleware failed: Database query error", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			c.Abort()
+			return
+		}
+		if err == mongo.ErrNoDocuments || user.Role != models.RoleDoctor || user.DoctorID == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Doctor access required"})
+			c.Abort()
+			return
+		}
+		c.Set("doctorID", user.DoctorID)
+		c.Next()
+	}
+}
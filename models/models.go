@@ -27,6 +27,28 @@ type LoginReq struct {
 	Otp         string `json:"otp"`
 }
 
+// DoctorProfileReq is the part of a doctor profile the doctor edits. The
+// rating and verification are not theirs to set.
+type DoctorProfileReq struct {
+	FirstName      string   `json:"first_name"`
+	LastName       string   `json:"last_name"`
+	Speciality     string   `json:"speciality"`
+	Phone          string   `json:"phone"`
+	Email          string   `json:"email"`
+	ClinicName     string   `json:"clinic_name"`
+	ClinicAddress  string   `json:"clinic_address"`
+	ProfilePicture string   `json:"profile_picture"`
+	Experience     int      `json:"experience"`
+	Fee            float64  `json:"fee"`
+	Availability   []string `json:"availability"`
+	Languages      []string `json:"languages"`
+	Qualifications []string `json:"qualifications"`
+	Services       []string `json:"services"`
+	About          string   `json:"about"`
+	SocialLinks    Social   `json:"social_links"`
+	LicenceNumber  string   `json:"licence_number"`
+}
+
 type OTPRequest struct {
 	Phone string `json:"phone" validate:"required,e164"`
 }
@@ -41,11 +63,23 @@ type UserDetails struct {
 	Username     string    `json:"username" bson:"username"`
 	Email        string    `json:"email" bson:"email"`
 	Phone        string    `json:"phone" bson:"phone"`
+	EmailIndex   string    `json:"-" bson:"email_index,omitempty"`
+	PhoneIndex   string    `json:"-" bson:"phone_index,omitempty"`
 	FirstName    string    `json:"first_name,omitempty" bson:"first_name,omitempty"`
 	LastName     string    `json:"last_name,omitempty" bson:"last_name,omitempty"`
 	PasswordHash string    `json:"password_hash" bson:"password_hash"`
+	Version      int64     `json:"version" bson:"version"`
 	CreatedAt    time.Time `json:"created_at" bson:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at" bson:"updated_at"`
+	// Role is RoleDoctor for users who onboarded as a doctor, with DoctorID
+	// naming their profile. Everyone else is a patient.
+	Role     string `json:"role,omitempty" bson:"role,omitempty"`
+	DoctorID string `json:"doctor_id,omitempty" bson:"doctor_id,omitempty"`
+	// Set while an erasure request waits out its grace period.
+	ErasureRequestedAt *time.Time `json:"erasure_requested_at,omitempty" bson:"erasure_requested_at,omitempty"`
+	ErasureDueAt       *time.Time `json:"erasure_due_at,omitempty" bson:"erasure_due_at,omitempty"`
+	// Medication reminders name the drug, so patients opt in to them.
+	MedicationReminders bool `json:"medication_reminders" bson:"medication_reminders,omitempty"`
 }
 type MedicalHistory struct {
 	ID            string         `json:"id" bson:"_id"`
@@ -53,29 +87,90 @@ type MedicalHistory struct {
 	MedicalIssues []Issue        `json:"medical_issues" bson:"medical_issues"`
 	Prescriptions []Prescription `json:"prescriptions" bson:"prescriptions"`
 	Appointments  []Appointment  `json:"appointments" bson:"appointments"`
+	Allergies     []Allergy      `json:"allergies" bson:"allergies"`
+	Immunizations []Immunization `json:"immunizations" bson:"immunizations"`
+	Version       int64          `json:"version" bson:"version"`
 	CreatedAt     time.Time      `json:"created_at" bson:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at" bson:"updated_at"`
+}
+
+type Coding struct {
+	System  string `json:"system" bson:"system"`
+	Code    string `json:"code" bson:"code"`
+	Display string `json:"display,omitempty" bson:"display,omitempty"`
 }
 
 type Issue struct {
-	Condition string     `json:"condition" bson:"condition"`
-	Severity  string     `json:"severity" bson:"severity"`
-	Notes     string     `json:"notes" bson:"notes"`
-	StartDate time.Time  `json:"start_date" bson:"start_date"`
-	EndDate   *time.Time `json:"end_date,omitempty" bson:"end_date,omitempty"`
+	ID            string     `json:"id,omitempty" bson:"id,omitempty"`
+	Condition     string     `json:"condition" bson:"condition"`
+	ConditionCode *Coding    `json:"condition_code,omitempty" bson:"condition_code,omitempty"`
+	Severity      string     `json:"severity" bson:"severity"`
+	Notes         string     `json:"notes" bson:"notes"`
+	StartDate     time.Time  `json:"start_date" bson:"start_date"`
+	EndDate       *time.Time `json:"end_date,omitempty" bson:"end_date,omitempty"`
+	AttachmentIDs []string   `json:"attachment_ids,omitempty" bson:"attachment_ids,omitempty"`
 }
 
 type Prescription struct {
-	MedicationName string     `json:"medication_name" bson:"medication_name"`
-	Dosage         string     `json:"dosage" bson:"dosage"`
-	StartDate      time.Time  `json:"start_date" bson:"start_date"`
-	EndDate        *time.Time `json:"end_date,omitempty" bson:"end_date,omitempty"`
+	ID               string     `json:"id,omitempty" bson:"id,omitempty"`
+	MedicationName   string     `json:"medication_name" bson:"medication_name"`
+	MedicationCode   *Coding    `json:"medication_code,omitempty" bson:"medication_code,omitempty"`
+	Dosage           string     `json:"dosage" bson:"dosage"`
+	StructuredDosage *Dosage    `json:"structured_dosage,omitempty" bson:"structured_dosage,omitempty"`
+	StartDate        time.Time  `json:"start_date" bson:"start_date"`
+	EndDate          *time.Time `json:"end_date,omitempty" bson:"end_date,omitempty"`
+}
+
+type Dosage struct {
+	Amount       float64    `json:"amount" bson:"amount"`
+	Unit         string     `json:"unit" bson:"unit"`
+	Route        string     `json:"route,omitempty" bson:"route,omitempty"`
+	Frequency    *Frequency `json:"frequency,omitempty" bson:"frequency,omitempty"`
+	AsNeeded     bool       `json:"as_needed" bson:"as_needed"`
+	DurationDays int        `json:"duration_days,omitempty" bson:"duration_days,omitempty"`
+	Instructions string     `json:"instructions,omitempty" bson:"instructions,omitempty"`
+}
+
+// Frequency is Count administrations every Period PeriodUnit, e.g. 2 per 1 d
+// for twice daily or 1 per 6 h for every six hours.
+type Frequency struct {
+	Count      int    `json:"count" bson:"count"`
+	Period     int    `json:"period" bson:"period"`
+	PeriodUnit string `json:"period_unit" bson:"period_unit"`
 }
 
 type Appointment struct {
+	ID              string    `json:"id,omitempty" bson:"id,omitempty"`
 	DoctorID        string    `json:"doctor_id" bson:"doctor_id"`
 	DoctorName      string    `json:"doctor_name" bson:"doctor_name"`
 	AppointmentDate time.Time `json:"appointment_date" bson:"appointment_date"`
 	Notes           string    `json:"notes" bson:"notes"`
+	AttachmentIDs   []string  `json:"attachment_ids,omitempty" bson:"attachment_ids,omitempty"`
+	BookingID       string    `json:"booking_id,omitempty" bson:"booking_id,omitempty"`
+}
+
+// Allergy is an allergy or intolerance. Code may name the substance or the
+// allergy itself, e.g. SNOMED CT "Allergy to penicillin".
+type Allergy struct {
+	Substance   string     `json:"substance" bson:"substance"`
+	Code        *Coding    `json:"code,omitempty" bson:"code,omitempty"`
+	Category    string     `json:"category,omitempty" bson:"category,omitempty"`
+	Criticality string     `json:"criticality,omitempty" bson:"criticality,omitempty"`
+	Reaction    string     `json:"reaction,omitempty" bson:"reaction,omitempty"`
+	Severity    string     `json:"severity,omitempty" bson:"severity,omitempty"`
+	Notes       string     `json:"notes,omitempty" bson:"notes,omitempty"`
+	OnsetDate   *time.Time `json:"onset_date,omitempty" bson:"onset_date,omitempty"`
+	EndDate     *time.Time `json:"end_date,omitempty" bson:"end_date,omitempty"`
+}
+
+type Immunization struct {
+	VaccineName    string    `json:"vaccine_name" bson:"vaccine_name"`
+	VaccineCode    *Coding   `json:"vaccine_code,omitempty" bson:"vaccine_code,omitempty"`
+	OccurrenceDate time.Time `json:"occurrence_date" bson:"occurrence_date"`
+	DoseNumber     int       `json:"dose_number,omitempty" bson:"dose_number,omitempty"`
+	LotNumber      string    `json:"lot_number,omitempty" bson:"lot_number,omitempty"`
+	Performer      string    `json:"performer,omitempty" bson:"performer,omitempty"`
+	Notes          string    `json:"notes,omitempty" bson:"notes,omitempty"`
 }
 type Doctor struct {
 	ID             string    `json:"id" bson:"_id"`
@@ -96,9 +191,422 @@ type Doctor struct {
 	Services       []string  `json:"services" bson:"services"`
 	About          string    `json:"about" bson:"about"`
 	SocialLinks    Social    `json:"social_links" bson:"social_links"`
+	Location       *GeoPoint `json:"location,omitempty" bson:"location,omitempty"`
+	CreatedAt      time.Time `json:"created_at" bson:"created_at"`
+
+	// Set for doctors who onboarded themselves rather than being imported.
+	UserID        string       `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	LicenceNumber string       `json:"licence_number,omitempty" bson:"licence_number,omitempty"`
+	Credentials   []Credential `json:"credentials,omitempty" bson:"credentials,omitempty"`
+	Verification  Verification `json:"verification" bson:"verification"`
+
+	// Copied from the doctor's rating ledger whenever a review changes;
+	// RatingVersion is the ledger version copied, so a late copy cannot
+	// overwrite a newer one.
+	ReviewCount   int   `json:"review_count" bson:"review_count"`
+	RatingVersion int64 `json:"-" bson:"rating_version,omitempty"`
+}
+
+const (
+	RolePatient = "patient"
+	RoleDoctor  = "doctor"
+)
+
+// Doctor verification states. Doctors fill in a draft and submit it for
+// review; an admin then approves, rejects or sends it back for changes. Only
+// verified doctors are listed to patients.
+const (
+	DoctorDraft            = "draft"
+	DoctorPending          = "pending"
+	DoctorChangesRequested = "changes-requested"
+	DoctorVerified         = "verified"
+	DoctorRejected         = "rejected"
+)
+
+type Verification struct {
+	Status      string     `json:"status" bson:"status"`
+	SubmittedAt *time.Time `json:"submitted_at,omitempty" bson:"submitted_at,omitempty"`
+	ReviewedAt  *time.Time `json:"reviewed_at,omitempty" bson:"reviewed_at,omitempty"`
+	ReviewedBy  string     `json:"reviewed_by,omitempty" bson:"reviewed_by,omitempty"`
+	Notes       string     `json:"notes,omitempty" bson:"notes,omitempty"`
+}
+
+// Credential is a document backing a doctor's profile, such as a scan of
+// their licence, stored as an attachment of the doctor's user account.
+type Credential struct {
+	AttachmentID string    `json:"attachment_id" bson:"attachment_id"`
+	Kind         string    `json:"kind" bson:"kind"`
+	FileName     string    `json:"file_name" bson:"file_name"`
+	UploadedAt   time.Time `json:"uploaded_at" bson:"uploaded_at"`
+}
+
+// GeoPoint is a GeoJSON point. Coordinates are longitude then latitude. On a
+// doctor it is the clinic, geocoded from ClinicAddress when not given.
+type GeoPoint struct {
+	Type        string    `json:"type" bson:"type"`
+	Coordinates []float64 `json:"coordinates" bson:"coordinates"`
+}
+
+// Schedule is a doctor's recurring weekly availability. Times are wall-clock
+// "15:04" in TimeZone, so slots follow daylight saving changes.
+type Schedule struct {
+	DoctorID    string              `json:"doctor_id" bson:"_id"`
+	TimeZone    string              `json:"time_zone" bson:"time_zone"`
+	SlotMinutes int                 `json:"slot_minutes" bson:"slot_minutes"`
+	Weekly      []WeeklyHours       `json:"weekly" bson:"weekly"`
+	Breaks      []WeeklyHours       `json:"breaks,omitempty" bson:"breaks,omitempty"`
+	Holidays    []Holiday           `json:"holidays,omitempty" bson:"holidays,omitempty"`
+	Exceptions  []ScheduleException `json:"exceptions,omitempty" bson:"exceptions,omitempty"`
+	Version     int64               `json:"version" bson:"version"`
+	UpdatedAt   time.Time           `json:"updated_at" bson:"updated_at"`
+}
+
+// WeeklyHours are hours on a day of the week such as "Monday". A break with
+// no day applies every day.
+type WeeklyHours struct {
+	Day   string `json:"day,omitempty" bson:"day,omitempty"`
+	Start string `json:"start" bson:"start"`
+	End   string `json:"end" bson:"end"`
+}
+
+// Holiday closes the whole of Date, a "2006-01-02" date.
+type Holiday struct {
+	Date string `json:"date" bson:"date"`
+	Name string `json:"name,omitempty" bson:"name,omitempty"`
+}
+
+// ScheduleException replaces the weekly hours of one date.
+type ScheduleException struct {
+	Date  string      `json:"date" bson:"date"`
+	Hours []TimeRange `json:"hours" bson:"hours"`
+	Note  string      `json:"note,omitempty" bson:"note,omitempty"`
+}
+
+// Slot is a bookable stretch of a doctor's time.
+type Slot struct {
+	Start time.Time `json:"start" bson:"start"`
+	End   time.Time `json:"end" bson:"end"`
+}
+
+const (
+	BookingRequested = "requested"
+	BookingConfirmed = "confirmed"
+	BookingCancelled = "cancelled"
+	BookingCompleted = "completed"
+	BookingNoShow    = "no-show"
+)
+
+// Booking is an appointment a patient booked in one of a doctor's slots.
+// Once completed it is recorded in the patient's MedicalHistory as an
+// Appointment. Holds are the instants, in steps of the schedule granularity,
+// the booking occupies; a unique index over them makes overlapping bookings
+// impossible, and they are dropped when the booking is cancelled.
+type Booking struct {
+	ID                 string     `json:"id" bson:"_id"`
+	UserID             string     `json:"user_id" bson:"user_id"`
+	DoctorID           string     `json:"doctor_id" bson:"doctor_id"`
+	DoctorName         string     `json:"doctor_name" bson:"doctor_name"`
+	Start              time.Time  `json:"start" bson:"start"`
+	End                time.Time  `json:"end" bson:"end"`
+	TimeZone           string     `json:"time_zone" bson:"time_zone"`
+	Status             string     `json:"status" bson:"status"`
+	Reason             string     `json:"reason,omitempty" bson:"reason,omitempty"`
+	Notes              string     `json:"notes,omitempty" bson:"notes,omitempty"`
+	CancelledBy        string     `json:"cancelled_by,omitempty" bson:"cancelled_by,omitempty"`
+	CancellationReason string     `json:"cancellation_reason,omitempty" bson:"cancellation_reason,omitempty"`
+	Reschedules        int        `json:"reschedules" bson:"reschedules"`
+	RecordedInHistory  bool       `json:"recorded_in_history" bson:"recorded_in_history"`
+	Holds              []string   `json:"-" bson:"holds,omitempty"`
+	Version            int64      `json:"version" bson:"version"`
+	CreatedAt          time.Time  `json:"created_at" bson:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at" bson:"updated_at"`
+	ConfirmedAt        *time.Time `json:"confirmed_at,omitempty" bson:"confirmed_at,omitempty"`
+	CancelledAt        *time.Time `json:"cancelled_at,omitempty" bson:"cancelled_at,omitempty"`
+	CompletedAt        *time.Time `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+
+	// The discount code entered when booking, redeemed when the invoice is
+	// issued.
+	DiscountCode string `json:"discount_code,omitempty" bson:"discount_code,omitempty"`
+}
+
+// CalendarFeed lets calendar apps subscribe to the appointments of a patient
+// or doctor through a secret URL. Only a hash of the token is kept, and
+// replacing or deleting the feed revokes the URL.
+type CalendarFeed struct {
+	ID        string    `json:"-" bson:"_id"`
+	Owner     string    `json:"owner" bson:"owner"`
+	OwnerID   string    `json:"owner_id" bson:"owner_id"`
+	TokenHash string    `json:"-" bson:"token_hash"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+)
+
+// Job is a unit of background work. A worker claims it by taking a lease
+// until LeaseUntil; a job whose lease ran out while running is claimed
+// again. A periodic job runs every IntervalSeconds and is never done.
+type Job struct {
+	ID              string            `json:"id" bson:"_id"`
+	Kind            string            `json:"kind" bson:"kind"`
+	Payload         map[string]string `json:"payload,omitempty" bson:"payload,omitempty"`
+	Status          string            `json:"status" bson:"status"`
+	RunAt           time.Time         `json:"run_at" bson:"run_at"`
+	Attempts        int               `json:"attempts" bson:"attempts"`
+	MaxAttempts     int               `json:"max_attempts" bson:"max_attempts"`
+	IntervalSeconds int64             `json:"interval_seconds,omitempty" bson:"interval_seconds,omitempty"`
+	LastError       string            `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	LeaseOwner      string            `json:"lease_owner,omitempty" bson:"lease_owner,omitempty"`
+	LeaseUntil      *time.Time        `json:"lease_until,omitempty" bson:"lease_until,omitempty"`
+	CreatedAt       time.Time         `json:"created_at" bson:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at" bson:"updated_at"`
+	FinishedAt      *time.Time        `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+}
+
+// DeadJob is a job that failed on every attempt, kept for inspection until
+// an admin retries or discards it.
+type DeadJob struct {
+	Job    `bson:",inline"`
+	DiedAt time.Time `json:"died_at" bson:"died_at"`
+}
+
+const (
+	InvoiceOpen              = "open"
+	InvoicePaid              = "paid"
+	InvoiceVoid              = "void"
+	InvoicePartiallyRefunded = "partially-refunded"
+	InvoiceRefunded          = "refunded"
+)
+
+// Invoice charges a patient the doctor's fee for a booking, one per
+// booking. Amounts are in the minor unit of Currency, such as cents, and
+// Total is Subtotal less DiscountTotal plus TaxTotal. PaymentID names the
+// provider's payment for it; AmountRefunded counts the refunds that have not
+// failed.
+type Invoice struct {
+	ID             string            `json:"id" bson:"_id"`
+	Number         string            `json:"number" bson:"number"`
+	BookingID      string            `json:"booking_id" bson:"booking_id"`
+	UserID         string            `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	DoctorID       string            `json:"doctor_id" bson:"doctor_id"`
+	Currency       string            `json:"currency" bson:"currency"`
+	Lines          []InvoiceLine     `json:"lines" bson:"lines"`
+	Discounts      []InvoiceDiscount `json:"discounts,omitempty" bson:"discounts,omitempty"`
+	Taxes          []TaxLine         `json:"taxes,omitempty" bson:"taxes,omitempty"`
+	Subtotal       int64             `json:"subtotal" bson:"subtotal"`
+	DiscountTotal  int64             `json:"discount_total" bson:"discount_total"`
+	TaxTotal       int64             `json:"tax_total" bson:"tax_total"`
+	Total          int64             `json:"total" bson:"total"`
+	AmountPaid     int64             `json:"amount_paid" bson:"amount_paid"`
+	AmountRefunded int64             `json:"amount_refunded" bson:"amount_refunded"`
+	Status         string            `json:"status" bson:"status"`
+	Provider       string            `json:"provider,omitempty" bson:"provider,omitempty"`
+	PaymentID      string            `json:"payment_id,omitempty" bson:"payment_id,omitempty"`
+	PaymentError   string            `json:"payment_error,omitempty" bson:"payment_error,omitempty"`
+	Refunds        []InvoiceRefund   `json:"refunds,omitempty" bson:"refunds,omitempty"`
+	Version        int64             `json:"version" bson:"version"`
+	IssuedAt       time.Time         `json:"issued_at" bson:"issued_at"`
+	UpdatedAt      time.Time         `json:"updated_at" bson:"updated_at"`
+	PaidAt         *time.Time        `json:"paid_at,omitempty" bson:"paid_at,omitempty"`
+	VoidedAt       *time.Time        `json:"voided_at,omitempty" bson:"voided_at,omitempty"`
+}
+
+type InvoiceLine struct {
+	Description string `json:"description" bson:"description"`
+	Quantity    int64  `json:"quantity" bson:"quantity"`
+	UnitAmount  int64  `json:"unit_amount" bson:"unit_amount"`
+	Amount      int64  `json:"amount" bson:"amount"`
+}
+
+type InvoiceDiscount struct {
+	Code        string `json:"code" bson:"code"`
+	Description string `json:"description,omitempty" bson:"description,omitempty"`
+	Amount      int64  `json:"amount" bson:"amount"`
+}
+
+// TaxLine is one tax charged on the discounted subtotal, at a rate in basis
+// points: 2000 is 20%.
+type TaxLine struct {
+	Name            string `json:"name" bson:"name"`
+	RateBasisPoints int64  `json:"rate_basis_points" bson:"rate_basis_points"`
+	Taxable         int64  `json:"taxable" bson:"taxable"`
+	Amount          int64  `json:"amount" bson:"amount"`
+}
+
+// InvoiceRefund is a refund of part or all of an invoice's payment, with
+// the provider's ID and status.
+type InvoiceRefund struct {
+	ID             string    `json:"id" bson:"id"`
+	Amount         int64     `json:"amount" bson:"amount"`
+	Reason         string    `json:"reason,omitempty" bson:"reason,omitempty"`
+	Status         string    `json:"status" bson:"status"`
+	IdempotencyKey string    `json:"-" bson:"idempotency_key,omitempty"`
 	CreatedAt      time.Time `json:"created_at" bson:"created_at"`
 }
 
+// Discount is a code patients enter when booking to take PercentOff or
+// AmountOff, in the minor unit of the billing currency, off their invoice.
+// MaxRedemptions of 0 means unlimited.
+type Discount struct {
+	Code           string     `json:"code" bson:"_id"`
+	Description    string     `json:"description,omitempty" bson:"description,omitempty"`
+	PercentOff     int64      `json:"percent_off,omitempty" bson:"percent_off,omitempty"`
+	AmountOff      int64      `json:"amount_off,omitempty" bson:"amount_off,omitempty"`
+	Currency       string     `json:"currency,omitempty" bson:"currency,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	MaxRedemptions int        `json:"max_redemptions" bson:"max_redemptions"`
+	Redemptions    int        `json:"redemptions" bson:"redemptions"`
+	Active         bool       `json:"active" bson:"active"`
+	CreatedAt      time.Time  `json:"created_at" bson:"created_at"`
+}
+
+type DiscountReq struct {
+	Code           string     `json:"code"`
+	Description    string     `json:"description"`
+	PercentOff     int64      `json:"percent_off"`
+	AmountOff      int64      `json:"amount_off"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	MaxRedemptions int        `json:"max_redemptions"`
+}
+
+// RefundReq asks for Amount, in the invoice's minor unit, to be refunded;
+// 0 refunds everything not refunded yet.
+type RefundReq struct {
+	Amount int64  `json:"amount"`
+	Reason string `json:"reason"`
+}
+
+const (
+	PaymentEventProcessing = "processing"
+	PaymentEventProcessed  = "processed"
+)
+
+// PaymentEvent records a webhook event from the payment provider, so one
+// delivered again after it was processed is not applied twice.
+type PaymentEvent struct {
+	ID          string     `json:"id" bson:"_id"`
+	Provider    string     `json:"provider" bson:"provider"`
+	Type        string     `json:"type" bson:"type"`
+	Status      string     `json:"status" bson:"status"`
+	ReceivedAt  time.Time  `json:"received_at" bson:"received_at"`
+	ProcessedAt *time.Time `json:"processed_at,omitempty" bson:"processed_at,omitempty"`
+}
+
+// Review states. Reviews are published straight away unless pre-moderation
+// holds them as pending for an admin. Enough abuse reports take a published
+// review down as flagged until an admin publishes or rejects it. Only
+// published reviews count towards the doctor's rating.
+const (
+	ReviewPending   = "pending"
+	ReviewPublished = "published"
+	ReviewFlagged   = "flagged"
+	ReviewRejected  = "rejected"
+)
+
+// Review is a patient's rating of a doctor they had a completed appointment
+// with, one per patient and doctor. BookingID names that appointment.
+// ReportsResolved is how many of the abuse reports an admin has already dealt
+// with, so only newer ones count towards flagging the review again.
+type Review struct {
+	ID              string       `json:"id" bson:"_id"`
+	DoctorID        string       `json:"doctor_id" bson:"doctor_id"`
+	UserID          string       `json:"user_id,omitempty" bson:"user_id"`
+	BookingID       string       `json:"booking_id,omitempty" bson:"booking_id"`
+	AuthorName      string       `json:"author_name" bson:"author_name"`
+	Rating          int          `json:"rating" bson:"rating"`
+	Comment         string       `json:"comment,omitempty" bson:"comment,omitempty"`
+	Status          string       `json:"status" bson:"status"`
+	Reply           *ReviewReply `json:"reply,omitempty" bson:"reply,omitempty"`
+	ReportCount     int          `json:"report_count,omitempty" bson:"report_count"`
+	ReportsResolved int          `json:"reports_resolved,omitempty" bson:"reports_resolved"`
+	ModerationNote  string       `json:"moderation_note,omitempty" bson:"moderation_note,omitempty"`
+	ModeratedBy     string       `json:"moderated_by,omitempty" bson:"moderated_by,omitempty"`
+	ModeratedAt     *time.Time   `json:"moderated_at,omitempty" bson:"moderated_at,omitempty"`
+	RatingSynced    bool         `json:"-" bson:"rating_synced"`
+	Version         int64        `json:"version" bson:"version"`
+	CreatedAt       time.Time    `json:"created_at" bson:"created_at"`
+	UpdatedAt       time.Time    `json:"updated_at" bson:"updated_at"`
+}
+
+type ReviewReply struct {
+	Text      string    `json:"text" bson:"text"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// ReviewReport is one user's abuse report about a review.
+type ReviewReport struct {
+	ID        string    `json:"id" bson:"_id"`
+	ReviewID  string    `json:"review_id" bson:"review_id"`
+	UserID    string    `json:"user_id" bson:"user_id"`
+	Reason    string    `json:"reason" bson:"reason"`
+	Details   string    `json:"details,omitempty" bson:"details,omitempty"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// DoctorRating is the ledger behind a doctor's rating. It holds the rating
+// each review adds, 0 for one that is not published, with the version of the
+// review it was taken from, and the sum and count of the non-zero ones. A
+// review change is applied only over an older version of the same review, so
+// a retried or late change is never counted twice or over a newer one.
+type DoctorRating struct {
+	DoctorID  string                 `json:"doctor_id" bson:"_id"`
+	Sum       int                    `json:"sum" bson:"sum"`
+	Count     int                    `json:"count" bson:"count"`
+	Reviews   map[string]RatedReview `json:"reviews" bson:"reviews"`
+	Version   int64                  `json:"version" bson:"version"`
+	UpdatedAt time.Time              `json:"updated_at" bson:"updated_at"`
+}
+
+type RatedReview struct {
+	Rating  int   `json:"rating" bson:"rating"`
+	Version int64 `json:"version" bson:"version"`
+}
+
+type ReviewReq struct {
+	Rating  int    `json:"rating"`
+	Comment string `json:"comment"`
+}
+
+type ReviewReplyReq struct {
+	Text string `json:"text"`
+}
+
+type ReviewReportReq struct {
+	Reason  string `json:"reason"`
+	Details string `json:"details"`
+}
+
+// ReviewModerationReq is an admin's decision on a pending or flagged review:
+// published or rejected, with an optional note to the author.
+type ReviewModerationReq struct {
+	Status string `json:"status"`
+	Note   string `json:"note"`
+}
+
+type BookingReq struct {
+	DoctorID     string    `json:"doctor_id"`
+	Start        time.Time `json:"start"`
+	Reason       string    `json:"reason"`
+	DiscountCode string    `json:"discount_code"`
+}
+
+// BookingUpdateReq carries the new start of a rescheduled booking, the
+// reason for a cancellation or a doctor's notes on a completed visit.
+type BookingUpdateReq struct {
+	Start  time.Time `json:"start"`
+	Reason string    `json:"reason"`
+	Notes  string    `json:"notes"`
+}
+
+type TimeRange struct {
+	Start string `json:"start" bson:"start"`
+	End   string `json:"end" bson:"end"`
+}
+
 type Social struct {
 	LinkedIn  string `json:"linkedin,omitempty" bson:"linkedin,omitempty"`
 	Twitter   string `json:"twitter,omitempty" bson:"twitter,omitempty"`
@@ -106,3 +614,94 @@ type Social struct {
 	Instagram string `json:"instagram,omitempty" bson:"instagram,omitempty"`
 	Website   string `json:"website,omitempty" bson:"website,omitempty"`
 }
+
+type LabObservation struct {
+	ID              string    `json:"id" bson:"_id"`
+	UserID          string    `json:"user_id" bson:"user_id"`
+	Code            string    `json:"code" bson:"code"`
+	Display         string    `json:"display" bson:"display"`
+	CodingSystem    string    `json:"coding_system,omitempty" bson:"coding_system,omitempty"`
+	Value           string    `json:"value" bson:"value"`
+	NumericValue    *float64  `json:"numeric_value,omitempty" bson:"numeric_value,omitempty"`
+	Unit            string    `json:"unit,omitempty" bson:"unit,omitempty"`
+	ReferenceRange  string    `json:"reference_range,omitempty" bson:"reference_range,omitempty"`
+	AbnormalFlag    string    `json:"abnormal_flag,omitempty" bson:"abnormal_flag,omitempty"`
+	Status          string    `json:"status" bson:"status"`
+	ObservedAt      time.Time `json:"observed_at" bson:"observed_at"`
+	SourceMessageID string    `json:"source_message_id,omitempty" bson:"source_message_id,omitempty"`
+	CreatedAt       time.Time `json:"created_at" bson:"created_at"`
+}
+
+// VitalSign is one measurement. Blood pressure keeps the systolic pressure in
+// Value and the diastolic in Diastolic; every other type uses Value only.
+type VitalSign struct {
+	ID         string    `json:"id" bson:"_id"`
+	UserID     string    `json:"user_id" bson:"user_id"`
+	Type       string    `json:"type" bson:"type"`
+	Value      float64   `json:"value" bson:"value"`
+	Diastolic  *float64  `json:"diastolic,omitempty" bson:"diastolic,omitempty"`
+	Unit       string    `json:"unit" bson:"unit"`
+	MeasuredAt time.Time `json:"measured_at" bson:"measured_at"`
+	Notes      string    `json:"notes,omitempty" bson:"notes,omitempty"`
+	CreatedAt  time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Attachment is an uploaded document. Its contents live in the blob store
+// under StorageKey, sealed with the owner's data key when Encrypted; issues
+// and appointments refer to it by ID.
+type Attachment struct {
+	ID          string    `json:"id" bson:"_id"`
+	UserID      string    `json:"user_id" bson:"user_id"`
+	FileName    string    `json:"file_name" bson:"file_name"`
+	ContentType string    `json:"content_type" bson:"content_type"`
+	Size        int64     `json:"size" bson:"size"`
+	SHA256      string    `json:"sha256" bson:"sha256"`
+	Description string    `json:"description,omitempty" bson:"description,omitempty"`
+	StorageKey  string    `json:"-" bson:"storage_key"`
+	Encrypted   bool      `json:"-" bson:"encrypted,omitempty"`
+	Scanned     bool      `json:"scanned" bson:"scanned"`
+	UploadedAt  time.Time `json:"uploaded_at" bson:"uploaded_at"`
+}
+
+type HL7DeadLetter struct {
+	ID                 string    `json:"id" bson:"_id"`
+	UserID             string    `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	ControlID          string    `json:"control_id,omitempty" bson:"control_id,omitempty"`
+	MessageType        string    `json:"message_type,omitempty" bson:"message_type,omitempty"`
+	SendingApplication string    `json:"sending_application,omitempty" bson:"sending_application,omitempty"`
+	SendingFacility    string    `json:"sending_facility,omitempty" bson:"sending_facility,omitempty"`
+	Error              string    `json:"error" bson:"error"`
+	ReceivedAt         time.Time `json:"received_at" bson:"received_at"`
+}
+
+// HealthSummary records a generated PDF summary so the QR code printed on it
+// can be checked. Only the hash of the document is kept, not its contents.
+type HealthSummary struct {
+	ID          string    `json:"id" bson:"_id"`
+	UserID      string    `json:"user_id" bson:"user_id"`
+	SHA256      string    `json:"sha256" bson:"sha256"`
+	GeneratedAt time.Time `json:"generated_at" bson:"generated_at"`
+}
+
+const (
+	AuditAccess           = "access"
+	AuditErasureRequested = "erasure-requested"
+	AuditErasureCancelled = "erasure-cancelled"
+	AuditErased           = "erased"
+)
+
+// AuditEntry records who touched whose records. Erased entries are the
+// tombstones kept after an account is erased; they only say how many records
+// were removed from where.
+type AuditEntry struct {
+	ID        string           `json:"id" bson:"_id"`
+	Type      string           `json:"type" bson:"type"`
+	ActorID   string           `json:"actor_id,omitempty" bson:"actor_id,omitempty"`
+	SubjectID string           `json:"subject_id" bson:"subject_id"`
+	Method    string           `json:"method,omitempty" bson:"method,omitempty"`
+	Path      string           `json:"path,omitempty" bson:"path,omitempty"`
+	Status    int              `json:"status,omitempty" bson:"status,omitempty"`
+	IP        string           `json:"ip,omitempty" bson:"ip,omitempty"`
+	Records   map[string]int64 `json:"records,omitempty" bson:"records,omitempty"`
+	At        time.Time        `json:"at" bson:"at"`
+}
@@ -0,0 +1,175 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"life-signal/database"
+	"life-signal/ical"
+	"life-signal/jobs"
+	"life-signal/models"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Changes to a booking that patients and doctors are told about.
+const (
+	BookingBooked      = "booked"
+	BookingConfirmed   = "confirmed"
+	BookingRescheduled = "rescheduled"
+	BookingCancelled   = "cancelled"
+)
+
+// bookingTime formats the start of a booking in the doctor's time zone.
+func bookingTime(booking models.Booking) string {
+	location, err := time.LoadLocation(booking.TimeZone)
+	if err != nil {
+		location = time.UTC
+	}
+	return booking.Start.In(location).Format("Mon 2 Jan 2006 15:04 MST")
+}
+
+// bookingSubject is the subject of the message about change for audience.
+func bookingSubject(booking models.Booking, change, audience string) string {
+	when := bookingTime(booking)
+	switch change {
+	case BookingBooked:
+		if audience == ical.ForDoctor {
+			return "New appointment request for " + when
+		}
+		return fmt.Sprintf("Appointment requested with %s on %s", booking.DoctorName, when)
+	case BookingConfirmed:
+		return fmt.Sprintf("Appointment confirmed with %s on %s", booking.DoctorName, when)
+	case BookingRescheduled:
+		return "Appointment moved to " + when
+	default:
+		return "Appointment cancelled: " + when
+	}
+}
+
+// BookingInvite returns booking as an iTIP invitation, or a cancellation
+// once the booking is cancelled, with the content type to send it as. The
+// patient is listed as attendee on their own copy only.
+func BookingInvite(booking models.Booking, doctor *models.Doctor, audience string, patient ical.Person) ([]byte, string, error) {
+	method := ical.MethodRequest
+	if booking.Status == models.BookingCancelled {
+		method = ical.MethodCancel
+	}
+	event := ical.BookingEvent(booking, doctor, audience)
+	if audience == ical.ForPatient && patient.Email != "" {
+		event.Attendees = []ical.Person{patient}
+	}
+	data, err := ical.Encode(ical.Calendar{Method: method, Events: []ical.Event{event}})
+	if err != nil {
+		return nil, "", err
+	}
+	return data, ical.ContentType + "; method=" + method, nil
+}
+
+// currentChange is change adjusted to booking as it is now, which may have
+// moved on since the change was queued: a booking cancelled in the meantime
+// is announced as cancelled, so the subject always matches the invitation.
+func currentChange(booking models.Booking, change string) string {
+	switch booking.Status {
+	case models.BookingCancelled:
+		return BookingCancelled
+	case models.BookingConfirmed:
+		if change == BookingBooked || change == BookingCancelled {
+			return BookingConfirmed
+		}
+	case models.BookingRequested:
+		if change == BookingConfirmed || change == BookingCancelled {
+			return BookingBooked
+		}
+	}
+	return change
+}
+
+// SendBookingUpdate tells the patient and, except for their own
+// confirmations, the doctor about change, attaching the invitation so their
+// calendars follow the booking. Audiences in sent are skipped, and onSent is
+// called after each message goes out.
+func SendBookingUpdate(ctx context.Context, db *mongo.Client, booking models.Booking, change string, sent map[string]bool, onSent func(audience string) error) error {
+	var doctor *models.Doctor
+	var found models.Doctor
+	err := database.GetCollection(db, "life-signal", "doctors").FindOne(ctx, bson.M{"_id": booking.DoctorID}).Decode(&found)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return fmt.Errorf("failed to find doctor: %w", err)
+	}
+	if err == nil {
+		doctor = &found
+	}
+	recipients := map[string]string{}
+	var patient ical.Person
+	user, err := database.FindUser(ctx, database.GetCollection(db, "life-signal", "users"), bson.M{"_id": booking.UserID})
+	if err != nil && err != mongo.ErrNoDocuments {
+		return fmt.Errorf("failed to find patient: %w", err)
+	}
+	if err == nil && user.Email != "" {
+		recipients[ical.ForPatient] = user.Email
+		patient = ical.Person{Name: strings.TrimSpace(user.FirstName + " " + user.LastName), Email: user.Email}
+	}
+	if doctor != nil && doctor.Email != "" && change != BookingConfirmed {
+		recipients[ical.ForDoctor] = doctor.Email
+	}
+
+	for _, audience := range []string{ical.ForPatient, ical.ForDoctor} {
+		email, ok := recipients[audience]
+		if !ok || sent[audience] {
+			continue
+		}
+		invite, contentType, err := BookingInvite(booking, doctor, audience, patient)
+		if err != nil {
+			return err
+		}
+		msg := Message{
+			To:          []string{email},
+			Subject:     bookingSubject(booking, change, audience),
+			Body:        bookingSubject(booking, change, audience) + ".\n\nThe attached invitation adds the appointment to your calendar and keeps it up to date.",
+			Attachments: []Attachment{{FileName: "invite.ics", ContentType: contentType, Data: invite}},
+		}
+		if err := Current().Send(ctx, msg); err != nil {
+			return err
+		}
+		if onSent != nil {
+			if err := onSent(audience); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+const bookingUpdateJob = "booking-update"
+
+// QueueBookingUpdate sends a booking update through the job queue, so a mail
+// server that is down delays the message rather than losing it. The message
+// describes the booking as it is when the job runs.
+func QueueBookingUpdate(ctx context.Context, db *mongo.Client, booking models.Booking, change string) error {
+	key := fmt.Sprintf("%s:%d", booking.ID, booking.Version)
+	return jobs.Enqueue(ctx, db, bookingUpdateJob, key, time.Now(), map[string]string{"booking_id": booking.ID, "change": change})
+}
+
+// Register adds the notification jobs to s. A booking update records each
+// recipient it reached in the job, so a retry only sends to the others.
+func Register(s *jobs.Scheduler) {
+	s.Handle(bookingUpdateJob, func(ctx context.Context, job *models.Job) error {
+		booking, err := database.FindBooking(ctx, database.GetCollection(s.DB, "life-signal", "appointments"), bson.M{"_id": job.Payload["booking_id"]})
+		if err != nil {
+			return err
+		}
+		// The booking was erased with its patient's account.
+		if booking == nil {
+			return nil
+		}
+		sent := map[string]bool{}
+		for _, audience := range []string{ical.ForPatient, ical.ForDoctor} {
+			sent[audience] = job.Payload["sent_"+audience] != ""
+		}
+		return SendBookingUpdate(ctx, s.DB, *booking, currentChange(*booking, job.Payload["change"]), sent, func(audience string) error {
+			return jobs.SetPayload(ctx, s.DB, job, "sent_"+audience, time.Now().UTC().Format(time.RFC3339))
+		})
+	})
+}
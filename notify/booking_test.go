@@ -0,0 +1,39 @@
+package notify
+
+import (
+	"life-signal/ical"
+	"life-signal/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCurrentChangeMatchesInvite(t *testing.T) {
+	tests := []struct {
+		status, queued, want string
+	}{
+		{models.BookingRequested, BookingBooked, BookingBooked},
+		{models.BookingRequested, BookingRescheduled, BookingRescheduled},
+		{models.BookingConfirmed, BookingConfirmed, BookingConfirmed},
+		{models.BookingConfirmed, BookingBooked, BookingConfirmed},
+		{models.BookingCancelled, BookingConfirmed, BookingCancelled},
+		{models.BookingCancelled, BookingRescheduled, BookingCancelled},
+	}
+	start := time.Date(2026, 10, 20, 9, 30, 0, 0, time.UTC)
+	for _, test := range tests {
+		booking := models.Booking{ID: "b1", DoctorName: "Dr. Mehta", Status: test.status, Start: start, End: start.Add(30 * time.Minute), TimeZone: "UTC"}
+		change := currentChange(booking, test.queued)
+		if change != test.want {
+			t.Errorf("%s booking queued as %s: change %s, want %s", test.status, test.queued, change, test.want)
+		}
+		_, contentType, err := BookingInvite(booking, nil, ical.ForPatient, ical.Person{})
+		if err != nil {
+			t.Fatalf("BookingInvite: %v", err)
+		}
+		cancelling := strings.HasSuffix(contentType, "method="+ical.MethodCancel)
+		subject := bookingSubject(booking, change, ical.ForPatient)
+		if cancelling != strings.HasPrefix(subject, "Appointment cancelled") {
+			t.Errorf("%s booking: subject %q sent with %s", test.status, subject, contentType)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+// Package notify delivers messages to patients and doctors.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a notification to one or more email addresses.
+type Message struct {
+	To          []string
+	Subject     string
+	Body        string
+	Attachments []Attachment
+}
+
+// Attachment is a file sent with a message. ContentType may carry
+// parameters, such as the method of a calendar invitation.
+type Attachment struct {
+	FileName    string
+	ContentType string
+	Data        []byte
+}
+
+// Notifier sends messages.
+type Notifier interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+var (
+	current     Notifier
+	currentOnce sync.Once
+	currentMu   sync.RWMutex
+)
+
+// Init configures the notifier from the environment: NOTIFIER=log (default)
+// only logs messages, NOTIFIER=smtp sends email through SMTP_ADDR as
+// SMTP_FROM, authenticating with SMTP_USERNAME and SMTP_PASSWORD when set.
+func Init() error {
+	var notifier Notifier
+	switch kind := strings.ToLower(os.Getenv("NOTIFIER")); kind {
+	case "", "log":
+		notifier = LogNotifier{}
+	case "smtp":
+		addr, from := os.Getenv("SMTP_ADDR"), os.Getenv("SMTP_FROM")
+		if addr == "" || from == "" {
+			return fmt.Errorf("SMTP_ADDR and SMTP_FROM are required for the smtp notifier")
+		}
+		notifier = &SMTPNotifier{
+			Addr:     addr,
+			From:     from,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}
+	default:
+		return fmt.Errorf("unknown NOTIFIER %q", kind)
+	}
+	Configure(notifier)
+	return nil
+}
+
+// Configure replaces the notifier in use.
+func Configure(notifier Notifier) {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	current = notifier
+}
+
+// Current returns the configured notifier, falling back to LogNotifier when
+// Init was never called, as in CLI commands that do not send anything.
+func Current() Notifier {
+	currentOnce.Do(func() {
+		currentMu.Lock()
+		defer currentMu.Unlock()
+		if current == nil {
+			current = LogNotifier{}
+		}
+	})
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+	return current
+}
+
+// LogNotifier writes messages to the log instead of sending them.
+type LogNotifier struct{}
+
+func (LogNotifier) Send(ctx context.Context, msg Message) error {
+	names := make([]string, len(msg.Attachments))
+	for i, attachment := range msg.Attachments {
+		names[i] = attachment.FileName
+	}
+	slog.Info("Notification", "to", msg.To, "subject", msg.Subject, "attachments", names)
+	return nil
+}
+
+// SMTPNotifier sends messages as MIME email.
+type SMTPNotifier struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func (n *SMTPNotifier) Send(ctx context.Context, msg Message) error {
+	if len(msg.To) == 0 {
+		return nil
+	}
+	data, err := n.compose(msg)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if n.Username != "" {
+		host, _, err := net.SplitHostPort(n.Addr)
+		if err != nil {
+			return fmt.Errorf("invalid SMTP_ADDR: %w", err)
+		}
+		auth = smtp.PlainAuth("", n.Username, n.Password, host)
+	}
+	// net/smtp takes no context, so the send runs to completion or failure.
+	if err := smtp.SendMail(n.Addr, auth, n.From, msg.To, data); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// compose builds a multipart/mixed email with the body as text and every
+// attachment base64 encoded.
+func (n *SMTPNotifier) compose(msg Message) ([]byte, error) {
+	var b bytes.Buffer
+	body := multipart.NewWriter(&b)
+	fmt.Fprintf(&b, "From: %s\r\n", n.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(msg.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", body.Boundary())
+
+	text, err := body.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := writeBase64(text, []byte(msg.Body)); err != nil {
+		return nil, err
+	}
+	for _, attachment := range msg.Attachments {
+		part, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {attachment.ContentType},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.FileName})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeBase64(part, attachment.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// writeBase64 writes data base64 encoded in lines of 76 characters.
+func writeBase64(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		if _, err := w.Write([]byte(encoded[:76] + "\r\n")); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err := w.Write([]byte(encoded + "\r\n"))
+	return err
+}
@@ -0,0 +1,27 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"life-signal/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// SendToUser sends a message to the email address of a user. Users who have
+// been erased or have no address are skipped.
+func SendToUser(ctx context.Context, db *mongo.Client, userID string, msg Message) error {
+	user, err := database.FindUser(ctx, database.GetCollection(db, "life-signal", "users"), bson.M{"_id": userID})
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find user: %w", err)
+	}
+	if user.Email == "" {
+		return nil
+	}
+	msg.To = []string{user.Email}
+	return Current().Send(ctx, msg)
+}
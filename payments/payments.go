@@ -0,0 +1,185 @@
+// Package payments collects and refunds payments through a payment provider.
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotConfigured    = errors.New("no payment provider is configured")
+	ErrInvalidSignature = errors.New("webhook signature is invalid")
+)
+
+// Event types the provider sends webhooks for. Providers other than Stripe
+// translate their own events to these.
+const (
+	EventPaymentSucceeded = "payment_intent.succeeded"
+	EventPaymentFailed    = "payment_intent.payment_failed"
+	EventPaymentCanceled  = "payment_intent.canceled"
+	EventRefundCreated    = "refund.created"
+	EventRefundUpdated    = "refund.updated"
+	EventChargeRefund     = "charge.refund.updated"
+)
+
+// Payment statuses the rest of the service looks at.
+const (
+	PaymentSucceeded = "succeeded"
+	PaymentCanceled  = "canceled"
+	RefundFailed     = "failed"
+	RefundCanceled   = "canceled"
+)
+
+// Payment is an amount the provider is asked to collect. The patient's
+// client completes it with ClientSecret.
+type Payment struct {
+	ID           string
+	Status       string
+	Amount       int64
+	Currency     string
+	ClientSecret string
+}
+
+// PaymentReq asks for Amount, in the minor unit of Currency, to be
+// collected. The provider returns the same payment for the same
+// IdempotencyKey instead of creating another.
+type PaymentReq struct {
+	Amount         int64
+	Currency       string
+	Description    string
+	Metadata       map[string]string
+	IdempotencyKey string
+}
+
+type Refund struct {
+	ID        string
+	PaymentID string
+	Status    string
+	Amount    int64
+	Metadata  map[string]string
+}
+
+// RefundReq asks for Amount of the payment PaymentID to be refunded, with
+// the same idempotency as PaymentReq.
+type RefundReq struct {
+	PaymentID      string
+	Amount         int64
+	Reason         string
+	Metadata       map[string]string
+	IdempotencyKey string
+}
+
+// Event is a webhook from the provider whose signature has been checked.
+// Payment is set for payment events and Refund for refund events.
+type Event struct {
+	ID      string
+	Type    string
+	Created time.Time
+	Payment *EventPayment
+	Refund  *Refund
+}
+
+type EventPayment struct {
+	ID             string
+	Status         string
+	Amount         int64
+	AmountReceived int64
+	Currency       string
+	Metadata       map[string]string
+	FailureMessage string
+}
+
+// Provider is a payment service.
+type Provider interface {
+	Name() string
+	CreatePayment(ctx context.Context, req PaymentReq) (*Payment, error)
+	GetPayment(ctx context.Context, id string) (*Payment, error)
+	CancelPayment(ctx context.Context, id, idempotencyKey string) error
+	Refund(ctx context.Context, req RefundReq) (*Refund, error)
+	// ParseWebhook checks the signature of a webhook request and decodes
+	// it, failing with ErrInvalidSignature when the check fails.
+	ParseWebhook(payload []byte, header http.Header) (*Event, error)
+}
+
+var (
+	current     Provider
+	currentOnce sync.Once
+	currentMu   sync.RWMutex
+)
+
+// Init configures the provider from the environment. PAYMENT_PROVIDER=none
+// (default) takes no payments; PAYMENT_PROVIDER=stripe uses the Stripe API
+// at STRIPE_API_BASE, api.stripe.com by default, with STRIPE_SECRET_KEY and
+// checks webhooks against STRIPE_WEBHOOK_SECRET. Pointing STRIPE_API_BASE at
+// a local mock of the API lets it stand in for Stripe.
+func Init() error {
+	var provider Provider
+	switch kind := strings.ToLower(os.Getenv("PAYMENT_PROVIDER")); kind {
+	case "", "none":
+		provider = None{}
+	case "stripe":
+		secretKey, webhookSecret := os.Getenv("STRIPE_SECRET_KEY"), os.Getenv("STRIPE_WEBHOOK_SECRET")
+		if secretKey == "" || webhookSecret == "" {
+			return fmt.Errorf("STRIPE_SECRET_KEY and STRIPE_WEBHOOK_SECRET are required for the stripe provider")
+		}
+		provider = NewStripe(os.Getenv("STRIPE_API_BASE"), secretKey, webhookSecret)
+	default:
+		return fmt.Errorf("unknown PAYMENT_PROVIDER %q, expected none or stripe", kind)
+	}
+	Configure(provider)
+	return nil
+}
+
+// Configure replaces the provider in use.
+func Configure(provider Provider) {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	current = provider
+}
+
+// Current returns the configured provider, falling back to None when Init
+// was never called.
+func Current() Provider {
+	currentOnce.Do(func() {
+		currentMu.Lock()
+		defer currentMu.Unlock()
+		if current == nil {
+			current = None{}
+		}
+	})
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+	return current
+}
+
+// None is the provider when payments are not set up. Invoices are still
+// issued, but cannot be paid online.
+type None struct{}
+
+func (None) Name() string { return "none" }
+
+func (None) CreatePayment(ctx context.Context, req PaymentReq) (*Payment, error) {
+	return nil, ErrNotConfigured
+}
+
+func (None) GetPayment(ctx context.Context, id string) (*Payment, error) {
+	return nil, ErrNotConfigured
+}
+
+func (None) CancelPayment(ctx context.Context, id, idempotencyKey string) error {
+	return ErrNotConfigured
+}
+
+func (None) Refund(ctx context.Context, req RefundReq) (*Refund, error) {
+	return nil, ErrNotConfigured
+}
+
+func (None) ParseWebhook(payload []byte, header http.Header) (*Event, error) {
+	return nil, ErrNotConfigured
+}
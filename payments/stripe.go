@@ -0,0 +1,283 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultStripeBase = "https://api.stripe.com"
+	// signatureTolerance is how old a webhook may be, which bounds how long
+	// a captured one can be replayed.
+	signatureTolerance = 5 * time.Minute
+	maxStripeResponse  = 1 << 20
+)
+
+// Stripe is a Provider speaking the Stripe API at BaseURL. Payments are
+// payment intents, and webhooks are signed with WebhookSecret as described
+// for the Stripe-Signature header.
+type Stripe struct {
+	BaseURL       string
+	SecretKey     string
+	WebhookSecret string
+	Tolerance     time.Duration
+	Client        *http.Client
+}
+
+// NewStripe returns a Stripe provider for the API at baseURL, or Stripe's
+// own when it is empty.
+func NewStripe(baseURL, secretKey, webhookSecret string) *Stripe {
+	if baseURL == "" {
+		baseURL = defaultStripeBase
+	}
+	return &Stripe{
+		BaseURL:       strings.TrimRight(baseURL, "/"),
+		SecretKey:     secretKey,
+		WebhookSecret: webhookSecret,
+		Tolerance:     signatureTolerance,
+		Client:        &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// APIError is an error answered by the Stripe API.
+type APIError struct {
+	Status  int
+	Type    string
+	Code    string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("stripe: %s (status %d, %s %s)", e.Message, e.Status, e.Type, e.Code)
+}
+
+type stripeIntent struct {
+	ID               string            `json:"id"`
+	Status           string            `json:"status"`
+	Amount           int64             `json:"amount"`
+	AmountReceived   int64             `json:"amount_received"`
+	Currency         string            `json:"currency"`
+	ClientSecret     string            `json:"client_secret"`
+	Metadata         map[string]string `json:"metadata"`
+	LastPaymentError *struct {
+		Message string `json:"message"`
+	} `json:"last_payment_error"`
+}
+
+func (i *stripeIntent) payment() *Payment {
+	return &Payment{ID: i.ID, Status: i.Status, Amount: i.Amount, Currency: strings.ToUpper(i.Currency), ClientSecret: i.ClientSecret}
+}
+
+type stripeRefund struct {
+	ID            string            `json:"id"`
+	Status        string            `json:"status"`
+	Amount        int64             `json:"amount"`
+	PaymentIntent string            `json:"payment_intent"`
+	Metadata      map[string]string `json:"metadata"`
+}
+
+func (r *stripeRefund) refund() *Refund {
+	return &Refund{ID: r.ID, PaymentID: r.PaymentIntent, Status: r.Status, Amount: r.Amount, Metadata: r.Metadata}
+}
+
+func (s *Stripe) Name() string { return "stripe" }
+
+// do sends a form-encoded request to the API and decodes the answer into
+// out.
+func (s *Stripe) do(ctx context.Context, method, path string, form url.Values, idempotencyKey string, out any) error {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.BaseURL+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to build stripe request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+s.SecretKey)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("stripe request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxStripeResponse))
+	if err != nil {
+		return fmt.Errorf("failed to read stripe response: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Error struct {
+				Type    string `json:"type"`
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		_ = json.Unmarshal(data, &failure)
+		return &APIError{Status: resp.StatusCode, Type: failure.Error.Type, Code: failure.Error.Code, Message: failure.Error.Message}
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to parse stripe response: %w", err)
+	}
+	return nil
+}
+
+func setMetadata(form url.Values, metadata map[string]string) {
+	for key, value := range metadata {
+		form.Set("metadata["+key+"]", value)
+	}
+}
+
+func (s *Stripe) CreatePayment(ctx context.Context, req PaymentReq) (*Payment, error) {
+	form := url.Values{}
+	form.Set("amount", strconv.FormatInt(req.Amount, 10))
+	form.Set("currency", strings.ToLower(req.Currency))
+	form.Set("automatic_payment_methods[enabled]", "true")
+	if req.Description != "" {
+		form.Set("description", req.Description)
+	}
+	setMetadata(form, req.Metadata)
+	var intent stripeIntent
+	if err := s.do(ctx, http.MethodPost, "/v1/payment_intents", form, req.IdempotencyKey, &intent); err != nil {
+		return nil, err
+	}
+	return intent.payment(), nil
+}
+
+func (s *Stripe) GetPayment(ctx context.Context, id string) (*Payment, error) {
+	var intent stripeIntent
+	if err := s.do(ctx, http.MethodGet, "/v1/payment_intents/"+url.PathEscape(id), nil, "", &intent); err != nil {
+		return nil, err
+	}
+	return intent.payment(), nil
+}
+
+func (s *Stripe) CancelPayment(ctx context.Context, id, idempotencyKey string) error {
+	var intent stripeIntent
+	return s.do(ctx, http.MethodPost, "/v1/payment_intents/"+url.PathEscape(id)+"/cancel", url.Values{}, idempotencyKey, &intent)
+}
+
+// Refund refunds as requested by the customer; the service's own reason is
+// kept in the refund's metadata.
+func (s *Stripe) Refund(ctx context.Context, req RefundReq) (*Refund, error) {
+	form := url.Values{}
+	form.Set("payment_intent", req.PaymentID)
+	form.Set("amount", strconv.FormatInt(req.Amount, 10))
+	form.Set("reason", "requested_by_customer")
+	metadata := map[string]string{"reason": req.Reason}
+	for key, value := range req.Metadata {
+		metadata[key] = value
+	}
+	setMetadata(form, metadata)
+	var refund stripeRefund
+	if err := s.do(ctx, http.MethodPost, "/v1/refunds", form, req.IdempotencyKey, &refund); err != nil {
+		return nil, err
+	}
+	return refund.refund(), nil
+}
+
+func (s *Stripe) ParseWebhook(payload []byte, header http.Header) (*Event, error) {
+	if err := s.verify(payload, header.Get("Stripe-Signature"), time.Now()); err != nil {
+		return nil, err
+	}
+	var raw struct {
+		ID      string `json:"id"`
+		Type    string `json:"type"`
+		Created int64  `json:"created"`
+		Data    struct {
+			Object json.RawMessage `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse stripe event: %w", err)
+	}
+	if raw.ID == "" || raw.Type == "" {
+		return nil, fmt.Errorf("stripe event has no id or type")
+	}
+	event := &Event{ID: raw.ID, Type: raw.Type, Created: time.Unix(raw.Created, 0).UTC()}
+	switch {
+	case strings.HasPrefix(raw.Type, "payment_intent."):
+		var intent stripeIntent
+		if err := json.Unmarshal(raw.Data.Object, &intent); err != nil {
+			return nil, fmt.Errorf("failed to parse stripe payment intent: %w", err)
+		}
+		event.Payment = &EventPayment{
+			ID:             intent.ID,
+			Status:         intent.Status,
+			Amount:         intent.Amount,
+			AmountReceived: intent.AmountReceived,
+			Currency:       strings.ToUpper(intent.Currency),
+			Metadata:       intent.Metadata,
+		}
+		if intent.LastPaymentError != nil {
+			event.Payment.FailureMessage = intent.LastPaymentError.Message
+		}
+	case raw.Type == EventRefundCreated || raw.Type == EventRefundUpdated || raw.Type == EventChargeRefund:
+		var refund stripeRefund
+		if err := json.Unmarshal(raw.Data.Object, &refund); err != nil {
+			return nil, fmt.Errorf("failed to parse stripe refund: %w", err)
+		}
+		event.Refund = refund.refund()
+	}
+	return event, nil
+}
+
+// verify checks a Stripe-Signature header of the form t=<unix time>,
+// v1=<hex HMAC-SHA256 of "<t>.<payload>">. Any of several v1 signatures
+// may match, as during a secret rotation, and t must be within Tolerance of
+// now.
+func (s *Stripe) verify(payload []byte, header string, now time.Time) error {
+	var timestamp int64
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp, _ = strconv.ParseInt(value, 10, 64)
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == 0 || len(signatures) == 0 {
+		return fmt.Errorf("%w: no timestamp or signature", ErrInvalidSignature)
+	}
+	age := now.Sub(time.Unix(timestamp, 0))
+	if age > s.Tolerance || age < -s.Tolerance {
+		return fmt.Errorf("%w: timestamp is outside the tolerance", ErrInvalidSignature)
+	}
+	expected := s.signature(timestamp, payload)
+	for _, signature := range signatures {
+		decoded, err := hex.DecodeString(signature)
+		if err == nil && hmac.Equal(decoded, expected) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no signature matches", ErrInvalidSignature)
+}
+
+func (s *Stripe) signature(timestamp int64, payload []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(s.WebhookSecret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// Sign returns the Stripe-Signature header for payload sent at at, for a
+// local mock of the API to sign the webhooks it sends.
+func (s *Stripe) Sign(payload []byte, at time.Time) string {
+	return fmt.Sprintf("t=%d,v1=%s", at.Unix(), hex.EncodeToString(s.signature(at.Unix(), payload)))
+}
@@ -0,0 +1,109 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testEvent = `{"id":"evt_1","type":"payment_intent.succeeded","created":1792411200,` +
+	`"data":{"object":{"id":"pi_1","status":"succeeded","amount":5000,"amount_received":5000,"currency":"usd","metadata":{"invoice_id":"inv-1"}}}}`
+
+func TestStripeVerify(t *testing.T) {
+	stripe := NewStripe("", "sk_test", "whsec_current")
+	rotated := NewStripe("", "sk_test", "whsec_previous")
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	payload := []byte(testEvent)
+	valid := stripe.Sign(payload, now)
+	_, validSignature, _ := strings.Cut(valid, ",")
+	_, oldSignature, _ := strings.Cut(rotated.Sign(payload, now), ",")
+
+	tests := []struct {
+		name    string
+		payload []byte
+		header  string
+		ok      bool
+	}{
+		{"valid", payload, valid, true},
+		{"a few minutes old", payload, stripe.Sign(payload, now.Add(-4*time.Minute)), true},
+		{"older than the tolerance", payload, stripe.Sign(payload, now.Add(-6*time.Minute)), false},
+		{"too far in the future", payload, stripe.Sign(payload, now.Add(6*time.Minute)), false},
+		{"one of several signatures matches", payload, valid + "," + oldSignature, true},
+		{"matching signature listed last", payload, "t=" + timestamp(now) + "," + oldSignature + "," + validSignature, true},
+		{"only a signature from another secret", payload, rotated.Sign(payload, now), false},
+		{"tampered body", []byte(strings.Replace(testEvent, "5000", "1", 1)), valid, false},
+		{"timestamp changed", payload, strings.Replace(valid, "t="+timestamp(now), "t="+timestamp(now.Add(time.Second)), 1), false},
+		{"no timestamp", payload, validSignature, false},
+		{"no signature", payload, "t=" + timestamp(now), false},
+		{"signature not hex", payload, "t=" + timestamp(now) + ",v1=zz", false},
+		{"empty header", payload, "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := stripe.verify(test.payload, test.header, now)
+			if test.ok && err != nil {
+				t.Errorf("verify = %v, want nil", err)
+			}
+			if !test.ok && !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("verify = %v, want ErrInvalidSignature", err)
+			}
+		})
+	}
+}
+
+func timestamp(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+func TestStripeParseWebhook(t *testing.T) {
+	stripe := NewStripe("", "sk_test", "whsec_current")
+	header := http.Header{}
+	header.Set("Stripe-Signature", stripe.Sign([]byte(testEvent), time.Now()))
+	event, err := stripe.ParseWebhook([]byte(testEvent), header)
+	if err != nil {
+		t.Fatalf("ParseWebhook: %v", err)
+	}
+	if event.ID != "evt_1" || event.Type != EventPaymentSucceeded || event.Payment == nil {
+		t.Fatalf("event %+v, want a payment_intent.succeeded with its payment", event)
+	}
+	if p := event.Payment; p.ID != "pi_1" || p.AmountReceived != 5000 || p.Currency != "USD" || p.Metadata["invoice_id"] != "inv-1" {
+		t.Errorf("payment %+v", p)
+	}
+
+	header.Set("Stripe-Signature", stripe.Sign([]byte(testEvent), time.Now().Add(-time.Hour)))
+	if _, err := stripe.ParseWebhook([]byte(testEvent), header); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("ParseWebhook of a replayed event = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestStripeRefundSendsIdempotencyKey(t *testing.T) {
+	var key, form string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key = r.Header.Get("Idempotency-Key")
+		r.ParseForm()
+		form = r.PostForm.Encode()
+		w.Write([]byte(`{"id":"re_1","status":"pending","amount":2500,"payment_intent":"pi_1"}`))
+	}))
+	defer server.Close()
+
+	refund, err := NewStripe(server.URL, "sk_test", "").Refund(context.Background(), RefundReq{
+		PaymentID: "pi_1", Amount: 2500, Reason: "late cancellation", IdempotencyKey: "refund-inv-1-abc",
+	})
+	if err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+	if refund.ID != "re_1" || refund.PaymentID != "pi_1" || refund.Amount != 2500 {
+		t.Errorf("refund %+v", refund)
+	}
+	if key != "refund-inv-1-abc" {
+		t.Errorf("Idempotency-Key %q, want the request's key", key)
+	}
+	if !strings.Contains(form, "amount=2500") || !strings.Contains(form, "metadata%5Breason%5D=late+cancellation") {
+		t.Errorf("form %q lacks the amount or reason", form)
+	}
+}
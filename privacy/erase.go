@@ -0,0 +1,230 @@
+package privacy
+
+import (
+	"context"
+	"fmt"
+	"life-signal/database"
+	"life-signal/encryption"
+	"life-signal/jobs"
+	"life-signal/models"
+	"life-signal/reviews"
+	"life-signal/storage"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const defaultGraceDays = 30
+
+// GracePeriod is how long an erasure request can still be cancelled, set in
+// days by ERASURE_GRACE_DAYS.
+func GracePeriod() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("ERASURE_GRACE_DAYS"))
+	if err != nil || days < 0 {
+		days = defaultGraceDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// userCollections hold records keyed by user_id that are erased outright.
+var userCollections = []string{
+	"user-medical-history",
+	"user-medical-history-revisions",
+	"vital-signs",
+	"lab-observations",
+	"health-summaries",
+	"doctors",
+	"appointments",
+	"reviews",
+	"hl7-dead-letters",
+}
+
+// Erase removes everything stored about userID: the account, its records,
+// attachment contents and the audit trail of access to them. The user's data
+// key is destroyed as well, so copies of encrypted records left in backups
+// can no longer be read. Only a tombstone in the audit log survives, recording
+// when the erasure happened and how many records were removed; it holds no
+// personal data beyond the random user ID.
+//
+// The account is deleted last, so an erasure that fails part way is picked up
+// again by the next run.
+func Erase(ctx context.Context, db *mongo.Client, userID string) (map[string]int64, error) {
+	users := database.GetCollection(db, "life-signal", "users")
+	user, err := database.FindUser(ctx, users, bson.M{"_id": userID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user: %w", err)
+	}
+	removed := map[string]int64{}
+
+	attachments := database.GetCollection(db, "life-signal", "attachments")
+	var stored []models.Attachment
+	if err := database.FindUserRecords(ctx, attachments, userID, "uploaded_at", time.Time{}, time.Time{}, &stored); err != nil {
+		return nil, fmt.Errorf("failed to find attachments: %w", err)
+	}
+	for _, attachment := range stored {
+		if err := storage.Current().Delete(ctx, attachment.StorageKey); err != nil && err != storage.ErrNotFound {
+			return nil, fmt.Errorf("failed to delete attachment %s: %w", attachment.ID, err)
+		}
+	}
+	result, err := attachments.DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete attachments: %w", err)
+	}
+	removed["attachments"] = result.DeletedCount
+
+	if user.DoctorID != "" {
+		result, err := database.GetCollection(db, "life-signal", "doctor-schedules").DeleteOne(ctx, bson.M{"_id": user.DoctorID})
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete doctor schedule: %w", err)
+		}
+		removed["doctor-schedules"] = result.DeletedCount
+		result, err = database.GetCollection(db, "life-signal", "doctor-ratings").DeleteOne(ctx, bson.M{"_id": user.DoctorID})
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete rating ledger: %w", err)
+		}
+		removed["doctor-ratings"] = result.DeletedCount
+	}
+
+	// The user's reviews leave their doctors' ratings before they are
+	// deleted below, and the reports about them go with them.
+	var written []models.Review
+	if err := database.FindUserRecords(ctx, database.GetCollection(db, "life-signal", "reviews"), userID, "created_at", time.Time{}, time.Time{}, &written); err != nil {
+		return nil, fmt.Errorf("failed to find reviews: %w", err)
+	}
+	reviewIDs := bson.A{}
+	for i := range written {
+		if err := reviews.Withdraw(ctx, db, &written[i]); err != nil {
+			return nil, fmt.Errorf("failed to withdraw review %s: %w", written[i].ID, err)
+		}
+		reviewIDs = append(reviewIDs, written[i].ID)
+	}
+	result, err = database.GetCollection(db, "life-signal", "review-reports").DeleteMany(ctx, bson.M{"$or": bson.A{
+		bson.M{"user_id": userID},
+		bson.M{"review_id": bson.M{"$in": reviewIDs}},
+	}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete review reports: %w", err)
+	}
+	removed["review-reports"] = result.DeletedCount
+	// Invoices are accounting records that must be kept, so they only lose
+	// the link to the user.
+	detached, err := database.GetCollection(db, "life-signal", "invoices").UpdateMany(ctx, bson.M{"user_id": userID}, bson.M{"$unset": bson.M{"user_id": ""}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to detach invoices: %w", err)
+	}
+	removed["invoices"] = detached.ModifiedCount
+	for _, name := range userCollections {
+		result, err := database.GetCollection(db, "life-signal", name).DeleteMany(ctx, bson.M{"user_id": userID})
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete %s: %w", name, err)
+		}
+		removed[name] = result.DeletedCount
+	}
+
+	feeds := bson.A{"patient:" + userID}
+	if user.DoctorID != "" {
+		feeds = append(feeds, "doctor:"+user.DoctorID)
+	}
+	result, err = database.GetCollection(db, "life-signal", "calendar-feeds").DeleteMany(ctx, bson.M{"_id": bson.M{"$in": feeds}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete calendar feeds: %w", err)
+	}
+	removed["calendar-feeds"] = result.DeletedCount
+
+	// Reminder jobs carry the user and their medication in the payload.
+	for _, name := range []string{"jobs", "job-dead-letters"} {
+		result, err := database.GetCollection(db, "life-signal", name).DeleteMany(ctx, bson.M{"payload.user_id": userID})
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete %s: %w", name, err)
+		}
+		removed[name] = result.DeletedCount
+	}
+
+	auditLog := database.GetCollection(db, "life-signal", "audit-log")
+	result, err = auditLog.DeleteMany(ctx, bson.M{"subject_id": userID, "type": bson.M{"$ne": models.AuditErased}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete audit log: %w", err)
+	}
+	removed["audit-log"] = result.DeletedCount
+
+	if err := encryption.Current().DeleteDataKey(ctx, userID); err != nil {
+		return nil, err
+	}
+	result, err = users.DeleteOne(ctx, bson.M{"_id": userID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete user: %w", err)
+	}
+	removed["users"] = result.DeletedCount
+
+	tombstone := models.AuditEntry{Type: models.AuditErased, SubjectID: userID, Records: removed}
+	if user.ErasureRequestedAt != nil {
+		tombstone.ActorID = userID
+	}
+	if err := database.RecordAudit(ctx, auditLog, tombstone); err != nil {
+		return removed, fmt.Errorf("failed to record erasure: %w", err)
+	}
+	return removed, nil
+}
+
+// DueErasures returns the IDs of users whose grace period ended before now.
+func DueErasures(ctx context.Context, db *mongo.Client, now time.Time) ([]string, error) {
+	users := database.GetCollection(db, "life-signal", "users")
+	ids, err := users.Distinct(ctx, "_id", bson.M{"erasure_due_at": bson.M{"$lte": now}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find due erasures: %w", err)
+	}
+	due := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if s, ok := id.(string); ok {
+			due = append(due, s)
+		}
+	}
+	return due, nil
+}
+
+// EraseDue erases every account whose grace period ended before now and
+// returns how many were due. Each account is checked again before it is
+// erased, as the user may have cancelled since the due list was read;
+// onErased is called for each one erased. Accounts that fail are logged and
+// retried on the next run.
+func EraseDue(ctx context.Context, db *mongo.Client, now time.Time, onErased func(userID string, removed map[string]int64)) (int, error) {
+	due, err := DueErasures(ctx, db, now)
+	if err != nil {
+		return 0, err
+	}
+	users := database.GetCollection(db, "life-signal", "users")
+	failed := 0
+	for _, userID := range due {
+		user, err := database.FindUser(ctx, users, bson.M{"_id": userID})
+		if err != nil || user.ErasureDueAt == nil || user.ErasureDueAt.After(now) {
+			continue
+		}
+		removed, err := Erase(ctx, db, userID)
+		if err != nil {
+			slog.Error("Erasure failed", "userID", userID, "error", err)
+			failed++
+			continue
+		}
+		if onErased != nil {
+			onErased(userID, removed)
+		}
+	}
+	if failed > 0 {
+		return len(due), fmt.Errorf("%d of %d erasures failed", failed, len(due))
+	}
+	return len(due), nil
+}
+
+// Register runs EraseDue every hour on the job queue.
+func Register(s *jobs.Scheduler) {
+	s.Every("process-erasures", time.Hour, func(ctx context.Context, job *models.Job) error {
+		_, err := EraseDue(ctx, s.DB, time.Now(), func(userID string, removed map[string]int64) {
+			slog.Info("Account erased", "userID", userID, "removed", removed)
+		})
+		return err
+	})
+}
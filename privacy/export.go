@@ -0,0 +1,236 @@
+// Package privacy implements the data subject rights: exporting everything
+// stored about a user and erasing it.
+package privacy
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"life-signal/database"
+	"life-signal/models"
+	"life-signal/storage"
+	"path"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const readme = `This archive holds everything Life Signal stores about your account.
+
+user.json                      your account details
+doctor-profile.json            your doctor profile, if you onboarded as a doctor
+doctor-schedule.json           your consultation hours, if you published them
+medical-history/current.json   your medical history as it is now
+medical-history/revisions/     every earlier version of your medical history
+vital-signs.json               vital sign readings
+lab-results.json               lab results
+health-summaries.json          health summaries generated for you
+appointments.json              appointments you booked
+invoices.json                  invoices for your appointments and their payments
+reviews.json                   reviews you wrote about doctors
+review-reports.json            reviews you reported as abusive
+attachments/attachments.json   details of your uploaded documents
+attachments/files/             the documents themselves
+audit-log.json                 every request that read or changed your records
+`
+
+type exporter struct {
+	zip *zip.Writer
+	now time.Time
+}
+
+func (e *exporter) writeJSON(name string, value any) error {
+	w, err := e.zip.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: e.now})
+	if err != nil {
+		return fmt.Errorf("failed to add %s: %w", name, err)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// Export writes a zip archive of every record held about userID to w. The
+// password hash is left out. Attachments are read from the blob store and
+// decrypted one at a time; an attachment whose contents are missing is listed
+// in attachments/missing.json instead of failing the export.
+func Export(ctx context.Context, db *mongo.Client, userID string, w io.Writer) error {
+	users := database.GetCollection(db, "life-signal", "users")
+	user, err := database.FindUser(ctx, users, bson.M{"_id": userID})
+	if err != nil {
+		return fmt.Errorf("failed to find user: %w", err)
+	}
+	user.PasswordHash = ""
+
+	e := &exporter{zip: zip.NewWriter(w), now: time.Now().UTC()}
+	if err := e.writeText("README.txt", readme); err != nil {
+		return err
+	}
+	if err := e.writeJSON("user.json", user); err != nil {
+		return err
+	}
+
+	var doctor models.Doctor
+	err = database.GetCollection(db, "life-signal", "doctors").FindOne(ctx, bson.M{"user_id": userID}).Decode(&doctor)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return fmt.Errorf("failed to find doctor profile: %w", err)
+	}
+	if err == nil {
+		if err := e.writeJSON("doctor-profile.json", doctor); err != nil {
+			return err
+		}
+		schedule, err := database.FindSchedule(ctx, database.GetCollection(db, "life-signal", "doctor-schedules"), doctor.ID)
+		if err != nil {
+			return fmt.Errorf("failed to find doctor schedule: %w", err)
+		}
+		if schedule != nil {
+			if err := e.writeJSON("doctor-schedule.json", schedule); err != nil {
+				return err
+			}
+		}
+	}
+
+	histories := database.GetCollection(db, "life-signal", "user-medical-history")
+	history, err := database.FindMedicalHistory(ctx, histories, userID)
+	if err != nil {
+		return fmt.Errorf("failed to find medical history: %w", err)
+	}
+	if history != nil {
+		if err := e.writeJSON("medical-history/current.json", history); err != nil {
+			return err
+		}
+	}
+	revisions, err := database.FindMedicalHistoryRevisions(ctx, histories, userID)
+	if err != nil {
+		return fmt.Errorf("failed to find medical history revisions: %w", err)
+	}
+	for _, revision := range revisions {
+		if err := e.writeJSON(fmt.Sprintf("medical-history/revisions/version-%04d.json", revision.Version), revision); err != nil {
+			return err
+		}
+	}
+
+	vitals, err := database.FindUserVitalSigns(ctx, database.GetCollection(db, "life-signal", "vital-signs"), userID, time.Time{}, time.Time{})
+	if err != nil {
+		return fmt.Errorf("failed to find vital signs: %w", err)
+	}
+	if err := e.writeJSON("vital-signs.json", nonNil(vitals)); err != nil {
+		return err
+	}
+	labs, err := database.FindUserLabObservations(ctx, database.GetCollection(db, "life-signal", "lab-observations"), userID, time.Time{}, time.Time{})
+	if err != nil {
+		return fmt.Errorf("failed to find lab results: %w", err)
+	}
+	if err := e.writeJSON("lab-results.json", nonNil(labs)); err != nil {
+		return err
+	}
+	var summaries []models.HealthSummary
+	if err := database.FindUserRecords(ctx, database.GetCollection(db, "life-signal", "health-summaries"), userID, "generated_at", time.Time{}, time.Time{}, &summaries); err != nil {
+		return fmt.Errorf("failed to find health summaries: %w", err)
+	}
+	if err := e.writeJSON("health-summaries.json", nonNil(summaries)); err != nil {
+		return err
+	}
+	bookings, err := database.FindBookings(ctx, database.GetCollection(db, "life-signal", "appointments"), bson.M{"user_id": userID}, time.Time{}, time.Time{})
+	if err != nil {
+		return fmt.Errorf("failed to find appointments: %w", err)
+	}
+	if err := e.writeJSON("appointments.json", nonNil(bookings)); err != nil {
+		return err
+	}
+	var invoices []models.Invoice
+	if err := database.FindUserRecords(ctx, database.GetCollection(db, "life-signal", "invoices"), userID, "issued_at", time.Time{}, time.Time{}, &invoices); err != nil {
+		return fmt.Errorf("failed to find invoices: %w", err)
+	}
+	if err := e.writeJSON("invoices.json", nonNil(invoices)); err != nil {
+		return err
+	}
+	var written []models.Review
+	if err := database.FindUserRecords(ctx, database.GetCollection(db, "life-signal", "reviews"), userID, "created_at", time.Time{}, time.Time{}, &written); err != nil {
+		return fmt.Errorf("failed to find reviews: %w", err)
+	}
+	if err := e.writeJSON("reviews.json", nonNil(written)); err != nil {
+		return err
+	}
+	var reports []models.ReviewReport
+	if err := database.FindUserRecords(ctx, database.GetCollection(db, "life-signal", "review-reports"), userID, "created_at", time.Time{}, time.Time{}, &reports); err != nil {
+		return fmt.Errorf("failed to find review reports: %w", err)
+	}
+	if err := e.writeJSON("review-reports.json", nonNil(reports)); err != nil {
+		return err
+	}
+
+	if err := e.writeAttachments(ctx, db, userID); err != nil {
+		return err
+	}
+
+	trail, err := database.FindAuditTrail(ctx, database.GetCollection(db, "life-signal", "audit-log"), userID)
+	if err != nil {
+		return fmt.Errorf("failed to find audit log: %w", err)
+	}
+	if err := e.writeJSON("audit-log.json", trail); err != nil {
+		return err
+	}
+	return e.zip.Close()
+}
+
+func (e *exporter) writeText(name, text string) error {
+	w, err := e.zip.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: e.now})
+	if err != nil {
+		return fmt.Errorf("failed to add %s: %w", name, err)
+	}
+	_, err = io.WriteString(w, text)
+	return err
+}
+
+func (e *exporter) writeAttachments(ctx context.Context, db *mongo.Client, userID string) error {
+	var attachments []models.Attachment
+	if err := database.FindUserRecords(ctx, database.GetCollection(db, "life-signal", "attachments"), userID, "uploaded_at", time.Time{}, time.Time{}, &attachments); err != nil {
+		return fmt.Errorf("failed to find attachments: %w", err)
+	}
+	if err := e.writeJSON("attachments/attachments.json", nonNil(attachments)); err != nil {
+		return err
+	}
+	missing := []string{}
+	for _, attachment := range attachments {
+		data, err := database.ReadAttachment(ctx, &attachment)
+		if err == storage.ErrNotFound {
+			missing = append(missing, attachment.ID)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read attachment %s: %w", attachment.ID, err)
+		}
+		// The file name is the user's own, so only its base is kept to stop
+		// it from escaping the attachment's folder.
+		base := path.Base("/" + attachment.FileName)
+		if base == "/" {
+			base = "file"
+		}
+		name := "attachments/files/" + attachment.ID + "/" + base
+		w, err := e.zip.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: attachment.UploadedAt.UTC()})
+		if err == nil {
+			_, err = w.Write(data)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write attachment %s: %w", attachment.ID, err)
+		}
+	}
+	if len(missing) > 0 {
+		return e.writeJSON("attachments/missing.json", missing)
+	}
+	return nil
+}
+
+// nonNil makes empty record lists come out as [] rather than null.
+func nonNil[T any](records []T) []T {
+	if records == nil {
+		return []T{}
+	}
+	return records
+}
@@ -0,0 +1,214 @@
+// Package reminders sends appointment and medication reminders through the
+// job queue.
+package reminders
+
+import (
+	"context"
+	"fmt"
+	"life-signal/database"
+	"life-signal/dosage"
+	"life-signal/jobs"
+	"life-signal/models"
+	"life-signal/notify"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	appointmentReminderJob = "appointment-reminder"
+	medicationScanJob      = "medication-reminders"
+	medicationReminderJob  = "medication-reminder"
+
+	defaultAppointmentOffsets = "24h,2h"
+	// medicationScanInterval is how often upcoming doses are queued, and
+	// medicationHorizon how far ahead. The overlap keeps a late scan from
+	// missing doses; the job keys keep it from queuing any twice.
+	medicationScanInterval = 30 * time.Minute
+	medicationHorizon      = time.Hour
+)
+
+// AppointmentOffsets are how long before a confirmed appointment patients
+// are reminded, set as comma separated durations by
+// APPOINTMENT_REMINDER_OFFSETS, "24h,2h" when unset.
+func AppointmentOffsets() []time.Duration {
+	raw := os.Getenv("APPOINTMENT_REMINDER_OFFSETS")
+	if raw == "" {
+		raw = defaultAppointmentOffsets
+	}
+	offsets := []time.Duration{}
+	for _, part := range strings.Split(raw, ",") {
+		offset, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil || offset <= 0 {
+			slog.Warn("Ignoring invalid appointment reminder offset", "offset", part)
+			continue
+		}
+		offsets = append(offsets, offset)
+	}
+	return offsets
+}
+
+// Location is the time zone medication doses are timed in, set by
+// REMINDER_TIME_ZONE, UTC when unset.
+func Location() *time.Location {
+	location, err := time.LoadLocation(os.Getenv("REMINDER_TIME_ZONE"))
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// ScheduleAppointmentReminders queues a reminder for each offset before a
+// confirmed booking that is still in the future. Reminders are keyed on the
+// booking's start, so confirming a rescheduled booking queues new ones and
+// those for the old time are dropped when they come due.
+func ScheduleAppointmentReminders(ctx context.Context, db *mongo.Client, booking models.Booking) error {
+	now := time.Now()
+	for _, offset := range AppointmentOffsets() {
+		at := booking.Start.Add(-offset)
+		if at.Before(now) {
+			continue
+		}
+		key := fmt.Sprintf("%s:%d:%s", booking.ID, booking.Start.Unix(), offset)
+		payload := map[string]string{"booking_id": booking.ID, "start": strconv.FormatInt(booking.Start.Unix(), 10)}
+		if err := jobs.Enqueue(ctx, db, appointmentReminderJob, key, at, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Register adds the reminder jobs to s.
+func Register(s *jobs.Scheduler) {
+	s.Handle(appointmentReminderJob, func(ctx context.Context, job *models.Job) error {
+		return sendAppointmentReminder(ctx, s.DB, job)
+	})
+	s.Every(medicationScanJob, medicationScanInterval, func(ctx context.Context, job *models.Job) error {
+		return queueMedicationReminders(ctx, s.DB, time.Now())
+	})
+	s.Handle(medicationReminderJob, func(ctx context.Context, job *models.Job) error {
+		return sendMedicationReminder(ctx, s.DB, job)
+	})
+}
+
+func sendAppointmentReminder(ctx context.Context, db *mongo.Client, job *models.Job) error {
+	booking, err := database.FindBooking(ctx, database.GetCollection(db, "life-signal", "appointments"), bson.M{"_id": job.Payload["booking_id"]})
+	if err != nil {
+		return err
+	}
+	// Cancelled, moved or erased since the reminder was queued.
+	if booking == nil || booking.Status != models.BookingConfirmed || strconv.FormatInt(booking.Start.Unix(), 10) != job.Payload["start"] {
+		return nil
+	}
+	location, err := time.LoadLocation(booking.TimeZone)
+	if err != nil {
+		location = time.UTC
+	}
+	when := booking.Start.In(location).Format("Mon 2 Jan 2006 15:04 MST")
+	return notify.SendToUser(ctx, db, booking.UserID, notify.Message{
+		Subject: fmt.Sprintf("Reminder: appointment with %s on %s", booking.DoctorName, when),
+		Body:    fmt.Sprintf("This is a reminder of your appointment with %s on %s.\n\nIf you cannot make it, please cancel or reschedule it in the app.", booking.DoctorName, when),
+	})
+}
+
+// active reports whether prescription is still being taken at t.
+func active(prescription models.Prescription, t time.Time) bool {
+	return prescription.EndDate == nil || prescription.EndDate.After(t)
+}
+
+// queueMedicationReminders queues a reminder for every dose of an active
+// prescription due within the horizon after now, for patients who opted in.
+// The jobs only name the prescription, as their payload is not encrypted.
+func queueMedicationReminders(ctx context.Context, db *mongo.Client, now time.Time) error {
+	location := Location()
+	to := now.Add(medicationHorizon)
+	userIDs, err := database.GetCollection(db, "life-signal", "users").Distinct(ctx, "_id", bson.M{"medication_reminders": true})
+	if err != nil {
+		return fmt.Errorf("failed to find users with medication reminders: %w", err)
+	}
+	if len(userIDs) == 0 {
+		return nil
+	}
+	cursor, err := database.GetCollection(db, "life-signal", "user-medical-history").Find(ctx, bson.M{"user_id": bson.M{"$in": userIDs}})
+	if err != nil {
+		return fmt.Errorf("failed to query medical histories: %w", err)
+	}
+	defer cursor.Close(ctx)
+	queued, unlinked := 0, 0
+	for cursor.Next(ctx) {
+		history, err := database.DecodeMedicalHistory(ctx, cursor.Current)
+		if err != nil {
+			slog.Warn("Skipping unreadable medical history", "error", err)
+			continue
+		}
+		for _, prescription := range history.Prescriptions {
+			if prescription.StructuredDosage == nil || !active(prescription, now) {
+				continue
+			}
+			if prescription.ID == "" {
+				unlinked++
+				continue
+			}
+			for _, at := range dosage.Times(*prescription.StructuredDosage, prescription.StartDate, now, to, location) {
+				key := fmt.Sprintf("%s:%s:%d", history.UserID, prescription.ID, at.Unix())
+				payload := map[string]string{
+					"user_id":         history.UserID,
+					"prescription_id": prescription.ID,
+					"at":              strconv.FormatInt(at.Unix(), 10),
+				}
+				if err := jobs.Enqueue(ctx, db, medicationReminderJob, key, at, payload); err != nil {
+					return err
+				}
+				queued++
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to iterate medical histories: %w", err)
+	}
+	if unlinked > 0 {
+		slog.Warn("Prescriptions without an ID get no reminders, run assign-entry-ids", "count", unlinked)
+	}
+	slog.Info("Medication reminders queued", "count", queued)
+	return nil
+}
+
+// sendMedicationReminder reminds a patient of a dose, unless they opted out
+// or the prescription was stopped since the reminder was queued.
+func sendMedicationReminder(ctx context.Context, db *mongo.Client, job *models.Job) error {
+	userID, prescriptionID := job.Payload["user_id"], job.Payload["prescription_id"]
+	unix, _ := strconv.ParseInt(job.Payload["at"], 10, 64)
+	at := time.Unix(unix, 0)
+	user, err := database.FindUser(ctx, database.GetCollection(db, "life-signal", "users"), bson.M{"_id": userID})
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !user.MedicationReminders || prescriptionID == "" {
+		return nil
+	}
+	history, err := database.FindMedicalHistory(ctx, database.GetCollection(db, "life-signal", "user-medical-history"), userID)
+	if err != nil {
+		return err
+	}
+	if history == nil {
+		return nil
+	}
+	for _, prescription := range history.Prescriptions {
+		if prescription.ID != prescriptionID || !active(prescription, at) {
+			continue
+		}
+		return notify.SendToUser(ctx, db, userID, notify.Message{
+			Subject: "Time to take your " + prescription.MedicationName,
+			Body:    fmt.Sprintf("It is time for your dose of %s: %s.", prescription.MedicationName, prescription.Dosage),
+		})
+	}
+	return nil
+}
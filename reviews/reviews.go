@@ -0,0 +1,223 @@
+// Package reviews keeps doctors' ratings in step with their reviews.
+//
+// Every doctor with reviews has a rating ledger holding what each review
+// adds to the rating. A review change is first written to the review, marked
+// unsynced, and then applied to the ledger as the difference between the old
+// and new entry, and the ledger's average is copied onto the doctor. A change
+// that fails part way is applied again by the sync job; applying it twice
+// has no effect.
+package reviews
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"life-signal/database"
+	"life-signal/jobs"
+	"life-signal/models"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultFlagThreshold = 3
+	maxLedgerAttempts    = 5
+	syncBatch            = 500
+)
+
+var errLedgerBusy = errors.New("rating ledger changed on every attempt")
+
+// Premoderated reports whether new and edited reviews wait for an admin
+// before they are published, set by REVIEW_PREMODERATION.
+func Premoderated() bool {
+	premoderated, _ := strconv.ParseBool(os.Getenv("REVIEW_PREMODERATION"))
+	return premoderated
+}
+
+// FlagThreshold is how many unresolved abuse reports take a published review
+// down until an admin looks at it, set by REVIEW_FLAG_THRESHOLD.
+func FlagThreshold() int {
+	threshold, err := strconv.Atoi(os.Getenv("REVIEW_FLAG_THRESHOLD"))
+	if err != nil || threshold < 1 {
+		threshold = defaultFlagThreshold
+	}
+	return threshold
+}
+
+// Contribution is what review adds to its doctor's rating: its rating while
+// published and nothing otherwise.
+func Contribution(review *models.Review) int {
+	if review.Status == models.ReviewPublished {
+		return review.Rating
+	}
+	return 0
+}
+
+// Apply records in the ledger of doctorID that version of the review reviewID
+// adds rating, 0 for nothing, and copies the new average onto the doctor.
+// The ledger is left alone when it holds that version of the review or a
+// newer one. Writes to the ledger are conditional on its version, so
+// concurrent changes to other reviews of the doctor are retried, not lost.
+func Apply(ctx context.Context, db *mongo.Client, doctorID, reviewID string, rating int, version int64) error {
+	ratings := database.GetCollection(db, "life-signal", "doctor-ratings")
+	for attempt := 0; attempt < maxLedgerAttempts; attempt++ {
+		ledger, err := database.FindDoctorRating(ctx, ratings, doctorID)
+		if err != nil {
+			return fmt.Errorf("failed to find rating ledger: %w", err)
+		}
+		if !record(ledger, reviewID, rating, version) {
+			return copyRating(ctx, db, ledger)
+		}
+		err = database.UpdateDoctorRating(ctx, ratings, ledger, reviewID, ledger.Version)
+		if err == database.ErrVersionConflict {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update rating ledger: %w", err)
+		}
+		return copyRating(ctx, db, ledger)
+	}
+	return errLedgerBusy
+}
+
+// record notes in ledger that version of the review reviewID adds rating,
+// reporting false when ledger already has that version or a later one.
+func record(ledger *models.DoctorRating, reviewID string, rating int, version int64) bool {
+	entry, found := ledger.Reviews[reviewID]
+	if found && entry.Version >= version {
+		return false
+	}
+	ledger.Sum += rating - entry.Rating
+	if entry.Rating > 0 {
+		ledger.Count--
+	}
+	if rating > 0 {
+		ledger.Count++
+	}
+	ledger.Reviews[reviewID] = models.RatedReview{Rating: rating, Version: version}
+	return true
+}
+
+// tally builds the rating ledger of doctorID from all of its reviews.
+func tally(doctorID string, reviews []models.Review) *models.DoctorRating {
+	ledger := &models.DoctorRating{DoctorID: doctorID, Reviews: map[string]models.RatedReview{}}
+	for i := range reviews {
+		rating := Contribution(&reviews[i])
+		ledger.Reviews[reviews[i].ID] = models.RatedReview{Rating: rating, Version: reviews[i].Version}
+		if rating > 0 {
+			ledger.Sum += rating
+			ledger.Count++
+		}
+	}
+	return ledger
+}
+
+func copyRating(ctx context.Context, db *mongo.Client, ledger *models.DoctorRating) error {
+	if err := database.SetDoctorRating(ctx, database.GetCollection(db, "life-signal", "doctors"), ledger); err != nil {
+		return fmt.Errorf("failed to update doctor rating: %w", err)
+	}
+	return nil
+}
+
+// Sync applies the current state of the review reviewID to its doctor's
+// rating and marks it synced, unless it changed again in the meantime.
+func Sync(ctx context.Context, db *mongo.Client, reviewID string) error {
+	collection := database.GetCollection(db, "life-signal", "reviews")
+	review, err := database.FindReview(ctx, collection, bson.M{"_id": reviewID})
+	if err != nil {
+		return fmt.Errorf("failed to find review: %w", err)
+	}
+	if review == nil || review.RatingSynced {
+		return nil
+	}
+	if err := Apply(ctx, db, review.DoctorID, review.ID, Contribution(review), review.Version); err != nil {
+		return err
+	}
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": review.ID, "version": review.Version}, bson.M{"$set": bson.M{"rating_synced": true}})
+	if err != nil {
+		return fmt.Errorf("failed to mark review synced: %w", err)
+	}
+	return nil
+}
+
+// Withdraw takes review out of its doctor's rating before it is deleted. It
+// is applied as a newer version of the review, so a sync of the review still
+// in flight cannot count it again.
+func Withdraw(ctx context.Context, db *mongo.Client, review *models.Review) error {
+	return Apply(ctx, db, review.DoctorID, review.ID, 0, review.Version+1)
+}
+
+// Rebuild recomputes the rating ledger of doctorID from its reviews and
+// copies the result onto the doctor, repairing a ledger that was lost or a
+// rating that never came from reviews. It returns the rebuilt ledger.
+func Rebuild(ctx context.Context, db *mongo.Client, doctorID string) (*models.DoctorRating, error) {
+	ratings := database.GetCollection(db, "life-signal", "doctor-ratings")
+	reviews := database.GetCollection(db, "life-signal", "reviews")
+	for attempt := 0; attempt < maxLedgerAttempts; attempt++ {
+		current, err := database.FindDoctorRating(ctx, ratings, doctorID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find rating ledger: %w", err)
+		}
+		found, err := reviews.Find(ctx, bson.M{"doctor_id": doctorID}, options.Find().SetProjection(bson.M{"status": 1, "rating": 1, "version": 1}))
+		if err != nil {
+			return nil, fmt.Errorf("failed to find reviews: %w", err)
+		}
+		var all []models.Review
+		if err := found.All(ctx, &all); err != nil {
+			return nil, fmt.Errorf("failed to read reviews: %w", err)
+		}
+		ledger := tally(doctorID, all)
+		err = database.ReplaceDoctorRating(ctx, ratings, ledger, current.Version)
+		if err == database.ErrVersionConflict {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to replace rating ledger: %w", err)
+		}
+		return ledger, copyRating(ctx, db, ledger)
+	}
+	return nil, errLedgerBusy
+}
+
+// SyncPending syncs the reviews whose last change has not reached their
+// doctor's rating, a batch at a time, and returns how many it synced.
+func SyncPending(ctx context.Context, db *mongo.Client) (int, error) {
+	collection := database.GetCollection(db, "life-signal", "reviews")
+	opts := options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(syncBatch)
+	found, err := collection.Find(ctx, bson.M{"rating_synced": false}, opts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find unsynced reviews: %w", err)
+	}
+	var pending []models.Review
+	if err := found.All(ctx, &pending); err != nil {
+		return 0, fmt.Errorf("failed to read unsynced reviews: %w", err)
+	}
+	synced, failed := 0, 0
+	for _, review := range pending {
+		if err := Sync(ctx, db, review.ID); err != nil {
+			slog.Error("Review rating sync failed", "reviewID", review.ID, "error", err)
+			failed++
+			continue
+		}
+		synced++
+	}
+	if failed > 0 {
+		return synced, fmt.Errorf("%d of %d review ratings failed to sync", failed, synced+failed)
+	}
+	return synced, nil
+}
+
+// Register syncs unsynced review ratings every five minutes on the job
+// queue.
+func Register(s *jobs.Scheduler) {
+	s.Every("sync-review-ratings", 5*time.Minute, func(ctx context.Context, job *models.Job) error {
+		_, err := SyncPending(ctx, s.DB)
+		return err
+	})
+}
@@ -0,0 +1,176 @@
+package reviews
+
+import (
+	"life-signal/models"
+	"maps"
+	"testing"
+)
+
+func newLedger() *models.DoctorRating {
+	return &models.DoctorRating{DoctorID: "doctor", Reviews: map[string]models.RatedReview{}}
+}
+
+// step changes one review the way a handler would, or withdraws it.
+type step struct {
+	review   string
+	status   string
+	rating   int
+	withdraw bool
+}
+
+func TestLedger(t *testing.T) {
+	tests := []struct {
+		name               string
+		steps              []step
+		wantSum, wantCount int
+	}{
+		{
+			name:    "published review counts",
+			steps:   []step{{review: "a", status: models.ReviewPublished, rating: 4}},
+			wantSum: 4, wantCount: 1,
+		},
+		{
+			name:    "pending review does not count",
+			steps:   []step{{review: "a", status: models.ReviewPending, rating: 4}},
+			wantSum: 0, wantCount: 0,
+		},
+		{
+			name: "edit replaces the rating",
+			steps: []step{
+				{review: "a", status: models.ReviewPublished, rating: 4},
+				{review: "b", status: models.ReviewPublished, rating: 2},
+				{review: "a", status: models.ReviewPublished, rating: 5},
+			},
+			wantSum: 7, wantCount: 2,
+		},
+		{
+			name: "create, edit, hide by moderation, withdraw",
+			steps: []step{
+				{review: "a", status: models.ReviewPublished, rating: 3},
+				{review: "b", status: models.ReviewPublished, rating: 5},
+				{review: "a", status: models.ReviewPublished, rating: 1},
+				{review: "a", status: models.ReviewFlagged, rating: 1},
+				{review: "a", withdraw: true},
+			},
+			wantSum: 5, wantCount: 1,
+		},
+		{
+			name: "moderation hides then republishes",
+			steps: []step{
+				{review: "a", status: models.ReviewPublished, rating: 2},
+				{review: "a", status: models.ReviewRejected, rating: 2},
+				{review: "a", status: models.ReviewPublished, rating: 2},
+			},
+			wantSum: 2, wantCount: 1,
+		},
+		{
+			name: "withdrawing a published review",
+			steps: []step{
+				{review: "a", status: models.ReviewPublished, rating: 3},
+				{review: "b", status: models.ReviewPublished, rating: 4},
+				{review: "b", withdraw: true},
+			},
+			wantSum: 3, wantCount: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ledger := newLedger()
+			reviews := map[string]*models.Review{}
+			for i, s := range tt.steps {
+				review, ok := reviews[s.review]
+				if !ok {
+					review = &models.Review{ID: s.review, DoctorID: "doctor"}
+					reviews[s.review] = review
+				}
+				if s.withdraw {
+					if !record(ledger, review.ID, 0, review.Version+1) {
+						t.Fatalf("step %d: withdrawal was not recorded", i)
+					}
+					delete(reviews, s.review)
+					continue
+				}
+				review.Status, review.Rating = s.status, s.rating
+				review.Version++
+				if !record(ledger, review.ID, Contribution(review), review.Version) {
+					t.Fatalf("step %d: version %d was not recorded", i, review.Version)
+				}
+			}
+			if ledger.Sum != tt.wantSum || ledger.Count != tt.wantCount {
+				t.Errorf("ledger = %d over %d, want %d over %d", ledger.Sum, ledger.Count, tt.wantSum, tt.wantCount)
+			}
+
+			remaining := []models.Review{}
+			for _, review := range reviews {
+				remaining = append(remaining, *review)
+			}
+			rebuilt := tally("doctor", remaining)
+			if rebuilt.Sum != ledger.Sum || rebuilt.Count != ledger.Count {
+				t.Errorf("rebuilt ledger = %d over %d, incremental %d over %d", rebuilt.Sum, rebuilt.Count, ledger.Sum, ledger.Count)
+			}
+			// The incremental ledger also keeps withdrawn reviews, at 0.
+			live := maps.Clone(ledger.Reviews)
+			maps.DeleteFunc(live, func(id string, _ models.RatedReview) bool { return reviews[id] == nil })
+			if !maps.Equal(rebuilt.Reviews, live) {
+				t.Errorf("rebuilt entries = %v, incremental %v", rebuilt.Reviews, live)
+			}
+		})
+	}
+}
+
+func TestRecordIgnoresStaleVersions(t *testing.T) {
+	ledger := newLedger()
+	record(ledger, "a", 5, 1)
+	record(ledger, "b", 3, 1)
+	record(ledger, "a", 2, 3)
+	before := *ledger
+	before.Reviews = maps.Clone(ledger.Reviews)
+
+	for _, replay := range []struct {
+		rating  int
+		version int64
+	}{
+		{5, 1}, // the first version again
+		{4, 2}, // an edit synced after a later one
+		{2, 3}, // the current version again
+	} {
+		if record(ledger, "a", replay.rating, replay.version) {
+			t.Errorf("record of version %d was applied over version 3", replay.version)
+		}
+	}
+	if ledger.Sum != before.Sum || ledger.Count != before.Count || !maps.Equal(ledger.Reviews, before.Reviews) {
+		t.Errorf("ledger = %+v after stale replays, want %+v", ledger, before)
+	}
+	if ledger.Sum != 5 || ledger.Count != 2 {
+		t.Errorf("ledger = %d over %d, want 5 over 2", ledger.Sum, ledger.Count)
+	}
+
+	// A withdrawal outranks every version the review had.
+	if !record(ledger, "a", 0, 4) || ledger.Sum != 3 || ledger.Count != 1 {
+		t.Errorf("after withdrawal ledger = %d over %d, want 3 over 1", ledger.Sum, ledger.Count)
+	}
+	if record(ledger, "a", 2, 3) {
+		t.Error("a stale edit brought a withdrawn review back")
+	}
+}
+
+func TestTally(t *testing.T) {
+	ledger := tally("doctor", []models.Review{
+		{ID: "a", Status: models.ReviewPublished, Rating: 5, Version: 2},
+		{ID: "b", Status: models.ReviewPublished, Rating: 2, Version: 1},
+		{ID: "c", Status: models.ReviewFlagged, Rating: 1, Version: 4},
+		{ID: "d", Status: models.ReviewPending, Rating: 4, Version: 1},
+	})
+	if ledger.DoctorID != "doctor" || ledger.Sum != 7 || ledger.Count != 2 {
+		t.Errorf("tally = %+v, want 7 over 2 for doctor", ledger)
+	}
+	want := map[string]models.RatedReview{
+		"a": {Rating: 5, Version: 2},
+		"b": {Rating: 2, Version: 1},
+		"c": {Rating: 0, Version: 4},
+		"d": {Rating: 0, Version: 1},
+	}
+	if !maps.Equal(ledger.Reviews, want) {
+		t.Errorf("tally entries = %v, want %v", ledger.Reviews, want)
+	}
+}
@@ -26,12 +26,92 @@ func Routes(engine *gin.Engine, db *mongo.Client) {
 		auth.POST("/verifyOtp", func(c *gin.Context) { handlers.VerifyOtpHandler(c, db) })
 	}
 
+	engine.GET("/files/:id", func(c *gin.Context) { handlers.DownloadAttachment(c, db) })
+	engine.GET("/verify/summaries/:id", func(c *gin.Context) { handlers.VerifyHealthSummary(c, db) })
+	engine.GET("/calendar/:token", func(c *gin.Context) { handlers.GetCalendarFeed(c, db) })
+	engine.POST("/webhooks/payments", func(c *gin.Context) { handlers.PaymentWebhook(c, db) })
+
 	protected := engine.Group("/v1")
-	protected.Use(middleware.AuthMiddleware())
+	protected.Use(middleware.AuthMiddleware(), middleware.AuditMiddleware(db))
 	{
+		protected.GET("/me/export", func(c *gin.Context) {
+			handlers.ExportMyData(c, db)
+		})
+		protected.DELETE("/me", func(c *gin.Context) {
+			handlers.RequestErasure(c, db)
+		})
+		protected.POST("/me/erasure/cancel", func(c *gin.Context) {
+			handlers.CancelErasure(c, db)
+		})
+		protected.PUT("/me/medication-reminders", func(c *gin.Context) {
+			handlers.SetMedicationReminders(c, db)
+		})
 		protected.GET("/get-doctors", func(c *gin.Context) {
 			handlers.GetAllDoctors(c, db)
 		})
+		protected.GET("/doctors/search", func(c *gin.Context) {
+			handlers.SearchDoctors(c, db)
+		})
+		protected.GET("/doctors/nearby", func(c *gin.Context) {
+			handlers.SearchDoctorsNearby(c, db)
+		})
+		protected.GET("/doctors/:id/schedule", func(c *gin.Context) {
+			handlers.GetDoctorSchedule(c, db)
+		})
+		protected.GET("/doctors/:id/slots", func(c *gin.Context) {
+			handlers.GetDoctorSlots(c, db)
+		})
+		protected.GET("/doctors/:id/reviews", func(c *gin.Context) {
+			handlers.GetDoctorReviews(c, db)
+		})
+		protected.POST("/doctors/:id/reviews", func(c *gin.Context) {
+			handlers.CreateReview(c, db)
+		})
+		protected.GET("/me/reviews", func(c *gin.Context) {
+			handlers.GetMyReviews(c, db)
+		})
+		protected.PUT("/reviews/:id", func(c *gin.Context) {
+			handlers.UpdateMyReview(c, db)
+		})
+		protected.DELETE("/reviews/:id", func(c *gin.Context) {
+			handlers.DeleteMyReview(c, db)
+		})
+		protected.POST("/reviews/:id/report", func(c *gin.Context) {
+			handlers.ReportReview(c, db)
+		})
+		protected.POST("/appointments", func(c *gin.Context) {
+			handlers.BookAppointment(c, db)
+		})
+		protected.GET("/appointments", func(c *gin.Context) {
+			handlers.GetMyAppointments(c, db)
+		})
+		protected.GET("/appointments/:id", func(c *gin.Context) {
+			handlers.GetMyAppointment(c, db)
+		})
+		protected.GET("/appointments/:id/ics", func(c *gin.Context) {
+			handlers.GetMyAppointmentInvite(c, db)
+		})
+		protected.POST("/me/calendar-feed", func(c *gin.Context) {
+			handlers.CreateMyCalendarFeed(c, db)
+		})
+		protected.DELETE("/me/calendar-feed", func(c *gin.Context) {
+			handlers.RevokeMyCalendarFeed(c, db)
+		})
+		protected.POST("/appointments/:id/cancel", func(c *gin.Context) {
+			handlers.CancelMyAppointment(c, db)
+		})
+		protected.POST("/appointments/:id/reschedule", func(c *gin.Context) {
+			handlers.RescheduleMyAppointment(c, db)
+		})
+		protected.GET("/invoices", func(c *gin.Context) {
+			handlers.GetMyInvoices(c, db)
+		})
+		protected.GET("/invoices/:id", func(c *gin.Context) {
+			handlers.GetMyInvoice(c, db)
+		})
+		protected.POST("/invoices/:id/pay", func(c *gin.Context) {
+			handlers.PayMyInvoice(c, db)
+		})
 		protected.GET("/get-medical-history/:userid", func(c *gin.Context) {
 			handlers.GetUserMedicalHistory(c, db)
 		})
@@ -41,5 +121,150 @@ func Routes(engine *gin.Engine, db *mongo.Client) {
 		protected.POST("/set-medical-history/:userid", func(c *gin.Context) {
 			handlers.SetUserMedicalHistory(c, db)
 		})
+		protected.PATCH("/medical-history/:userid", func(c *gin.Context) {
+			handlers.PatchUserMedicalHistory(c, db)
+		})
+		protected.POST("/vitals/:userid", func(c *gin.Context) {
+			handlers.CreateVitalSign(c, db)
+		})
+		protected.GET("/vitals/:userid", func(c *gin.Context) {
+			handlers.GetVitalSigns(c, db)
+		})
+		protected.POST("/lab-results/:userid", func(c *gin.Context) {
+			handlers.CreateLabResult(c, db)
+		})
+		protected.GET("/lab-results/:userid", func(c *gin.Context) {
+			handlers.GetLabResults(c, db)
+		})
+		protected.POST("/attachments/:userid", func(c *gin.Context) {
+			handlers.UploadAttachment(c, db)
+		})
+		protected.GET("/attachments/:userid", func(c *gin.Context) {
+			handlers.GetAttachments(c, db)
+		})
+		protected.GET("/attachments/:userid/:id", func(c *gin.Context) {
+			handlers.GetAttachment(c, db)
+		})
+		protected.DELETE("/attachments/:userid/:id", func(c *gin.Context) {
+			handlers.DeleteAttachment(c, db)
+		})
+		protected.GET("/timeline/:userid", func(c *gin.Context) {
+			handlers.GetTimeline(c, db)
+		})
+		protected.GET("/summary/:userid/pdf", func(c *gin.Context) {
+			handlers.ExportHealthSummary(c, db)
+		})
+		protected.GET("/fhir/export/:userid", func(c *gin.Context) {
+			handlers.ExportFHIRBundle(c, db)
+		})
+		protected.POST("/fhir/import/:userid", func(c *gin.Context) {
+			handlers.ImportFHIRBundle(c, db)
+		})
+		protected.GET("/terminology/search", handlers.SearchTerminology)
+		protected.GET("/terminology/:system/:code", handlers.LookupTerminologyCode)
+		protected.POST("/interactions/check", func(c *gin.Context) {
+			handlers.CheckInteractions(c, db)
+		})
+	}
+	protected.POST("/doctor/onboarding", func(c *gin.Context) {
+		handlers.StartDoctorOnboarding(c, db)
+	})
+	doctor := protected.Group("/doctor")
+	doctor.Use(middleware.DoctorMiddleware(db))
+	{
+		doctor.GET("/profile", func(c *gin.Context) {
+			handlers.GetMyDoctorProfile(c, db)
+		})
+		doctor.PUT("/profile", func(c *gin.Context) {
+			handlers.UpdateMyDoctorProfile(c, db)
+		})
+		doctor.POST("/credentials", func(c *gin.Context) {
+			handlers.UploadDoctorCredential(c, db)
+		})
+		doctor.POST("/submit", func(c *gin.Context) {
+			handlers.SubmitDoctorProfile(c, db)
+		})
+		doctor.GET("/schedule", func(c *gin.Context) {
+			handlers.GetMySchedule(c, db)
+		})
+		doctor.PUT("/schedule", func(c *gin.Context) {
+			handlers.SetMySchedule(c, db)
+		})
+		doctor.POST("/calendar-feed", func(c *gin.Context) {
+			handlers.CreateDoctorCalendarFeed(c, db)
+		})
+		doctor.DELETE("/calendar-feed", func(c *gin.Context) {
+			handlers.RevokeDoctorCalendarFeed(c, db)
+		})
+		doctor.GET("/appointments", func(c *gin.Context) {
+			handlers.GetDoctorAppointments(c, db)
+		})
+		doctor.POST("/appointments/:id/confirm", func(c *gin.Context) {
+			handlers.ConfirmAppointment(c, db)
+		})
+		doctor.POST("/appointments/:id/cancel", func(c *gin.Context) {
+			handlers.CancelDoctorAppointment(c, db)
+		})
+		doctor.POST("/appointments/:id/complete", func(c *gin.Context) {
+			handlers.CompleteAppointment(c, db)
+		})
+		doctor.POST("/appointments/:id/no-show", func(c *gin.Context) {
+			handlers.MarkAppointmentNoShow(c, db)
+		})
+		doctor.GET("/reviews", func(c *gin.Context) {
+			handlers.GetMyDoctorReviews(c, db)
+		})
+		doctor.PUT("/reviews/:id/reply", func(c *gin.Context) {
+			handlers.ReplyToReview(c, db)
+		})
+		doctor.DELETE("/reviews/:id/reply", func(c *gin.Context) {
+			handlers.DeleteReviewReply(c, db)
+		})
+		doctor.GET("/invoices", func(c *gin.Context) {
+			handlers.GetDoctorInvoices(c, db)
+		})
+	}
+	admin := protected.Group("/admin")
+	admin.Use(middleware.AdminMiddleware())
+	{
+		admin.GET("/export/:kind", func(c *gin.Context) {
+			handlers.ExportRecords(c, db)
+		})
+		admin.POST("/import/:kind", func(c *gin.Context) {
+			handlers.ImportRecords(c, db)
+		})
+		admin.GET("/jobs/dead-letters", func(c *gin.Context) {
+			handlers.GetDeadJobs(c, db)
+		})
+		admin.POST("/jobs/dead-letters/:id/retry", func(c *gin.Context) {
+			handlers.RetryDeadJob(c, db)
+		})
+		admin.GET("/hl7/dead-letters", func(c *gin.Context) {
+			handlers.GetHL7DeadLetters(c, db)
+		})
+		admin.GET("/reviews", func(c *gin.Context) {
+			handlers.GetReviewQueue(c, db)
+		})
+		admin.POST("/reviews/:id/moderate", func(c *gin.Context) {
+			handlers.ModerateReview(c, db)
+		})
+		admin.GET("/discounts", func(c *gin.Context) {
+			handlers.GetDiscounts(c, db)
+		})
+		admin.POST("/discounts", func(c *gin.Context) {
+			handlers.CreateDiscount(c, db)
+		})
+		admin.POST("/invoices/:id/refund", func(c *gin.Context) {
+			handlers.RefundInvoice(c, db)
+		})
+		admin.GET("/doctors/reviews", func(c *gin.Context) {
+			handlers.ListDoctorReviews(c, db)
+		})
+		admin.GET("/doctors/:id", func(c *gin.Context) {
+			handlers.GetDoctorReview(c, db)
+		})
+		admin.POST("/doctors/:id/review", func(c *gin.Context) {
+			handlers.ReviewDoctor(c, db)
+		})
 	}
 }
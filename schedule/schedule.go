@@ -0,0 +1,280 @@
+// Package schedule expands a doctor's weekly hours, breaks, holidays and
+// exceptions into concrete time slots.
+package schedule
+
+import (
+	"fmt"
+	"life-signal/models"
+	"sort"
+	"strings"
+	"time"
+	// Time zones must resolve even where the host has no zoneinfo.
+	_ "time/tzdata"
+)
+
+const (
+	MinSlotMinutes = 5
+	MaxSlotMinutes = 480
+	// Granularity is the step in minutes all hours and slot lengths align
+	// to, so bookings can reserve time in units of it.
+	Granularity = 5
+	dateLayout  = "2006-01-02"
+)
+
+// span is a stretch of a day in minutes since midnight, end exclusive.
+type span struct {
+	start, end int
+}
+
+// Plan is a validated schedule ready to produce slots.
+type Plan struct {
+	location    *time.Location
+	slotMinutes int
+	weekly      map[time.Weekday][]span
+	breaks      map[time.Weekday][]span
+	dailyBreaks []span
+	holidays    map[string]bool
+	exceptions  map[string][]span
+}
+
+func parseWeekday(day string) (time.Weekday, error) {
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		if strings.EqualFold(day, weekday.String()) {
+			return weekday, nil
+		}
+	}
+	return 0, fmt.Errorf("day %q is not a day of the week", day)
+}
+
+// parseClock reads "15:04". "24:00" is allowed so hours can run to midnight.
+func parseClock(value string) (int, error) {
+	var hour, minute int
+	if n, err := fmt.Sscanf(value, "%d:%d", &hour, &minute); err != nil || n != 2 || len(value) != 5 {
+		return 0, fmt.Errorf("time %q must be written as HH:MM", value)
+	}
+	if hour < 0 || minute < 0 || minute > 59 || hour > 24 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("time %q is not a time of day", value)
+	}
+	if minute%Granularity != 0 {
+		return 0, fmt.Errorf("time %q must fall on a multiple of %d minutes", value, Granularity)
+	}
+	return hour*60 + minute, nil
+}
+
+func parseSpan(start, end string) (span, error) {
+	s, err := parseClock(start)
+	if err != nil {
+		return span{}, err
+	}
+	e, err := parseClock(end)
+	if err != nil {
+		return span{}, err
+	}
+	if s >= e {
+		return span{}, fmt.Errorf("%s-%s must start before it ends", start, end)
+	}
+	return span{s, e}, nil
+}
+
+// sortSpans orders spans and rejects any that overlap, naming what in err.
+func sortSpans(spans []span, what string) error {
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	for i := 1; i < len(spans); i++ {
+		if spans[i].start < spans[i-1].end {
+			return fmt.Errorf("%s has overlapping hours", what)
+		}
+	}
+	return nil
+}
+
+// Compile validates s and prepares it for producing slots.
+func Compile(s models.Schedule) (*Plan, error) {
+	if s.TimeZone == "" {
+		return nil, fmt.Errorf("time_zone is required")
+	}
+	location, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("time_zone %q is not a known IANA time zone", s.TimeZone)
+	}
+	if s.SlotMinutes < MinSlotMinutes || s.SlotMinutes > MaxSlotMinutes || s.SlotMinutes%Granularity != 0 {
+		return nil, fmt.Errorf("slot_minutes must be a multiple of %d between %d and %d", Granularity, MinSlotMinutes, MaxSlotMinutes)
+	}
+	p := &Plan{
+		location:    location,
+		slotMinutes: s.SlotMinutes,
+		weekly:      map[time.Weekday][]span{},
+		breaks:      map[time.Weekday][]span{},
+		holidays:    map[string]bool{},
+		exceptions:  map[string][]span{},
+	}
+
+	for i, hours := range s.Weekly {
+		weekday, err := parseWeekday(hours.Day)
+		if err != nil {
+			return nil, fmt.Errorf("weekly[%d]: %w", i, err)
+		}
+		hoursSpan, err := parseSpan(hours.Start, hours.End)
+		if err != nil {
+			return nil, fmt.Errorf("weekly[%d]: %w", i, err)
+		}
+		p.weekly[weekday] = append(p.weekly[weekday], hoursSpan)
+	}
+	for weekday, spans := range p.weekly {
+		if err := sortSpans(spans, weekday.String()); err != nil {
+			return nil, err
+		}
+	}
+	for i, hours := range s.Breaks {
+		breakSpan, err := parseSpan(hours.Start, hours.End)
+		if err != nil {
+			return nil, fmt.Errorf("breaks[%d]: %w", i, err)
+		}
+		if hours.Day == "" {
+			p.dailyBreaks = append(p.dailyBreaks, breakSpan)
+			continue
+		}
+		weekday, err := parseWeekday(hours.Day)
+		if err != nil {
+			return nil, fmt.Errorf("breaks[%d]: %w", i, err)
+		}
+		p.breaks[weekday] = append(p.breaks[weekday], breakSpan)
+	}
+	for i, holiday := range s.Holidays {
+		date, err := time.Parse(dateLayout, holiday.Date)
+		if err != nil {
+			return nil, fmt.Errorf("holidays[%d]: date %q must be written as YYYY-MM-DD", i, holiday.Date)
+		}
+		p.holidays[date.Format(dateLayout)] = true
+	}
+	for i, exception := range s.Exceptions {
+		date, err := time.Parse(dateLayout, exception.Date)
+		if err != nil {
+			return nil, fmt.Errorf("exceptions[%d]: date %q must be written as YYYY-MM-DD", i, exception.Date)
+		}
+		key := date.Format(dateLayout)
+		if _, ok := p.exceptions[key]; ok {
+			return nil, fmt.Errorf("exceptions[%d]: %s already has an exception", i, key)
+		}
+		if len(exception.Hours) == 0 {
+			return nil, fmt.Errorf("exceptions[%d]: hours are required, use a holiday to close the whole day", i)
+		}
+		spans := make([]span, 0, len(exception.Hours))
+		for _, hours := range exception.Hours {
+			hoursSpan, err := parseSpan(hours.Start, hours.End)
+			if err != nil {
+				return nil, fmt.Errorf("exceptions[%d]: %w", i, err)
+			}
+			spans = append(spans, hoursSpan)
+		}
+		if err := sortSpans(spans, key); err != nil {
+			return nil, err
+		}
+		p.exceptions[key] = spans
+	}
+	return p, nil
+}
+
+func (p *Plan) Location() *time.Location {
+	return p.location
+}
+
+// Days returns the days of the week with weekly hours, Monday first.
+func (p *Plan) Days() []string {
+	days := []string{}
+	for i := 1; i <= 7; i++ {
+		weekday := time.Weekday(i % 7)
+		if len(p.weekly[weekday]) > 0 {
+			days = append(days, weekday.String())
+		}
+	}
+	return days
+}
+
+// subtract removes cuts from spans, which must be sorted.
+func subtract(spans, cuts []span) []span {
+	for _, cut := range cuts {
+		kept := make([]span, 0, len(spans)+1)
+		for _, s := range spans {
+			if cut.end <= s.start || cut.start >= s.end {
+				kept = append(kept, s)
+				continue
+			}
+			if s.start < cut.start {
+				kept = append(kept, span{s.start, cut.start})
+			}
+			if cut.end < s.end {
+				kept = append(kept, span{cut.end, s.end})
+			}
+		}
+		spans = kept
+	}
+	return spans
+}
+
+// hours returns the open hours of a local date after breaks.
+func (p *Plan) hours(date time.Time) []span {
+	key := date.Format(dateLayout)
+	if p.holidays[key] {
+		return nil
+	}
+	spans, ok := p.exceptions[key]
+	if !ok {
+		spans = p.weekly[date.Weekday()]
+	}
+	spans = subtract(spans, p.breaks[date.Weekday()])
+	return subtract(spans, p.dailyBreaks)
+}
+
+func overlaps(slot models.Slot, busy []models.Slot) bool {
+	for _, b := range busy {
+		if slot.Start.Before(b.End) && b.Start.Before(slot.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// Slots returns the free slots lying wholly within [from, to), in order.
+// Slots start at the beginning of each stretch of open hours and do not run
+// past its end; those overlapping a busy interval are left out. Wall-clock
+// times that do not exist because of a daylight saving change are skipped.
+func (p *Plan) Slots(from, to time.Time, busy []models.Slot) []models.Slot {
+	slots := []models.Slot{}
+	first := from.In(p.location)
+	last := to.In(p.location)
+	day := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, p.location)
+	for !day.After(last) {
+		year, month, date := day.Date()
+		for _, open := range p.hours(day) {
+			for minute := open.start; minute+p.slotMinutes <= open.end; minute += p.slotMinutes {
+				start := time.Date(year, month, date, minute/60, minute%60, 0, 0, p.location)
+				if start.Hour()*60+start.Minute() != minute {
+					continue
+				}
+				// Add the length rather than reading the end off the clock, so a
+				// slot the clocks go back in is not an hour longer.
+				end := start.Add(time.Duration(p.slotMinutes) * time.Minute)
+				slot := models.Slot{Start: start, End: end}
+				if start.Before(from) || end.After(to) || overlaps(slot, busy) {
+					continue
+				}
+				slots = append(slots, slot)
+			}
+		}
+		day = time.Date(year, month, date+1, 0, 0, 0, 0, p.location)
+	}
+	return slots
+}
+
+// Slot returns the slot starting at start, free or not, and whether the
+// schedule has one.
+func (p *Plan) Slot(start time.Time) (models.Slot, bool) {
+	local := start.In(p.location)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, p.location)
+	for _, slot := range p.Slots(day, day.AddDate(0, 0, 1), nil) {
+		if slot.Start.Equal(start) {
+			return slot, true
+		}
+	}
+	return models.Slot{}, false
+}
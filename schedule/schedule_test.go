@@ -0,0 +1,243 @@
+package schedule
+
+import (
+	"life-signal/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+var london = mustLoad("Europe/London")
+
+func mustLoad(name string) *time.Location {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return location
+}
+
+func at(month time.Month, day, hour, minute int) time.Time {
+	return time.Date(2025, month, day, hour, minute, 0, 0, london)
+}
+
+func hours(day, start, end string) models.WeeklyHours {
+	return models.WeeklyHours{Day: day, Start: start, End: end}
+}
+
+func TestCompileRejects(t *testing.T) {
+	valid := models.Schedule{TimeZone: "Europe/London", SlotMinutes: 30}
+	tests := []struct {
+		name    string
+		change  func(*models.Schedule)
+		wantErr string
+	}{
+		{"missing time zone", func(s *models.Schedule) { s.TimeZone = "" }, "time_zone is required"},
+		{"unknown time zone", func(s *models.Schedule) { s.TimeZone = "Europe/Atlantis" }, "not a known IANA time zone"},
+		{"slot off the granularity", func(s *models.Schedule) { s.SlotMinutes = 7 }, "slot_minutes must be"},
+		{"unknown day", func(s *models.Schedule) { s.Weekly = []models.WeeklyHours{hours("Funday", "09:00", "12:00")} }, "not a day of the week"},
+		{"end before start", func(s *models.Schedule) { s.Weekly = []models.WeeklyHours{hours("Monday", "12:00", "09:00")} }, "must start before it ends"},
+		{"time off the granularity", func(s *models.Schedule) { s.Weekly = []models.WeeklyHours{hours("Monday", "09:03", "12:00")} }, "multiple of 5 minutes"},
+		{
+			"overlapping weekly hours",
+			func(s *models.Schedule) {
+				s.Weekly = []models.WeeklyHours{hours("Monday", "09:00", "12:00"), hours("Tuesday", "11:00", "13:00"), hours("monday", "11:30", "14:00")}
+			},
+			"Monday has overlapping hours",
+		},
+		{
+			"overlapping exception hours",
+			func(s *models.Schedule) {
+				s.Exceptions = []models.ScheduleException{{Date: "2025-03-10", Hours: []models.TimeRange{{Start: "13:00", End: "15:00"}, {Start: "09:00", End: "13:05"}}}}
+			},
+			"2025-03-10 has overlapping hours",
+		},
+		{
+			"two exceptions for a date",
+			func(s *models.Schedule) {
+				s.Exceptions = []models.ScheduleException{
+					{Date: "2025-03-10", Hours: []models.TimeRange{{Start: "09:00", End: "10:00"}}},
+					{Date: "2025-03-10", Hours: []models.TimeRange{{Start: "11:00", End: "12:00"}}},
+				}
+			},
+			"already has an exception",
+		},
+		{
+			"exception without hours",
+			func(s *models.Schedule) { s.Exceptions = []models.ScheduleException{{Date: "2025-03-10"}} },
+			"hours are required",
+		},
+		{"bad holiday date", func(s *models.Schedule) { s.Holidays = []models.Holiday{{Date: "10/03/2025"}} }, "must be written as YYYY-MM-DD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := valid
+			tt.change(&s)
+			_, err := Compile(s)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Compile error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	adjacent := valid
+	adjacent.Weekly = []models.WeeklyHours{hours("Monday", "09:00", "12:00"), hours("Monday", "12:00", "13:00")}
+	if _, err := Compile(adjacent); err != nil {
+		t.Errorf("Compile with adjacent hours: %v", err)
+	}
+}
+
+func TestSlots(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule models.Schedule
+		from, to time.Time
+		busy     []models.Slot
+		want     []time.Time
+	}{
+		{
+			name: "weekly hours less breaks",
+			schedule: models.Schedule{
+				SlotMinutes: 60,
+				Weekly:      []models.WeeklyHours{hours("Monday", "09:00", "12:00"), hours("Monday", "14:00", "16:00")},
+				Breaks:      []models.WeeklyHours{hours("", "10:00", "11:00")},
+			},
+			from: at(time.March, 10, 0, 0),
+			to:   at(time.March, 11, 0, 0),
+			want: []time.Time{at(time.March, 10, 9, 0), at(time.March, 10, 11, 0), at(time.March, 10, 14, 0), at(time.March, 10, 15, 0)},
+		},
+		{
+			name: "slots must fit wholly within the hours and the range",
+			schedule: models.Schedule{
+				SlotMinutes: 45,
+				Weekly:      []models.WeeklyHours{hours("Monday", "09:00", "11:00")},
+			},
+			from: at(time.March, 10, 9, 30),
+			to:   at(time.March, 10, 11, 0),
+			want: []time.Time{at(time.March, 10, 9, 45)},
+		},
+		{
+			name: "exception replaces the weekly hours",
+			schedule: models.Schedule{
+				SlotMinutes: 60,
+				Weekly:      []models.WeeklyHours{hours("Monday", "09:00", "17:00")},
+				Breaks:      []models.WeeklyHours{hours("Monday", "13:00", "14:00")},
+				Exceptions: []models.ScheduleException{
+					{Date: "2025-03-10", Hours: []models.TimeRange{{Start: "12:00", End: "16:00"}}},
+					{Date: "2025-03-15", Hours: []models.TimeRange{{Start: "10:00", End: "11:00"}}},
+				},
+			},
+			from: at(time.March, 10, 0, 0),
+			to:   at(time.March, 16, 0, 0),
+			want: []time.Time{at(time.March, 10, 12, 0), at(time.March, 10, 14, 0), at(time.March, 10, 15, 0), at(time.March, 15, 10, 0)},
+		},
+		{
+			name: "holiday closes the day",
+			schedule: models.Schedule{
+				SlotMinutes: 60,
+				Weekly:      []models.WeeklyHours{hours("Monday", "09:00", "10:00"), hours("Tuesday", "09:00", "10:00")},
+				Holidays:    []models.Holiday{{Date: "2025-03-10"}},
+			},
+			from: at(time.March, 10, 0, 0),
+			to:   at(time.March, 12, 0, 0),
+			want: []time.Time{at(time.March, 11, 9, 0)},
+		},
+		{
+			name: "busy slots are left out",
+			schedule: models.Schedule{
+				SlotMinutes: 30,
+				Weekly:      []models.WeeklyHours{hours("Monday", "09:00", "11:00")},
+			},
+			from: at(time.March, 10, 0, 0),
+			to:   at(time.March, 11, 0, 0),
+			busy: []models.Slot{
+				{Start: at(time.March, 10, 9, 10), End: at(time.March, 10, 9, 40)},
+				{Start: at(time.March, 10, 10, 0), End: at(time.March, 10, 10, 30)},
+				{Start: at(time.March, 10, 11, 0), End: at(time.March, 10, 12, 0)},
+			},
+			want: []time.Time{at(time.March, 10, 10, 30)},
+		},
+		{
+			name: "spring forward skips the missing hour",
+			schedule: models.Schedule{
+				SlotMinutes: 60,
+				Weekly:      []models.WeeklyHours{hours("Sunday", "00:00", "04:00")},
+			},
+			from: at(time.March, 30, 0, 0),
+			to:   at(time.March, 31, 0, 0),
+			want: []time.Time{at(time.March, 30, 0, 0), at(time.March, 30, 2, 0), at(time.March, 30, 3, 0)},
+		},
+		{
+			name: "fall back offers the repeated hour once",
+			schedule: models.Schedule{
+				SlotMinutes: 60,
+				Weekly:      []models.WeeklyHours{hours("Sunday", "00:00", "04:00")},
+			},
+			from: at(time.October, 26, 0, 0),
+			to:   at(time.October, 27, 0, 0),
+			want: []time.Time{
+				at(time.October, 26, 0, 0),
+				time.Date(2025, time.October, 26, 1, 0, 0, 0, time.UTC),
+				time.Date(2025, time.October, 26, 2, 0, 0, 0, time.UTC),
+				time.Date(2025, time.October, 26, 3, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.schedule.TimeZone = "Europe/London"
+			plan, err := Compile(tt.schedule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			slots := plan.Slots(tt.from, tt.to, tt.busy)
+			if len(slots) != len(tt.want) {
+				t.Fatalf("Slots = %v, want starts %v", slots, tt.want)
+			}
+			length := time.Duration(tt.schedule.SlotMinutes) * time.Minute
+			for i, slot := range slots {
+				if !slot.Start.Equal(tt.want[i]) {
+					t.Errorf("slot %d starts %v, want %v", i, slot.Start, tt.want[i])
+				}
+				if got := slot.End.Sub(slot.Start); got != length {
+					t.Errorf("slot %d lasts %v, want %v", i, got, length)
+				}
+			}
+		})
+	}
+}
+
+func TestSlot(t *testing.T) {
+	plan, err := Compile(models.Schedule{
+		TimeZone:    "Europe/London",
+		SlotMinutes: 30,
+		Weekly:      []models.WeeklyHours{hours("Monday", "09:00", "10:00"), hours("Sunday", "01:00", "03:00")},
+		Holidays:    []models.Holiday{{Date: "2025-03-17"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		start  time.Time
+		wantOK bool
+	}{
+		{"start of a slot", at(time.March, 10, 9, 30), true},
+		{"same instant in another zone", at(time.March, 10, 9, 30).UTC(), true},
+		{"between slots", at(time.March, 10, 9, 15), false},
+		{"outside the hours", at(time.March, 10, 10, 0), false},
+		{"on a holiday", at(time.March, 17, 9, 0), false},
+		{"after the clocks go forward", at(time.March, 30, 2, 30), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slot, ok := plan.Slot(tt.start)
+			if ok != tt.wantOK {
+				t.Fatalf("Slot(%v) found = %t, want %t", tt.start, ok, tt.wantOK)
+			}
+			if ok && (!slot.Start.Equal(tt.start) || slot.End.Sub(slot.Start) != 30*time.Minute) {
+				t.Errorf("Slot(%v) = %v, want a 30 minute slot starting then", tt.start, slot)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package search
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Vocabulary is the set of words a typo can be corrected to.
+type Vocabulary struct {
+	words map[string]bool
+}
+
+func NewVocabulary(texts ...string) *Vocabulary {
+	v := &Vocabulary{words: map[string]bool{}}
+	for _, text := range texts {
+		for _, word := range tokenize(text) {
+			v.words[word] = true
+		}
+	}
+	return v
+}
+
+// maxEdits is how many typos a word of length n may have: none in short
+// words, where a single edit often makes another real word.
+func maxEdits(n int) int {
+	switch {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Correct returns the closest known word to an unknown word and true, or the
+// word and false when it is known or nothing is close enough. Ties go to the
+// alphabetically first word so corrections are stable.
+func (v *Vocabulary) Correct(word string) (string, bool) {
+	if v == nil || v.words[word] {
+		return word, false
+	}
+	limit := maxEdits(len([]rune(word)))
+	best, bestDistance := "", limit+1
+	for candidate := range v.words {
+		distance := editDistance(word, candidate, limit)
+		if distance < bestDistance || (distance == bestDistance && candidate < best) {
+			best, bestDistance = candidate, distance
+		}
+	}
+	if bestDistance > limit {
+		return word, false
+	}
+	return best, true
+}
+
+// editDistance is the optimal string alignment distance between a and b:
+// insertions, deletions, substitutions and swaps of neighbouring letters each
+// count as one edit. Anything above limit is reported as limit+1.
+func editDistance(a, b string, limit int) int {
+	s, t := []rune(a), []rune(b)
+	if abs(len(s)-len(t)) > limit {
+		return limit + 1
+	}
+	rows := make([][]int, len(s)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(t)+1)
+		rows[i][0] = i
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		rowMin := rows[i][0]
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			d := min(rows[i-1][j]+1, rows[i][j-1]+1, rows[i-1][j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				d = min(d, rows[i-2][j-2]+1)
+			}
+			rows[i][j] = d
+			rowMin = min(rowMin, d)
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+	}
+	return min(rows[len(s)][len(t)], limit+1)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+const vocabularyTTL = 5 * time.Minute
+
+var (
+	vocabulary       *Vocabulary
+	vocabularyLoaded time.Time
+	vocabularyMu     sync.Mutex
+)
+
+// CachedVocabulary returns the words from load together with those of the
+// synonyms, reloading them at most every few minutes.
+func CachedVocabulary(ctx context.Context, load func(context.Context) ([]string, error)) (*Vocabulary, error) {
+	vocabularyMu.Lock()
+	defer vocabularyMu.Unlock()
+	if vocabulary != nil && time.Since(vocabularyLoaded) < vocabularyTTL {
+		return vocabulary, nil
+	}
+	texts, err := load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	vocabulary = NewVocabulary(append(texts, Current().words()...)...)
+	vocabularyLoaded = time.Now()
+	return vocabulary, nil
+}
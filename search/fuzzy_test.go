@@ -0,0 +1,52 @@
+package search
+
+import "testing"
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		limit int
+		want  int
+	}{
+		{"dermatologist", "dermatologist", 2, 0},
+		{"dermatolgist", "dermatologist", 2, 1},
+		{"dermatolgoist", "dermatologist", 2, 1},
+		{"cardiolgst", "cardiologist", 2, 2},
+		{"neurologist", "dermatologist", 2, 3},
+		{"gp", "dermatologist", 2, 3},
+		{"mehta", "metha", 1, 1},
+		{"", "ent", 3, 3},
+	}
+	for _, test := range tests {
+		if got := editDistance(test.a, test.b, test.limit); got != test.want {
+			t.Errorf("editDistance(%q, %q, %d) = %d, want %d", test.a, test.b, test.limit, got, test.want)
+		}
+	}
+}
+
+func TestCorrect(t *testing.T) {
+	vocabulary := NewVocabulary("Dermatologist", "Cardiologist", "Ravi Mehta", "Mehta Clinic", "ENT")
+	tests := []struct {
+		word string
+		want string
+		ok   bool
+	}{
+		{"dermatolgist", "dermatologist", true},
+		{"cardiologst", "cardiologist", true},
+		{"metha", "mehta", true},
+		{"dermatologist", "dermatologist", false},
+		{"ent", "ent", false},
+		{"ant", "ant", false},
+		{"neurologist", "neurologist", false},
+	}
+	for _, test := range tests {
+		got, ok := vocabulary.Correct(test.word)
+		if got != test.want || ok != test.ok {
+			t.Errorf("Correct(%q) = %q, %v, want %q, %v", test.word, got, ok, test.want, test.ok)
+		}
+	}
+	var none *Vocabulary
+	if got, ok := none.Correct("dermatolgist"); got != "dermatolgist" || ok {
+		t.Errorf("nil vocabulary corrected to %q", got)
+	}
+}
@@ -0,0 +1,74 @@
+package search
+
+import (
+	"html"
+	"life-signal/models"
+	"strings"
+	"unicode"
+)
+
+// matches reports whether a word of a field matches a search term. The text
+// index stems words, so a term also matches a word sharing all but its last
+// letter when the rest is a short ending, as in "surgery" and "surgeries".
+func matches(word string, terms []string) bool {
+	for _, term := range terms {
+		if word == term {
+			return true
+		}
+		short, long := term, word
+		if len(short) > len(long) {
+			short, long = long, short
+		}
+		prefix := 0
+		for prefix < len(short) && short[prefix] == long[prefix] {
+			prefix++
+		}
+		if prefix >= 4 && prefix >= len(short)-1 && len(long)-prefix <= 3 {
+			return true
+		}
+	}
+	return false
+}
+
+// mark HTML-escapes text and wraps the words matching terms in <mark> tags.
+// It reports whether anything matched.
+func mark(text string, terms []string) (string, bool) {
+	var out strings.Builder
+	found := false
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		j := i
+		isWord := unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])
+		for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j])) == isWord {
+			j++
+		}
+		segment := string(runes[i:j])
+		if isWord && matches(strings.ToLower(segment), terms) {
+			out.WriteString("<mark>" + html.EscapeString(segment) + "</mark>")
+			found = true
+		} else {
+			out.WriteString(html.EscapeString(segment))
+		}
+		i = j
+	}
+	return out.String(), found
+}
+
+// Highlight returns the searchable fields of doctor that match terms, keyed
+// by their JSON name, with the matching words marked.
+func Highlight(doctor models.Doctor, terms []string) map[string]string {
+	highlights := map[string]string{}
+	for field, text := range map[string]string{
+		"first_name":  doctor.FirstName,
+		"last_name":   doctor.LastName,
+		"speciality":  doctor.Speciality,
+		"services":    strings.Join(doctor.Services, ", "),
+		"about":       doctor.About,
+		"clinic_name": doctor.ClinicName,
+	} {
+		if marked, ok := mark(text, terms); ok {
+			highlights[field] = marked
+		}
+	}
+	return highlights
+}
@@ -0,0 +1,186 @@
+// Package search turns what patients type into a doctor text search: it
+// drops filler words, corrects typos against the words doctors are described
+// with and adds synonyms, then highlights what matched.
+package search
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+//go:embed data/synonyms.json
+var bundled []byte
+
+// Synonyms maps everyday words and phrases to the terms doctors are listed
+// under, such as "skin" to Dermatologist.
+type Synonyms struct {
+	Version   string              `json:"version"`
+	Synonyms  map[string][]string `json:"synonyms"`
+	StopWords []string            `json:"stop_words"`
+
+	phrases   map[string][]string
+	maxPhrase int
+	stop      map[string]bool
+}
+
+var (
+	current = mustParse(bundled)
+	mu      sync.RWMutex
+)
+
+// Init switches to the synonyms at path, keeping the bundled ones when path
+// is empty.
+func Init(path string) error {
+	s, err := Load(path)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	current = s
+	return nil
+}
+
+func Current() *Synonyms {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+func Load(path string) (*Synonyms, error) {
+	if path == "" {
+		return parse(bundled)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read synonyms: %w", err)
+	}
+	return parse(data)
+}
+
+func mustParse(data []byte) *Synonyms {
+	s, err := parse(data)
+	if err != nil {
+		panic(fmt.Sprintf("bundled synonyms are invalid: %v", err))
+	}
+	return s
+}
+
+func parse(data []byte) (*Synonyms, error) {
+	var s Synonyms
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse synonyms: %w", err)
+	}
+	if s.Version == "" {
+		return nil, fmt.Errorf("synonyms have no version")
+	}
+	s.phrases = map[string][]string{}
+	for phrase, expansions := range s.Synonyms {
+		words := tokenize(phrase)
+		if len(words) == 0 || len(expansions) == 0 {
+			return nil, fmt.Errorf("synonym %q must have a phrase and at least one expansion", phrase)
+		}
+		s.phrases[strings.Join(words, " ")] = expansions
+		s.maxPhrase = max(s.maxPhrase, len(words))
+	}
+	s.stop = map[string]bool{}
+	for _, word := range s.StopWords {
+		s.stop[strings.ToLower(word)] = true
+	}
+	return &s, nil
+}
+
+// words returns every word the synonyms know, so typos in them can be
+// corrected too.
+func (s *Synonyms) words() []string {
+	words := []string{}
+	for phrase, expansions := range s.Synonyms {
+		words = append(words, phrase)
+		words = append(words, expansions...)
+	}
+	return words
+}
+
+// tokenize splits text into lower case words of letters and digits.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Expansion is a query rewritten for the text index.
+type Expansion struct {
+	// Terms are the words to search for, lower case and without duplicates.
+	Terms []string `json:"terms"`
+	// Corrections maps misspelt words to the words searched instead.
+	Corrections map[string]string `json:"corrections,omitempty"`
+	// Synonyms maps phrases to what they were expanded into.
+	Synonyms map[string][]string `json:"synonyms,omitempty"`
+}
+
+// Text is the expansion as a $text search string. Its words are alternatives,
+// so a doctor matching more of them ranks higher.
+func (e Expansion) Text() string {
+	return strings.Join(e.Terms, " ")
+}
+
+// Expand rewrites query: the longest known phrases are replaced by their
+// synonyms, filler words are dropped and other words are corrected against
+// vocabulary. The words of a phrase are searched as well as its synonyms.
+func (s *Synonyms) Expand(query string, vocabulary *Vocabulary) Expansion {
+	e := Expansion{Corrections: map[string]string{}, Synonyms: map[string][]string{}}
+	seen := map[string]bool{}
+	add := func(words ...string) {
+		for _, word := range words {
+			if !seen[word] && !s.stop[word] {
+				seen[word] = true
+				e.Terms = append(e.Terms, word)
+			}
+		}
+	}
+	expand := func(phrase string) bool {
+		expansions, ok := s.phrases[phrase]
+		if !ok {
+			return false
+		}
+		e.Synonyms[phrase] = expansions
+		add(tokenize(phrase)...)
+		for _, expansion := range expansions {
+			add(tokenize(expansion)...)
+		}
+		return true
+	}
+
+	words := tokenize(query)
+	for i := 0; i < len(words); {
+		matched := 0
+		for n := min(s.maxPhrase, len(words)-i); n > 1; n-- {
+			if expand(strings.Join(words[i:i+n], " ")) {
+				matched = n
+				break
+			}
+		}
+		if matched > 0 {
+			i += matched
+			continue
+		}
+		word := words[i]
+		i++
+		if s.stop[word] {
+			continue
+		}
+		if corrected, ok := vocabulary.Correct(word); ok {
+			e.Corrections[word] = corrected
+			word = corrected
+		}
+		if !expand(word) {
+			add(word)
+		}
+	}
+	return e
+}
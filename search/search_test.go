@@ -0,0 +1,36 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	vocabulary := NewVocabulary(append([]string{"Dermatologist", "Cardiologist", "Ravi Mehta", "Acne treatment"}, Current().words()...)...)
+	tests := []struct {
+		query       string
+		terms       []string
+		corrections map[string]string
+		synonyms    map[string][]string
+	}{
+		{"dermatolgist", []string{"dermatologist"}, map[string]string{"dermatolgist": "dermatologist"}, map[string][]string{}},
+		{"skin doctor", []string{"skin", "dermatologist"}, map[string]string{}, map[string][]string{"skin": {"Dermatologist"}}},
+		{"a doctor for chest pain near me", []string{"chest", "pain", "cardiologist"}, map[string]string{}, map[string][]string{"chest pain": {"Cardiologist"}}},
+		{"Dr Metha", []string{"mehta"}, map[string]string{"metha": "mehta"}, map[string][]string{}},
+		{"eczma rash", []string{"eczema", "dermatologist", "rash"}, map[string]string{"eczma": "eczema"}, map[string][]string{"eczema": {"Dermatologist"}, "rash": {"Dermatologist"}}},
+		{"skn", []string{"skn"}, map[string]string{}, map[string][]string{}},
+		{"the best doctor", nil, map[string]string{}, map[string][]string{}},
+	}
+	for _, test := range tests {
+		e := Current().Expand(test.query, vocabulary)
+		if !reflect.DeepEqual(e.Terms, test.terms) {
+			t.Errorf("Expand(%q) terms %q, want %q", test.query, e.Terms, test.terms)
+		}
+		if !reflect.DeepEqual(e.Corrections, test.corrections) {
+			t.Errorf("Expand(%q) corrections %v, want %v", test.query, e.Corrections, test.corrections)
+		}
+		if !reflect.DeepEqual(e.Synonyms, test.synonyms) {
+			t.Errorf("Expand(%q) synonyms %v, want %v", test.query, e.Synonyms, test.synonyms)
+		}
+	}
+}
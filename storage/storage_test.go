@@ -0,0 +1,276 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 serves the path-style bucket and object calls S3Store makes.
+type fakeS3 struct {
+	mu      sync.Mutex
+	buckets map[string]bool
+	objects map[string][]byte
+	types   map[string]string
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{buckets: map[string]bool{}, objects: map[string][]byte{}, types: map[string]string{}}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if key == "" {
+		switch r.Method {
+		case http.MethodHead:
+			if !f.buckets[bucket] {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case http.MethodPut:
+			f.buckets[bucket] = true
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+		return
+	}
+	if !f.buckets[bucket] {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	name := bucket + "/" + key
+	switch r.Method {
+	case http.MethodPut:
+		body, err := readS3Body(r)
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		f.objects[name] = body
+		f.types[name] = r.Header.Get("Content-Type")
+		w.Header().Set("ETag", `"fake"`)
+	case http.MethodHead, http.MethodGet:
+		data, ok := f.objects[name]
+		if !ok {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Type", f.types[name])
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("ETag", `"fake"`)
+		w.Header().Set("Last-Modified", "Mon, 19 Oct 2026 12:00:00 GMT")
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case http.MethodDelete:
+		delete(f.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+// readS3Body decodes the aws-chunked encoding the client uses over plain
+// HTTP, where each chunk is "<hex size>;chunk-signature=<sig>\r\n<data>\r\n".
+func readS3Body(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+	reader := bufio.NewReader(r.Body)
+	var body bytes.Buffer
+	for {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		rawSize, _, _ := strings.Cut(strings.TrimSpace(header), ";")
+		size, err := strconv.ParseInt(rawSize, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return body.Bytes(), nil
+		}
+		if _, err := io.CopyN(&body, reader, size); err != nil {
+			return nil, err
+		}
+		if _, err := reader.Discard(2); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func testBlobStore(t *testing.T, store BlobStore) {
+	t.Helper()
+	ctx := context.Background()
+	key := "users/u1/a1"
+	content := []byte("%PDF-1.4 lab report")
+	if err := store.Put(ctx, key, bytes.NewReader(content), int64(len(content)), "application/pdf"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	blob, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	got, err := io.ReadAll(blob)
+	blob.Close()
+	if err != nil {
+		t.Fatalf("read blob: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("Get returned %q, want %q", got, content)
+	}
+
+	replaced := []byte("second version")
+	if err := store.Put(ctx, key, bytes.NewReader(replaced), int64(len(replaced)), "text/plain"); err != nil {
+		t.Fatalf("Put again: %v", err)
+	}
+	blob, err = store.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get after overwrite: %v", err)
+	}
+	got, _ = io.ReadAll(blob)
+	blob.Close()
+	if !bytes.Equal(got, replaced) {
+		t.Errorf("Get after overwrite returned %q, want %q", got, replaced)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("Delete of a missing blob = %v, want nil", err)
+	}
+	if _, err := store.Get(ctx, "users/u1/missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a missing blob = %v, want ErrNotFound", err)
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	testBlobStore(t, store)
+
+	for _, key := range []string{"", "/", "../outside", "users/../../outside"} {
+		if err := store.Put(context.Background(), key, strings.NewReader("x"), 1, "text/plain"); err == nil {
+			t.Errorf("Put(%q) accepted a key outside the root", key)
+		}
+	}
+}
+
+func TestS3Store(t *testing.T) {
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	store, err := NewS3Store(context.Background(), S3Config{
+		Endpoint:  strings.TrimPrefix(server.URL, "http://"),
+		AccessKey: "test",
+		SecretKey: "test-secret",
+		Bucket:    "attachments",
+		Region:    "us-east-1",
+		UseSSL:    false,
+	})
+	if err != nil {
+		t.Fatalf("NewS3Store: %v", err)
+	}
+	if !fake.buckets["attachments"] {
+		t.Fatal("NewS3Store did not create the missing bucket")
+	}
+	testBlobStore(t, store)
+	if got := fake.types["attachments/users/u1/a1"]; got != "text/plain" {
+		t.Errorf("stored content type %q, want text/plain", got)
+	}
+
+	if _, err := NewS3Store(context.Background(), S3Config{Bucket: "attachments"}); err == nil {
+		t.Error("NewS3Store accepted a config without an endpoint")
+	}
+}
+
+// fakeClamd answers one INSTREAM request with reply, after reading the
+// whole stream.
+func fakeClamd(t *testing.T, reply string) (string, *bytes.Buffer) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	received := &bytes.Buffer{}
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		command, _ := reader.ReadString(0)
+		if command != "zINSTREAM\x00" {
+			return
+		}
+		size := make([]byte, 4)
+		for {
+			if _, err := io.ReadFull(reader, size); err != nil {
+				return
+			}
+			n := binary.BigEndian.Uint32(size)
+			if n == 0 {
+				break
+			}
+			io.CopyN(received, reader, int64(n))
+		}
+		conn.Write([]byte(reply + "\x00"))
+	}()
+	return listener.Addr().String(), received
+}
+
+func TestClamdScanner(t *testing.T) {
+	content := strings.Repeat("clean ", clamdChunkSize/4)
+
+	addr, received := fakeClamd(t, "stream: OK")
+	if err := (&ClamdScanner{Addr: addr}).Scan(context.Background(), strings.NewReader(content)); err != nil {
+		t.Errorf("Scan of a clean file = %v", err)
+	}
+	if received.String() != content {
+		t.Errorf("clamd received %d bytes, want %d", received.Len(), len(content))
+	}
+
+	addr, _ = fakeClamd(t, "stream: Eicar-Test-Signature FOUND")
+	err := (&ClamdScanner{Addr: addr}).Scan(context.Background(), strings.NewReader("X5O!P%@AP"))
+	var infected *InfectedError
+	if !errors.As(err, &infected) || infected.Signature != "Eicar-Test-Signature" {
+		t.Errorf("Scan of an infected file = %v, want InfectedError with the signature", err)
+	}
+
+	addr, _ = fakeClamd(t, "INSTREAM size limit exceeded. ERROR")
+	if err := (&ClamdScanner{Addr: addr}).Scan(context.Background(), strings.NewReader("x")); err == nil || errors.As(err, &infected) {
+		t.Errorf("Scan with a clamd error = %v, want a plain error", err)
+	}
+}
@@ -0,0 +1,351 @@
+package summary
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"life-signal/dosage"
+	"life-signal/models"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	recentAppointmentWindow = 12 // months
+	maxRecentAppointments   = 10
+)
+
+// Summary is what goes on a printed health summary.
+type Summary struct {
+	ID                   string
+	User                 models.UserDetails
+	ActiveIssues         []models.Issue
+	CurrentPrescriptions []models.Prescription
+	Allergies            []models.Allergy
+	RecentAppointments   []models.Appointment
+	GeneratedAt          time.Time
+	VerifyURL            string
+}
+
+func active(end *time.Time, now time.Time) bool {
+	return end == nil || end.After(now)
+}
+
+// Collect picks what a summary shows from the user's history: unresolved
+// issues and allergies, prescriptions that have not ended and appointments
+// from the last year onwards, newest first.
+func Collect(user models.UserDetails, history *models.MedicalHistory, now time.Time) Summary {
+	s := Summary{User: user, GeneratedAt: now}
+	if history == nil {
+		return s
+	}
+	for _, issue := range history.MedicalIssues {
+		if active(issue.EndDate, now) {
+			s.ActiveIssues = append(s.ActiveIssues, issue)
+		}
+	}
+	for _, prescription := range history.Prescriptions {
+		if active(prescription.EndDate, now) {
+			s.CurrentPrescriptions = append(s.CurrentPrescriptions, prescription)
+		}
+	}
+	for _, allergy := range history.Allergies {
+		if active(allergy.EndDate, now) {
+			s.Allergies = append(s.Allergies, allergy)
+		}
+	}
+	since := now.AddDate(0, -recentAppointmentWindow, 0)
+	for _, appointment := range history.Appointments {
+		if !appointment.AppointmentDate.Before(since) {
+			s.RecentAppointments = append(s.RecentAppointments, appointment)
+		}
+	}
+	sort.SliceStable(s.RecentAppointments, func(i, j int) bool {
+		return s.RecentAppointments[i].AppointmentDate.After(s.RecentAppointments[j].AppointmentDate)
+	})
+	if len(s.RecentAppointments) > maxRecentAppointments {
+		s.RecentAppointments = s.RecentAppointments[:maxRecentAppointments]
+	}
+	return s
+}
+
+const (
+	pageMargin = 15.0
+	lineHeight = 5.0
+	qrSize     = 32.0
+	fontFamily = "Helvetica"
+)
+
+type renderer struct {
+	pdf       *fpdf.Fpdf
+	translate func(string) string
+	width     float64
+}
+
+// Render writes s as an A4 PDF. Output is deterministic for a given summary,
+// which keeps it comparable byte for byte.
+func Render(s Summary, w io.Writer) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetCreationDate(s.GeneratedAt)
+	pdf.SetModificationDate(s.GeneratedAt)
+	pdf.SetCatalogSort(true)
+	pdf.SetTitle("Health summary", true)
+	pdf.SetMargins(pageMargin, pageMargin+15, pageMargin)
+	pdf.SetAutoPageBreak(true, pageMargin+10)
+	pdf.AliasNbPages("")
+
+	pageWidth, _ := pdf.GetPageSize()
+	r := &renderer{pdf: pdf, translate: pdf.UnicodeTranslatorFromDescriptor(""), width: pageWidth - 2*pageMargin}
+	name := strings.TrimSpace(s.User.FirstName + " " + s.User.LastName)
+	if name == "" {
+		name = s.User.Username
+	}
+	generated := s.GeneratedAt.UTC().Format("2 Jan 2006 15:04 MST")
+
+	pdf.SetHeaderFunc(func() {
+		pdf.SetXY(pageMargin, pageMargin)
+		pdf.SetFont(fontFamily, "B", 14)
+		pdf.CellFormat(r.width/2, 7, "Life Signal health summary", "", 0, "L", false, 0, "")
+		pdf.SetFont(fontFamily, "", 9)
+		pdf.CellFormat(r.width/2, 7, r.translate(name+"  |  Generated "+generated), "", 1, "R", false, 0, "")
+		pdf.SetDrawColor(120, 120, 120)
+		pdf.Line(pageMargin, pageMargin+9, pageMargin+r.width, pageMargin+9)
+		pdf.SetY(pageMargin + 15)
+	})
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-pageMargin - 3)
+		pdf.SetFont(fontFamily, "", 8)
+		pdf.SetTextColor(100, 100, 100)
+		pdf.CellFormat(r.width/2, 5, "Summary "+s.ID, "", 0, "L", false, 0, "")
+		pdf.CellFormat(r.width/2, 5, fmt.Sprintf("Page %d of {nb}", pdf.PageNo()), "", 0, "R", false, 0, "")
+		pdf.SetTextColor(0, 0, 0)
+	})
+
+	pdf.AddPage()
+	if err := r.patient(s, name); err != nil {
+		return err
+	}
+	r.allergies(s.Allergies)
+	r.issues(s.ActiveIssues)
+	r.prescriptions(s.CurrentPrescriptions)
+	r.appointments(s.RecentAppointments)
+
+	if err := pdf.Error(); err != nil {
+		return fmt.Errorf("failed to render summary: %w", err)
+	}
+	return pdf.Output(w)
+}
+
+// patient prints the contact block with the verification QR code beside it.
+func (r *renderer) patient(s Summary, name string) error {
+	top := r.pdf.GetY()
+	if s.VerifyURL != "" {
+		png, err := qrcode.Encode(s.VerifyURL, qrcode.Medium, 256)
+		if err != nil {
+			return fmt.Errorf("failed to encode QR code: %w", err)
+		}
+		options := fpdf.ImageOptions{ImageType: "PNG"}
+		r.pdf.RegisterImageOptionsReader("verify-qr", options, bytes.NewReader(png))
+		x := pageMargin + r.width - qrSize
+		r.pdf.ImageOptions("verify-qr", x, top, qrSize, qrSize, false, options, 0, s.VerifyURL)
+		r.pdf.SetFont(fontFamily, "", 7)
+		r.pdf.SetXY(x, top+qrSize)
+		r.pdf.CellFormat(qrSize, 4, "Scan to verify", "", 0, "C", false, 0, "")
+	}
+
+	r.pdf.SetXY(pageMargin, top)
+	r.heading("Patient")
+	for _, field := range [][2]string{
+		{"Name", name},
+		{"Username", s.User.Username},
+		{"Phone", s.User.Phone},
+		{"Email", s.User.Email},
+		{"Generated", s.GeneratedAt.UTC().Format(time.RFC1123)},
+	} {
+		r.pdf.SetFont(fontFamily, "B", 10)
+		r.pdf.CellFormat(28, 6, field[0], "", 0, "L", false, 0, "")
+		r.pdf.SetFont(fontFamily, "", 10)
+		r.pdf.CellFormat(r.width-qrSize-32, 6, r.translate(field[1]), "", 1, "L", false, 0, "")
+	}
+	if bottom := top + qrSize + 6; r.pdf.GetY() < bottom {
+		r.pdf.SetY(bottom)
+	}
+	return nil
+}
+
+// ensureSpace starts a new page unless height fits above the footer.
+func (r *renderer) ensureSpace(height float64) {
+	_, pageHeight := r.pdf.GetPageSize()
+	if r.pdf.GetY()+height > pageHeight-pageMargin-10 {
+		r.pdf.AddPage()
+	}
+}
+
+// heading keeps a section title on the same page as its first rows.
+func (r *renderer) heading(title string) {
+	r.ensureSpace(3 + 7 + 2*(lineHeight+1))
+	r.pdf.Ln(3)
+	r.pdf.SetFont(fontFamily, "B", 12)
+	r.pdf.CellFormat(0, 7, title, "", 1, "L", false, 0, "")
+}
+
+func (r *renderer) empty(text string) {
+	r.pdf.SetFont(fontFamily, "I", 10)
+	r.pdf.CellFormat(0, 6, text, "", 1, "L", false, 0, "")
+}
+
+// table prints rows under headers, repeating the headers when a row has to
+// move to a new page. Widths are fractions of the printable width.
+func (r *renderer) table(headers []string, widths []float64, rows [][]string) {
+	absolute := make([]float64, len(widths))
+	for i, fraction := range widths {
+		absolute[i] = fraction * r.width
+	}
+	r.pdf.SetFillColor(230, 230, 230)
+	r.ensureSpace(2 * (lineHeight + 1))
+	r.row(headers, absolute, true)
+	for _, cells := range rows {
+		if r.row(cells, absolute, false) {
+			continue
+		}
+		// The row did not fit: start a new page and try again.
+		r.pdf.AddPage()
+		r.row(headers, absolute, true)
+		r.row(cells, absolute, false)
+	}
+	r.pdf.Ln(2)
+}
+
+// row prints one table row and reports whether it fit on the page. A row
+// taller than a whole page is printed anyway.
+func (r *renderer) row(cells []string, widths []float64, header bool) bool {
+	style := ""
+	if header {
+		style = "B"
+	}
+	r.pdf.SetFont(fontFamily, style, 9)
+	lines := make([][]string, len(cells))
+	count := 1
+	for i, cell := range cells {
+		lines[i] = r.split(cell, widths[i]-2)
+		if len(lines[i]) > count {
+			count = len(lines[i])
+		}
+	}
+	height := float64(count)*lineHeight + 1
+	_, pageHeight := r.pdf.GetPageSize()
+	if !header && r.pdf.GetY()+height > pageHeight-pageMargin-10 && height < pageHeight/2 {
+		return false
+	}
+
+	x, y := pageMargin, r.pdf.GetY()
+	fill := "D"
+	if header {
+		fill = "FD"
+	}
+	for i := range cells {
+		r.pdf.Rect(x, y, widths[i], height, fill)
+		for j, line := range lines[i] {
+			r.pdf.Text(x+1, y+float64(j+1)*lineHeight-0.5, line)
+		}
+		x += widths[i]
+	}
+	r.pdf.SetXY(pageMargin, y+height)
+	return true
+}
+
+// split wraps text to width.
+func (r *renderer) split(text string, width float64) []string {
+	encoded := r.translate(text)
+	runes := make([]rune, len(encoded))
+	for i := 0; i < len(encoded); i++ {
+		runes[i] = rune(encoded[i])
+	}
+	lines := r.pdf.SplitText(string(runes), width)
+	for i, line := range lines {
+		raw := make([]byte, 0, len(line))
+		for _, c := range line {
+			raw = append(raw, byte(c))
+		}
+		lines[i] = string(raw)
+	}
+	return lines
+}
+
+func date(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format("2 Jan 2006")
+}
+
+func codeText(coding *models.Coding) string {
+	if coding == nil {
+		return ""
+	}
+	return coding.System + " " + coding.Code
+}
+
+func (r *renderer) allergies(allergies []models.Allergy) {
+	r.heading("Allergies")
+	if len(allergies) == 0 {
+		r.empty("No known allergies")
+		return
+	}
+	rows := make([][]string, 0, len(allergies))
+	for _, allergy := range allergies {
+		rows = append(rows, []string{allergy.Substance, allergy.Reaction, allergy.Severity, allergy.Criticality})
+	}
+	r.table([]string{"Substance", "Reaction", "Severity", "Criticality"}, []float64{0.35, 0.35, 0.15, 0.15}, rows)
+}
+
+func (r *renderer) issues(issues []models.Issue) {
+	r.heading("Active conditions")
+	if len(issues) == 0 {
+		r.empty("No active conditions")
+		return
+	}
+	rows := make([][]string, 0, len(issues))
+	for _, issue := range issues {
+		rows = append(rows, []string{issue.Condition, codeText(issue.ConditionCode), issue.Severity, date(issue.StartDate), issue.Notes})
+	}
+	r.table([]string{"Condition", "Code", "Severity", "Since", "Notes"}, []float64{0.25, 0.2, 0.12, 0.13, 0.3}, rows)
+}
+
+func (r *renderer) prescriptions(prescriptions []models.Prescription) {
+	r.heading("Current medication")
+	if len(prescriptions) == 0 {
+		r.empty("No current medication")
+		return
+	}
+	rows := make([][]string, 0, len(prescriptions))
+	for _, prescription := range prescriptions {
+		sig := prescription.Dosage
+		if prescription.StructuredDosage != nil {
+			sig = dosage.Render(*prescription.StructuredDosage)
+		}
+		until := ""
+		if prescription.EndDate != nil {
+			until = date(*prescription.EndDate)
+		}
+		rows = append(rows, []string{prescription.MedicationName, sig, date(prescription.StartDate), until})
+	}
+	r.table([]string{"Medication", "Dosage", "Since", "Until"}, []float64{0.3, 0.4, 0.15, 0.15}, rows)
+}
+
+func (r *renderer) appointments(appointments []models.Appointment) {
+	r.heading("Recent appointments")
+	if len(appointments) == 0 {
+		r.empty("No appointments in the last year")
+		return
+	}
+	rows := make([][]string, 0, len(appointments))
+	for _, appointment := range appointments {
+		rows = append(rows, []string{date(appointment.AppointmentDate), appointment.DoctorName, appointment.Notes})
+	}
+	r.table([]string{"Date", "Doctor", "Notes"}, []float64{0.15, 0.3, 0.55}, rows)
+}
@@ -0,0 +1,121 @@
+package summary
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"life-signal/models"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func testSummary(now time.Time) Summary {
+	ended := now.AddDate(0, -1, 0)
+	user := models.UserDetails{ID: "52e6cc3d-664b-45f5-8d2c-e29e521ca5f1", Username: "asha", FirstName: "Asha", LastName: "Rāo", Email: "asha@example.com", Phone: "+15555550100"}
+	history := &models.MedicalHistory{
+		MedicalIssues: []models.Issue{
+			{Condition: "Type 2 diabetes", ConditionCode: &models.Coding{System: "http://snomed.info/sct", Code: "44054006"}, Severity: "Moderate", StartDate: now.AddDate(-2, 0, 0)},
+			{Condition: "Sprained ankle", Severity: "Mild", StartDate: now.AddDate(0, -3, 0), EndDate: &ended},
+		},
+		Prescriptions: []models.Prescription{
+			{MedicationName: "Metformin 500 mg", Dosage: "1 tablet twice daily with meals", StartDate: now.AddDate(-2, 0, 0)},
+			{MedicationName: "Ibuprofen", Dosage: "as needed", StartDate: now.AddDate(0, -3, 0), EndDate: &ended},
+		},
+		Allergies: []models.Allergy{
+			{Substance: "Penicillin", Category: "medication", Criticality: "high", Reaction: "Hives", Severity: "Moderate"},
+		},
+		Appointments: []models.Appointment{
+			{DoctorName: "Dr. Mehta", AppointmentDate: now.AddDate(0, -2, 0), Notes: "Follow-up, HbA1c 6.9%"},
+			{DoctorName: "Dr. Mehta", AppointmentDate: now.AddDate(-2, 0, 0), Notes: "Too old to show"},
+		},
+	}
+	s := Collect(user, history, now)
+	s.ID = "hs-0001"
+	s.VerifyURL = "https://life-signal.example/v1/summary/verify/hs-0001"
+	return s
+}
+
+// longSummary has enough rows to spill onto further pages.
+func longSummary(now time.Time) Summary {
+	s := testSummary(now)
+	for i := 0; i < 60; i++ {
+		s.CurrentPrescriptions = append(s.CurrentPrescriptions, models.Prescription{
+			MedicationName: fmt.Sprintf("Medication %02d", i),
+			Dosage:         "1 tablet every morning, taken with water and not on an empty stomach",
+			StartDate:      now.AddDate(0, 0, -i),
+		})
+	}
+	return s
+}
+
+func render(t *testing.T, s Summary) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	if err := Render(s, &out); err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	return out.Bytes()
+}
+
+func TestRenderMatchesGolden(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		summary Summary
+	}{
+		{"summary", testSummary(now)},
+		{"empty", Summary{ID: "hs-0002", User: models.UserDetails{Username: "newuser"}, GeneratedAt: now, VerifyURL: "https://life-signal.example/v1/summary/verify/hs-0002"}},
+		{"multipage", longSummary(now)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := render(t, test.summary)
+			if again := render(t, test.summary); !bytes.Equal(got, again) {
+				t.Fatal("rendering the same summary twice gave different bytes")
+			}
+			golden := filepath.Join("testdata", test.name+".pdf")
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatalf("write golden file: %v", err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("read golden file, run go test ./summary -update to create it: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("Render output differs from %s (%d bytes, want %d); run go test ./summary -update if the change is intended", golden, len(got), len(want))
+			}
+		})
+	}
+}
+
+func TestCollect(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	s := testSummary(now)
+	if len(s.ActiveIssues) != 1 || s.ActiveIssues[0].Condition != "Type 2 diabetes" {
+		t.Errorf("active issues %+v, want only the unresolved one", s.ActiveIssues)
+	}
+	if len(s.CurrentPrescriptions) != 1 || s.CurrentPrescriptions[0].MedicationName != "Metformin 500 mg" {
+		t.Errorf("current prescriptions %+v, want only the one that has not ended", s.CurrentPrescriptions)
+	}
+	if len(s.RecentAppointments) != 1 {
+		t.Errorf("%d recent appointments, want the one from the last year", len(s.RecentAppointments))
+	}
+
+	var history models.MedicalHistory
+	for i := 0; i < maxRecentAppointments+3; i++ {
+		history.Appointments = append(history.Appointments, models.Appointment{AppointmentDate: now.AddDate(0, 0, -i)})
+	}
+	s = Collect(models.UserDetails{}, &history, now)
+	if len(s.RecentAppointments) != maxRecentAppointments {
+		t.Fatalf("%d recent appointments, want %d", len(s.RecentAppointments), maxRecentAppointments)
+	}
+	if !s.RecentAppointments[0].AppointmentDate.Equal(now) {
+		t.Error("recent appointments are not newest first")
+	}
+}
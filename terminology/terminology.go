@@ -0,0 +1,217 @@
+package terminology
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	ICD10  = "http://hl7.org/fhir/sid/icd-10-cm"
+	SNOMED = "http://snomed.info/sct"
+	RxNorm = "http://www.nlm.nih.gov/research/umls/rxnorm"
+	CVX    = "http://hl7.org/fhir/sid/cvx"
+)
+
+// Aliases lets API callers name a code system without its full URI.
+var Aliases = map[string]string{
+	"icd10":  ICD10,
+	"icd-10": ICD10,
+	"snomed": SNOMED,
+	"sct":    SNOMED,
+	"rxnorm": RxNorm,
+	"cvx":    CVX,
+}
+
+//go:embed data/terminology.json
+var bundled []byte
+
+type Concept struct {
+	System   string   `json:"system"`
+	Code     string   `json:"code"`
+	Display  string   `json:"display"`
+	Synonyms []string `json:"synonyms,omitempty"`
+}
+
+type Terminology struct {
+	Version  string    `json:"version"`
+	Concepts []Concept `json:"concepts"`
+
+	index map[string]int
+}
+
+var (
+	current = mustParse(bundled)
+	mu      sync.RWMutex
+)
+
+// Init replaces the bundled terminology with the one at path, if any.
+func Init(path string) error {
+	t, err := Load(path)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	current = t
+	return nil
+}
+
+// Current returns the terminology in use.
+func Current() *Terminology {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+func Load(path string) (*Terminology, error) {
+	if path == "" {
+		return parse(bundled)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read terminology file: %w", err)
+	}
+	return parse(data)
+}
+
+func mustParse(data []byte) *Terminology {
+	t, err := parse(data)
+	if err != nil {
+		panic(fmt.Sprintf("bundled terminology is invalid: %v", err))
+	}
+	return t
+}
+
+func parse(data []byte) (*Terminology, error) {
+	var t Terminology
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("failed to parse terminology: %w", err)
+	}
+	t.index = make(map[string]int, len(t.Concepts))
+	for i, concept := range t.Concepts {
+		if concept.System == "" || concept.Code == "" || concept.Display == "" {
+			return nil, fmt.Errorf("concept %d is missing system, code or display", i)
+		}
+		key := concept.System + "|" + concept.Code
+		if _, dup := t.index[key]; dup {
+			return nil, fmt.Errorf("duplicate concept %s", key)
+		}
+		t.index[key] = i
+	}
+	return &t, nil
+}
+
+// ResolveSystem turns an alias into a system URI. Unknown values are returned
+// unchanged so full URIs pass through.
+func ResolveSystem(system string) string {
+	if uri, ok := Aliases[strings.ToLower(system)]; ok {
+		return uri
+	}
+	return system
+}
+
+func (t *Terminology) Lookup(system, code string) (Concept, bool) {
+	i, ok := t.index[ResolveSystem(system)+"|"+code]
+	if !ok {
+		return Concept{}, false
+	}
+	return t.Concepts[i], true
+}
+
+// Match finds the concept whose display or a synonym equals text, ignoring
+// case.
+func (t *Terminology) Match(text, system string) (Concept, bool) {
+	system = ResolveSystem(system)
+	text = strings.ToLower(strings.TrimSpace(text))
+	for _, concept := range t.Concepts {
+		if concept.System != system {
+			continue
+		}
+		if strings.ToLower(concept.Display) == text {
+			return concept, true
+		}
+		for _, synonym := range concept.Synonyms {
+			if strings.ToLower(synonym) == text {
+				return concept, true
+			}
+		}
+	}
+	return Concept{}, false
+}
+
+// Search ranks concepts for autocomplete: an exact code first, then display
+// prefixes, word prefixes and synonym matches, then plain substrings.
+func (t *Terminology) Search(query, system string, limit int) []Concept {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return []Concept{}
+	}
+	if system != "" {
+		system = ResolveSystem(system)
+	}
+
+	type hit struct {
+		concept Concept
+		score   int
+	}
+	var hits []hit
+	for _, concept := range t.Concepts {
+		if system != "" && concept.System != system {
+			continue
+		}
+		if score, ok := matchScore(concept, query); ok {
+			hits = append(hits, hit{concept: concept, score: score})
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].score != hits[j].score {
+			return hits[i].score < hits[j].score
+		}
+		return hits[i].concept.Display < hits[j].concept.Display
+	})
+
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	results := make([]Concept, len(hits))
+	for i, h := range hits {
+		results[i] = h.concept
+	}
+	return results
+}
+
+func matchScore(concept Concept, query string) (int, bool) {
+	display := strings.ToLower(concept.Display)
+	switch {
+	case strings.ToLower(concept.Code) == query:
+		return 0, true
+	case strings.HasPrefix(display, query):
+		return 1, true
+	case strings.HasPrefix(strings.ToLower(concept.Code), query):
+		return 2, true
+	}
+	for _, word := range strings.Fields(display) {
+		if strings.HasPrefix(word, query) {
+			return 3, true
+		}
+	}
+	for _, synonym := range concept.Synonyms {
+		if strings.HasPrefix(strings.ToLower(synonym), query) {
+			return 3, true
+		}
+	}
+	if strings.Contains(display, query) {
+		return 4, true
+	}
+	for _, synonym := range concept.Synonyms {
+		if strings.Contains(strings.ToLower(synonym), query) {
+			return 4, true
+		}
+	}
+	return 0, false
+}
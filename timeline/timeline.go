@@ -0,0 +1,285 @@
+package timeline
+
+import (
+	"encoding/base64"
+	"fmt"
+	"life-signal/models"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TypeConditionOnset     = "condition-onset"
+	TypeConditionResolved  = "condition-resolved"
+	TypePrescriptionStart  = "prescription-start"
+	TypePrescriptionStop   = "prescription-stop"
+	TypeAppointment        = "appointment"
+	TypeAllergyOnset       = "allergy-onset"
+	TypeAllergyResolved    = "allergy-resolved"
+	TypeImmunization       = "immunization"
+	TypeVitalSign          = "vital-sign"
+	TypeLabResult          = "lab-result"
+	TypeAttachmentUploaded = "attachment-uploaded"
+)
+
+// Categories maps every event type to the record type it comes from. Filters
+// accept either.
+var Categories = map[string]string{
+	TypeConditionOnset:     "condition",
+	TypeConditionResolved:  "condition",
+	TypePrescriptionStart:  "prescription",
+	TypePrescriptionStop:   "prescription",
+	TypeAppointment:        "appointment",
+	TypeAllergyOnset:       "allergy",
+	TypeAllergyResolved:    "allergy",
+	TypeImmunization:       "immunization",
+	TypeVitalSign:          "vital-sign",
+	TypeLabResult:          "lab-result",
+	TypeAttachmentUploaded: "attachment",
+}
+
+// Event is one dated entry in a patient's timeline. Record is the entry it
+// was derived from, e.g. the models.Issue for a condition onset.
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Category  string    `json:"category"`
+	Date      time.Time `json:"date"`
+	Title     string    `json:"title"`
+	Condition string    `json:"condition,omitempty"`
+	Record    any       `json:"record"`
+}
+
+// Sources holds every record a timeline is built from. Any of them may be
+// empty.
+type Sources struct {
+	History     *models.MedicalHistory
+	VitalSigns  []models.VitalSign
+	LabResults  []models.LabObservation
+	Attachments []models.Attachment
+}
+
+func newEvent(eventType, id string, date time.Time, title string, record any) Event {
+	return Event{ID: eventType + ":" + id, Type: eventType, Category: Categories[eventType], Date: date, Title: title, Record: record}
+}
+
+// entryID identifies a history entry by its ID, or by its position for
+// entries stored before they had one.
+func entryID(id string, i int) string {
+	if id == "" {
+		return strconv.Itoa(i)
+	}
+	return id
+}
+
+// Build turns sources into events. The result is not sorted.
+func Build(sources Sources) []Event {
+	var events []Event
+	attachmentConditions := map[string]string{}
+	if history := sources.History; history != nil {
+		for i, issue := range history.MedicalIssues {
+			id := entryID(issue.ID, i)
+			onset := newEvent(TypeConditionOnset, id, issue.StartDate, issue.Condition, issue)
+			onset.Condition = issue.Condition
+			events = append(events, onset)
+			if issue.EndDate != nil {
+				resolved := newEvent(TypeConditionResolved, id, *issue.EndDate, issue.Condition+" resolved", issue)
+				resolved.Condition = issue.Condition
+				events = append(events, resolved)
+			}
+			for _, attachmentID := range issue.AttachmentIDs {
+				attachmentConditions[attachmentID] = issue.Condition
+			}
+		}
+		for i, prescription := range history.Prescriptions {
+			id := entryID(prescription.ID, i)
+			title := prescription.MedicationName
+			if prescription.Dosage != "" {
+				title += " " + prescription.Dosage
+			}
+			events = append(events, newEvent(TypePrescriptionStart, id, prescription.StartDate, "Started "+title, prescription))
+			if prescription.EndDate != nil {
+				events = append(events, newEvent(TypePrescriptionStop, id, *prescription.EndDate, "Stopped "+prescription.MedicationName, prescription))
+			}
+		}
+		for i, appointment := range history.Appointments {
+			title := "Appointment"
+			if appointment.DoctorName != "" {
+				title += " with " + appointment.DoctorName
+			}
+			events = append(events, newEvent(TypeAppointment, entryID(appointment.ID, i), appointment.AppointmentDate, title, appointment))
+		}
+		for i, allergy := range history.Allergies {
+			id := strconv.Itoa(i)
+			if allergy.OnsetDate != nil {
+				events = append(events, newEvent(TypeAllergyOnset, id, *allergy.OnsetDate, "Allergy to "+allergy.Substance, allergy))
+			}
+			if allergy.EndDate != nil {
+				events = append(events, newEvent(TypeAllergyResolved, id, *allergy.EndDate, "Allergy to "+allergy.Substance+" resolved", allergy))
+			}
+		}
+		for i, immunization := range history.Immunizations {
+			title := immunization.VaccineName
+			if immunization.DoseNumber > 0 {
+				title += fmt.Sprintf(" dose %d", immunization.DoseNumber)
+			}
+			events = append(events, newEvent(TypeImmunization, strconv.Itoa(i), immunization.OccurrenceDate, title, immunization))
+		}
+	}
+
+	for _, vital := range sources.VitalSigns {
+		value := strconv.FormatFloat(vital.Value, 'f', -1, 64)
+		if vital.Diastolic != nil {
+			value += "/" + strconv.FormatFloat(*vital.Diastolic, 'f', -1, 64)
+		}
+		title := fmt.Sprintf("%s %s %s", vital.Type, value, vital.Unit)
+		events = append(events, newEvent(TypeVitalSign, vital.ID, vital.MeasuredAt, title, vital))
+	}
+	for _, lab := range sources.LabResults {
+		title := strings.TrimSpace(fmt.Sprintf("%s %s %s", lab.Display, lab.Value, lab.Unit))
+		if lab.AbnormalFlag != "" && lab.AbnormalFlag != "N" {
+			title += " (" + lab.AbnormalFlag + ")"
+		}
+		events = append(events, newEvent(TypeLabResult, lab.ID, lab.ObservedAt, title, lab))
+	}
+	for _, attachment := range sources.Attachments {
+		event := newEvent(TypeAttachmentUploaded, attachment.ID, attachment.UploadedAt, attachment.FileName, attachment)
+		event.Condition = attachmentConditions[attachment.ID]
+		events = append(events, event)
+	}
+	return events
+}
+
+// Query selects and orders a page of events. From is inclusive, To exclusive;
+// zero values leave the range open. Types holds event types or categories.
+type Query struct {
+	From      time.Time
+	To        time.Time
+	Types     map[string]bool
+	Ascending bool
+	Cursor    string
+	Limit     int
+}
+
+func (q Query) matches(event Event) bool {
+	if !q.From.IsZero() && event.Date.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !event.Date.Before(q.To) {
+		return false
+	}
+	return len(q.Types) == 0 || q.Types[event.Type] || q.Types[event.Category]
+}
+
+// before orders events by date, then ID so the order is total.
+func before(a, b Event) bool {
+	if !a.Date.Equal(b.Date) {
+		return a.Date.Before(b.Date)
+	}
+	return a.ID < b.ID
+}
+
+// cursor encodes the position of the last event on a page.
+func cursor(event Event) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(event.Date.UTC().Format(time.RFC3339Nano) + "|" + event.ID))
+}
+
+func parseCursor(value string) (Event, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return Event{}, fmt.Errorf("invalid cursor")
+	}
+	date, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return Event{}, fmt.Errorf("invalid cursor")
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, date)
+	if err != nil {
+		return Event{}, fmt.Errorf("invalid cursor")
+	}
+	return Event{Date: parsed, ID: id}, nil
+}
+
+// Page filters and sorts events and returns the page after q.Cursor, with the
+// cursor of the next page or "" when this is the last one.
+func Page(events []Event, q Query) ([]Event, string, error) {
+	var after *Event
+	if q.Cursor != "" {
+		position, err := parseCursor(q.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		after = &position
+	}
+
+	selected := []Event{}
+	for _, event := range events {
+		if !q.matches(event) {
+			continue
+		}
+		if after != nil && (q.Ascending && !before(*after, event) || !q.Ascending && !before(event, *after)) {
+			continue
+		}
+		selected = append(selected, event)
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		if q.Ascending {
+			return before(selected[i], selected[j])
+		}
+		return before(selected[j], selected[i])
+	})
+
+	if q.Limit <= 0 || len(selected) <= q.Limit {
+		return selected, "", nil
+	}
+	page := selected[:q.Limit]
+	return page, cursor(page[len(page)-1]), nil
+}
+
+type Group struct {
+	Key    string  `json:"key"`
+	Label  string  `json:"label"`
+	Events []Event `json:"events"`
+}
+
+// GroupByMonth groups sorted events by calendar month in UTC, keeping their
+// order.
+func GroupByMonth(events []Event) []Group {
+	return group(events, func(event Event) (string, string) {
+		date := event.Date.UTC()
+		return date.Format("2006-01"), date.Format("January 2006")
+	})
+}
+
+// GroupByCondition groups sorted events by the condition they belong to.
+// Events not tied to a condition end up in a final "unlinked" group.
+func GroupByCondition(events []Event) []Group {
+	groups := group(events, func(event Event) (string, string) {
+		if event.Condition == "" {
+			return "unlinked", "Not linked to a condition"
+		}
+		return strings.ToLower(event.Condition), event.Condition
+	})
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].Key != "unlinked" && groups[j].Key == "unlinked"
+	})
+	return groups
+}
+
+func group(events []Event, key func(Event) (string, string)) []Group {
+	groups := []Group{}
+	index := map[string]int{}
+	for _, event := range events {
+		k, label := key(event)
+		i, ok := index[k]
+		if !ok {
+			i = len(groups)
+			index[k] = i
+			groups = append(groups, Group{Key: k, Label: label})
+		}
+		groups[i].Events = append(groups[i].Events, event)
+	}
+	return groups
+}
@@ -0,0 +1,172 @@
+package timeline
+
+import (
+	"life-signal/models"
+	"slices"
+	"testing"
+	"time"
+)
+
+func day(month time.Month, d int) time.Time {
+	return time.Date(2025, month, d, 10, 0, 0, 0, time.UTC)
+}
+
+func ids(events []Event) []string {
+	out := make([]string, len(events))
+	for i, event := range events {
+		out[i] = event.ID
+	}
+	return out
+}
+
+func TestBuild(t *testing.T) {
+	resolved := day(time.March, 1)
+	stopped := day(time.February, 20)
+	onset := day(time.January, 2)
+	sources := Sources{
+		History: &models.MedicalHistory{
+			MedicalIssues: []models.Issue{
+				{ID: "issue-a", Condition: "Asthma", StartDate: day(time.January, 5), EndDate: &resolved, AttachmentIDs: []string{"scan"}},
+				{Condition: "Migraine", StartDate: day(time.January, 6)},
+			},
+			Prescriptions: []models.Prescription{{ID: "rx-a", MedicationName: "Salbutamol", Dosage: "100 mcg", StartDate: day(time.January, 5), EndDate: &stopped}},
+			Appointments:  []models.Appointment{{ID: "visit-a", DoctorName: "Dr Shah", AppointmentDate: day(time.January, 5)}},
+			Allergies:     []models.Allergy{{Substance: "Penicillin", OnsetDate: &onset}},
+			Immunizations: []models.Immunization{{VaccineName: "Influenza", DoseNumber: 2, OccurrenceDate: day(time.January, 3)}},
+		},
+		Attachments: []models.Attachment{{ID: "scan", FileName: "scan.pdf", UploadedAt: day(time.January, 7)}},
+	}
+
+	events := Build(sources)
+	want := []string{
+		"condition-onset:issue-a",
+		"condition-resolved:issue-a",
+		"condition-onset:1",
+		"prescription-start:rx-a",
+		"prescription-stop:rx-a",
+		"appointment:visit-a",
+		"allergy-onset:0",
+		"immunization:0",
+		"attachment-uploaded:scan",
+	}
+	if got := ids(events); !slices.Equal(got, want) {
+		t.Fatalf("Build IDs = %v, want %v", got, want)
+	}
+	titles := map[string]string{
+		"prescription-start:rx-a": "Started Salbutamol 100 mcg",
+		"appointment:visit-a":     "Appointment with Dr Shah",
+		"immunization:0":          "Influenza dose 2",
+	}
+	for _, event := range events {
+		if title, ok := titles[event.ID]; ok && event.Title != title {
+			t.Errorf("%s title = %q, want %q", event.ID, event.Title, title)
+		}
+		if event.ID == "attachment-uploaded:scan" && event.Condition != "Asthma" {
+			t.Errorf("attachment condition = %q, want Asthma", event.Condition)
+		}
+	}
+}
+
+func TestPage(t *testing.T) {
+	events := []Event{
+		{ID: "e", Type: TypeVitalSign, Date: day(time.March, 1)},
+		{ID: "a", Type: TypeConditionOnset, Category: "condition", Date: day(time.January, 1)},
+		{ID: "c", Type: TypeLabResult, Date: day(time.February, 1)},
+		{ID: "b", Type: TypeVitalSign, Date: day(time.February, 1)},
+		{ID: "d", Type: TypeVitalSign, Date: day(time.February, 1)},
+	}
+	tests := []struct {
+		name  string
+		query Query
+		want  [][]string
+	}{
+		{"ascending", Query{Ascending: true, Limit: 2}, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}},
+		{"descending", Query{Limit: 2}, [][]string{{"e", "d"}, {"c", "b"}, {"a"}}},
+		{"ties split across pages", Query{Ascending: true, Limit: 3}, [][]string{{"a", "b", "c"}, {"d", "e"}}},
+		{"exact last page", Query{Limit: 5}, [][]string{{"e", "d", "c", "b", "a"}}},
+		{"no limit", Query{Ascending: true}, [][]string{{"a", "b", "c", "d", "e"}}},
+		{"type filter", Query{Types: map[string]bool{TypeVitalSign: true}, Limit: 2}, [][]string{{"e", "d"}, {"b"}}},
+		{"category filter", Query{Types: map[string]bool{"condition": true}}, [][]string{{"a"}}},
+		{"date range", Query{From: day(time.February, 1), To: day(time.March, 1), Ascending: true, Limit: 2}, [][]string{{"b", "c"}, {"d"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := tt.query
+			var pages [][]string
+			for {
+				page, next, err := Page(events, query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				pages = append(pages, ids(page))
+				if next == "" || len(pages) > len(events) {
+					break
+				}
+				query.Cursor = next
+			}
+			if !slices.EqualFunc(pages, tt.want, slices.Equal[[]string]) {
+				t.Errorf("pages = %v, want %v", pages, tt.want)
+			}
+		})
+	}
+}
+
+func TestPageRejectsInvalidCursor(t *testing.T) {
+	for _, value := range []string{"!!", "bm8tc2VwYXJhdG9y", "bm90LWEtZGF0ZXxh"} {
+		if _, _, err := Page(nil, Query{Cursor: value}); err == nil {
+			t.Errorf("Page with cursor %q succeeded, want an error", value)
+		}
+	}
+}
+
+func TestGroupByMonth(t *testing.T) {
+	events := []Event{
+		{ID: "a", Date: day(time.January, 5)},
+		{ID: "b", Date: day(time.January, 31)},
+		{ID: "c", Date: time.Date(2025, time.February, 1, 0, 30, 0, 0, time.FixedZone("CET", 3600))},
+		{ID: "d", Date: day(time.February, 2)},
+	}
+	groups := GroupByMonth(events)
+	want := []struct {
+		key, label string
+		events     []string
+	}{
+		{"2025-01", "January 2025", []string{"a", "b", "c"}},
+		{"2025-02", "February 2025", []string{"d"}},
+	}
+	if len(groups) != len(want) {
+		t.Fatalf("GroupByMonth = %d groups, want %d", len(groups), len(want))
+	}
+	for i, group := range groups {
+		if group.Key != want[i].key || group.Label != want[i].label || !slices.Equal(ids(group.Events), want[i].events) {
+			t.Errorf("group %d = %s %q %v, want %s %q %v", i, group.Key, group.Label, ids(group.Events), want[i].key, want[i].label, want[i].events)
+		}
+	}
+}
+
+func TestGroupByCondition(t *testing.T) {
+	events := []Event{
+		{ID: "a"},
+		{ID: "b", Condition: "Asthma"},
+		{ID: "c", Condition: "Migraine"},
+		{ID: "d", Condition: "asthma"},
+		{ID: "e"},
+	}
+	groups := GroupByCondition(events)
+	want := []struct {
+		key, label string
+		events     []string
+	}{
+		{"asthma", "Asthma", []string{"b", "d"}},
+		{"migraine", "Migraine", []string{"c"}},
+		{"unlinked", "Not linked to a condition", []string{"a", "e"}},
+	}
+	if len(groups) != len(want) {
+		t.Fatalf("GroupByCondition = %d groups, want %d", len(groups), len(want))
+	}
+	for i, group := range groups {
+		if group.Key != want[i].key || group.Label != want[i].label || !slices.Equal(ids(group.Events), want[i].events) {
+			t.Errorf("group %d = %s %q %v, want %s %q %v", i, group.Key, group.Label, ids(group.Events), want[i].key, want[i].label, want[i].events)
+		}
+	}
+}